@@ -7,11 +7,17 @@ import (
 	"runtime"
 	"strings"
 
+	"github.com/abjrcode/swervo/credsserver"
 	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/eventing"
 	"github.com/abjrcode/swervo/internal/plumbing"
 	"github.com/abjrcode/swervo/internal/utils"
+	awsassumerole "github.com/abjrcode/swervo/providers/aws_assume_role"
+	awsiamuser "github.com/abjrcode/swervo/providers/aws_iam_user"
 	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+	"github.com/abjrcode/swervo/settings"
 	awscredssink "github.com/abjrcode/swervo/sinks/awscredssink"
+	"github.com/abjrcode/swervo/totp"
 	"github.com/rs/zerolog"
 	"github.com/wailsapp/wails/v2/pkg/menu"
 	"github.com/wailsapp/wails/v2/pkg/menu/keys"
@@ -27,13 +33,34 @@ type AppController struct {
 	mainMenu     *menu.Menu
 	logger       zerolog.Logger
 	errorHandler app.ErrorHandler
+	eventBus     *eventing.Eventbus
 
 	authController      *AuthController
 	dashboardController *DashboardController
+	settingsController  *SettingsController
 
-	awsIdcController *awsidc.AwsIdentityCenterController
+	awsIdcController        *awsidc.AwsIdentityCenterController
+	awsIamUserController    *awsiamuser.AwsIamUserController
+	awsAssumeRoleController *awsassumerole.AwsAssumeRoleController
 
 	awsCredentialsSinkController *awscredssink.AwsCredentialsSinkController
+
+	credentialsServerController *credsserver.CredentialsServerController
+	// localSocketPath is where the creds command finds the app while its vault is open
+	localSocketPath string
+
+	totpController *totp.TotpController
+}
+
+// vaultOpened starts everything that needs an open vault, it is stopped again when the vault is sealed
+func (c *AppController) vaultOpened(ctx app.Context) {
+	c.awsIdcController.StartCredentialsPump(ctx)
+	c.awsIamUserController.StartCredentialsPump(ctx)
+	c.awsAssumeRoleController.StartCredentialsPump(ctx)
+
+	if err := c.credentialsServerController.StartLocalSocket(ctx, c.localSocketPath); err != nil {
+		ctx.Logger().Warn().Err(err).Msg("failed to start local socket, the creds command will have to unlock the vault on its own")
+	}
 }
 
 func (c *AppController) init(ctx context.Context, errorHandler app.ErrorHandler) {
@@ -65,6 +92,36 @@ func (c *AppController) init(ctx context.Context, errorHandler app.ErrorHandler)
 	})
 
 	wailsRuntime.MenuSetApplicationMenu(ctx, appMenu)
+
+	reqId := utils.NewRequestId()
+	appContext := app.NewContext(ctx, "root", reqId, reqId, reqId, &c.logger)
+
+	if err := c.settingsController.ApplyNetworkSettings(appContext); err != nil {
+		c.errorHandler.Catch(appContext, c.logger, err)
+	}
+
+	if err := c.settingsController.LoadCustomAwsRegions(appContext); err != nil {
+		c.errorHandler.Catch(appContext, c.logger, err)
+	}
+
+	if err := c.settingsController.ApplyAwsCliSettings(appContext); err != nil {
+		c.errorHandler.Catch(appContext, c.logger, err)
+	}
+
+	c.forwardEventsToFrontend(ctx, awsidc.AwsIdcDeviceFlowEventSource)
+}
+
+// forwardEventsToFrontend emits every event of the given sources as a frontend event named after the source
+func (c *AppController) forwardEventsToFrontend(ctx context.Context, sources ...eventing.EventSource) {
+	for _, source := range sources {
+		events := c.eventBus.Subscribe(source)
+
+		go func(source eventing.EventSource) {
+			for envelope := range events {
+				wailsRuntime.EventsEmit(ctx, string(source), envelope.Event)
+			}
+		}(source)
+	}
 }
 
 func (c *AppController) ShowErrorDialog(msg string) {
@@ -128,13 +185,26 @@ func (c *AppController) RunAppCommand(command string, commandInput map[string]an
 			Auth_ConfigureVaultCommandInput{
 				Password: commandInput["password"].(string),
 			})
+
+		if err == nil {
+			c.vaultOpened(appContext)
+		}
 	case "Auth_Unlock":
-		output, err = c.authController.UnlockVault(
+		var unlocked bool
+		unlocked, err = c.authController.UnlockVault(
 			appContext,
 			Auth_UnlockCommandInput{
 				Password: commandInput["password"].(string),
 			})
+		output = unlocked
+
+		if err == nil && unlocked {
+			c.vaultOpened(appContext)
+		}
 	case "Auth_Lock":
+		c.awsIdcController.StopCredentialsPump()
+		c.awsIamUserController.StopCredentialsPump()
+		c.awsAssumeRoleController.StopCredentialsPump()
 		c.authController.LockVault(appContext)
 	case "Dashboard_ListProviders":
 		output = c.dashboardController.ListProviders()
@@ -142,6 +212,22 @@ func (c *AppController) RunAppCommand(command string, commandInput map[string]an
 		output = c.dashboardController.ListCompatibleSinks(appContext, commandInput["providerCode"].(string))
 	case "Dashboard_ListFavorites":
 		output, err = c.dashboardController.ListFavorites(appContext)
+	case "Settings_GetNetworkSettings":
+		output, err = c.settingsController.GetNetworkSettings(appContext)
+	case "Settings_SaveNetworkSettings":
+		err = c.settingsController.SaveNetworkSettings(appContext, networkSettingsFromCommandInput(commandInput))
+	case "Settings_GetAwsCliSettings":
+		output, err = c.settingsController.GetAwsCliSettings(appContext)
+	case "Settings_SaveAwsCliSettings":
+		err = c.settingsController.SaveAwsCliSettings(appContext, settings.AwsCliSettings{
+			ShareSsoTokens: commandInput["shareSsoTokens"].(bool),
+		})
+	case "Settings_ListAwsRegions":
+		output = c.settingsController.ListAwsRegions(appContext)
+	case "Settings_AddCustomAwsRegion":
+		output, err = c.settingsController.AddCustomAwsRegion(appContext, commandInput["region"].(string))
+	case "Settings_RemoveCustomAwsRegion":
+		err = c.settingsController.RemoveCustomAwsRegion(appContext, commandInput["region"].(string))
 	case "AwsIdc_ListInstances":
 		output, err = c.awsIdcController.ListInstances(appContext)
 	case "AwsIdc_GetInstanceData":
@@ -149,19 +235,58 @@ func (c *AppController) RunAppCommand(command string, commandInput map[string]an
 			commandInput["instanceId"].(string),
 			commandInput["forceRefresh"].(bool))
 	case "AwsIdc_CopyRoleCredentials":
+		forceRefresh, _ := commandInput["forceRefresh"].(bool)
+		format, _ := commandInput["format"].(string)
+		region, _ := commandInput["region"].(string)
+
 		err = c.awsIdcController.CopyRoleCredentials(appContext,
 			awsidc.AwsIdc_CopyRoleCredentialsCommandInput{
-				InstanceId: commandInput["instanceId"].(string),
-				AccountId:  commandInput["accountId"].(string),
-				RoleName:   commandInput["roleName"].(string),
+				InstanceId:   commandInput["instanceId"].(string),
+				AccountId:    commandInput["accountId"].(string),
+				RoleName:     commandInput["roleName"].(string),
+				ForceRefresh: forceRefresh,
+				Format:       format,
+				Region:       region,
+			})
+	case "AwsIdc_OpenConsole":
+		service, _ := commandInput["service"].(string)
+		region, _ := commandInput["region"].(string)
+		firefoxContainer, _ := commandInput["firefoxContainer"].(string)
+
+		err = c.awsIdcController.OpenConsole(appContext,
+			awsidc.AwsIdc_OpenConsoleCommandInput{
+				InstanceId:       commandInput["instanceId"].(string),
+				AccountId:        commandInput["accountId"].(string),
+				RoleName:         commandInput["roleName"].(string),
+				Service:          service,
+				Region:           region,
+				FirefoxContainer: firefoxContainer,
 			})
+	case "AwsIdc_ListCredentialsFormats":
+		output = c.awsIdcController.ListCredentialsFormats()
 	case "AwsIdc_SaveRoleCredentials":
+		forceRefresh, _ := commandInput["forceRefresh"].(bool)
+
 		err = c.awsIdcController.SaveRoleCredentials(appContext,
 			awsidc.AwsIdc_SaveRoleCredentialsCommandInput{
+				InstanceId:   commandInput["instanceId"].(string),
+				AccountId:    commandInput["accountId"].(string),
+				RoleName:     commandInput["roleName"].(string),
+				AwsProfile:   commandInput["awsProfile"].(string),
+				ForceRefresh: forceRefresh,
+			})
+	case "AwsIdc_WriteAwsCliProfile":
+		ssoSession, _ := commandInput["ssoSession"].(string)
+		region, _ := commandInput["region"].(string)
+
+		err = c.awsIdcController.WriteAwsCliProfile(appContext,
+			awsidc.AwsIdc_WriteAwsCliProfileCommandInput{
 				InstanceId: commandInput["instanceId"].(string),
 				AccountId:  commandInput["accountId"].(string),
 				RoleName:   commandInput["roleName"].(string),
 				AwsProfile: commandInput["awsProfile"].(string),
+				SsoSession: ssoSession,
+				Region:     region,
 			})
 	case "AwsIdc_Setup":
 		output, err = c.awsIdcController.Setup(appContext,
@@ -186,6 +311,8 @@ func (c *AppController) RunAppCommand(command string, commandInput map[string]an
 		err = c.awsIdcController.UnmarkAsFavorite(appContext, commandInput["instanceId"].(string))
 	case "AwsIdc_RefreshAccessToken":
 		output, err = c.awsIdcController.RefreshAccessToken(appContext, commandInput["instanceId"].(string))
+	case "AwsIdc_RefreshAccessTokenSilently":
+		err = c.awsIdcController.RefreshAccessTokenSilently(appContext, commandInput["instanceId"].(string))
 	case "AwsIdc_FinalizeRefreshAccessToken":
 		err = c.awsIdcController.FinalizeRefreshAccessToken(appContext,
 			awsidc.AwsIdc_FinalizeRefreshAccessTokenCommandInput{
@@ -194,7 +321,125 @@ func (c *AppController) RunAppCommand(command string, commandInput map[string]an
 				UserCode:   commandInput["userCode"].(string),
 				DeviceCode: commandInput["deviceCode"].(string),
 			})
+	case "AwsIdc_AwaitSetup":
+		output, err = c.awsIdcController.AwaitSetup(appContext,
+			awsidc.AwsIdc_AwaitSetupCommandInput{
+				FlowId:     commandInput["flowId"].(string),
+				ClientId:   commandInput["clientId"].(string),
+				StartUrl:   commandInput["startUrl"].(string),
+				AwsRegion:  commandInput["awsRegion"].(string),
+				Label:      commandInput["label"].(string),
+				UserCode:   commandInput["userCode"].(string),
+				DeviceCode: commandInput["deviceCode"].(string),
+				Interval:   int32(commandInput["interval"].(float64)),
+				ExpiresIn:  int32(commandInput["expiresIn"].(float64)),
+			})
+	case "AwsIdc_AwaitRefreshAccessToken":
+		err = c.awsIdcController.AwaitRefreshAccessToken(appContext,
+			awsidc.AwsIdc_AwaitRefreshAccessTokenCommandInput{
+				FlowId:     commandInput["flowId"].(string),
+				InstanceId: commandInput["instanceId"].(string),
+				Region:     commandInput["region"].(string),
+				UserCode:   commandInput["userCode"].(string),
+				DeviceCode: commandInput["deviceCode"].(string),
+				Interval:   int32(commandInput["interval"].(float64)),
+				ExpiresIn:  int32(commandInput["expiresIn"].(float64)),
+			})
+	case "AwsIdc_CancelDeviceFlow":
+		c.awsIdcController.CancelDeviceFlow(appContext, commandInput["flowId"].(string))
+	case "AwsIdc_SetupWithAuthCode":
+		output, err = c.awsIdcController.SetupWithAuthCode(appContext,
+			awsidc.AwsIdc_SetupCommandInput{
+				StartUrl:  commandInput["startUrl"].(string),
+				AwsRegion: commandInput["awsRegion"].(string),
+				Label:     commandInput["label"].(string),
+			})
+	case "AwsIdc_AwaitSetupWithAuthCode":
+		output, err = c.awsIdcController.AwaitSetupWithAuthCode(appContext,
+			awsidc.AwsIdc_AwaitSetupWithAuthCodeCommandInput{
+				FlowId:    commandInput["flowId"].(string),
+				StartUrl:  commandInput["startUrl"].(string),
+				AwsRegion: commandInput["awsRegion"].(string),
+				Label:     commandInput["label"].(string),
+			})
+	case "AwsIdc_RefreshAccessTokenWithAuthCode":
+		output, err = c.awsIdcController.RefreshAccessTokenWithAuthCode(appContext, commandInput["instanceId"].(string))
+	case "AwsIdc_AwaitRefreshAccessTokenWithAuthCode":
+		err = c.awsIdcController.AwaitRefreshAccessTokenWithAuthCode(appContext,
+			awsidc.AwsIdc_AwaitRefreshAccessTokenWithAuthCodeCommandInput{
+				FlowId:     commandInput["flowId"].(string),
+				InstanceId: commandInput["instanceId"].(string),
+				Region:     commandInput["region"].(string),
+			})
+	case "AwsIdc_CancelAuthCodeFlow":
+		c.awsIdcController.CancelAuthCodeFlow(appContext, commandInput["flowId"].(string))
+	case "AwsIdc_ListAwsCliSessions":
+		output, err = c.awsIdcController.ListAwsCliSessions(appContext)
+	case "AwsIdc_ImportFromAwsCli":
+		output, err = c.awsIdcController.ImportFromAwsCli(appContext,
+			awsidc.AwsIdc_ImportFromAwsCliCommandInput{
+				SessionName: commandInput["sessionName"].(string),
+				Label:       commandInput["label"].(string),
+			})
+	case "AwsIamUser_ListInstances":
+		output, err = c.awsIamUserController.ListInstances(appContext)
+	case "AwsIamUser_GetInstanceData":
+		output, err = c.awsIamUserController.GetInstanceData(appContext, commandInput["instanceId"].(string))
+	case "AwsIamUser_Setup":
+		mfaSerial, _ := commandInput["mfaSerial"].(string)
+		sessionDurationSeconds, _ := commandInput["sessionDurationSeconds"].(float64)
+
+		output, err = c.awsIamUserController.Setup(appContext,
+			awsiamuser.AwsIamUser_SetupCommandInput{
+				Label:                  commandInput["label"].(string),
+				Region:                 commandInput["region"].(string),
+				AccessKeyId:            commandInput["accessKeyId"].(string),
+				SecretAccessKey:        commandInput["secretAccessKey"].(string),
+				MfaSerial:              mfaSerial,
+				SessionDurationSeconds: int32(sessionDurationSeconds),
+			})
+	case "AwsIamUser_StartSession":
+		mfaCode, _ := commandInput["mfaCode"].(string)
+
+		err = c.awsIamUserController.StartSession(appContext,
+			awsiamuser.AwsIamUser_StartSessionCommandInput{
+				InstanceId: commandInput["instanceId"].(string),
+				MfaCode:    mfaCode,
+			})
+	case "AwsIamUser_MarkAsFavorite":
+		err = c.awsIamUserController.MarkAsFavorite(appContext, commandInput["instanceId"].(string))
+	case "AwsIamUser_UnmarkAsFavorite":
+		err = c.awsIamUserController.UnmarkAsFavorite(appContext, commandInput["instanceId"].(string))
+	case "AwsAssumeRole_ListInstances":
+		output, err = c.awsAssumeRoleController.ListInstances(appContext)
+	case "AwsAssumeRole_GetInstanceData":
+		output, err = c.awsAssumeRoleController.GetInstanceData(appContext, commandInput["instanceId"].(string))
+	case "AwsAssumeRole_Setup":
+		sourceSelector, _ := commandInput["sourceSelector"].(map[string]any)
+		accountId, _ := sourceSelector["accountId"].(string)
+		roleName, _ := sourceSelector["roleName"].(string)
+
+		output, err = c.awsAssumeRoleController.Setup(appContext,
+			awsassumerole.AwsAssumeRole_SetupCommandInput{
+				Label:              commandInput["label"].(string),
+				Region:             commandInput["region"].(string),
+				SourceProviderCode: commandInput["sourceProviderCode"].(string),
+				SourceProviderId:   commandInput["sourceProviderId"].(string),
+				SourceSelector: plumbing.SourceSelector{
+					AccountId: accountId,
+					RoleName:  roleName,
+				},
+				Hops: parseAssumeRoleHops(commandInput["hops"]),
+			})
+	case "AwsAssumeRole_MarkAsFavorite":
+		err = c.awsAssumeRoleController.MarkAsFavorite(appContext, commandInput["instanceId"].(string))
+	case "AwsAssumeRole_UnmarkAsFavorite":
+		err = c.awsAssumeRoleController.UnmarkAsFavorite(appContext, commandInput["instanceId"].(string))
 	case "AwsCredentialsSink_NewInstance":
+		sourceSelector, _ := commandInput["sourceSelector"].(map[string]any)
+		accountId, _ := sourceSelector["accountId"].(string)
+		roleName, _ := sourceSelector["roleName"].(string)
+
 		output, err = c.awsCredentialsSinkController.NewInstance(appContext,
 			awscredssink.AwsCredentialsSink_NewInstanceCommandInput{
 				FilePath:       commandInput["filePath"].(string),
@@ -202,7 +447,13 @@ func (c *AppController) RunAppCommand(command string, commandInput map[string]an
 				Label:          commandInput["label"].(string),
 				ProviderCode:   commandInput["providerCode"].(string),
 				ProviderId:     commandInput["providerId"].(string),
+				SourceSelector: plumbing.SourceSelector{
+					AccountId: accountId,
+					RoleName:  roleName,
+				},
 			})
+	case "AwsCredentialsSink_ListInstances":
+		output, err = c.awsCredentialsSinkController.ListInstances(appContext)
 	case "AwsCredentialsSink_GetInstanceData":
 		output, err = c.awsCredentialsSinkController.GetInstanceData(appContext,
 			commandInput["instanceId"].(string),
@@ -212,6 +463,52 @@ func (c *AppController) RunAppCommand(command string, commandInput map[string]an
 			SinkCode: commandInput["sinkCode"].(string),
 			SinkId:   commandInput["sinkId"].(string),
 		})
+	case "CredentialsServer_ListEcsRoutes":
+		output, err = c.credentialsServerController.ListEcsRoutes(appContext)
+	case "CredentialsServer_AddEcsRoute":
+		err = c.credentialsServerController.AddEcsRoute(appContext, credsserver.EcsRoute{
+			Path:       commandInput["path"].(string),
+			InstanceId: commandInput["instanceId"].(string),
+			AccountId:  commandInput["accountId"].(string),
+			RoleName:   commandInput["roleName"].(string),
+		})
+	case "CredentialsServer_RemoveEcsRoute":
+		err = c.credentialsServerController.RemoveEcsRoute(appContext, commandInput["path"].(string))
+	case "CredentialsServer_StartEcsServer":
+		port, _ := commandInput["port"].(float64)
+
+		output, err = c.credentialsServerController.StartEcsServer(appContext, int(port))
+	case "CredentialsServer_StopEcsServer":
+		c.credentialsServerController.StopEcsServer(appContext)
+	case "CredentialsServer_GetEcsServerStatus":
+		output = c.credentialsServerController.GetEcsServerStatus(appContext)
+	case "CredentialsServer_StartImdsServer":
+		output, err = c.credentialsServerController.StartImdsServer(appContext,
+			commandInput["address"].(string),
+			credsserver.ImdsRole{
+				InstanceId: commandInput["instanceId"].(string),
+				AccountId:  commandInput["accountId"].(string),
+				RoleName:   commandInput["roleName"].(string),
+			})
+	case "CredentialsServer_StopImdsServer":
+		c.credentialsServerController.StopImdsServer(appContext)
+	case "CredentialsServer_GetImdsServerStatus":
+		output = c.credentialsServerController.GetImdsServerStatus(appContext)
+	case "Totp_Import":
+		label, _ := commandInput["label"].(string)
+		confirmationCode, _ := commandInput["confirmationCode"].(string)
+
+		output, err = c.totpController.Import(appContext,
+			totp.Totp_ImportCommandInput{
+				Label:            label,
+				MfaSerial:        commandInput["mfaSerial"].(string),
+				Secret:           commandInput["secret"].(string),
+				ConfirmationCode: confirmationCode,
+			})
+	case "Totp_ListAuthenticators":
+		output, err = c.totpController.ListAuthenticators(appContext)
+	case "Totp_Remove":
+		err = c.totpController.Remove(appContext, commandInput["instanceId"].(string))
 	default:
 		output, err = nil, errors.Join(ErrInvalidAppCommand, app.ErrFatal)
 	}
@@ -226,3 +523,41 @@ func (c *AppController) RunAppCommand(command string, commandInput map[string]an
 
 	return output, err
 }
+
+// parseAssumeRoleHops reads the hops of a chain as the frontend sends them, optional fields are left empty when missing
+func parseAssumeRoleHops(input any) []awsassumerole.Hop {
+	items, _ := input.([]any)
+	hops := make([]awsassumerole.Hop, 0, len(items))
+
+	for _, item := range items {
+		fields, _ := item.(map[string]any)
+
+		roleArn, _ := fields["roleArn"].(string)
+		externalId, _ := fields["externalId"].(string)
+		sessionNameTemplate, _ := fields["sessionNameTemplate"].(string)
+		durationSeconds, _ := fields["durationSeconds"].(float64)
+		policy, _ := fields["policy"].(string)
+		tagFields, _ := fields["tags"].(map[string]any)
+
+		var tags map[string]string
+
+		for key, value := range tagFields {
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+
+			tags[key], _ = value.(string)
+		}
+
+		hops = append(hops, awsassumerole.Hop{
+			RoleArn:             roleArn,
+			ExternalId:          externalId,
+			SessionNameTemplate: sessionNameTemplate,
+			DurationSeconds:     int32(durationSeconds),
+			Policy:              policy,
+			Tags:                tags,
+		})
+	}
+
+	return hops
+}
@@ -0,0 +1,165 @@
+package awscredsfile
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/utils"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+var (
+	ErrInvalidConfigFile = app.NewValidationError("INVALID_CONFIG_FILE")
+)
+
+type configFileManager struct {
+	filePath string
+}
+
+func NewConfigFileManager(filePath string) *configFileManager {
+	return &configFileManager{
+		filePath: filePath,
+	}
+}
+
+func NewDefaultConfigFileManager() *configFileManager {
+	return NewConfigFileManager(config.DefaultSharedConfigFilename())
+}
+
+// ProfileConfig holds the settings of a named profile in the config file.
+// Empty fields are left untouched when the profile is written.
+type ProfileConfig struct {
+	Region string
+	Output string
+
+	// SsoSession refers to an [sso-session] block, it replaces SsoStartUrl and SsoRegion
+	SsoSession   string
+	SsoStartUrl  string
+	SsoRegion    string
+	SsoAccountId string
+	SsoRoleName  string
+}
+
+// SsoSessionConfig holds the settings of an [sso-session] block in the config file.
+// Empty fields are left untouched when the session is written.
+type SsoSessionConfig struct {
+	SsoStartUrl           string
+	SsoRegion             string
+	SsoRegistrationScopes string
+}
+
+// profileSection returns the section name of a profile.
+// Only the default profile is written without the "profile" prefix in the config file.
+func profileSection(profileName string) string {
+	if profileName == "default" {
+		return profileName
+	}
+
+	return "profile " + profileName
+}
+
+func ssoSessionSection(sessionName string) string {
+	return "sso-session " + sessionName
+}
+
+func setIfNotEmpty(doc *iniDocument, section, key, value string) {
+	if value != "" {
+		doc.Set(section, key, value)
+	}
+}
+
+// WriteProfile creates or updates the [profile x] section of a profile.
+// Every other line of the file, including comments and unknown keys, is kept as is.
+func (manager *configFileManager) WriteProfile(profileName string, profile ProfileConfig) error {
+	if profileName == "" {
+		return ErrEmptyProfile
+	}
+
+	doc, err := readIniDocument(manager.filePath, ErrInvalidConfigFile)
+
+	if err != nil {
+		return err
+	}
+
+	section := profileSection(profileName)
+
+	if profile.SsoSession != "" {
+		doc.Set(section, "sso_session", profile.SsoSession)
+		// the AWS CLI refuses profiles whose legacy SSO settings disagree with the session
+		doc.Unset(section, "sso_start_url")
+		doc.Unset(section, "sso_region")
+	}
+
+	setIfNotEmpty(doc, section, "sso_start_url", profile.SsoStartUrl)
+	setIfNotEmpty(doc, section, "sso_region", profile.SsoRegion)
+	setIfNotEmpty(doc, section, "sso_account_id", profile.SsoAccountId)
+	setIfNotEmpty(doc, section, "sso_role_name", profile.SsoRoleName)
+	setIfNotEmpty(doc, section, "region", profile.Region)
+	setIfNotEmpty(doc, section, "output", profile.Output)
+
+	return utils.SafelyOverwriteFile(manager.filePath, doc.String())
+}
+
+// WriteSsoSession creates or updates the [sso-session y] block of a session.
+// Every other line of the file, including comments and unknown keys, is kept as is.
+func (manager *configFileManager) WriteSsoSession(sessionName string, session SsoSessionConfig) error {
+	if sessionName == "" {
+		return ErrEmptyProfile
+	}
+
+	doc, err := readIniDocument(manager.filePath, ErrInvalidConfigFile)
+
+	if err != nil {
+		return err
+	}
+
+	section := ssoSessionSection(sessionName)
+
+	setIfNotEmpty(doc, section, "sso_start_url", session.SsoStartUrl)
+	setIfNotEmpty(doc, section, "sso_region", session.SsoRegion)
+	setIfNotEmpty(doc, section, "sso_registration_scopes", session.SsoRegistrationScopes)
+
+	return utils.SafelyOverwriteFile(manager.filePath, doc.String())
+}
+
+// ReadSsoSessions returns the [sso-session y] blocks of the config file keyed by session name.
+// A missing config file has no sessions.
+func (manager *configFileManager) ReadSsoSessions() (map[string]SsoSessionConfig, error) {
+	sessions := make(map[string]SsoSessionConfig)
+
+	content, err := os.ReadFile(manager.filePath)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return sessions, nil
+		}
+
+		return nil, err
+	}
+
+	doc, err := parseIniDocument(string(content))
+
+	if err != nil {
+		return nil, errors.Join(err, ErrInvalidConfigFile)
+	}
+
+	for _, section := range doc.Sections() {
+		sessionName, ok := strings.CutPrefix(section, "sso-session ")
+
+		if !ok {
+			continue
+		}
+
+		var session SsoSessionConfig
+
+		session.SsoStartUrl, _ = doc.Get(section, "sso_start_url")
+		session.SsoRegion, _ = doc.Get(section, "sso_region")
+		session.SsoRegistrationScopes, _ = doc.Get(section, "sso_registration_scopes")
+
+		sessions[sessionName] = session
+	}
+
+	return sessions, nil
+}
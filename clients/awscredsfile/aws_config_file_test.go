@@ -0,0 +1,162 @@
+package awscredsfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const realWorldConfigFile = `[default]
+region = eu-west-1
+output = json
+cli_pager =
+
+# platform team
+[profile   platform]
+sso_start_url = https://platform.awsapps.com/start
+sso_region = eu-west-1
+sso_account_id = 111111111111
+sso_role_name = ReadOnly
+s3 =
+  max_concurrent_requests = 20
+
+[sso-session my-sso]
+sso_start_url = https://my-sso.awsapps.com/start
+sso_region = us-east-1
+`
+
+func TestWriteProfile_NewFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), ".aws", "config")
+
+	manager := NewConfigFileManager(filePath)
+
+	err := manager.WriteSsoSession("swervo", SsoSessionConfig{
+		SsoStartUrl:           "https://swervo.awsapps.com/start",
+		SsoRegion:             "eu-central-1",
+		SsoRegistrationScopes: "sso:account:access",
+	})
+	require.NoError(t, err)
+
+	err = manager.WriteProfile("dev", ProfileConfig{
+		Region:       "eu-central-1",
+		Output:       "json",
+		SsoSession:   "swervo",
+		SsoAccountId: "222222222222",
+		SsoRoleName:  "Developer",
+	})
+	require.NoError(t, err)
+
+	err = manager.WriteProfile("default", ProfileConfig{
+		Region: "eu-central-1",
+	})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+
+	require.Equal(t, `[sso-session swervo]
+sso_start_url = https://swervo.awsapps.com/start
+sso_region = eu-central-1
+sso_registration_scopes = sso:account:access
+
+[profile dev]
+sso_session = swervo
+sso_account_id = 222222222222
+sso_role_name = Developer
+region = eu-central-1
+output = json
+
+[default]
+region = eu-central-1
+`, string(content))
+}
+
+func TestWriteProfile_ExistingFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config")
+
+	err := os.WriteFile(filePath, []byte(realWorldConfigFile), 0600)
+	require.NoError(t, err)
+
+	manager := NewConfigFileManager(filePath)
+
+	err = manager.WriteProfile("platform", ProfileConfig{
+		Region:       "eu-west-2",
+		SsoSession:   "my-sso",
+		SsoAccountId: "111111111111",
+		SsoRoleName:  "Admin",
+	})
+	require.NoError(t, err)
+
+	err = manager.WriteSsoSession("my-sso", SsoSessionConfig{
+		SsoRegion: "eu-west-1",
+	})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+
+	require.Equal(t, `[default]
+region = eu-west-1
+output = json
+cli_pager =
+
+# platform team
+[profile   platform]
+sso_account_id = 111111111111
+sso_role_name = Admin
+s3 =
+  max_concurrent_requests = 20
+sso_session = my-sso
+region = eu-west-2
+
+[sso-session my-sso]
+sso_start_url = https://my-sso.awsapps.com/start
+sso_region = eu-west-1
+`, string(content))
+}
+
+func TestWriteProfile_Error_InvalidFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config")
+
+	err := os.WriteFile(filePath, []byte("[]\nregion = eu-west-1\n"), 0600)
+	require.NoError(t, err)
+
+	manager := NewConfigFileManager(filePath)
+
+	err = manager.WriteProfile("dev", ProfileConfig{Region: "eu-west-1"})
+
+	require.ErrorIs(t, err, ErrInvalidConfigFile)
+}
+
+func TestWriteProfile_Error_EmptyProfileName(t *testing.T) {
+	manager := NewConfigFileManager(filepath.Join(t.TempDir(), "config"))
+
+	err := manager.WriteProfile("", ProfileConfig{Region: "eu-west-1"})
+
+	require.ErrorIs(t, err, ErrEmptyProfile)
+}
+
+func TestReadSsoSessions(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config")
+
+	manager := NewConfigFileManager(filePath)
+
+	sessions, err := manager.ReadSsoSessions()
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+
+	err = os.WriteFile(filePath, []byte(realWorldConfigFile), 0600)
+	require.NoError(t, err)
+
+	sessions, err = manager.ReadSsoSessions()
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]SsoSessionConfig{
+		"my-sso": {
+			SsoStartUrl: "https://my-sso.awsapps.com/start",
+			SsoRegion:   "us-east-1",
+		},
+	}, sessions)
+}
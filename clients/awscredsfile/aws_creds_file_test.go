@@ -252,3 +252,75 @@ func TestWriteProfileCredentials_OverrideWithExistingRegion(t *testing.T) {
 		SessionToken:    nil,
 	}, credentials[0])
 }
+
+func TestWriteProfileCredentials_KeepsEverythingElse(t *testing.T) {
+	dirPath := t.TempDir()
+	filePath := filepath.Join(dirPath, "credentials")
+
+	err := os.WriteFile(filePath, []byte(realWorldCredentialsFile), 0600)
+	require.NoError(t, err)
+
+	manager := NewCredentialsFileManager(filePath)
+
+	err = manager.WriteProfileCredentials("nightly", ProfileCreds{
+		AwsAccessKeyId:     "AKIANEW",
+		AwsSecretAccessKey: "new-secret",
+	})
+	require.NoError(t, err)
+
+	err = manager.WriteProfileCredentials("swervo", ProfileCreds{
+		AwsAccessKeyId:     "AKIASWERVO",
+		AwsSecretAccessKey: "swervo-secret",
+		AwsSessionToken:    utils.AddressOf("swervo-token"),
+	})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+
+	require.Equal(t, `# Managed by hand, please keep the team profiles at the bottom
+[default]
+aws_access_key_id = AKIADEFAULT
+aws_secret_access_key = default-secret
+region = eu-west-1
+output = json
+
+; legacy profile used by the nightly jobs
+[nightly]
+aws_access_key_id = AKIANEW
+aws_secret_access_key = new-secret
+x_security_token_expires = 2023-12-01T10:00:00Z
+
+[process]
+credential_process = /usr/local/bin/creds --profile process
+
+[team-dev]
+aws_access_key_id = AKIATEAMDEV
+aws_secret_access_key = team-dev-secret
+s3 =
+  max_concurrent_requests = 20
+  addressing_style = path
+
+[swervo]
+aws_access_key_id = AKIASWERVO
+aws_secret_access_key = swervo-secret
+aws_session_token = swervo-token
+`, string(content))
+}
+
+func TestWriteProfileCredentials_CreatesMissingDirectory(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), ".aws", "credentials")
+
+	manager := NewCredentialsFileManager(filePath)
+
+	err := manager.WriteProfileCredentials("test-profile", ProfileCreds{
+		AwsAccessKeyId:     "test-access-key-id",
+		AwsSecretAccessKey: "test-secret-access-key",
+	})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+
+	require.Equal(t, "[test-profile]\naws_access_key_id = test-access-key-id\naws_secret_access_key = test-secret-access-key\n", string(content))
+}
@@ -0,0 +1,313 @@
+package awscredsfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type iniLineKind int
+
+const (
+	iniBlank iniLineKind = iota
+	iniComment
+	iniSection
+	iniKeyValue
+	// iniNested is an indented line that belongs to the key/value above it
+	// when that key has an empty value, e.g. the "s3 =" sub-settings of the config file
+	iniNested
+	// iniUnknown is any other line, it is kept as is
+	iniUnknown
+)
+
+type iniLine struct {
+	kind iniLineKind
+	raw  string
+
+	indent string
+	// name is the section name for section lines and the key for key/value lines
+	name  string
+	value string
+}
+
+// iniDocument keeps every line of an INI file so that it can be written back
+// exactly as it was read, except for the keys that were explicitly changed.
+type iniDocument struct {
+	lines           []iniLine
+	newline         string
+	trailingNewline bool
+}
+
+func parseIniDocument(input string) (*iniDocument, error) {
+	doc := &iniDocument{
+		newline:         "\n",
+		trailingNewline: input == "" || strings.HasSuffix(input, "\n"),
+	}
+
+	if strings.Contains(input, "\r\n") {
+		doc.newline = "\r\n"
+	}
+
+	if input == "" {
+		return doc, nil
+	}
+
+	rawLines := strings.Split(strings.TrimSuffix(input, "\n"), "\n")
+
+	// indentation of the last key with an empty value, -1 when lines cannot be nested
+	parentIndent := -1
+
+	for _, raw := range rawLines {
+		raw = strings.TrimSuffix(raw, "\r")
+		trimmed := strings.TrimSpace(raw)
+		indent := raw[:len(raw)-len(strings.TrimLeft(raw, " \t"))]
+
+		line := iniLine{raw: raw, indent: indent}
+
+		switch {
+		case trimmed == "":
+			line.kind = iniBlank
+		case parentIndent >= 0 && len(indent) > parentIndent:
+			line.kind = iniNested
+		case trimmed[0] == '#' || trimmed[0] == ';':
+			line.kind = iniComment
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			line.kind = iniSection
+			// "[profile   x]" and "[profile x]" are the same section
+			line.name = strings.Join(strings.Fields(trimmed[1:len(trimmed)-1]), " ")
+
+			if line.name == "" {
+				return nil, ErrEmptyProfile
+			}
+
+			parentIndent = -1
+		case strings.Contains(trimmed, "="):
+			parts := strings.SplitN(trimmed, "=", 2)
+
+			line.kind = iniKeyValue
+			line.name = strings.TrimSpace(parts[0])
+			line.value = strings.TrimSpace(parts[1])
+
+			if line.name == "" {
+				return nil, ErrEmptyKey
+			}
+
+			if line.value == "" {
+				parentIndent = len(indent)
+			} else {
+				parentIndent = -1
+			}
+		default:
+			line.kind = iniUnknown
+		}
+
+		doc.lines = append(doc.lines, line)
+	}
+
+	return doc, nil
+}
+
+// readIniDocument parses the file at filePath, or returns an empty document if it does not exist yet.
+// In the latter case the parent directory is created so that the document can be written back.
+// Parsing errors are joined with invalidFileErr.
+func readIniDocument(filePath string, invalidFileErr error) (*iniDocument, error) {
+	content, err := os.ReadFile(filePath)
+
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	doc, err := parseIniDocument(string(content))
+
+	if err != nil {
+		return nil, errors.Join(err, invalidFileErr)
+	}
+
+	return doc, nil
+}
+
+// Sections returns the names of all sections in the order they appear.
+func (doc *iniDocument) Sections() []string {
+	sections := make([]string, 0)
+
+	for _, line := range doc.lines {
+		if line.kind == iniSection {
+			sections = append(sections, line.name)
+		}
+	}
+
+	return sections
+}
+
+// sectionRange returns the index of the first section with the given name
+// and the index right after its last line.
+func (doc *iniDocument) sectionRange(section string) (int, int, bool) {
+	start := -1
+
+	for i, line := range doc.lines {
+		if line.kind != iniSection {
+			continue
+		}
+
+		if start != -1 {
+			return start, i, true
+		}
+
+		if line.name == section {
+			start = i
+		}
+	}
+
+	if start == -1 {
+		return -1, -1, false
+	}
+
+	return start, len(doc.lines), true
+}
+
+func (doc *iniDocument) findKey(section, key string) int {
+	start, end, ok := doc.sectionRange(section)
+
+	if !ok {
+		return -1
+	}
+
+	for i := start + 1; i < end; i++ {
+		if doc.lines[i].kind == iniKeyValue && doc.lines[i].name == key {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Get returns the value of a key in a section.
+func (doc *iniDocument) Get(section, key string) (string, bool) {
+	index := doc.findKey(section, key)
+
+	if index == -1 {
+		return "", false
+	}
+
+	return doc.lines[index].value, true
+}
+
+// Set changes the value of a key in place, adds it after the last key of the section
+// or appends a new section at the end of the document. No other line is touched.
+func (doc *iniDocument) Set(section, key, value string) {
+	if index := doc.findKey(section, key); index != -1 {
+		line := &doc.lines[index]
+
+		if line.value != value {
+			line.value = value
+			line.raw = line.indent + key + " = " + value
+		}
+
+		return
+	}
+
+	start, end, ok := doc.sectionRange(section)
+
+	if !ok {
+		if len(doc.lines) > 0 && doc.lines[len(doc.lines)-1].kind != iniBlank {
+			doc.lines = append(doc.lines, iniLine{kind: iniBlank})
+		}
+
+		doc.lines = append(doc.lines,
+			iniLine{kind: iniSection, raw: "[" + section + "]", name: section},
+			iniLine{kind: iniKeyValue, raw: key + " = " + value, name: key, value: value},
+		)
+		doc.trailingNewline = true
+
+		return
+	}
+
+	after := start
+	for i := start + 1; i < end; i++ {
+		if doc.lines[i].kind == iniKeyValue || doc.lines[i].kind == iniNested {
+			after = i
+		}
+	}
+
+	indent := doc.lines[after].indent
+	if doc.lines[after].kind == iniNested {
+		indent = doc.lines[doc.parentOf(after)].indent
+	}
+
+	line := iniLine{kind: iniKeyValue, raw: indent + key + " = " + value, indent: indent, name: key, value: value}
+
+	doc.lines = append(doc.lines[:after+1], append([]iniLine{line}, doc.lines[after+1:]...)...)
+}
+
+// Unset removes a key and its nested lines from a section.
+// It reports whether the key was found.
+func (doc *iniDocument) Unset(section, key string) bool {
+	index := doc.findKey(section, key)
+
+	if index == -1 {
+		return false
+	}
+
+	end := index + 1
+	for end < len(doc.lines) && (doc.lines[end].kind == iniNested || doc.lines[end].kind == iniBlank) {
+		end++
+	}
+
+	// blank lines after the key are not part of it
+	for end > index+1 && doc.lines[end-1].kind == iniBlank {
+		end--
+	}
+
+	doc.lines = append(doc.lines[:index], doc.lines[end:]...)
+
+	return true
+}
+
+// hasNested reports whether the key/value line at the given index has nested lines.
+func (doc *iniDocument) hasNested(index int) bool {
+	for i := index + 1; i < len(doc.lines); i++ {
+		switch doc.lines[i].kind {
+		case iniBlank:
+			continue
+		case iniNested:
+			return true
+		default:
+			return false
+		}
+	}
+
+	return false
+}
+
+func (doc *iniDocument) parentOf(index int) int {
+	for index > 0 && doc.lines[index].kind != iniKeyValue {
+		index--
+	}
+
+	return index
+}
+
+func (doc *iniDocument) String() string {
+	var builder strings.Builder
+
+	for i, line := range doc.lines {
+		if i > 0 {
+			builder.WriteString(doc.newline)
+		}
+
+		builder.WriteString(line.raw)
+	}
+
+	if doc.trailingNewline && len(doc.lines) > 0 {
+		builder.WriteString(doc.newline)
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,203 @@
+package awscredsfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const realWorldCredentialsFile = `# Managed by hand, please keep the team profiles at the bottom
+[default]
+aws_access_key_id = AKIADEFAULT
+aws_secret_access_key = default-secret
+region = eu-west-1
+output = json
+
+; legacy profile used by the nightly jobs
+[nightly]
+aws_access_key_id=AKIANIGHTLY
+aws_secret_access_key=nightly-secret
+aws_session_token=nightly-token
+x_security_token_expires = 2023-12-01T10:00:00Z
+
+[process]
+credential_process = /usr/local/bin/creds --profile process
+
+[team-dev]
+aws_access_key_id = AKIATEAMDEV
+aws_secret_access_key = team-dev-secret
+s3 =
+  max_concurrent_requests = 20
+  addressing_style = path
+`
+
+func TestIniDocument_RoundTrip(t *testing.T) {
+	inputs := []string{
+		realWorldCredentialsFile,
+		"",
+		"[default]\naws_access_key_id = AKIA",
+		"[default]\r\naws_access_key_id = AKIA\r\n\r\n# comment\r\n",
+		"\n\n\t\t[default]\n\t\tregion = us-east-1\n\t\n",
+		"region = us-east-1\nnot a key value\n[default]\n",
+	}
+
+	for _, input := range inputs {
+		doc, err := parseIniDocument(input)
+		require.NoError(t, err)
+
+		require.Equal(t, input, doc.String())
+	}
+}
+
+func TestIniDocument_Get(t *testing.T) {
+	doc, err := parseIniDocument(realWorldCredentialsFile)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"default", "nightly", "process", "team-dev"}, doc.Sections())
+
+	value, ok := doc.Get("nightly", "aws_session_token")
+	require.True(t, ok)
+	require.Equal(t, "nightly-token", value)
+
+	value, ok = doc.Get("process", "credential_process")
+	require.True(t, ok)
+	require.Equal(t, "/usr/local/bin/creds --profile process", value)
+
+	_, ok = doc.Get("team-dev", "max_concurrent_requests")
+	require.False(t, ok)
+
+	_, ok = doc.Get("missing", "region")
+	require.False(t, ok)
+}
+
+func TestIniDocument_Set_ExistingKey(t *testing.T) {
+	doc, err := parseIniDocument(realWorldCredentialsFile)
+	require.NoError(t, err)
+
+	doc.Set("nightly", "aws_session_token", "new-token")
+	doc.Set("default", "region", "eu-west-1")
+
+	require.Equal(t, `# Managed by hand, please keep the team profiles at the bottom
+[default]
+aws_access_key_id = AKIADEFAULT
+aws_secret_access_key = default-secret
+region = eu-west-1
+output = json
+
+; legacy profile used by the nightly jobs
+[nightly]
+aws_access_key_id=AKIANIGHTLY
+aws_secret_access_key=nightly-secret
+aws_session_token = new-token
+x_security_token_expires = 2023-12-01T10:00:00Z
+
+[process]
+credential_process = /usr/local/bin/creds --profile process
+
+[team-dev]
+aws_access_key_id = AKIATEAMDEV
+aws_secret_access_key = team-dev-secret
+s3 =
+  max_concurrent_requests = 20
+  addressing_style = path
+`, doc.String())
+}
+
+func TestIniDocument_Set_NewKey(t *testing.T) {
+	doc, err := parseIniDocument(realWorldCredentialsFile)
+	require.NoError(t, err)
+
+	doc.Set("process", "region", "us-east-1")
+	doc.Set("team-dev", "aws_session_token", "team-dev-token")
+
+	require.Equal(t, `# Managed by hand, please keep the team profiles at the bottom
+[default]
+aws_access_key_id = AKIADEFAULT
+aws_secret_access_key = default-secret
+region = eu-west-1
+output = json
+
+; legacy profile used by the nightly jobs
+[nightly]
+aws_access_key_id=AKIANIGHTLY
+aws_secret_access_key=nightly-secret
+aws_session_token=nightly-token
+x_security_token_expires = 2023-12-01T10:00:00Z
+
+[process]
+credential_process = /usr/local/bin/creds --profile process
+region = us-east-1
+
+[team-dev]
+aws_access_key_id = AKIATEAMDEV
+aws_secret_access_key = team-dev-secret
+s3 =
+  max_concurrent_requests = 20
+  addressing_style = path
+aws_session_token = team-dev-token
+`, doc.String())
+}
+
+func TestIniDocument_Set_NewSection(t *testing.T) {
+	doc, err := parseIniDocument("# my profiles\n[default]\nregion = eu-west-1")
+	require.NoError(t, err)
+
+	doc.Set("swervo", "aws_access_key_id", "AKIASWERVO")
+
+	require.Equal(t, "# my profiles\n[default]\nregion = eu-west-1\n\n[swervo]\naws_access_key_id = AKIASWERVO\n", doc.String())
+
+	doc, err = parseIniDocument("[default]\r\nregion = eu-west-1\r\n")
+	require.NoError(t, err)
+
+	doc.Set("swervo", "aws_access_key_id", "AKIASWERVO")
+
+	require.Equal(t, "[default]\r\nregion = eu-west-1\r\n\r\n[swervo]\r\naws_access_key_id = AKIASWERVO\r\n", doc.String())
+}
+
+func TestIniDocument_Unset(t *testing.T) {
+	doc, err := parseIniDocument(realWorldCredentialsFile)
+	require.NoError(t, err)
+
+	require.True(t, doc.Unset("nightly", "aws_session_token"))
+	require.True(t, doc.Unset("team-dev", "s3"))
+	require.False(t, doc.Unset("process", "aws_session_token"))
+
+	require.Equal(t, `# Managed by hand, please keep the team profiles at the bottom
+[default]
+aws_access_key_id = AKIADEFAULT
+aws_secret_access_key = default-secret
+region = eu-west-1
+output = json
+
+; legacy profile used by the nightly jobs
+[nightly]
+aws_access_key_id=AKIANIGHTLY
+aws_secret_access_key=nightly-secret
+x_security_token_expires = 2023-12-01T10:00:00Z
+
+[process]
+credential_process = /usr/local/bin/creds --profile process
+
+[team-dev]
+aws_access_key_id = AKIATEAMDEV
+aws_secret_access_key = team-dev-secret
+`, doc.String())
+}
+
+func TestIniDocument_Error_EmptySection(t *testing.T) {
+	_, err := parseIniDocument("[ ]\nregion = eu-west-1\n")
+
+	require.ErrorIs(t, err, ErrEmptyProfile)
+}
+
+func TestParse_NestedKeys(t *testing.T) {
+	credentials, err := newParser(realWorldCredentialsFile).parse()
+	require.NoError(t, err)
+
+	require.Equal(t, 4, len(credentials))
+	require.Equal(t, profileCredentials{
+		Profile:         "team-dev",
+		AccessKeyID:     "AKIATEAMDEV",
+		SecretAccessKey: "team-dev-secret",
+	}, credentials[3])
+}
@@ -1,9 +1,7 @@
 package awscredsfile
 
 import (
-	"bufio"
 	"errors"
-	"strings"
 )
 
 type profileCredentials struct {
@@ -14,61 +12,52 @@ type profileCredentials struct {
 	SessionToken    *string
 }
 
+// parser reads the credentials of every profile out of a credentials file.
+// It is a read-only view, use [iniDocument] to change the file.
 type parser struct {
-	scanner *bufio.Scanner
+	input string
 }
 
 func newParser(input string) *parser {
 	return &parser{
-		scanner: bufio.NewScanner(strings.NewReader(input)),
+		input: input,
 	}
 }
 
 func (p *parser) parse() ([]profileCredentials, error) {
-	var credentials []profileCredentials
+	doc, err := parseIniDocument(p.input)
 
-	for p.scanner.Scan() {
-		line := strings.TrimSpace(p.scanner.Text())
+	if err != nil {
+		return nil, errors.Join(err, ErrInvalidCredentialsFile)
+	}
 
-		if line == "" || line[0] == '#' {
-			continue // skip empty lines and comments
-		}
+	var credentials []profileCredentials
 
-		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
-			profile := strings.TrimSpace(line[1 : len(line)-1])
-			if profile == "" {
-				return nil, errors.Join(ErrEmptyProfile, ErrInvalidCredentialsFile)
+	for i, line := range doc.lines {
+		switch line.kind {
+		case iniSection:
+			credentials = append(credentials, profileCredentials{Profile: line.name})
+		case iniKeyValue:
+			if line.value == "" && !doc.hasNested(i) {
+				return nil, errors.Join(ErrEmptyKeyValue, ErrInvalidCredentialsFile)
 			}
-			credentials = append(credentials, profileCredentials{Profile: profile})
-		} else {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				key := strings.TrimSpace(parts[0])
-				value := strings.TrimSpace(parts[1])
 
-				if key == "" {
-					return nil, errors.Join(ErrEmptyKey, ErrInvalidCredentialsFile)
-				}
+			if len(credentials) == 0 {
+				continue // keys outside of a profile are ignored
+			}
 
-				if value == "" {
-					return nil, errors.Join(ErrEmptyKeyValue, ErrInvalidCredentialsFile)
-				}
+			value := line.value
 
-				switch key {
-				case "aws_access_key_id":
-					credentials[len(credentials)-1].AccessKeyID = value
-				case "aws_secret_access_key":
-					credentials[len(credentials)-1].SecretAccessKey = value
-				case "aws_session_token":
-					credentials[len(credentials)-1].SessionToken = &value
-				}
+			switch line.name {
+			case "aws_access_key_id":
+				credentials[len(credentials)-1].AccessKeyID = value
+			case "aws_secret_access_key":
+				credentials[len(credentials)-1].SecretAccessKey = value
+			case "aws_session_token":
+				credentials[len(credentials)-1].SessionToken = &value
 			}
 		}
 	}
 
-	if err := p.scanner.Err(); err != nil {
-		return nil, err
-	}
-
 	return credentials, nil
 }
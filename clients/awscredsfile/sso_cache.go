@@ -0,0 +1,230 @@
+package awscredsfile
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/utils"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// ssoCacheTimeFormat is the timestamp format the AWS CLI uses in its SSO token cache
+const ssoCacheTimeFormat = "2006-01-02T15:04:05Z"
+
+// legacySsoCacheTimeFormat is the timestamp format of cache entries written by older AWS CLI versions
+const legacySsoCacheTimeFormat = "2006-01-02T15:04:05UTC"
+
+var (
+	ErrInvalidSsoCacheEntry = app.NewValidationError("INVALID_SSO_CACHE_ENTRY")
+)
+
+// SsoCachedToken is an entry of the AWS CLI v2 SSO token cache.
+// Times are Unix timestamps in seconds. Refresh tokens are neither written nor read, they never leave the vault.
+type SsoCachedToken struct {
+	StartUrl              string
+	Region                string
+	AccessToken           string
+	ExpiresAt             int64
+	ClientId              string
+	ClientSecret          string
+	RegistrationExpiresAt int64
+}
+
+type ssoCachedTokenJson struct {
+	StartUrl              string `json:"startUrl"`
+	Region                string `json:"region"`
+	AccessToken           string `json:"accessToken"`
+	ExpiresAt             string `json:"expiresAt"`
+	ClientId              string `json:"clientId,omitempty"`
+	ClientSecret          string `json:"clientSecret,omitempty"`
+	RegistrationExpiresAt string `json:"registrationExpiresAt,omitempty"`
+}
+
+type ssoCacheManager struct {
+	dirPath    string
+	configFile *configFileManager
+}
+
+// NewSsoCacheManager manages the SSO token cache in dirPath.
+// Sessions of the config file at configFilePath that share the start URL of a token get a copy of it.
+func NewSsoCacheManager(dirPath, configFilePath string) *ssoCacheManager {
+	return &ssoCacheManager{
+		dirPath:    dirPath,
+		configFile: NewConfigFileManager(configFilePath),
+	}
+}
+
+func NewDefaultSsoCacheManager() *ssoCacheManager {
+	configFilePath := config.DefaultSharedConfigFilename()
+
+	return NewSsoCacheManager(filepath.Join(filepath.Dir(configFilePath), "sso", "cache"), configFilePath)
+}
+
+// SsoCacheFileName returns the name of the cache file the AWS CLI looks up for a cache key.
+// The key is the session name for profiles using an sso_session and the start URL for legacy profiles.
+func SsoCacheFileName(cacheKey string) string {
+	hash := sha1.Sum([]byte(cacheKey))
+
+	return hex.EncodeToString(hash[:]) + ".json"
+}
+
+func formatSsoCacheTime(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+
+	return time.Unix(unix, 0).UTC().Format(ssoCacheTimeFormat)
+}
+
+func parseSsoCacheTime(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+
+	if err != nil {
+		parsed, err = time.Parse(legacySsoCacheTimeFormat, value)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return parsed.Unix(), nil
+}
+
+// ReadToken returns the cached token of a cache key or nil if there is none.
+func (manager *ssoCacheManager) ReadToken(cacheKey string) (*SsoCachedToken, error) {
+	content, err := os.ReadFile(filepath.Join(manager.dirPath, SsoCacheFileName(cacheKey)))
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var entry ssoCachedTokenJson
+
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return nil, errors.Join(err, ErrInvalidSsoCacheEntry)
+	}
+
+	expiresAt, err := parseSsoCacheTime(entry.ExpiresAt)
+
+	if err != nil || expiresAt == 0 || entry.AccessToken == "" {
+		return nil, errors.Join(err, ErrInvalidSsoCacheEntry)
+	}
+
+	registrationExpiresAt, err := parseSsoCacheTime(entry.RegistrationExpiresAt)
+
+	if err != nil {
+		return nil, errors.Join(err, ErrInvalidSsoCacheEntry)
+	}
+
+	return &SsoCachedToken{
+		StartUrl:              entry.StartUrl,
+		Region:                entry.Region,
+		AccessToken:           entry.AccessToken,
+		ExpiresAt:             expiresAt,
+		ClientId:              entry.ClientId,
+		ClientSecret:          entry.ClientSecret,
+		RegistrationExpiresAt: registrationExpiresAt,
+	}, nil
+}
+
+// AwsCliSsoSession is an [sso-session] of the config file along with its cached token.
+type AwsCliSsoSession struct {
+	Name     string
+	StartUrl string
+	Region   string
+
+	// Token is nil when the AWS CLI has no valid cache entry for the session
+	Token *SsoCachedToken
+}
+
+// ListSessions returns every [sso-session] of the config file ordered by name.
+// Cache entries that cannot be read are reported as missing tokens.
+func (manager *ssoCacheManager) ListSessions() ([]AwsCliSsoSession, error) {
+	configSessions, err := manager.configFile.ReadSsoSessions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]AwsCliSsoSession, 0, len(configSessions))
+
+	for name, configSession := range configSessions {
+		token, err := manager.ReadToken(name)
+
+		if err != nil && !errors.Is(err, ErrInvalidSsoCacheEntry) {
+			return nil, err
+		}
+
+		sessions = append(sessions, AwsCliSsoSession{
+			Name:     name,
+			StartUrl: configSession.SsoStartUrl,
+			Region:   configSession.SsoRegion,
+			Token:    token,
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Name < sessions[j].Name
+	})
+
+	return sessions, nil
+}
+
+// WriteToken puts the token in the cache under its start URL
+// and under the name of every [sso-session] of the config file that uses the same start URL.
+func (manager *ssoCacheManager) WriteToken(token SsoCachedToken) error {
+	sessions, err := manager.configFile.ReadSsoSessions()
+
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(ssoCachedTokenJson{
+		StartUrl:              token.StartUrl,
+		Region:                token.Region,
+		AccessToken:           token.AccessToken,
+		ExpiresAt:             formatSsoCacheTime(token.ExpiresAt),
+		ClientId:              token.ClientId,
+		ClientSecret:          token.ClientSecret,
+		RegistrationExpiresAt: formatSsoCacheTime(token.RegistrationExpiresAt),
+	}, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(manager.dirPath, 0700); err != nil {
+		return err
+	}
+
+	cacheKeys := []string{token.StartUrl}
+
+	for sessionName, session := range sessions {
+		if session.SsoStartUrl == token.StartUrl {
+			cacheKeys = append(cacheKeys, sessionName)
+		}
+	}
+
+	for _, cacheKey := range cacheKeys {
+		if err := utils.SafelyOverwriteFile(filepath.Join(manager.dirPath, SsoCacheFileName(cacheKey)), string(content)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package awscredsfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSsoCacheFileName(t *testing.T) {
+	require.Equal(t, "a00fce5cb007c23a469c136160398db65edcb180.json", SsoCacheFileName("https://my-sso.awsapps.com/start"))
+	require.Equal(t, "0ad374308c5a4e22f723adf10145eafad7c4031c.json", SsoCacheFileName("my-sso"))
+}
+
+func TestWriteToken(t *testing.T) {
+	dirPath := t.TempDir()
+	configFilePath := filepath.Join(dirPath, "config")
+	cacheDirPath := filepath.Join(dirPath, "sso", "cache")
+
+	err := os.WriteFile(configFilePath, []byte(realWorldConfigFile), 0600)
+	require.NoError(t, err)
+
+	manager := NewSsoCacheManager(cacheDirPath, configFilePath)
+
+	err = manager.WriteToken(SsoCachedToken{
+		StartUrl:              "https://my-sso.awsapps.com/start",
+		Region:                "us-east-1",
+		AccessToken:           "access-token",
+		ExpiresAt:             1702382400,
+		ClientId:              "client-id",
+		ClientSecret:          "client-secret",
+		RegistrationExpiresAt: 1709769600,
+	})
+	require.NoError(t, err)
+
+	expected := `{
+  "startUrl": "https://my-sso.awsapps.com/start",
+  "region": "us-east-1",
+  "accessToken": "access-token",
+  "expiresAt": "2023-12-12T12:00:00Z",
+  "clientId": "client-id",
+  "clientSecret": "client-secret",
+  "registrationExpiresAt": "2024-03-07T00:00:00Z"
+}`
+
+	entries, err := os.ReadDir(cacheDirPath)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	for _, cacheKey := range []string{"https://my-sso.awsapps.com/start", "my-sso"} {
+		content, err := os.ReadFile(filepath.Join(cacheDirPath, SsoCacheFileName(cacheKey)))
+		require.NoError(t, err)
+
+		require.Equal(t, expected, string(content))
+	}
+}
+
+func TestWriteToken_WithoutConfigFile(t *testing.T) {
+	dirPath := t.TempDir()
+	cacheDirPath := filepath.Join(dirPath, "sso", "cache")
+
+	manager := NewSsoCacheManager(cacheDirPath, filepath.Join(dirPath, "config"))
+
+	err := manager.WriteToken(SsoCachedToken{
+		StartUrl:    "https://other.awsapps.com/start",
+		Region:      "eu-west-1",
+		AccessToken: "access-token",
+		ExpiresAt:   1702382400,
+	})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(cacheDirPath, SsoCacheFileName("https://other.awsapps.com/start")))
+	require.NoError(t, err)
+
+	require.Equal(t, `{
+  "startUrl": "https://other.awsapps.com/start",
+  "region": "eu-west-1",
+  "accessToken": "access-token",
+  "expiresAt": "2023-12-12T12:00:00Z"
+}`, string(content))
+}
+
+func TestListSessions(t *testing.T) {
+	dirPath := t.TempDir()
+	configFilePath := filepath.Join(dirPath, "config")
+	cacheDirPath := filepath.Join(dirPath, "sso", "cache")
+
+	err := os.WriteFile(configFilePath, []byte(realWorldConfigFile+`
+[sso-session legacy-cli]
+sso_start_url = https://legacy.awsapps.com/start
+sso_region = eu-west-1
+
+[sso-session not-logged-in]
+sso_start_url = https://not-logged-in.awsapps.com/start
+sso_region = eu-west-1
+
+[sso-session corrupt]
+sso_start_url = https://corrupt.awsapps.com/start
+sso_region = eu-west-1
+`), 0600)
+	require.NoError(t, err)
+
+	err = os.MkdirAll(cacheDirPath, 0700)
+	require.NoError(t, err)
+
+	cacheEntries := map[string]string{
+		"my-sso": `{"startUrl": "https://my-sso.awsapps.com/start", "region": "us-east-1", "accessToken": "access-token",
+			"expiresAt": "2023-12-12T12:00:00Z", "clientId": "client-id", "clientSecret": "client-secret",
+			"registrationExpiresAt": "2024-03-07T00:00:00Z", "refreshToken": "refresh-token"}`,
+		"legacy-cli": `{"startUrl": "https://legacy.awsapps.com/start", "region": "eu-west-1", "accessToken": "legacy-access-token",
+			"expiresAt": "2023-12-12T12:00:00UTC"}`,
+		"corrupt": `{"startUrl": `,
+	}
+
+	for cacheKey, content := range cacheEntries {
+		err = os.WriteFile(filepath.Join(cacheDirPath, SsoCacheFileName(cacheKey)), []byte(content), 0600)
+		require.NoError(t, err)
+	}
+
+	manager := NewSsoCacheManager(cacheDirPath, configFilePath)
+
+	sessions, err := manager.ListSessions()
+	require.NoError(t, err)
+
+	require.Equal(t, []AwsCliSsoSession{{
+		Name:     "corrupt",
+		StartUrl: "https://corrupt.awsapps.com/start",
+		Region:   "eu-west-1",
+	}, {
+		Name:     "legacy-cli",
+		StartUrl: "https://legacy.awsapps.com/start",
+		Region:   "eu-west-1",
+		Token: &SsoCachedToken{
+			StartUrl:    "https://legacy.awsapps.com/start",
+			Region:      "eu-west-1",
+			AccessToken: "legacy-access-token",
+			ExpiresAt:   1702382400,
+		},
+	}, {
+		Name:     "my-sso",
+		StartUrl: "https://my-sso.awsapps.com/start",
+		Region:   "us-east-1",
+		Token: &SsoCachedToken{
+			StartUrl:              "https://my-sso.awsapps.com/start",
+			Region:                "us-east-1",
+			AccessToken:           "access-token",
+			ExpiresAt:             1702382400,
+			ClientId:              "client-id",
+			ClientSecret:          "client-secret",
+			RegistrationExpiresAt: 1709769600,
+		},
+	}, {
+		Name:     "not-logged-in",
+		StartUrl: "https://not-logged-in.awsapps.com/start",
+		Region:   "eu-west-1",
+	}}, sessions)
+
+	_, err = manager.ReadToken("corrupt")
+	require.ErrorIs(t, err, ErrInvalidSsoCacheEntry)
+}
@@ -0,0 +1,155 @@
+// Package awsnet holds what clients of AWS services share about reaching them, the network they go through and how they cope with failures.
+package awsnet
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/app"
+)
+
+var (
+	ErrInvalidProxyMode      = app.NewValidationError("INVALID_PROXY_MODE")
+	ErrInvalidProxyUrl       = app.NewValidationError("INVALID_PROXY_URL")
+	ErrInvalidCaBundle       = app.NewValidationError("INVALID_CA_BUNDLE")
+	ErrInvalidRequestTimeout = app.NewValidationError("INVALID_REQUEST_TIMEOUT")
+	ErrInvalidEndpoint       = app.NewValidationError("INVALID_ENDPOINT")
+)
+
+type AwsRegion string
+
+type ProxyMode string
+
+const (
+	// ProxyFromEnvironment honors HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+	ProxyFromEnvironment ProxyMode = "environment"
+	ProxyExplicit        ProxyMode = "explicit"
+	ProxyNone            ProxyMode = "none"
+)
+
+// Endpoints overrides the AWS SSO endpoints of a region, e.g. with VPC endpoints.
+// An empty endpoint keeps the default one.
+type Endpoints struct {
+	// Oidc replaces the endpoint of the SSO OIDC service
+	Oidc string
+	// Portal replaces the endpoint of the SSO portal service
+	Portal string
+}
+
+// NetworkOptions controls how AWS endpoints are reached.
+type NetworkOptions struct {
+	Endpoints map[AwsRegion]Endpoints
+
+	ProxyMode ProxyMode
+	// ProxyUrl is only used with [ProxyExplicit]
+	ProxyUrl string
+
+	// CaBundlePem holds certificates that are trusted on top of the system ones, e.g. the CA of an inspecting proxy
+	CaBundlePem string
+
+	// RequestTimeout bounds a single attempt of a call, including reading its response
+	RequestTimeout time.Duration
+}
+
+var DefaultNetworkOptions = NetworkOptions{
+	ProxyMode:      ProxyFromEnvironment,
+	RequestTimeout: 30 * time.Second,
+}
+
+func parseProxyUrl(proxyUrl string) (*url.URL, error) {
+	parsed, err := url.Parse(proxyUrl)
+
+	if err != nil || parsed.Host == "" {
+		return nil, ErrInvalidProxyUrl
+	}
+
+	switch parsed.Scheme {
+	case "http", "https", "socks5":
+		return parsed, nil
+	default:
+		return nil, ErrInvalidProxyUrl
+	}
+}
+
+func validateEndpoint(endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+
+	parsed, err := url.Parse(endpoint)
+
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return ErrInvalidEndpoint
+	}
+
+	return nil
+}
+
+// NewHttpClient returns an HTTP client that reaches AWS through the proxy and with the CA bundle of the given options,
+// so that clients of every AWS service behave alike. It fails with a validation error if options cannot be applied.
+func NewHttpClient(options NetworkOptions) (*http.Client, error) {
+	if options.RequestTimeout < 0 {
+		return nil, ErrInvalidRequestTimeout
+	}
+
+	for _, endpoints := range options.Endpoints {
+		if err := validateEndpoint(endpoints.Oidc); err != nil {
+			return nil, err
+		}
+
+		if err := validateEndpoint(endpoints.Portal); err != nil {
+			return nil, err
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	switch options.ProxyMode {
+	case ProxyFromEnvironment, "":
+		transport.Proxy = http.ProxyFromEnvironment
+	case ProxyNone:
+		transport.Proxy = nil
+	case ProxyExplicit:
+		proxyUrl, err := parseProxyUrl(options.ProxyUrl)
+
+		if err != nil {
+			return nil, err
+		}
+
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	default:
+		return nil, ErrInvalidProxyMode
+	}
+
+	if options.CaBundlePem != "" {
+		rootCAs, err := x509.SystemCertPool()
+
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+
+		if !rootCAs.AppendCertsFromPEM([]byte(options.CaBundlePem)) {
+			return nil, ErrInvalidCaBundle
+		}
+
+		transport.TLSClientConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			RootCAs:    rootCAs,
+		}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   options.RequestTimeout,
+	}, nil
+}
+
+// ValidateNetworkOptions fails with a validation error if options cannot be applied to clients of AWS services.
+func ValidateNetworkOptions(options NetworkOptions) error {
+	_, err := NewHttpClient(options)
+
+	return err
+}
@@ -0,0 +1,37 @@
+package awsnet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateNetworkOptions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		options  NetworkOptions
+		expected error
+	}{
+		{name: "defaults", options: DefaultNetworkOptions},
+		{name: "no proxy", options: NetworkOptions{ProxyMode: ProxyNone}},
+		{name: "explicit proxy", options: NetworkOptions{ProxyMode: ProxyExplicit, ProxyUrl: "http://proxy.corp.internal:3128"}},
+		{name: "unknown proxy mode", options: NetworkOptions{ProxyMode: "pac"}, expected: ErrInvalidProxyMode},
+		{name: "missing proxy url", options: NetworkOptions{ProxyMode: ProxyExplicit}, expected: ErrInvalidProxyUrl},
+		{name: "unsupported proxy scheme", options: NetworkOptions{ProxyMode: ProxyExplicit, ProxyUrl: "ftp://proxy.corp.internal"}, expected: ErrInvalidProxyUrl},
+		{name: "invalid ca bundle", options: NetworkOptions{CaBundlePem: "not a certificate"}, expected: ErrInvalidCaBundle},
+		{name: "negative timeout", options: NetworkOptions{RequestTimeout: -1}, expected: ErrInvalidRequestTimeout},
+		{name: "invalid endpoint", options: NetworkOptions{Endpoints: map[AwsRegion]Endpoints{"eu-west-1": {Oidc: "oidc.eu-west-1.amazonaws.com"}}}, expected: ErrInvalidEndpoint},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := ValidateNetworkOptions(testCase.options)
+
+			if testCase.expected == nil {
+				require.NoError(t, err)
+			} else {
+				require.Same(t, testCase.expected, err)
+			}
+		})
+	}
+}
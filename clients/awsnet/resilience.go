@@ -0,0 +1,307 @@
+package awsnet
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/utils"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrCircuitOpen = errors.New("aws endpoint is unavailable, not calling it for a while")
+)
+
+// RetryPolicy controls how calls that failed for a transient reason are retried.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt, 1 disables retries
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry, it doubles with every retry up to MaxDelay.
+	// The actual delay is jittered between zero and the backoff.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// CircuitBreakerPolicy controls when calls to a region fail fast because its endpoint seems down.
+type CircuitBreakerPolicy struct {
+	// FailureThreshold is the number of consecutive calls that exhausted their retries before the circuit of a region opens, 0 disables the circuit breaker
+	FailureThreshold int
+	// OpenDuration is how long an open circuit fails fast before a single trial call is let through
+	OpenDuration time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   200 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+var DefaultCircuitBreakerPolicy = CircuitBreakerPolicy{
+	FailureThreshold: 5,
+	OpenDuration:     30 * time.Second,
+}
+
+type ClientOptions struct {
+	Retry          RetryPolicy
+	CircuitBreaker CircuitBreakerPolicy
+	Network        NetworkOptions
+}
+
+var DefaultClientOptions = ClientOptions{
+	Retry:          DefaultRetryPolicy,
+	CircuitBreaker: DefaultCircuitBreakerPolicy,
+	Network:        DefaultNetworkOptions,
+}
+
+// CallSafety tells whether a call can be repeated after a failure whose outcome is unknown.
+type CallSafety int
+
+const (
+	IdempotentCall CallSafety = iota
+	// NonIdempotentCall is only retried when it was throttled, because any other failure may have happened after the service acted on it,
+	// e.g. after a one-time code was redeemed or a refresh token was rotated
+	NonIdempotentCall
+)
+
+// ServiceErrors recognizes the errors of an AWS service on top of the ones every service shares.
+type ServiceErrors struct {
+	// IsThrottled tells whether the service turned a call down without acting on it, HTTP 429 always is
+	IsThrottled func(err error) bool
+	// IsTransient tells whether the service failed for a reason that may go away, HTTP 5xx and unreachable endpoints always are
+	IsTransient func(err error) bool
+}
+
+// Throttled tells whether the service turned a call down without acting on it.
+func (e ServiceErrors) Throttled(err error) bool {
+	if e.IsThrottled != nil && e.IsThrottled(err) {
+		return true
+	}
+
+	var responseErr *smithyhttp.ResponseError
+
+	return errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusTooManyRequests
+}
+
+// Retryable tells transient failures, like throttling, an unreachable endpoint or one that hangs, apart from answers of the service.
+// Whether the caller gave up is not decided from err, an attempt that exceeded the request timeout matches [context.DeadlineExceeded] as well.
+func (e ServiceErrors) Retryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	if e.Throttled(err) {
+		return true
+	}
+
+	if e.IsTransient != nil && e.IsTransient(err) {
+		return true
+	}
+
+	var responseErr *smithyhttp.ResponseError
+
+	if errors.As(err, &responseErr) {
+		return responseErr.HTTPStatusCode() >= http.StatusInternalServerError
+	}
+
+	var sendErr *smithyhttp.RequestSendError
+	var netErr net.Error
+
+	return errors.As(err, &sendErr) || errors.As(err, &netErr)
+}
+
+func (policy RetryPolicy) backoff(retry int) time.Duration {
+	backoff := policy.BaseDelay << (retry - 1)
+
+	if backoff <= 0 || backoff > policy.MaxDelay {
+		backoff = policy.MaxDelay
+	}
+
+	if backoff <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+func sleepFor(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type regionCircuit struct {
+	failures  int
+	openUntil int64
+	// trialInFlight is set while a half open circuit lets a single call through
+	trialInFlight bool
+}
+
+type circuitBreaker struct {
+	policy CircuitBreakerPolicy
+	clock  utils.Clock
+
+	mu       sync.Mutex
+	circuits map[AwsRegion]*regionCircuit
+}
+
+func newCircuitBreaker(policy CircuitBreakerPolicy, clock utils.Clock) *circuitBreaker {
+	return &circuitBreaker{
+		policy:   policy,
+		clock:    clock,
+		circuits: make(map[AwsRegion]*regionCircuit),
+	}
+}
+
+// allow fails with [ErrCircuitOpen] while the circuit of a region is open.
+func (b *circuitBreaker) allow(region AwsRegion) error {
+	if b.policy.FailureThreshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	circuit, ok := b.circuits[region]
+
+	if !ok || circuit.failures < b.policy.FailureThreshold {
+		return nil
+	}
+
+	if b.clock.NowUnix() < circuit.openUntil || circuit.trialInFlight {
+		return ErrCircuitOpen
+	}
+
+	circuit.trialInFlight = true
+
+	return nil
+}
+
+// record closes the circuit of a region after a call that reached the service, or counts a failure otherwise.
+func (b *circuitBreaker) record(region AwsRegion, failed bool) {
+	if b.policy.FailureThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	circuit, ok := b.circuits[region]
+
+	if !ok {
+		circuit = &regionCircuit{}
+		b.circuits[region] = circuit
+	}
+
+	circuit.trialInFlight = false
+
+	if !failed {
+		circuit.failures = 0
+		return
+	}
+
+	circuit.failures++
+
+	if circuit.failures >= b.policy.FailureThreshold {
+		circuit.openUntil = b.clock.NowUnix() + int64(b.policy.OpenDuration/time.Second)
+	}
+}
+
+// abandon lets another trial call through after a call whose outcome is unknown, e.g. because it was cancelled.
+func (b *circuitBreaker) abandon(region AwsRegion) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if circuit, ok := b.circuits[region]; ok {
+		circuit.trialInFlight = false
+	}
+}
+
+// Resilience retries the calls of a client to an AWS service and fails them fast while the endpoint of a region seems down.
+// Clients disable the retries of the AWS SDK so that retries can be logged and feed the circuit breaker.
+type Resilience struct {
+	serviceErrors ServiceErrors
+	retryPolicy   RetryPolicy
+	breaker       *circuitBreaker
+	sleep         func(ctx context.Context, d time.Duration) error
+}
+
+func NewResilience(options ClientOptions, serviceErrors ServiceErrors, clock utils.Clock) *Resilience {
+	return &Resilience{
+		serviceErrors: serviceErrors,
+		retryPolicy:   options.Retry,
+		breaker:       newCircuitBreaker(options.CircuitBreaker, clock),
+		sleep:         sleepFor,
+	}
+}
+
+// Invoke calls the service with retries, unless the circuit of the region is open.
+// Calls that are not idempotent are only retried when throttled, other transient failures are returned right away.
+func Invoke[T any](ctx context.Context, logger *zerolog.Logger, r *Resilience, region AwsRegion, operation string, safety CallSafety, call func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+
+	if err := r.breaker.allow(region); err != nil {
+		logger.Warn().Msgf("not calling [%s] because the circuit of region [%s] is open", operation, region)
+		return zero, err
+	}
+
+	maxAttempts := r.retryPolicy.MaxAttempts
+
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		output, err := call(ctx)
+
+		// only the context of the caller tells that it gave up, timeouts of single attempts are failures of the endpoint
+		if ctx.Err() != nil {
+			r.breaker.abandon(region)
+			return output, err
+		}
+
+		if err == nil || !r.serviceErrors.Retryable(err) {
+			r.breaker.record(region, false)
+
+			if attempt > 1 {
+				logger.Info().Msgf("[%s] in region [%s] completed after %d attempts", operation, region, attempt)
+			}
+
+			return output, err
+		}
+
+		if safety == NonIdempotentCall && !r.serviceErrors.Throttled(err) {
+			r.breaker.record(region, true)
+
+			logger.Error().Err(err).Msgf("[%s] in region [%s] failed on attempt %d and is not safe to retry", operation, region, attempt)
+			return zero, err
+		}
+
+		if attempt >= maxAttempts {
+			r.breaker.record(region, true)
+
+			logger.Error().Err(err).Msgf("[%s] in region [%s] failed after %d attempts", operation, region, attempt)
+			return zero, err
+		}
+
+		delay := r.retryPolicy.backoff(attempt)
+
+		logger.Warn().Err(err).Msgf("[%s] in region [%s] failed on attempt %d of %d, retrying in %s", operation, region, attempt, maxAttempts, delay)
+
+		if err := r.sleep(ctx, delay); err != nil {
+			r.breaker.abandon(region)
+			return zero, err
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package awsnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker_FailedTrialReopensCircuit(t *testing.T) {
+	clock := testhelpers.NewMockClock()
+	clock.On("NowUnix").Return(10)
+
+	breaker := newCircuitBreaker(CircuitBreakerPolicy{FailureThreshold: 1, OpenDuration: 30 * time.Second}, clock)
+
+	breaker.record("eu-west-1", true)
+	require.ErrorIs(t, breaker.allow("eu-west-1"), ErrCircuitOpen)
+
+	clock.ExpectedCalls = nil
+	clock.On("NowUnix").Return(40)
+
+	require.NoError(t, breaker.allow("eu-west-1"))
+	require.ErrorIs(t, breaker.allow("eu-west-1"), ErrCircuitOpen, "only a single trial call is let through")
+
+	breaker.record("eu-west-1", true)
+	require.ErrorIs(t, breaker.allow("eu-west-1"), ErrCircuitOpen)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for retry := 1; retry < 10; retry++ {
+		delay := policy.backoff(retry)
+
+		require.GreaterOrEqual(t, delay, time.Duration(0))
+		require.LessOrEqual(t, delay, time.Second)
+	}
+
+	require.LessOrEqual(t, policy.backoff(1), 100*time.Millisecond)
+}
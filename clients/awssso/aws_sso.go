@@ -1,9 +1,17 @@
 package awssso
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 
+	"github.com/abjrcode/swervo/clients/awsnet"
 	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/utils"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sso"
 	ssotypes "github.com/aws/aws-sdk-go-v2/service/sso/types"
@@ -11,41 +19,21 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/ssooidc/types"
 )
 
-var SupportedAwsRegions = map[string]string{
-	"us-east-1":      "US East (N. Virginia)",
-	"us-east-2":      "US East (Ohio)",
-	"us-west-1":      "US West (N. California)",
-	"us-west-2":      "US West (Oregon)",
-	"af-south-1":     "Africa (Cape Town)",
-	"ap-east-1":      "Asia Pacific (Hong Kong)",
-	"ap-south-1":     "Asia Pacific (Mumbai)",
-	"ap-northeast-3": "Asia Pacific (Osaka-Local)",
-	"ap-northeast-2": "Asia Pacific (Seoul)",
-	"ap-southeast-1": "Asia Pacific (Singapore)",
-	"ap-southeast-2": "Asia Pacific (Sydney)",
-	"ap-northeast-1": "Asia Pacific (Tokyo)",
-	"ca-central-1":   "Canada (Central)",
-	"cn-north-1":     "China (Beijing)",
-	"cn-northwest-1": "China (Ningxia)",
-	"eu-central-1":   "Europe (Frankfurt)",
-	"eu-west-1":      "Europe (Ireland)",
-	"eu-west-2":      "Europe (London)",
-	"eu-south-1":     "Europe (Milan)",
-	"eu-west-3":      "Europe (Paris)",
-	"eu-north-1":     "Europe (Stockholm)",
-	"me-south-1":     "Middle East (Bahrain)",
-	"sa-east-1":      "South America (São Paulo)",
-}
-
 var (
-	ErrInvalidRequest          = errors.New("request is not valid")
-	ErrDeviceFlowNotAuthorized = errors.New("device flow not authorized")
-	ErrDeviceCodeExpired       = errors.New("device code expired")
-	ErrAccessTokenExpired      = errors.New("access token expired")
-	ErrUnauthorizedAccessToken = errors.New("unauthorized access token")
+	ErrInvalidRequest           = errors.New("request is not valid")
+	ErrDeviceFlowNotAuthorized  = errors.New("device flow not authorized")
+	ErrDeviceCodeExpired        = errors.New("device code expired")
+	ErrDeviceFlowAccessDenied   = errors.New("device flow access denied")
+	ErrSlowDown                 = errors.New("polling too fast, slow down")
+	ErrAccessTokenExpired       = errors.New("access token expired")
+	ErrUnauthorizedAccessToken  = errors.New("unauthorized access token")
+	ErrInvalidRefreshToken      = errors.New("refresh token is invalid or expired")
+	ErrInvalidAuthorizationCode = errors.New("authorization code is invalid or expired")
 )
 
-type AwsRegion string
+// ClientScopes are requested when registering a client.
+// IAM Identity Center only issues refresh tokens to clients registered with scopes.
+var ClientScopes = []string{"sso:account:access"}
 
 type RegistrationResponse struct {
 	ClientId, ClientSecret string
@@ -71,6 +59,9 @@ type AwsAccountRole struct {
 type AwsAccount struct {
 	AccountId, AccountEmail, AccountName string
 	Roles                                []AwsAccountRole
+
+	// Err is set when the roles of the account could not be listed
+	Err error
 }
 
 type ListAccountsResponse struct {
@@ -83,35 +74,68 @@ type GetRoleCredentialsResponse struct {
 }
 
 type AwsSsoOidcClient interface {
-	RegisterClient(ctx app.Context, awsRegion AwsRegion, friendlyClientName string) (*RegistrationResponse, error)
+	RegisterClient(ctx app.Context, awsRegion awsnet.AwsRegion, friendlyClientName string) (*RegistrationResponse, error)
+
+	StartDeviceAuthorization(ctx app.Context, awsRegion awsnet.AwsRegion, startUrl string, clientId, clientSecret string) (*AuthorizationResponse, error)
+
+	CreateToken(ctx app.Context, awsRegion awsnet.AwsRegion, clientId, clientSecret, userCode, deviceCode string) (*GetTokenResponse, error)
+
+	RefreshToken(ctx app.Context, awsRegion awsnet.AwsRegion, clientId, clientSecret, refreshToken string) (*GetTokenResponse, error)
+
+	RegisterAuthorizationCodeClient(ctx app.Context, awsRegion awsnet.AwsRegion, friendlyClientName, issuerUrl, redirectUri string) (*RegistrationResponse, error)
+
+	AuthorizationUrl(awsRegion awsnet.AwsRegion, clientId, redirectUri, state, codeChallenge string) string
 
-	StartDeviceAuthorization(ctx app.Context, awsRegion AwsRegion, startUrl string, clientId, clientSecret string) (*AuthorizationResponse, error)
+	CreateTokenWithAuthorizationCode(ctx app.Context, awsRegion awsnet.AwsRegion, clientId, clientSecret, code, codeVerifier, redirectUri string) (*GetTokenResponse, error)
 
-	CreateToken(ctx app.Context, awsRegion AwsRegion, clientId, clientSecret, userCode, deviceCode string) (*GetTokenResponse, error)
+	ListAccounts(ctx app.Context, awsRegion awsnet.AwsRegion, accessToken string) (*ListAccountsResponse, error)
 
-	ListAccounts(ctx app.Context, awsRegion AwsRegion, accessToken string) (*ListAccountsResponse, error)
+	GetRoleCredentials(ctx app.Context, awsRegion awsnet.AwsRegion, accountId, roleName, accessToken string) (*GetRoleCredentialsResponse, error)
 
-	GetRoleCredentials(ctx app.Context, awsRegion AwsRegion, accountId, roleName, accessToken string) (*GetRoleCredentialsResponse, error)
+	Configure(options awsnet.NetworkOptions) error
 }
 
 type awsSsoClientImpl struct {
 	oidcClient *ssooidc.Client
 	ssoClient  *sso.Client
+
+	networkMu sync.RWMutex
+	network   *networkConfig
+
+	resilience *awsnet.Resilience
 }
 
-func NewAwsSsoOidcClient() AwsSsoOidcClient {
-	return &awsSsoClientImpl{
-		oidcClient: ssooidc.NewFromConfig(aws.Config{}),
-		ssoClient:  sso.NewFromConfig(aws.Config{}),
+func NewAwsSsoOidcClient(options awsnet.ClientOptions, clock utils.Clock) (AwsSsoOidcClient, error) {
+	return newAwsSsoClient(aws.Config{}, options, clock)
+}
+
+func newAwsSsoClient(cfg aws.Config, options awsnet.ClientOptions, clock utils.Clock) (*awsSsoClientImpl, error) {
+	network, err := newNetworkConfig(options.Network)
+
+	if err != nil {
+		return nil, err
 	}
+
+	// retries are handled by awsnet.Invoke so that they can be logged and feed the circuit breaker
+	cfg.Retryer = func() aws.Retryer {
+		return aws.NopRetryer{}
+	}
+
+	return &awsSsoClientImpl{
+		oidcClient: ssooidc.NewFromConfig(cfg),
+		ssoClient:  sso.NewFromConfig(cfg),
+		network:    network,
+		resilience: awsnet.NewResilience(options, ssoErrors, clock),
+	}, nil
 }
 
-func (c *awsSsoClientImpl) RegisterClient(ctx app.Context, awsRegion AwsRegion, friendlyClientName string) (*RegistrationResponse, error) {
-	output, err := c.oidcClient.RegisterClient(ctx, &ssooidc.RegisterClientInput{
-		ClientName: aws.String(friendlyClientName),
-		ClientType: aws.String("public"),
-	}, func(options *ssooidc.Options) {
-		options.Region = string(awsRegion)
+func (c *awsSsoClientImpl) RegisterClient(ctx app.Context, awsRegion awsnet.AwsRegion, friendlyClientName string) (*RegistrationResponse, error) {
+	output, err := awsnet.Invoke(ctx, ctx.Logger(), c.resilience, awsRegion, "RegisterClient", awsnet.IdempotentCall, func(ctx context.Context) (*ssooidc.RegisterClientOutput, error) {
+		return c.oidcClient.RegisterClient(ctx, &ssooidc.RegisterClientInput{
+			ClientName: aws.String(friendlyClientName),
+			ClientType: aws.String("public"),
+			Scopes:     ClientScopes,
+		}, c.oidcOptions(awsRegion))
 	})
 
 	if err != nil {
@@ -126,13 +150,13 @@ func (c *awsSsoClientImpl) RegisterClient(ctx app.Context, awsRegion AwsRegion,
 	}, nil
 }
 
-func (c *awsSsoClientImpl) StartDeviceAuthorization(ctx app.Context, region AwsRegion, startUrl string, clientId, clientSecret string) (*AuthorizationResponse, error) {
-	output, err := c.oidcClient.StartDeviceAuthorization(ctx, &ssooidc.StartDeviceAuthorizationInput{
-		ClientId:     aws.String(clientId),
-		ClientSecret: aws.String(clientSecret),
-		StartUrl:     aws.String(startUrl),
-	}, func(options *ssooidc.Options) {
-		options.Region = string(region)
+func (c *awsSsoClientImpl) StartDeviceAuthorization(ctx app.Context, region awsnet.AwsRegion, startUrl string, clientId, clientSecret string) (*AuthorizationResponse, error) {
+	output, err := awsnet.Invoke(ctx, ctx.Logger(), c.resilience, region, "StartDeviceAuthorization", awsnet.IdempotentCall, func(ctx context.Context) (*ssooidc.StartDeviceAuthorizationOutput, error) {
+		return c.oidcClient.StartDeviceAuthorization(ctx, &ssooidc.StartDeviceAuthorizationInput{
+			ClientId:     aws.String(clientId),
+			ClientSecret: aws.String(clientSecret),
+			StartUrl:     aws.String(startUrl),
+		}, c.oidcOptions(region))
 	})
 
 	if err != nil {
@@ -154,15 +178,15 @@ func (c *awsSsoClientImpl) StartDeviceAuthorization(ctx app.Context, region AwsR
 	}, nil
 }
 
-func (c *awsSsoClientImpl) CreateToken(ctx app.Context, region AwsRegion, clientId, clientSecret, userCode, deviceCode string) (*GetTokenResponse, error) {
-	output, err := c.oidcClient.CreateToken(ctx, &ssooidc.CreateTokenInput{
-		ClientId:     aws.String(clientId),
-		ClientSecret: aws.String(clientSecret),
-		GrantType:    aws.String("urn:ietf:params:oauth:grant-type:device_code"),
-		DeviceCode:   aws.String(deviceCode),
-		Code:         aws.String(userCode),
-	}, func(options *ssooidc.Options) {
-		options.Region = string(region)
+func (c *awsSsoClientImpl) CreateToken(ctx app.Context, region awsnet.AwsRegion, clientId, clientSecret, userCode, deviceCode string) (*GetTokenResponse, error) {
+	output, err := awsnet.Invoke(ctx, ctx.Logger(), c.resilience, region, "CreateToken", awsnet.NonIdempotentCall, func(ctx context.Context) (*ssooidc.CreateTokenOutput, error) {
+		return c.oidcClient.CreateToken(ctx, &ssooidc.CreateTokenInput{
+			ClientId:     aws.String(clientId),
+			ClientSecret: aws.String(clientSecret),
+			GrantType:    aws.String("urn:ietf:params:oauth:grant-type:device_code"),
+			DeviceCode:   aws.String(deviceCode),
+			Code:         aws.String(userCode),
+		}, c.oidcOptions(region))
 	})
 
 	if err != nil {
@@ -172,99 +196,306 @@ func (c *awsSsoClientImpl) CreateToken(ctx app.Context, region AwsRegion, client
 			return nil, ErrDeviceFlowNotAuthorized
 		}
 
+		var sde *types.SlowDownException
+
+		if errors.As(err, &sde) {
+			return nil, ErrSlowDown
+		}
+
+		var ete *types.ExpiredTokenException
+
+		if errors.As(err, &ete) {
+			return nil, ErrDeviceCodeExpired
+		}
+
+		var ade *types.AccessDeniedException
+
+		if errors.As(err, &ade) {
+			return nil, ErrDeviceFlowAccessDenied
+		}
+
 		return nil, err
 	}
 
-	return &GetTokenResponse{
-		IdToken:      "", // Not supported by AWS SSO
-		AccessToken:  *output.AccessToken,
-		RefreshToken: "", // Not supported by AWS SSO
-		TokenType:    *output.TokenType,
-		ExpiresIn:    output.ExpiresIn,
-	}, nil
+	return newGetTokenResponse(output), nil
 }
 
-func (c *awsSsoClientImpl) ListAccounts(ctx app.Context, region AwsRegion, accessToken string) (*ListAccountsResponse, error) {
-	output, err := c.ssoClient.ListAccounts(ctx, &sso.ListAccountsInput{
-		AccessToken: aws.String(accessToken),
-	}, func(options *sso.Options) {
-		options.Region = string(region)
+// RefreshToken exchanges a refresh token for a new access token.
+// The refresh token must have been issued to the same client.
+func (c *awsSsoClientImpl) RefreshToken(ctx app.Context, region awsnet.AwsRegion, clientId, clientSecret, refreshToken string) (*GetTokenResponse, error) {
+	output, err := awsnet.Invoke(ctx, ctx.Logger(), c.resilience, region, "RefreshToken", awsnet.NonIdempotentCall, func(ctx context.Context) (*ssooidc.CreateTokenOutput, error) {
+		return c.oidcClient.CreateToken(ctx, &ssooidc.CreateTokenInput{
+			ClientId:     aws.String(clientId),
+			ClientSecret: aws.String(clientSecret),
+			GrantType:    aws.String("refresh_token"),
+			RefreshToken: aws.String(refreshToken),
+		}, c.oidcOptions(region))
 	})
 
 	if err != nil {
+		var ige *types.InvalidGrantException
 		var ete *types.ExpiredTokenException
+		var ice *types.InvalidClientException
 
-		if errors.As(err, &ete) {
-			return nil, ErrAccessTokenExpired
+		if errors.As(err, &ige) || errors.As(err, &ete) || errors.As(err, &ice) {
+			return nil, ErrInvalidRefreshToken
 		}
 
-		var ue *ssotypes.UnauthorizedException
+		return nil, err
+	}
+
+	return newGetTokenResponse(output), nil
+}
+
+// RegisterAuthorizationCodeClient registers a client for the authorization code grant with PKCE.
+// Such a client is bound to the IAM Identity Center instance of issuerUrl, which is its start URL.
+// Loopback redirect URIs match regardless of their port, see RFC 8252.
+func (c *awsSsoClientImpl) RegisterAuthorizationCodeClient(ctx app.Context, awsRegion awsnet.AwsRegion, friendlyClientName, issuerUrl, redirectUri string) (*RegistrationResponse, error) {
+	output, err := awsnet.Invoke(ctx, ctx.Logger(), c.resilience, awsRegion, "RegisterAuthorizationCodeClient", awsnet.IdempotentCall, func(ctx context.Context) (*ssooidc.RegisterClientOutput, error) {
+		return c.oidcClient.RegisterClient(ctx, &ssooidc.RegisterClientInput{
+			ClientName:   aws.String(friendlyClientName),
+			ClientType:   aws.String("public"),
+			Scopes:       ClientScopes,
+			GrantTypes:   []string{"authorization_code", "refresh_token"},
+			IssuerUrl:    aws.String(issuerUrl),
+			RedirectUris: []string{redirectUri},
+		}, c.oidcOptions(awsRegion))
+	})
+
+	if err != nil {
+		var ire *types.InvalidRequestException
 
-		if errors.As(err, &ue) {
-			return nil, ErrUnauthorizedAccessToken
+		if errors.As(err, &ire) {
+			return nil, ErrInvalidRequest
 		}
 
 		return nil, err
 	}
 
-	var accounts []AwsAccount
+	return &RegistrationResponse{
+		ClientId:     *output.ClientId,
+		ClientSecret: *output.ClientSecret,
+		CreatedAt:    output.ClientIdIssuedAt,
+		ExpiresAt:    output.ClientSecretExpiresAt,
+	}, nil
+}
+
+// AuthorizationUrl returns the page where the user signs in and grants access to a client.
+// The browser is then redirected to redirectUri with an authorization code and state.
+func (c *awsSsoClientImpl) AuthorizationUrl(awsRegion awsnet.AwsRegion, clientId, redirectUri, state, codeChallenge string) string {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", clientId)
+	query.Set("redirect_uri", redirectUri)
+	query.Set("state", state)
+	query.Set("code_challenge_method", "S256")
+	query.Set("code_challenge", codeChallenge)
+	query.Set("scopes", strings.Join(ClientScopes, " "))
+
+	endpoint := OidcEndpoint(awsRegion)
+
+	if override := c.currentNetwork().endpoints[awsRegion].Oidc; override != "" {
+		endpoint = strings.TrimSuffix(override, "/")
+	}
 
-	for _, account := range output.AccountList {
-		var roles []AwsAccountRole
+	return fmt.Sprintf("%s/authorize?%s", endpoint, query.Encode())
+}
 
-		accountRoles, err := c.ssoClient.ListAccountRoles(ctx, &sso.ListAccountRolesInput{
-			AccessToken: aws.String(accessToken),
-			AccountId:   account.AccountId,
-		}, func(options *sso.Options) {
-			options.Region = string(region)
+// CreateTokenWithAuthorizationCode exchanges an authorization code for an access token.
+// codeVerifier and redirectUri must be the ones the code was requested with.
+func (c *awsSsoClientImpl) CreateTokenWithAuthorizationCode(ctx app.Context, region awsnet.AwsRegion, clientId, clientSecret, code, codeVerifier, redirectUri string) (*GetTokenResponse, error) {
+	output, err := awsnet.Invoke(ctx, ctx.Logger(), c.resilience, region, "CreateTokenWithAuthorizationCode", awsnet.NonIdempotentCall, func(ctx context.Context) (*ssooidc.CreateTokenOutput, error) {
+		return c.oidcClient.CreateToken(ctx, &ssooidc.CreateTokenInput{
+			ClientId:     aws.String(clientId),
+			ClientSecret: aws.String(clientSecret),
+			GrantType:    aws.String("authorization_code"),
+			Code:         aws.String(code),
+			CodeVerifier: aws.String(codeVerifier),
+			RedirectUri:  aws.String(redirectUri),
+		}, c.oidcOptions(region))
+	})
+
+	if err != nil {
+		var ige *types.InvalidGrantException
+
+		if errors.As(err, &ige) {
+			return nil, ErrInvalidAuthorizationCode
+		}
+
+		return nil, err
+	}
+
+	return newGetTokenResponse(output), nil
+}
+
+func newGetTokenResponse(output *ssooidc.CreateTokenOutput) *GetTokenResponse {
+	return &GetTokenResponse{
+		IdToken:      aws.ToString(output.IdToken),
+		AccessToken:  aws.ToString(output.AccessToken),
+		RefreshToken: aws.ToString(output.RefreshToken),
+		TokenType:    aws.ToString(output.TokenType),
+		ExpiresIn:    output.ExpiresIn,
+	}
+}
+
+func mapSsoError(err error) error {
+	var ete *types.ExpiredTokenException
+
+	if errors.As(err, &ete) {
+		return ErrAccessTokenExpired
+	}
+
+	var ue *ssotypes.UnauthorizedException
+
+	if errors.As(err, &ue) {
+		return ErrUnauthorizedAccessToken
+	}
+
+	return err
+}
+
+// ListAccounts lists every account the user has access to along with the roles of each account.
+// Roles are looked up concurrently. An account whose roles could not be listed is returned with Err set,
+// unless the access token is no longer valid in which case the whole listing fails.
+// Accounts are ordered by name and roles by role name.
+func (c *awsSsoClientImpl) ListAccounts(ctx app.Context, region awsnet.AwsRegion, accessToken string) (*ListAccountsResponse, error) {
+	withRegion := c.ssoOptions(region)
+
+	logger := ctx.Logger()
+	accounts := make([]AwsAccount, 0)
+
+	accountsPaginator := sso.NewListAccountsPaginator(c.ssoClient, &sso.ListAccountsInput{
+		AccessToken: aws.String(accessToken),
+	})
+
+	for accountsPaginator.HasMorePages() {
+		page, err := awsnet.Invoke(ctx, logger, c.resilience, region, "ListAccounts", awsnet.IdempotentCall, func(ctx context.Context) (*sso.ListAccountsOutput, error) {
+			return accountsPaginator.NextPage(ctx, withRegion)
 		})
 
 		if err != nil {
-			return nil, err
+			return nil, mapSsoError(err)
 		}
 
-		for _, role := range accountRoles.RoleList {
-			roles = append(roles, AwsAccountRole{
-				RoleName: *role.RoleName,
+		for _, account := range page.AccountList {
+			accounts = append(accounts, AwsAccount{
+				AccountId:    aws.ToString(account.AccountId),
+				AccountEmail: aws.ToString(account.EmailAddress),
+				AccountName:  aws.ToString(account.AccountName),
 			})
 		}
+	}
+
+	err := listRolesConcurrently(ctx, accounts, listAccountRolesConcurrency, func(ctx context.Context, accountId string) ([]AwsAccountRole, error) {
+		roles := make([]AwsAccountRole, 0)
 
-		accounts = append(accounts, AwsAccount{
-			AccountId:    *account.AccountId,
-			AccountEmail: *account.EmailAddress,
-			AccountName:  *account.AccountName,
-			Roles:        roles,
+		rolesPaginator := sso.NewListAccountRolesPaginator(c.ssoClient, &sso.ListAccountRolesInput{
+			AccessToken: aws.String(accessToken),
+			AccountId:   aws.String(accountId),
 		})
+
+		for rolesPaginator.HasMorePages() {
+			page, err := awsnet.Invoke(ctx, logger, c.resilience, region, "ListAccountRoles", awsnet.IdempotentCall, func(ctx context.Context) (*sso.ListAccountRolesOutput, error) {
+				return rolesPaginator.NextPage(ctx, withRegion)
+			})
+
+			if err != nil {
+				return nil, mapSsoError(err)
+			}
+
+			for _, role := range page.RoleList {
+				roles = append(roles, AwsAccountRole{
+					RoleName: aws.ToString(role.RoleName),
+				})
+			}
+		}
+
+		return roles, nil
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
+	sort.SliceStable(accounts, func(i, j int) bool {
+		if accounts[i].AccountName != accounts[j].AccountName {
+			return accounts[i].AccountName < accounts[j].AccountName
+		}
+
+		return accounts[i].AccountId < accounts[j].AccountId
+	})
+
 	return &ListAccountsResponse{
 		Accounts: accounts,
 	}, nil
 }
 
-func (c *awsSsoClientImpl) GetRoleCredentials(ctx app.Context, region AwsRegion, accountId, roleName, accessToken string) (*GetRoleCredentialsResponse, error) {
-	output, err := c.ssoClient.GetRoleCredentials(ctx, &sso.GetRoleCredentialsInput{
-		AccountId:   aws.String(accountId),
-		RoleName:    aws.String(roleName),
-		AccessToken: aws.String(accessToken),
-	}, func(options *sso.Options) {
-		options.Region = string(region)
-	})
+// listAccountRolesConcurrency bounds the number of accounts whose roles are listed at the same time
+const listAccountRolesConcurrency = 8
 
-	if err != nil {
-		var ete *types.ExpiredTokenException
+// listRolesConcurrently fills in the roles of every account with at most concurrency calls to listRoles in flight.
+// A failure is recorded on its account, except for invalid access tokens which abort the listing and are returned.
+func listRolesConcurrently(ctx context.Context, accounts []AwsAccount, concurrency int, listRoles func(ctx context.Context, accountId string) ([]AwsAccountRole, error)) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
-		if errors.As(err, &ete) {
-			return nil, ErrAccessTokenExpired
-		}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	for worker := 0; worker < concurrency && worker < len(accounts); worker++ {
+		wg.Add(1)
 
-		var ue *ssotypes.UnauthorizedException
+		go func() {
+			defer wg.Done()
 
-		if errors.As(err, &ue) {
-			return nil, ErrUnauthorizedAccessToken
+			for index := range indexes {
+				roles, err := listRoles(ctx, accounts[index].AccountId)
+
+				if errors.Is(err, ErrAccessTokenExpired) || errors.Is(err, ErrUnauthorizedAccessToken) {
+					cancel(err)
+					continue
+				}
+
+				if err != nil {
+					accounts[index].Err = err
+					continue
+				}
+
+				sort.Slice(roles, func(i, j int) bool {
+					return roles[i].RoleName < roles[j].RoleName
+				})
+
+				accounts[index].Roles = roles
+			}
+		}()
+	}
+
+feed:
+	for index := range accounts {
+		select {
+		case indexes <- index:
+		case <-ctx.Done():
+			break feed
 		}
+	}
 
-		return nil, err
+	close(indexes)
+	wg.Wait()
+
+	return context.Cause(ctx)
+}
+
+func (c *awsSsoClientImpl) GetRoleCredentials(ctx app.Context, region awsnet.AwsRegion, accountId, roleName, accessToken string) (*GetRoleCredentialsResponse, error) {
+	output, err := awsnet.Invoke(ctx, ctx.Logger(), c.resilience, region, "GetRoleCredentials", awsnet.IdempotentCall, func(ctx context.Context) (*sso.GetRoleCredentialsOutput, error) {
+		return c.ssoClient.GetRoleCredentials(ctx, &sso.GetRoleCredentialsInput{
+			AccountId:   aws.String(accountId),
+			RoleName:    aws.String(roleName),
+			AccessToken: aws.String(accessToken),
+		}, c.ssoOptions(region))
+	})
+
+	if err != nil {
+		return nil, mapSsoError(err)
 	}
 
 	return &GetRoleCredentialsResponse{
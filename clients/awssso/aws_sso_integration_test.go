@@ -0,0 +1,149 @@
+package awssso
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/clients/awssso/awsssotest"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakeServerClient(t *testing.T, server *awsssotest.Server) *awsSsoClientImpl {
+	client, err := newAwsSsoClient(aws.Config{BaseEndpoint: aws.String(server.URL)}, withoutBackoff(awsnet.DefaultClientOptions), testhelpers.NewMockClock())
+	require.NoError(t, err)
+
+	return client
+}
+
+// authorizeDevice runs the device flow up to the first poll for a token
+func authorizeDevice(t *testing.T, ctx app.Context, client *awsSsoClientImpl) (*RegistrationResponse, *AuthorizationResponse, *GetTokenResponse, error) {
+	registration, err := client.RegisterClient(ctx, "eu-west-1", "swervo")
+	require.NoError(t, err)
+
+	authorization, err := client.StartDeviceAuthorization(ctx, "eu-west-1", "https://test.awsapps.com/start", registration.ClientId, registration.ClientSecret)
+	require.NoError(t, err)
+
+	token, err := client.CreateToken(ctx, "eu-west-1", registration.ClientId, registration.ClientSecret, authorization.UserCode, authorization.DeviceCode)
+
+	return registration, authorization, token, err
+}
+
+func TestFakeServer_DeviceFlowAndRoleCredentials(t *testing.T) {
+	server := awsssotest.NewServer(t)
+	server.AddAccount(awsssotest.Account{AccountId: "111111111111", AccountName: "production", EmailAddress: "prod@example.com", Roles: []string{"ReadOnly", "Admin", "Billing"}})
+	server.AddAccount(awsssotest.Account{AccountId: "222222222222", AccountName: "development", EmailAddress: "dev@example.com", Roles: []string{"Developer"}})
+	server.AddAccount(awsssotest.Account{AccountId: "333333333333", AccountName: "sandbox", Roles: []string{}})
+	server.ScriptDeviceAuthorization(awsssotest.Pending, awsssotest.SlowDown, awsssotest.Approved)
+
+	client := newFakeServerClient(t, server)
+	ctx := testhelpers.NewMockAppContext()
+
+	registration, authorization, _, err := authorizeDevice(t, ctx, client)
+	require.ErrorIs(t, err, ErrDeviceFlowNotAuthorized)
+	require.NotEmpty(t, authorization.VerificationUriComplete)
+
+	_, err = client.CreateToken(ctx, "eu-west-1", registration.ClientId, registration.ClientSecret, authorization.UserCode, authorization.DeviceCode)
+	require.ErrorIs(t, err, ErrSlowDown)
+
+	token, err := client.CreateToken(ctx, "eu-west-1", registration.ClientId, registration.ClientSecret, authorization.UserCode, authorization.DeviceCode)
+	require.NoError(t, err)
+	require.NotEmpty(t, token.AccessToken)
+	require.NotEmpty(t, token.RefreshToken)
+
+	accounts, err := client.ListAccounts(ctx, "eu-west-1", token.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, []AwsAccount{
+		{AccountId: "222222222222", AccountName: "development", AccountEmail: "dev@example.com", Roles: []AwsAccountRole{{RoleName: "Developer"}}},
+		{AccountId: "111111111111", AccountName: "production", AccountEmail: "prod@example.com", Roles: []AwsAccountRole{{RoleName: "Admin"}, {RoleName: "Billing"}, {RoleName: "ReadOnly"}}},
+		{AccountId: "333333333333", AccountName: "sandbox", Roles: []AwsAccountRole{}},
+	}, accounts.Accounts)
+	require.Equal(t, 2, server.Requests(awsssotest.ListAccounts), "accounts must be paginated")
+
+	credentials, err := client.GetRoleCredentials(ctx, "eu-west-1", "111111111111", "Admin", token.AccessToken)
+	require.NoError(t, err)
+	require.NotEmpty(t, credentials.AccessKeyId)
+	require.NotEmpty(t, credentials.SecretAccessKey)
+	require.NotEmpty(t, credentials.SessionToken)
+	require.Greater(t, credentials.Expiration, time.Now().UnixMilli())
+
+	refreshed, err := client.RefreshToken(ctx, "eu-west-1", registration.ClientId, registration.ClientSecret, token.RefreshToken)
+	require.NoError(t, err)
+	require.NotEqual(t, token.AccessToken, refreshed.AccessToken)
+
+	_, err = client.RefreshToken(ctx, "eu-west-1", registration.ClientId, registration.ClientSecret, token.RefreshToken)
+	require.ErrorIs(t, err, ErrInvalidRefreshToken, "refresh tokens are rotated")
+}
+
+func TestFakeServer_DeviceFlowOutcomes(t *testing.T) {
+	testCases := []struct {
+		state    awsssotest.DeviceAuthorizationState
+		expected error
+	}{
+		{state: awsssotest.Pending, expected: ErrDeviceFlowNotAuthorized},
+		{state: awsssotest.SlowDown, expected: ErrSlowDown},
+		{state: awsssotest.Denied, expected: ErrDeviceFlowAccessDenied},
+		{state: awsssotest.Expired, expected: ErrDeviceCodeExpired},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(string(testCase.state), func(t *testing.T) {
+			server := awsssotest.NewServer(t)
+			server.ScriptDeviceAuthorization(testCase.state)
+
+			_, _, _, err := authorizeDevice(t, testhelpers.NewMockAppContext(), newFakeServerClient(t, server))
+			require.ErrorIs(t, err, testCase.expected)
+		})
+	}
+}
+
+func TestFakeServer_InvalidClient(t *testing.T) {
+	server := awsssotest.NewServer(t)
+	client := newFakeServerClient(t, server)
+
+	_, err := client.StartDeviceAuthorization(testhelpers.NewMockAppContext(), "eu-west-1", "https://test.awsapps.com/start", "unknown-client-id", "unknown-client-secret")
+	require.Error(t, err)
+	require.False(t, ssoErrors.Retryable(err))
+}
+
+func TestFakeServer_RevokedAccessToken(t *testing.T) {
+	server := awsssotest.NewServer(t)
+	server.AddAccount(awsssotest.Account{AccountId: "111111111111", AccountName: "production", Roles: []string{"Admin"}})
+
+	client := newFakeServerClient(t, server)
+	ctx := testhelpers.NewMockAppContext()
+
+	_, _, token, err := authorizeDevice(t, ctx, client)
+	require.NoError(t, err)
+
+	server.RevokeAccessTokens()
+
+	_, err = client.ListAccounts(ctx, "eu-west-1", token.AccessToken)
+	require.ErrorIs(t, err, ErrUnauthorizedAccessToken)
+
+	_, err = client.GetRoleCredentials(ctx, "eu-west-1", "111111111111", "Admin", token.AccessToken)
+	require.ErrorIs(t, err, ErrUnauthorizedAccessToken)
+}
+
+func TestFakeServer_ThrottledCallsAreRetried(t *testing.T) {
+	server := awsssotest.NewServer(t)
+	server.AddAccount(awsssotest.Account{AccountId: "111111111111", AccountName: "production", Roles: []string{"Admin"}})
+
+	client := newFakeServerClient(t, server)
+	ctx := testhelpers.NewMockAppContext()
+
+	_, _, token, err := authorizeDevice(t, ctx, client)
+	require.NoError(t, err)
+
+	server.FailNext(awsssotest.ListAccountRoles, awsssotest.Throttled, awsssotest.Unavailable)
+
+	accounts, err := client.ListAccounts(ctx, "eu-west-1", token.AccessToken)
+	require.NoError(t, err)
+	require.Len(t, accounts.Accounts, 1)
+	require.NoError(t, accounts.Accounts[0].Err)
+	require.Equal(t, []AwsAccountRole{{RoleName: "Admin"}}, accounts.Accounts[0].Roles)
+	require.Equal(t, 3, server.Requests(awsssotest.ListAccountRoles))
+}
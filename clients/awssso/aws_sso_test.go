@@ -0,0 +1,102 @@
+package awssso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testAccounts(count int) []AwsAccount {
+	accounts := make([]AwsAccount, count)
+
+	for i := range accounts {
+		accounts[i].AccountId = fmt.Sprintf("account-%02d", i)
+	}
+
+	return accounts
+}
+
+func TestListRolesConcurrently(t *testing.T) {
+	accounts := testAccounts(25)
+
+	var inFlight, maxInFlight atomic.Int32
+
+	err := listRolesConcurrently(context.Background(), accounts, 4, func(ctx context.Context, accountId string) ([]AwsAccountRole, error) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for {
+			seen := maxInFlight.Load()
+			if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+
+		time.Sleep(time.Millisecond)
+
+		return []AwsAccountRole{{RoleName: "b-" + accountId}, {RoleName: "a-" + accountId}}, nil
+	})
+
+	require.NoError(t, err)
+	require.LessOrEqual(t, maxInFlight.Load(), int32(4))
+
+	for i, account := range accounts {
+		require.Equal(t, fmt.Sprintf("account-%02d", i), account.AccountId)
+		require.Equal(t, []AwsAccountRole{{RoleName: "a-" + account.AccountId}, {RoleName: "b-" + account.AccountId}}, account.Roles)
+		require.NoError(t, account.Err)
+	}
+}
+
+func TestListRolesConcurrently_PartialFailure(t *testing.T) {
+	accounts := testAccounts(5)
+	listErr := errors.New("throttled")
+
+	err := listRolesConcurrently(context.Background(), accounts, 2, func(ctx context.Context, accountId string) ([]AwsAccountRole, error) {
+		if accountId == "account-03" {
+			return nil, listErr
+		}
+
+		return []AwsAccountRole{{RoleName: "role"}}, nil
+	})
+
+	require.NoError(t, err)
+
+	for _, account := range accounts {
+		if account.AccountId == "account-03" {
+			require.ErrorIs(t, account.Err, listErr)
+			require.Empty(t, account.Roles)
+		} else {
+			require.NoError(t, account.Err)
+			require.Len(t, account.Roles, 1)
+		}
+	}
+}
+
+func TestListRolesConcurrently_InvalidAccessTokenAbortsListing(t *testing.T) {
+	accounts := testAccounts(50)
+
+	var calls atomic.Int32
+
+	err := listRolesConcurrently(context.Background(), accounts, 2, func(ctx context.Context, accountId string) ([]AwsAccountRole, error) {
+		calls.Add(1)
+
+		return nil, ErrAccessTokenExpired
+	})
+
+	require.ErrorIs(t, err, ErrAccessTokenExpired)
+	require.Less(t, calls.Load(), int32(50))
+}
+
+func TestListRolesConcurrently_NoAccounts(t *testing.T) {
+	err := listRolesConcurrently(context.Background(), nil, 4, func(ctx context.Context, accountId string) ([]AwsAccountRole, error) {
+		t.Fatal("no roles should be listed")
+		return nil, nil
+	})
+
+	require.NoError(t, err)
+}
@@ -0,0 +1,540 @@
+// Package awsssotest provides an in-process stand-in for the AWS SSO OIDC and SSO portal services
+// so that the AWS SSO client can be tested end to end without reaching AWS.
+package awsssotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Operation names the API operations the server implements.
+type Operation string
+
+const (
+	RegisterClient           Operation = "RegisterClient"
+	StartDeviceAuthorization Operation = "StartDeviceAuthorization"
+	CreateToken              Operation = "CreateToken"
+	ListAccounts             Operation = "ListAccounts"
+	ListAccountRoles         Operation = "ListAccountRoles"
+	GetRoleCredentials       Operation = "GetRoleCredentials"
+)
+
+// DeviceAuthorizationState is how the server answers a poll for the token of a device authorization.
+type DeviceAuthorizationState string
+
+const (
+	Pending  DeviceAuthorizationState = "pending"
+	SlowDown DeviceAuthorizationState = "slow_down"
+	Approved DeviceAuthorizationState = "approved"
+	Denied   DeviceAuthorizationState = "denied"
+	Expired  DeviceAuthorizationState = "expired"
+)
+
+type Account struct {
+	AccountId, AccountName, EmailAddress string
+	Roles                                []string
+}
+
+// Failure is an error answer injected with [Server.FailNext].
+type Failure struct {
+	Status    int
+	ErrorType string
+}
+
+var (
+	Throttled   = Failure{Status: http.StatusTooManyRequests, ErrorType: "TooManyRequestsException"}
+	Unavailable = Failure{Status: http.StatusServiceUnavailable}
+)
+
+type client struct {
+	secret string
+}
+
+type deviceAuthorization struct {
+	clientId string
+	polls    int
+}
+
+// Server implements the JSON protocols of the SSO OIDC and SSO portal services on a single endpoint,
+// both can be pointed at [Server.URL].
+type Server struct {
+	*httptest.Server
+
+	// PageSize is the number of accounts or roles returned per page
+	PageSize int
+	// AccessTokenExpiresIn is the lifetime in seconds of issued access tokens
+	AccessTokenExpiresIn int32
+
+	mu                   sync.Mutex
+	clients              map[string]client
+	deviceAuthorizations map[string]*deviceAuthorization
+	deviceScript         []DeviceAuthorizationState
+	accessTokens         map[string]bool
+	refreshTokens        map[string]string
+	accounts             []Account
+	failures             map[Operation][]Failure
+	requests             map[Operation]int
+	issued               int
+}
+
+// NewServer starts a server that is closed when the test ends.
+// Device authorizations are approved on the first poll unless scripted otherwise with [Server.ScriptDeviceAuthorization].
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		PageSize:             2,
+		AccessTokenExpiresIn: 3600,
+		clients:              make(map[string]client),
+		deviceAuthorizations: make(map[string]*deviceAuthorization),
+		deviceScript:         []DeviceAuthorizationState{Approved},
+		accessTokens:         make(map[string]bool),
+		refreshTokens:        make(map[string]string),
+		failures:             make(map[Operation][]Failure),
+		requests:             make(map[Operation]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/client/register", s.handle(RegisterClient, s.registerClient))
+	mux.HandleFunc("/device_authorization", s.handle(StartDeviceAuthorization, s.startDeviceAuthorization))
+	mux.HandleFunc("/token", s.handle(CreateToken, s.createToken))
+	mux.HandleFunc("/assignment/accounts", s.handle(ListAccounts, s.listAccounts))
+	mux.HandleFunc("/assignment/roles", s.handle(ListAccountRoles, s.listAccountRoles))
+	mux.HandleFunc("/federation/credentials", s.handle(GetRoleCredentials, s.getRoleCredentials))
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// AddAccount makes an account and its roles visible to every access token.
+func (s *Server) AddAccount(account Account) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accounts = append(s.accounts, account)
+}
+
+// ScriptDeviceAuthorization sets how successive polls of a device authorization are answered, the last state repeats.
+func (s *Server) ScriptDeviceAuthorization(states ...DeviceAuthorizationState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deviceScript = states
+}
+
+// FailNext answers the next calls of an operation with failures, one per call.
+func (s *Server) FailNext(operation Operation, failures ...Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[operation] = append(s.failures[operation], failures...)
+}
+
+// RevokeAccessTokens makes every access token issued so far unauthorized.
+func (s *Server) RevokeAccessTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token := range s.accessTokens {
+		s.accessTokens[token] = false
+	}
+}
+
+// Requests returns the number of calls of an operation, including failed ones.
+func (s *Server) Requests(operation Operation) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[operation]
+}
+
+type serviceError struct {
+	status    int
+	errorType string
+	code      string
+}
+
+func (e *serviceError) Error() string {
+	return e.errorType
+}
+
+var (
+	errInvalidRequest       = &serviceError{http.StatusBadRequest, "InvalidRequestException", "invalid_request"}
+	errInvalidClient        = &serviceError{http.StatusUnauthorized, "InvalidClientException", "invalid_client"}
+	errInvalidGrant         = &serviceError{http.StatusBadRequest, "InvalidGrantException", "invalid_grant"}
+	errUnsupportedGrantType = &serviceError{http.StatusBadRequest, "UnsupportedGrantTypeException", "unsupported_grant_type"}
+	errAuthorizationPending = &serviceError{http.StatusBadRequest, "AuthorizationPendingException", "authorization_pending"}
+	errSlowDown             = &serviceError{http.StatusBadRequest, "SlowDownException", "slow_down"}
+	errAccessDenied         = &serviceError{http.StatusBadRequest, "AccessDeniedException", "access_denied"}
+	errExpiredToken         = &serviceError{http.StatusBadRequest, "ExpiredTokenException", "expired_token"}
+	errUnauthorized         = &serviceError{http.StatusUnauthorized, "UnauthorizedException", ""}
+	errResourceNotFound     = &serviceError{http.StatusNotFound, "ResourceNotFoundException", ""}
+)
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, errorType, code string) {
+	if errorType != "" {
+		w.Header().Set("X-Amzn-ErrorType", errorType)
+	}
+
+	body := map[string]string{"message": errorType}
+
+	if code != "" {
+		body["error"] = code
+		body["error_description"] = errorType
+	}
+
+	writeJson(w, status, body)
+}
+
+// handle counts calls, injects failures and serializes answers and service errors
+func (s *Server) handle(operation Operation, serve func(r *http.Request) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+
+		s.requests[operation]++
+
+		if failures := s.failures[operation]; len(failures) > 0 {
+			s.failures[operation] = failures[1:]
+			s.mu.Unlock()
+
+			writeError(w, failures[0].Status, failures[0].ErrorType, "")
+			return
+		}
+
+		output, err := serve(r)
+
+		s.mu.Unlock()
+
+		if err != nil {
+			if serviceErr, ok := err.(*serviceError); ok {
+				writeError(w, serviceErr.status, serviceErr.errorType, serviceErr.code)
+				return
+			}
+
+			writeError(w, http.StatusInternalServerError, "InternalServerException", "server_error")
+			return
+		}
+
+		writeJson(w, http.StatusOK, output)
+	}
+}
+
+func decodeInput(r *http.Request, input any) error {
+	if r.Method != http.MethodPost {
+		return errInvalidRequest
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(input); err != nil {
+		return errInvalidRequest
+	}
+
+	return nil
+}
+
+func (s *Server) nextId() int {
+	s.issued++
+
+	return s.issued
+}
+
+func (s *Server) authenticateClient(clientId, clientSecret string) error {
+	client, ok := s.clients[clientId]
+
+	if !ok || client.secret != clientSecret {
+		return errInvalidClient
+	}
+
+	return nil
+}
+
+func (s *Server) registerClient(r *http.Request) (any, error) {
+	var input struct {
+		ClientName string `json:"clientName"`
+		ClientType string `json:"clientType"`
+	}
+
+	if err := decodeInput(r, &input); err != nil {
+		return nil, err
+	}
+
+	if input.ClientName == "" || input.ClientType != "public" {
+		return nil, errInvalidRequest
+	}
+
+	id := s.nextId()
+	clientId := fmt.Sprintf("client-id-%d", id)
+	clientSecret := fmt.Sprintf("client-secret-%d", id)
+
+	s.clients[clientId] = client{secret: clientSecret}
+
+	now := time.Now().Unix()
+
+	return map[string]any{
+		"clientId":              clientId,
+		"clientSecret":          clientSecret,
+		"clientIdIssuedAt":      now,
+		"clientSecretExpiresAt": now + 90*24*3600,
+	}, nil
+}
+
+func (s *Server) startDeviceAuthorization(r *http.Request) (any, error) {
+	var input struct {
+		ClientId     string `json:"clientId"`
+		ClientSecret string `json:"clientSecret"`
+		StartUrl     string `json:"startUrl"`
+	}
+
+	if err := decodeInput(r, &input); err != nil {
+		return nil, err
+	}
+
+	if err := s.authenticateClient(input.ClientId, input.ClientSecret); err != nil {
+		return nil, err
+	}
+
+	if input.StartUrl == "" {
+		return nil, errInvalidRequest
+	}
+
+	id := s.nextId()
+	deviceCode := fmt.Sprintf("device-code-%d", id)
+	userCode := fmt.Sprintf("USER-%04d", id)
+
+	s.deviceAuthorizations[deviceCode] = &deviceAuthorization{clientId: input.ClientId}
+
+	verificationUri := s.URL + "/device"
+
+	return map[string]any{
+		"deviceCode":              deviceCode,
+		"userCode":                userCode,
+		"verificationUri":         verificationUri,
+		"verificationUriComplete": verificationUri + "?user_code=" + userCode,
+		"expiresIn":               600,
+		"interval":                1,
+	}, nil
+}
+
+func (s *Server) issueToken(clientId string) map[string]any {
+	id := s.nextId()
+	accessToken := fmt.Sprintf("access-token-%d", id)
+	refreshToken := fmt.Sprintf("refresh-token-%d", id)
+
+	s.accessTokens[accessToken] = true
+	s.refreshTokens[refreshToken] = clientId
+
+	return map[string]any{
+		"accessToken":  accessToken,
+		"refreshToken": refreshToken,
+		"tokenType":    "Bearer",
+		"expiresIn":    s.AccessTokenExpiresIn,
+	}
+}
+
+func (s *Server) createToken(r *http.Request) (any, error) {
+	var input struct {
+		ClientId     string `json:"clientId"`
+		ClientSecret string `json:"clientSecret"`
+		GrantType    string `json:"grantType"`
+		DeviceCode   string `json:"deviceCode"`
+		RefreshToken string `json:"refreshToken"`
+	}
+
+	if err := decodeInput(r, &input); err != nil {
+		return nil, err
+	}
+
+	if err := s.authenticateClient(input.ClientId, input.ClientSecret); err != nil {
+		return nil, err
+	}
+
+	switch input.GrantType {
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		authorization, ok := s.deviceAuthorizations[input.DeviceCode]
+
+		if !ok || authorization.clientId != input.ClientId {
+			return nil, errInvalidGrant
+		}
+
+		state := s.deviceScript[min(authorization.polls, len(s.deviceScript)-1)]
+		authorization.polls++
+
+		switch state {
+		case Pending:
+			return nil, errAuthorizationPending
+		case SlowDown:
+			return nil, errSlowDown
+		case Denied:
+			return nil, errAccessDenied
+		case Expired:
+			return nil, errExpiredToken
+		}
+
+		delete(s.deviceAuthorizations, input.DeviceCode)
+
+		return s.issueToken(input.ClientId), nil
+	case "refresh_token":
+		clientId, ok := s.refreshTokens[input.RefreshToken]
+
+		if !ok || clientId != input.ClientId {
+			return nil, errInvalidGrant
+		}
+
+		delete(s.refreshTokens, input.RefreshToken)
+
+		return s.issueToken(input.ClientId), nil
+	default:
+		return nil, errUnsupportedGrantType
+	}
+}
+
+// authorize checks the bearer token of a portal call
+func (s *Server) authorize(r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return errInvalidRequest
+	}
+
+	if !s.accessTokens[r.Header.Get("x-amz-sso_bearer_token")] {
+		return errUnauthorized
+	}
+
+	return nil
+}
+
+// page returns the items of the page starting at the offset encoded in next_token, along with the token of the next page
+func page[T any](s *Server, r *http.Request, items []T) ([]T, *string, error) {
+	start := 0
+
+	if nextToken := r.URL.Query().Get("next_token"); nextToken != "" {
+		offset, err := strconv.Atoi(nextToken)
+
+		if err != nil || offset < 0 || offset > len(items) {
+			return nil, nil, errInvalidRequest
+		}
+
+		start = offset
+	}
+
+	end := min(start+s.PageSize, len(items))
+
+	if end == len(items) {
+		return items[start:end], nil, nil
+	}
+
+	nextToken := strconv.Itoa(end)
+
+	return items[start:end], &nextToken, nil
+}
+
+func (s *Server) findAccount(accountId string) (*Account, error) {
+	for i := range s.accounts {
+		if s.accounts[i].AccountId == accountId {
+			return &s.accounts[i], nil
+		}
+	}
+
+	return nil, errResourceNotFound
+}
+
+func (s *Server) listAccounts(r *http.Request) (any, error) {
+	if err := s.authorize(r); err != nil {
+		return nil, err
+	}
+
+	accounts, nextToken, err := page(s, r, s.accounts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	accountList := make([]map[string]string, 0, len(accounts))
+
+	for _, account := range accounts {
+		accountList = append(accountList, map[string]string{
+			"accountId":    account.AccountId,
+			"accountName":  account.AccountName,
+			"emailAddress": account.EmailAddress,
+		})
+	}
+
+	return map[string]any{
+		"accountList": accountList,
+		"nextToken":   nextToken,
+	}, nil
+}
+
+func (s *Server) listAccountRoles(r *http.Request) (any, error) {
+	if err := s.authorize(r); err != nil {
+		return nil, err
+	}
+
+	account, err := s.findAccount(r.URL.Query().Get("account_id"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	roles, nextToken, err := page(s, r, account.Roles)
+
+	if err != nil {
+		return nil, err
+	}
+
+	roleList := make([]map[string]string, 0, len(roles))
+
+	for _, role := range roles {
+		roleList = append(roleList, map[string]string{
+			"accountId": account.AccountId,
+			"roleName":  role,
+		})
+	}
+
+	return map[string]any{
+		"roleList":  roleList,
+		"nextToken": nextToken,
+	}, nil
+}
+
+func (s *Server) getRoleCredentials(r *http.Request) (any, error) {
+	if err := s.authorize(r); err != nil {
+		return nil, err
+	}
+
+	account, err := s.findAccount(r.URL.Query().Get("account_id"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	roleName := r.URL.Query().Get("role_name")
+
+	for _, role := range account.Roles {
+		if role != roleName {
+			continue
+		}
+
+		id := s.nextId()
+
+		return map[string]any{
+			"roleCredentials": map[string]any{
+				"accessKeyId":     fmt.Sprintf("ASIA%016d", id),
+				"secretAccessKey": fmt.Sprintf("secret-access-key-%d", id),
+				"sessionToken":    fmt.Sprintf("session-token-%d", id),
+				"expiration":      time.Now().Add(time.Hour).UnixMilli(),
+			},
+		}, nil
+	}
+
+	return nil, errResourceNotFound
+}
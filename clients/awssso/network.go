@@ -0,0 +1,91 @@
+package awssso
+
+import (
+	"net/http"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/aws/aws-sdk-go-v2/service/sso"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+)
+
+// networkConfig is what calls need from [awsnet.NetworkOptions] once they were validated
+type networkConfig struct {
+	httpClient *http.Client
+	endpoints  map[awsnet.AwsRegion]awsnet.Endpoints
+}
+
+func newNetworkConfig(options awsnet.NetworkOptions) (*networkConfig, error) {
+	httpClient, err := awsnet.NewHttpClient(options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make(map[awsnet.AwsRegion]awsnet.Endpoints, len(options.Endpoints))
+
+	for region, regionEndpoints := range options.Endpoints {
+		endpoints[region] = regionEndpoints
+	}
+
+	return &networkConfig{
+		httpClient: httpClient,
+		endpoints:  endpoints,
+	}, nil
+}
+
+// Configure applies network options to the calls that start from now on.
+func (c *awsSsoClientImpl) Configure(options awsnet.NetworkOptions) error {
+	network, err := newNetworkConfig(options)
+
+	if err != nil {
+		return err
+	}
+
+	c.networkMu.Lock()
+	defer c.networkMu.Unlock()
+
+	c.network = network
+
+	return nil
+}
+
+func (c *awsSsoClientImpl) currentNetwork() *networkConfig {
+	c.networkMu.RLock()
+	defer c.networkMu.RUnlock()
+
+	return c.network
+}
+
+// oidcOptions points a call to the SSO OIDC service at a region
+func (c *awsSsoClientImpl) oidcOptions(region awsnet.AwsRegion) func(options *ssooidc.Options) {
+	network := c.currentNetwork()
+
+	return func(options *ssooidc.Options) {
+		options.Region = string(region)
+		options.HTTPClient = network.httpClient
+
+		if endpoint := network.endpoints[region].Oidc; endpoint != "" {
+			options.BaseEndpoint = &endpoint
+		} else if options.BaseEndpoint == nil {
+			endpoint := OidcEndpoint(region)
+			options.BaseEndpoint = &endpoint
+		}
+	}
+}
+
+// ssoOptions points a call to the SSO portal service at a region
+func (c *awsSsoClientImpl) ssoOptions(region awsnet.AwsRegion) func(options *sso.Options) {
+	network := c.currentNetwork()
+
+	return func(options *sso.Options) {
+		options.Region = string(region)
+		options.HTTPClient = network.httpClient
+
+		if endpoint := network.endpoints[region].Portal; endpoint != "" {
+			options.BaseEndpoint = &endpoint
+		} else if options.BaseEndpoint == nil {
+			endpoint := PortalEndpoint(region)
+			options.BaseEndpoint = &endpoint
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package awssso
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/require"
+)
+
+func respondWithRoleCredentials(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"roleCredentials":{"accessKeyId":"test-access-key-id","secretAccessKey":"test-secret-access-key","sessionToken":"test-session-token","expiration":100}}`))
+}
+
+func newNetworkTestClient(t *testing.T, network awsnet.NetworkOptions) *awsSsoClientImpl {
+	client, err := newAwsSsoClient(aws.Config{}, awsnet.ClientOptions{
+		Retry:   awsnet.RetryPolicy{MaxAttempts: 1},
+		Network: network,
+	}, testhelpers.NewMockClock())
+	require.NoError(t, err)
+
+	return client
+}
+
+func TestNetwork_EndpointOverride(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(respondWithRoleCredentials))
+	t.Cleanup(server.Close)
+
+	client := newNetworkTestClient(t, awsnet.NetworkOptions{
+		Endpoints: map[awsnet.AwsRegion]awsnet.Endpoints{
+			"eu-west-1": {Portal: server.URL, Oidc: "https://vpce-test.oidc.eu-west-1.vpce.amazonaws.com/"},
+		},
+	})
+
+	credentials, err := client.GetRoleCredentials(testhelpers.NewMockAppContext(), "eu-west-1", "test-account-id", "test-role", "test-access-token")
+	require.NoError(t, err)
+	require.Equal(t, "test-access-key-id", credentials.AccessKeyId)
+
+	require.True(t, strings.HasPrefix(client.AuthorizationUrl("eu-west-1", "id", "uri", "state", "challenge"), "https://vpce-test.oidc.eu-west-1.vpce.amazonaws.com/authorize?"))
+	require.True(t, strings.HasPrefix(client.AuthorizationUrl("us-east-1", "id", "uri", "state", "challenge"), "https://oidc.us-east-1.amazonaws.com/authorize?"))
+}
+
+func TestNetwork_ExplicitProxy(t *testing.T) {
+	var proxiedHost string
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedHost = r.Host
+		respondWithRoleCredentials(w, r)
+	}))
+	t.Cleanup(proxy.Close)
+
+	client := newNetworkTestClient(t, awsnet.NetworkOptions{
+		ProxyMode: awsnet.ProxyExplicit,
+		ProxyUrl:  proxy.URL,
+		Endpoints: map[awsnet.AwsRegion]awsnet.Endpoints{
+			"eu-west-1": {Portal: "http://portal.sso.eu-west-1.example.internal"},
+		},
+	})
+
+	_, err := client.GetRoleCredentials(testhelpers.NewMockAppContext(), "eu-west-1", "test-account-id", "test-role", "test-access-token")
+	require.NoError(t, err)
+	require.Equal(t, "portal.sso.eu-west-1.example.internal", proxiedHost)
+}
+
+func TestNetwork_CaBundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(respondWithRoleCredentials))
+	t.Cleanup(server.Close)
+
+	endpoints := map[awsnet.AwsRegion]awsnet.Endpoints{
+		"eu-west-1": {Portal: server.URL},
+	}
+
+	ctx := testhelpers.NewMockAppContext()
+
+	client := newNetworkTestClient(t, awsnet.NetworkOptions{Endpoints: endpoints})
+
+	_, err := client.GetRoleCredentials(ctx, "eu-west-1", "test-account-id", "test-role", "test-access-token")
+	require.Error(t, err, "certificates of unknown authorities must not be trusted")
+
+	caBundlePem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	err = client.Configure(awsnet.NetworkOptions{Endpoints: endpoints, CaBundlePem: string(caBundlePem)})
+	require.NoError(t, err)
+
+	_, err = client.GetRoleCredentials(ctx, "eu-west-1", "test-account-id", "test-role", "test-access-token")
+	require.NoError(t, err)
+}
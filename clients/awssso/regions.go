@@ -0,0 +1,201 @@
+package awssso
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/internal/app"
+)
+
+var (
+	ErrInvalidCustomRegion = app.NewValidationError("INVALID_CUSTOM_AWS_REGION")
+	ErrRegionAlreadyExists = app.NewValidationError("AWS_REGION_ALREADY_EXISTS")
+	ErrRegionNotCustom     = app.NewValidationError("AWS_REGION_NOT_CUSTOM")
+)
+
+type Partition string
+
+const (
+	PartitionAws      Partition = "aws"
+	PartitionAwsCn    Partition = "aws-cn"
+	PartitionAwsUsGov Partition = "aws-us-gov"
+)
+
+// dnsSuffixes of the service endpoints of each partition
+var dnsSuffixes = map[Partition]string{
+	PartitionAws:      "amazonaws.com",
+	PartitionAwsCn:    "amazonaws.com.cn",
+	PartitionAwsUsGov: "amazonaws.com",
+}
+
+type Region struct {
+	Code      awsnet.AwsRegion `json:"code"`
+	Name      string           `json:"name"`
+	Partition Partition        `json:"partition"`
+	// Custom is set for regions added by the user, which are not known to Swervo yet
+	Custom bool `json:"custom"`
+}
+
+func builtinRegion(code awsnet.AwsRegion, name string) Region {
+	return Region{Code: code, Name: name, Partition: PartitionOf(code)}
+}
+
+var builtinRegions = []Region{
+	builtinRegion("us-east-1", "US East (N. Virginia)"),
+	builtinRegion("us-east-2", "US East (Ohio)"),
+	builtinRegion("us-west-1", "US West (N. California)"),
+	builtinRegion("us-west-2", "US West (Oregon)"),
+	builtinRegion("af-south-1", "Africa (Cape Town)"),
+	builtinRegion("ap-east-1", "Asia Pacific (Hong Kong)"),
+	builtinRegion("ap-south-1", "Asia Pacific (Mumbai)"),
+	builtinRegion("ap-south-2", "Asia Pacific (Hyderabad)"),
+	builtinRegion("ap-northeast-3", "Asia Pacific (Osaka)"),
+	builtinRegion("ap-northeast-2", "Asia Pacific (Seoul)"),
+	builtinRegion("ap-southeast-1", "Asia Pacific (Singapore)"),
+	builtinRegion("ap-southeast-2", "Asia Pacific (Sydney)"),
+	builtinRegion("ap-southeast-3", "Asia Pacific (Jakarta)"),
+	builtinRegion("ap-southeast-4", "Asia Pacific (Melbourne)"),
+	builtinRegion("ap-northeast-1", "Asia Pacific (Tokyo)"),
+	builtinRegion("ca-central-1", "Canada (Central)"),
+	builtinRegion("ca-west-1", "Canada West (Calgary)"),
+	builtinRegion("eu-central-1", "Europe (Frankfurt)"),
+	builtinRegion("eu-central-2", "Europe (Zurich)"),
+	builtinRegion("eu-west-1", "Europe (Ireland)"),
+	builtinRegion("eu-west-2", "Europe (London)"),
+	builtinRegion("eu-south-1", "Europe (Milan)"),
+	builtinRegion("eu-west-3", "Europe (Paris)"),
+	builtinRegion("eu-south-2", "Europe (Spain)"),
+	builtinRegion("eu-north-1", "Europe (Stockholm)"),
+	builtinRegion("il-central-1", "Israel (Tel Aviv)"),
+	builtinRegion("me-south-1", "Middle East (Bahrain)"),
+	builtinRegion("me-central-1", "Middle East (UAE)"),
+	builtinRegion("sa-east-1", "South America (São Paulo)"),
+	builtinRegion("cn-north-1", "China (Beijing)"),
+	builtinRegion("cn-northwest-1", "China (Ningxia)"),
+	builtinRegion("us-gov-east-1", "AWS GovCloud (US-East)"),
+	builtinRegion("us-gov-west-1", "AWS GovCloud (US-West)"),
+}
+
+var regionCodePattern = regexp.MustCompile(`^[a-z]{2,4}(-[a-z]+)+-[0-9]{1,2}$`)
+
+// PartitionOf tells the partition of a region from its code, including regions that are not in any catalog.
+func PartitionOf(region awsnet.AwsRegion) Partition {
+	switch {
+	case strings.HasPrefix(string(region), "cn-"):
+		return PartitionAwsCn
+	case strings.HasPrefix(string(region), "us-gov-"):
+		return PartitionAwsUsGov
+	default:
+		return PartitionAws
+	}
+}
+
+// OidcEndpoint is the endpoint of the SSO OIDC service in a region.
+func OidcEndpoint(region awsnet.AwsRegion) string {
+	return fmt.Sprintf("https://oidc.%s.%s", region, dnsSuffixes[PartitionOf(region)])
+}
+
+// PortalEndpoint is the endpoint of the SSO portal service in a region.
+func PortalEndpoint(region awsnet.AwsRegion) string {
+	return fmt.Sprintf("https://portal.sso.%s.%s", region, dnsSuffixes[PartitionOf(region)])
+}
+
+// RegionCatalog knows the regions IAM Identity Center instances can be homed in.
+// On top of the regions Swervo ships with, users can add regions that were launched since.
+type RegionCatalog struct {
+	mu     sync.RWMutex
+	custom map[awsnet.AwsRegion]Region
+}
+
+func NewRegionCatalog() *RegionCatalog {
+	return &RegionCatalog{
+		custom: make(map[awsnet.AwsRegion]Region),
+	}
+}
+
+func (c *RegionCatalog) Lookup(code string) (Region, bool) {
+	for _, region := range builtinRegions {
+		if string(region.Code) == code {
+			return region, true
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	region, ok := c.custom[awsnet.AwsRegion(code)]
+
+	return region, ok
+}
+
+// List returns every region grouped by partition, built-in regions first.
+func (c *RegionCatalog) List() []Region {
+	c.mu.RLock()
+
+	regions := make([]Region, 0, len(builtinRegions)+len(c.custom))
+	regions = append(regions, builtinRegions...)
+
+	for _, region := range c.custom {
+		regions = append(regions, region)
+	}
+
+	c.mu.RUnlock()
+
+	partitionOrder := map[Partition]int{PartitionAws: 0, PartitionAwsCn: 1, PartitionAwsUsGov: 2}
+
+	sort.SliceStable(regions, func(i, j int) bool {
+		if regions[i].Partition != regions[j].Partition {
+			return partitionOrder[regions[i].Partition] < partitionOrder[regions[j].Partition]
+		}
+
+		if regions[i].Custom != regions[j].Custom {
+			return !regions[i].Custom
+		}
+
+		return regions[i].Custom && regions[i].Code < regions[j].Code
+	})
+
+	return regions
+}
+
+// AddCustomRegion makes a region that Swervo does not know about valid, its partition is derived from its code.
+func (c *RegionCatalog) AddCustomRegion(code string) (Region, error) {
+	if !regionCodePattern.MatchString(code) {
+		return Region{}, ErrInvalidCustomRegion
+	}
+
+	if _, ok := c.Lookup(code); ok {
+		return Region{}, ErrRegionAlreadyExists
+	}
+
+	region := Region{
+		Code:      awsnet.AwsRegion(code),
+		Name:      code,
+		Partition: PartitionOf(awsnet.AwsRegion(code)),
+		Custom:    true,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.custom[region.Code] = region
+
+	return region, nil
+}
+
+func (c *RegionCatalog) RemoveCustomRegion(code string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.custom[awsnet.AwsRegion(code)]; !ok {
+		return ErrRegionNotCustom
+	}
+
+	delete(c.custom, awsnet.AwsRegion(code))
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package awssso
+
+import (
+	"testing"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionOf(t *testing.T) {
+	require.Equal(t, PartitionAws, PartitionOf("eu-central-2"))
+	require.Equal(t, PartitionAwsCn, PartitionOf("cn-northwest-1"))
+	require.Equal(t, PartitionAwsUsGov, PartitionOf("us-gov-west-1"))
+	require.Equal(t, PartitionAws, PartitionOf("us-east-1"))
+}
+
+func TestEndpoints(t *testing.T) {
+	require.Equal(t, "https://oidc.eu-west-1.amazonaws.com", OidcEndpoint("eu-west-1"))
+	require.Equal(t, "https://portal.sso.eu-west-1.amazonaws.com", PortalEndpoint("eu-west-1"))
+	require.Equal(t, "https://oidc.cn-north-1.amazonaws.com.cn", OidcEndpoint("cn-north-1"))
+	require.Equal(t, "https://portal.sso.cn-north-1.amazonaws.com.cn", PortalEndpoint("cn-north-1"))
+	require.Equal(t, "https://oidc.us-gov-east-1.amazonaws.com", OidcEndpoint("us-gov-east-1"))
+}
+
+func TestRegionCatalog_Lookup(t *testing.T) {
+	catalog := NewRegionCatalog()
+
+	for _, code := range []string{"ap-south-2", "ap-southeast-3", "ap-southeast-4", "eu-central-2", "eu-south-2", "il-central-1", "me-central-1", "ca-west-1", "us-gov-west-1", "cn-north-1"} {
+		_, ok := catalog.Lookup(code)
+		require.True(t, ok, "region [%s] must be known", code)
+	}
+
+	region, ok := catalog.Lookup("us-gov-east-1")
+	require.True(t, ok)
+	require.Equal(t, Region{Code: "us-gov-east-1", Name: "AWS GovCloud (US-East)", Partition: PartitionAwsUsGov}, region)
+
+	_, ok = catalog.Lookup("region_mars")
+	require.False(t, ok)
+}
+
+func TestRegionCatalog_CustomRegions(t *testing.T) {
+	catalog := NewRegionCatalog()
+
+	region, err := catalog.AddCustomRegion("cn-south-1")
+	require.NoError(t, err)
+	require.Equal(t, Region{Code: "cn-south-1", Name: "cn-south-1", Partition: PartitionAwsCn, Custom: true}, region)
+
+	_, err = catalog.AddCustomRegion("ap-southeast-7")
+	require.NoError(t, err)
+
+	_, ok := catalog.Lookup("cn-south-1")
+	require.True(t, ok)
+
+	_, err = catalog.AddCustomRegion("cn-south-1")
+	require.Same(t, ErrRegionAlreadyExists, err)
+
+	_, err = catalog.AddCustomRegion("eu-west-1")
+	require.Same(t, ErrRegionAlreadyExists, err)
+
+	for _, invalid := range []string{"", "mars", "EU-WEST-9", "eu_west_9", "eu-west-", "https://eu-west-9"} {
+		_, err = catalog.AddCustomRegion(invalid)
+		require.Same(t, ErrInvalidCustomRegion, err, "region [%s] must be rejected", invalid)
+	}
+
+	regions := catalog.List()
+	require.Len(t, regions, len(builtinRegions)+2)
+
+	indexOf := func(code awsnet.AwsRegion) int {
+		for i, region := range regions {
+			if region.Code == code {
+				return i
+			}
+		}
+
+		return -1
+	}
+
+	require.Less(t, indexOf("sa-east-1"), indexOf("ap-southeast-7"), "custom regions come after built-in ones of the same partition")
+	require.Less(t, indexOf("ap-southeast-7"), indexOf("cn-north-1"))
+	require.Less(t, indexOf("cn-northwest-1"), indexOf("cn-south-1"))
+	require.Less(t, indexOf("cn-south-1"), indexOf("us-gov-east-1"))
+
+	require.NoError(t, catalog.RemoveCustomRegion("cn-south-1"))
+	require.Same(t, ErrRegionNotCustom, catalog.RemoveCustomRegion("cn-south-1"))
+	require.Same(t, ErrRegionNotCustom, catalog.RemoveCustomRegion("eu-west-1"))
+
+	_, ok = catalog.Lookup("cn-south-1")
+	require.False(t, ok)
+}
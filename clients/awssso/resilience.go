@@ -0,0 +1,23 @@
+package awssso
+
+import (
+	"errors"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	ssotypes "github.com/aws/aws-sdk-go-v2/service/sso/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc/types"
+)
+
+// ssoErrors recognizes the throttling and internal errors of the SSO OIDC and SSO portal services
+var ssoErrors = awsnet.ServiceErrors{
+	IsThrottled: func(err error) bool {
+		var tmre *ssotypes.TooManyRequestsException
+
+		return errors.As(err, &tmre)
+	},
+	IsTransient: func(err error) bool {
+		var ise *types.InternalServerException
+
+		return errors.As(err, &ise)
+	},
+}
@@ -0,0 +1,232 @@
+package awssso
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer stands in for the AWS SSO endpoints of every region, answering each request with respond.
+func newTestServer(t *testing.T, respond func(w http.ResponseWriter, attempt int32)) (*httptest.Server, *atomic.Int32) {
+	var attempts atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		respond(w, attempts.Add(1))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &attempts
+}
+
+// withoutBackoff retries right away so that tests do not wait between attempts
+func withoutBackoff(options awsnet.ClientOptions) awsnet.ClientOptions {
+	options.Retry.BaseDelay = 0
+	options.Retry.MaxDelay = 0
+
+	return options
+}
+
+func newTestClient(t *testing.T, server *httptest.Server, options awsnet.ClientOptions, clock *testhelpers.MockClock) *awsSsoClientImpl {
+	client, err := newAwsSsoClient(aws.Config{BaseEndpoint: aws.String(server.URL)}, withoutBackoff(options), clock)
+	require.NoError(t, err)
+
+	return client
+}
+
+func respondWithError(w http.ResponseWriter, status int, errorType string) {
+	if errorType != "" {
+		w.Header().Set("X-Amzn-ErrorType", errorType)
+	}
+
+	w.WriteHeader(status)
+	w.Write([]byte(`{"message":"test failure"}`))
+}
+
+func respondWithClient(w http.ResponseWriter) {
+	w.Write([]byte(`{"clientId":"test-client-id","clientSecret":"test-client-secret","clientIdIssuedAt":1,"clientSecretExpiresAt":20}`))
+}
+
+func TestInvoke_RetriesServerErrors(t *testing.T) {
+	server, attempts := newTestServer(t, func(w http.ResponseWriter, attempt int32) {
+		if attempt < 3 {
+			respondWithError(w, http.StatusInternalServerError, "InternalServerException")
+			return
+		}
+
+		respondWithClient(w)
+	})
+
+	client := newTestClient(t, server, awsnet.DefaultClientOptions, testhelpers.NewMockClock())
+
+	registration, err := client.RegisterClient(testhelpers.NewMockAppContext(), "eu-west-1", "test-client")
+	require.NoError(t, err)
+	require.Equal(t, "test-client-id", registration.ClientId)
+	require.Equal(t, int32(3), attempts.Load())
+}
+
+func TestInvoke_RetriesThrottling(t *testing.T) {
+	server, attempts := newTestServer(t, func(w http.ResponseWriter, attempt int32) {
+		if attempt == 1 {
+			respondWithError(w, http.StatusTooManyRequests, "TooManyRequestsException")
+			return
+		}
+
+		w.Write([]byte(`{"roleCredentials":{"accessKeyId":"test-access-key-id","secretAccessKey":"test-secret-access-key","sessionToken":"test-session-token","expiration":100}}`))
+	})
+
+	client := newTestClient(t, server, awsnet.DefaultClientOptions, testhelpers.NewMockClock())
+
+	credentials, err := client.GetRoleCredentials(testhelpers.NewMockAppContext(), "eu-west-1", "test-account-id", "test-role", "test-access-token")
+	require.NoError(t, err)
+	require.Equal(t, "test-access-key-id", credentials.AccessKeyId)
+	require.Equal(t, int32(2), attempts.Load())
+}
+
+func TestInvoke_DoesNotRetryServiceAnswers(t *testing.T) {
+	server, attempts := newTestServer(t, func(w http.ResponseWriter, attempt int32) {
+		respondWithError(w, http.StatusBadRequest, "AuthorizationPendingException")
+	})
+
+	client := newTestClient(t, server, awsnet.DefaultClientOptions, testhelpers.NewMockClock())
+
+	_, err := client.CreateToken(testhelpers.NewMockAppContext(), "eu-west-1", "test-client-id", "test-client-secret", "test-user-code", "test-device-code")
+	require.ErrorIs(t, err, ErrDeviceFlowNotAuthorized)
+	require.Equal(t, int32(1), attempts.Load())
+}
+
+func TestInvoke_DoesNotRetryAmbiguousFailuresOfTokenExchanges(t *testing.T) {
+	server, attempts := newTestServer(t, func(w http.ResponseWriter, attempt int32) {
+		respondWithError(w, http.StatusInternalServerError, "InternalServerException")
+	})
+
+	client := newTestClient(t, server, awsnet.DefaultClientOptions, testhelpers.NewMockClock())
+
+	_, err := client.RefreshToken(testhelpers.NewMockAppContext(), "eu-west-1", "test-client-id", "test-client-secret", "test-refresh-token")
+	require.Error(t, err)
+	require.Equal(t, int32(1), attempts.Load())
+}
+
+func TestInvoke_RetriesThrottledTokenExchanges(t *testing.T) {
+	server, attempts := newTestServer(t, func(w http.ResponseWriter, attempt int32) {
+		if attempt == 1 {
+			respondWithError(w, http.StatusTooManyRequests, "")
+			return
+		}
+
+		w.Write([]byte(`{"accessToken":"test-access-token","expiresIn":3600,"refreshToken":"test-refresh-token","tokenType":"Bearer"}`))
+	})
+
+	client := newTestClient(t, server, awsnet.DefaultClientOptions, testhelpers.NewMockClock())
+
+	token, err := client.CreateToken(testhelpers.NewMockAppContext(), "eu-west-1", "test-client-id", "test-client-secret", "test-user-code", "test-device-code")
+	require.NoError(t, err)
+	require.Equal(t, "test-access-token", token.AccessToken)
+	require.Equal(t, int32(2), attempts.Load())
+}
+
+func TestInvoke_GivesUpAfterMaxAttempts(t *testing.T) {
+	server, attempts := newTestServer(t, func(w http.ResponseWriter, attempt int32) {
+		respondWithError(w, http.StatusServiceUnavailable, "")
+	})
+
+	client := newTestClient(t, server, awsnet.DefaultClientOptions, testhelpers.NewMockClock())
+
+	_, err := client.RegisterClient(testhelpers.NewMockAppContext(), "eu-west-1", "test-client")
+	require.Error(t, err)
+	require.True(t, ssoErrors.Retryable(err))
+	require.Equal(t, int32(awsnet.DefaultRetryPolicy.MaxAttempts), attempts.Load())
+}
+
+func TestInvoke_HangingEndpoint(t *testing.T) {
+	hang := make(chan struct{})
+
+	server, attempts := newTestServer(t, func(w http.ResponseWriter, attempt int32) {
+		<-hang
+	})
+	t.Cleanup(func() { close(hang) })
+
+	clock := testhelpers.NewMockClock()
+	clock.On("NowUnix").Return(10)
+
+	network := awsnet.DefaultNetworkOptions
+	network.RequestTimeout = 50 * time.Millisecond
+
+	client := newTestClient(t, server, awsnet.ClientOptions{
+		Retry:          awsnet.RetryPolicy{MaxAttempts: 2},
+		CircuitBreaker: awsnet.CircuitBreakerPolicy{FailureThreshold: 2, OpenDuration: 30 * time.Second},
+		Network:        network,
+	}, clock)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	for i := 0; i < 2; i++ {
+		_, err := client.RegisterClient(ctx, "eu-west-1", "test-client")
+		require.Error(t, err)
+		require.NotErrorIs(t, err, awsnet.ErrCircuitOpen)
+	}
+
+	require.Equal(t, int32(4), attempts.Load(), "attempts that time out must be retried")
+
+	_, err := client.RegisterClient(ctx, "eu-west-1", "test-client")
+	require.ErrorIs(t, err, awsnet.ErrCircuitOpen, "calls that time out must count as failures of the endpoint")
+
+	_, err = client.RefreshToken(ctx, "us-east-1", "test-client-id", "test-client-secret", "test-refresh-token")
+	require.Error(t, err)
+	require.Equal(t, int32(5), attempts.Load(), "token exchanges that time out may have been acted on and must not be retried")
+}
+
+func TestInvoke_CircuitBreaker(t *testing.T) {
+	healthy := atomic.Bool{}
+
+	server, attempts := newTestServer(t, func(w http.ResponseWriter, attempt int32) {
+		if !healthy.Load() {
+			respondWithError(w, http.StatusServiceUnavailable, "")
+			return
+		}
+
+		respondWithClient(w)
+	})
+
+	clock := testhelpers.NewMockClock()
+	clock.On("NowUnix").Return(10)
+
+	client := newTestClient(t, server, awsnet.ClientOptions{
+		Retry:          awsnet.RetryPolicy{MaxAttempts: 1},
+		CircuitBreaker: awsnet.CircuitBreakerPolicy{FailureThreshold: 2, OpenDuration: 30 * time.Second},
+	}, clock)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	for i := 0; i < 2; i++ {
+		_, err := client.RegisterClient(ctx, "eu-west-1", "test-client")
+		require.Error(t, err)
+		require.NotErrorIs(t, err, awsnet.ErrCircuitOpen)
+	}
+
+	_, err := client.RegisterClient(ctx, "eu-west-1", "test-client")
+	require.ErrorIs(t, err, awsnet.ErrCircuitOpen)
+	require.Equal(t, int32(2), attempts.Load(), "an open circuit must fail fast")
+
+	healthy.Store(true)
+
+	_, err = client.RegisterClient(ctx, "us-east-1", "test-client")
+	require.NoError(t, err, "circuits of other regions are not affected")
+
+	clock.ExpectedCalls = nil
+	clock.On("NowUnix").Return(40)
+
+	_, err = client.RegisterClient(ctx, "eu-west-1", "test-client")
+	require.NoError(t, err, "a trial call must be let through once the circuit was open long enough")
+
+	_, err = client.RegisterClient(ctx, "eu-west-1", "test-client")
+	require.NoError(t, err)
+	require.Equal(t, int32(5), attempts.Load())
+}
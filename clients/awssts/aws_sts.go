@@ -0,0 +1,264 @@
+package awssts
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
+)
+
+var (
+	ErrInvalidClientToken = errors.New("access key is invalid or does not match its secret")
+	ErrAccessDenied       = errors.New("access denied")
+	ErrExpiredToken       = errors.New("security token expired")
+	ErrRegionDisabled     = errors.New("sts is not activated in region")
+	ErrMalformedPolicy    = errors.New("session policy is malformed")
+	ErrPolicyTooLarge     = errors.New("session policy and tags are too large")
+	ErrInvalidRequest     = errors.New("request is not valid")
+)
+
+// Credentials are the keys calls to STS are signed with and the temporary keys it returns.
+type Credentials struct {
+	AccessKeyId, SecretAccessKey, SessionToken string
+	// Expiration is a Unix time in seconds, zero for long-lived keys
+	Expiration int64
+}
+
+type GetSessionTokenInput struct {
+	DurationSeconds int32
+	// MfaSerial is the ARN of a virtual MFA device or the serial number of a hardware one, MFA is not used when empty
+	MfaSerial string
+	MfaCode   string
+}
+
+type AssumeRoleInput struct {
+	RoleArn         string
+	RoleSessionName string
+	// ExternalId is required by roles that third parties assume, it is not sent when empty
+	ExternalId      string
+	DurationSeconds int32
+	// Policy is an inline session policy that further restricts the permissions of the role, it is not sent when empty
+	Policy string
+	// Tags are session tags passed to the role, they show up in CloudTrail and can be used in conditions of policies
+	Tags map[string]string
+}
+
+type AwsStsClient interface {
+	GetSessionToken(ctx app.Context, region awsnet.AwsRegion, credentials Credentials, input GetSessionTokenInput) (*Credentials, error)
+
+	AssumeRole(ctx app.Context, region awsnet.AwsRegion, credentials Credentials, input AssumeRoleInput) (*Credentials, error)
+
+	Configure(options awsnet.NetworkOptions) error
+}
+
+// stsErrors recognizes the throttling errors of STS, which come as error codes of HTTP 400 responses
+var stsErrors = awsnet.ServiceErrors{
+	IsThrottled: func(err error) bool {
+		var apiErr smithy.APIError
+
+		if !errors.As(err, &apiErr) {
+			return false
+		}
+
+		switch apiErr.ErrorCode() {
+		case "Throttling", "ThrottlingException", "RequestLimitExceeded":
+			return true
+		default:
+			return false
+		}
+	},
+}
+
+type awsStsClientImpl struct {
+	stsClient *sts.Client
+
+	httpClientMu sync.RWMutex
+	httpClient   *http.Client
+
+	resilience *awsnet.Resilience
+}
+
+func NewAwsStsClient(options awsnet.ClientOptions, clock utils.Clock) (AwsStsClient, error) {
+	return newAwsStsClient(aws.Config{}, options, clock)
+}
+
+func newAwsStsClient(cfg aws.Config, options awsnet.ClientOptions, clock utils.Clock) (*awsStsClientImpl, error) {
+	httpClient, err := awsnet.NewHttpClient(options.Network)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// retries are handled by awsnet.Invoke so that they can be logged and feed the circuit breaker
+	cfg.Retryer = func() aws.Retryer {
+		return aws.NopRetryer{}
+	}
+
+	return &awsStsClientImpl{
+		stsClient:  sts.NewFromConfig(cfg),
+		httpClient: httpClient,
+		resilience: awsnet.NewResilience(options, stsErrors, clock),
+	}, nil
+}
+
+// Configure applies the proxy, CA bundle and timeout of network options to the calls that start from now on.
+func (c *awsStsClientImpl) Configure(options awsnet.NetworkOptions) error {
+	httpClient, err := awsnet.NewHttpClient(options)
+
+	if err != nil {
+		return err
+	}
+
+	c.httpClientMu.Lock()
+	defer c.httpClientMu.Unlock()
+
+	c.httpClient = httpClient
+
+	return nil
+}
+
+// stsOptions signs a call with the given credentials and points it at the STS endpoint of a region
+func (c *awsStsClientImpl) stsOptions(region awsnet.AwsRegion, credentials Credentials) func(options *sts.Options) {
+	c.httpClientMu.RLock()
+	httpClient := c.httpClient
+	c.httpClientMu.RUnlock()
+
+	return func(options *sts.Options) {
+		options.Region = string(region)
+		options.HTTPClient = httpClient
+		options.Credentials = aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{
+				AccessKeyID:     credentials.AccessKeyId,
+				SecretAccessKey: credentials.SecretAccessKey,
+				SessionToken:    credentials.SessionToken,
+			}, nil
+		})
+	}
+}
+
+func mapStsError(err error) error {
+	var ete *types.ExpiredTokenException
+
+	if errors.As(err, &ete) {
+		return ErrExpiredToken
+	}
+
+	var rde *types.RegionDisabledException
+
+	if errors.As(err, &rde) {
+		return ErrRegionDisabled
+	}
+
+	var apiErr smithy.APIError
+
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "InvalidClientTokenId", "SignatureDoesNotMatch":
+			return ErrInvalidClientToken
+		case "AccessDenied":
+			return ErrAccessDenied
+		case "ExpiredToken":
+			return ErrExpiredToken
+		case "MalformedPolicyDocument":
+			return ErrMalformedPolicy
+		case "PackedPolicyTooLarge":
+			return ErrPolicyTooLarge
+		case "ValidationError":
+			return ErrInvalidRequest
+		}
+	}
+
+	return err
+}
+
+func newCredentials(credentials *types.Credentials) *Credentials {
+	return &Credentials{
+		AccessKeyId:     aws.ToString(credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(credentials.SessionToken),
+		Expiration:      aws.ToTime(credentials.Expiration).Unix(),
+	}
+}
+
+// GetSessionToken exchanges the long-lived keys of an IAM user for temporary ones,
+// which also carry the MFA context when an MFA code is given.
+func (c *awsStsClientImpl) GetSessionToken(ctx app.Context, region awsnet.AwsRegion, credentials Credentials, input GetSessionTokenInput) (*Credentials, error) {
+	stsInput := &sts.GetSessionTokenInput{}
+
+	if input.DurationSeconds != 0 {
+		stsInput.DurationSeconds = aws.Int32(input.DurationSeconds)
+	}
+
+	if input.MfaSerial != "" {
+		stsInput.SerialNumber = aws.String(input.MfaSerial)
+		stsInput.TokenCode = aws.String(input.MfaCode)
+	}
+
+	// an MFA code cannot be used twice, so a call that carries one is only retried when STS turned it down
+	safety := awsnet.IdempotentCall
+
+	if input.MfaSerial != "" {
+		safety = awsnet.NonIdempotentCall
+	}
+
+	output, err := awsnet.Invoke(ctx, ctx.Logger(), c.resilience, region, "GetSessionToken", safety, func(ctx context.Context) (*sts.GetSessionTokenOutput, error) {
+		return c.stsClient.GetSessionToken(ctx, stsInput, c.stsOptions(region, credentials))
+	})
+
+	if err != nil {
+		return nil, mapStsError(err)
+	}
+
+	return newCredentials(output.Credentials), nil
+}
+
+// AssumeRole exchanges credentials for the temporary ones of a role, credentials of another role make it a role chain.
+func (c *awsStsClientImpl) AssumeRole(ctx app.Context, region awsnet.AwsRegion, credentials Credentials, input AssumeRoleInput) (*Credentials, error) {
+	stsInput := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(input.RoleArn),
+		RoleSessionName: aws.String(input.RoleSessionName),
+	}
+
+	if input.DurationSeconds != 0 {
+		stsInput.DurationSeconds = aws.Int32(input.DurationSeconds)
+	}
+
+	if input.ExternalId != "" {
+		stsInput.ExternalId = aws.String(input.ExternalId)
+	}
+
+	if input.Policy != "" {
+		stsInput.Policy = aws.String(input.Policy)
+	}
+
+	tagKeys := make([]string, 0, len(input.Tags))
+
+	for key := range input.Tags {
+		tagKeys = append(tagKeys, key)
+	}
+
+	sort.Strings(tagKeys)
+
+	for _, key := range tagKeys {
+		stsInput.Tags = append(stsInput.Tags, types.Tag{Key: aws.String(key), Value: aws.String(input.Tags[key])})
+	}
+
+	output, err := awsnet.Invoke(ctx, ctx.Logger(), c.resilience, region, "AssumeRole", awsnet.IdempotentCall, func(ctx context.Context) (*sts.AssumeRoleOutput, error) {
+		return c.stsClient.AssumeRole(ctx, stsInput, c.stsOptions(region, credentials))
+	})
+
+	if err != nil {
+		return nil, mapStsError(err)
+	}
+
+	return newCredentials(output.Credentials), nil
+}
@@ -0,0 +1,275 @@
+package awssts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/require"
+)
+
+const getSessionTokenResponse = `<GetSessionTokenResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetSessionTokenResult>
+    <Credentials>
+      <SessionToken>test-session-token</SessionToken>
+      <SecretAccessKey>test-session-secret</SecretAccessKey>
+      <Expiration>2023-11-14T22:13:20Z</Expiration>
+      <AccessKeyId>ASIATESTSESSIONKEY</AccessKeyId>
+    </Credentials>
+  </GetSessionTokenResult>
+  <ResponseMetadata><RequestId>test-request-id</RequestId></ResponseMetadata>
+</GetSessionTokenResponse>`
+
+func stsErrorResponse(code string) string {
+	return `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error><Type>Sender</Type><Code>` + code + `</Code><Message>test message</Message></Error>
+  <RequestId>test-request-id</RequestId>
+</ErrorResponse>`
+}
+
+// testClientOptions retry right away and never open the circuit of a region
+var testClientOptions = awsnet.ClientOptions{
+	Retry:   awsnet.RetryPolicy{MaxAttempts: 3},
+	Network: awsnet.DefaultNetworkOptions,
+}
+
+// newFlakyStsClient answers the first failures attempts with an error of the given status and code, and the later ones with body
+func newFlakyStsClient(t *testing.T, failures int32, status int, code string, body string) (*awsStsClientImpl, *atomic.Int32) {
+	var attempts atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+
+		if attempts.Add(1) <= failures {
+			w.WriteHeader(status)
+			w.Write([]byte(stsErrorResponse(code)))
+			return
+		}
+
+		w.Write([]byte(body))
+	}))
+
+	t.Cleanup(server.Close)
+
+	client, err := newAwsStsClient(aws.Config{BaseEndpoint: aws.String(server.URL)}, testClientOptions, testhelpers.NewMockClock())
+	require.NoError(t, err)
+
+	return client, &attempts
+}
+
+func newFakeStsClient(t *testing.T, status int, body string, requests chan<- http.Request) *awsStsClientImpl {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+
+		if requests != nil {
+			requests <- *r
+		}
+
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+
+	t.Cleanup(server.Close)
+
+	client, err := newAwsStsClient(aws.Config{BaseEndpoint: aws.String(server.URL)}, testClientOptions, testhelpers.NewMockClock())
+	require.NoError(t, err)
+
+	return client
+}
+
+var testUserCredentials = Credentials{AccessKeyId: "AKIATESTUSERKEY", SecretAccessKey: "test-user-secret"}
+
+func TestGetSessionToken(t *testing.T) {
+	requests := make(chan http.Request, 1)
+	client := newFakeStsClient(t, http.StatusOK, getSessionTokenResponse, requests)
+
+	credentials, err := client.GetSessionToken(testhelpers.NewMockAppContext(), "eu-west-1", testUserCredentials, GetSessionTokenInput{
+		DurationSeconds: 3600,
+		MfaSerial:       "arn:aws:iam::123456789012:mfa/test-user",
+		MfaCode:         "123456",
+	})
+	require.NoError(t, err)
+	require.Equal(t, &Credentials{
+		AccessKeyId:     "ASIATESTSESSIONKEY",
+		SecretAccessKey: "test-session-secret",
+		SessionToken:    "test-session-token",
+		Expiration:      1700000000,
+	}, credentials)
+
+	request := <-requests
+	require.Equal(t, "GetSessionToken", request.PostForm.Get("Action"))
+	require.Equal(t, "3600", request.PostForm.Get("DurationSeconds"))
+	require.Equal(t, "arn:aws:iam::123456789012:mfa/test-user", request.PostForm.Get("SerialNumber"))
+	require.Equal(t, "123456", request.PostForm.Get("TokenCode"))
+	require.True(t, strings.Contains(request.Header.Get("Authorization"), "Credential=AKIATESTUSERKEY/"), "calls must be signed with the given keys")
+}
+
+func TestGetSessionToken_WithoutMfa(t *testing.T) {
+	requests := make(chan http.Request, 1)
+	client := newFakeStsClient(t, http.StatusOK, getSessionTokenResponse, requests)
+
+	_, err := client.GetSessionToken(testhelpers.NewMockAppContext(), "eu-west-1", testUserCredentials, GetSessionTokenInput{})
+	require.NoError(t, err)
+
+	request := <-requests
+	require.Empty(t, request.PostForm.Get("DurationSeconds"))
+	require.Empty(t, request.PostForm.Get("SerialNumber"))
+	require.Empty(t, request.PostForm.Get("TokenCode"))
+}
+
+func TestStsErrors(t *testing.T) {
+	testCases := []struct {
+		code     string
+		status   int
+		expected error
+	}{
+		{code: "InvalidClientTokenId", status: http.StatusForbidden, expected: ErrInvalidClientToken},
+		{code: "SignatureDoesNotMatch", status: http.StatusForbidden, expected: ErrInvalidClientToken},
+		{code: "AccessDenied", status: http.StatusForbidden, expected: ErrAccessDenied},
+		{code: "ExpiredToken", status: http.StatusBadRequest, expected: ErrExpiredToken},
+		{code: "RegionDisabledException", status: http.StatusForbidden, expected: ErrRegionDisabled},
+		{code: "MalformedPolicyDocument", status: http.StatusBadRequest, expected: ErrMalformedPolicy},
+		{code: "PackedPolicyTooLarge", status: http.StatusBadRequest, expected: ErrPolicyTooLarge},
+		{code: "ValidationError", status: http.StatusBadRequest, expected: ErrInvalidRequest},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.code, func(t *testing.T) {
+			client := newFakeStsClient(t, testCase.status, stsErrorResponse(testCase.code), nil)
+
+			_, err := client.GetSessionToken(testhelpers.NewMockAppContext(), "eu-west-1", testUserCredentials, GetSessionTokenInput{})
+			require.ErrorIs(t, err, testCase.expected)
+
+			_, err = client.AssumeRole(testhelpers.NewMockAppContext(), "eu-west-1", testUserCredentials, AssumeRoleInput{RoleArn: "arn:aws:iam::123456789012:role/deployer", RoleSessionName: "test-session"})
+			require.ErrorIs(t, err, testCase.expected)
+		})
+	}
+}
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/deployer/test-session</Arn>
+      <AssumedRoleId>AROATESTROLEID:test-session</AssumedRoleId>
+    </AssumedRoleUser>
+    <Credentials>
+      <SessionToken>test-role-session-token</SessionToken>
+      <SecretAccessKey>test-role-secret</SecretAccessKey>
+      <Expiration>2023-11-14T22:13:20Z</Expiration>
+      <AccessKeyId>ASIATESTROLEKEY</AccessKeyId>
+    </Credentials>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>test-request-id</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`
+
+func TestAssumeRole(t *testing.T) {
+	requests := make(chan http.Request, 1)
+	client := newFakeStsClient(t, http.StatusOK, assumeRoleResponse, requests)
+
+	credentials, err := client.AssumeRole(testhelpers.NewMockAppContext(), "eu-west-1", testUserCredentials, AssumeRoleInput{
+		RoleArn:         "arn:aws:iam::123456789012:role/deployer",
+		RoleSessionName: "test-session",
+		ExternalId:      "test-external-id",
+		DurationSeconds: 900,
+		Policy:          `{"Version":"2012-10-17","Statement":[]}`,
+		Tags:            map[string]string{"team": "platform", "env": "prod"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, &Credentials{
+		AccessKeyId:     "ASIATESTROLEKEY",
+		SecretAccessKey: "test-role-secret",
+		SessionToken:    "test-role-session-token",
+		Expiration:      1700000000,
+	}, credentials)
+
+	request := <-requests
+	require.Equal(t, "AssumeRole", request.PostForm.Get("Action"))
+	require.Equal(t, "arn:aws:iam::123456789012:role/deployer", request.PostForm.Get("RoleArn"))
+	require.Equal(t, "test-session", request.PostForm.Get("RoleSessionName"))
+	require.Equal(t, "test-external-id", request.PostForm.Get("ExternalId"))
+	require.Equal(t, "900", request.PostForm.Get("DurationSeconds"))
+	require.Equal(t, `{"Version":"2012-10-17","Statement":[]}`, request.PostForm.Get("Policy"))
+	require.Equal(t, "env", request.PostForm.Get("Tags.member.1.Key"))
+	require.Equal(t, "prod", request.PostForm.Get("Tags.member.1.Value"))
+	require.Equal(t, "team", request.PostForm.Get("Tags.member.2.Key"))
+	require.Equal(t, "platform", request.PostForm.Get("Tags.member.2.Value"))
+}
+
+func TestAssumeRole_OptionalParameters(t *testing.T) {
+	requests := make(chan http.Request, 1)
+	client := newFakeStsClient(t, http.StatusOK, assumeRoleResponse, requests)
+
+	_, err := client.AssumeRole(testhelpers.NewMockAppContext(), "eu-west-1", testUserCredentials, AssumeRoleInput{
+		RoleArn:         "arn:aws:iam::123456789012:role/deployer",
+		RoleSessionName: "test-session",
+	})
+	require.NoError(t, err)
+
+	request := <-requests
+	require.NotContains(t, request.PostForm, "ExternalId")
+	require.NotContains(t, request.PostForm, "DurationSeconds")
+	require.NotContains(t, request.PostForm, "Policy")
+	require.NotContains(t, request.PostForm, "Tags")
+}
+
+func TestRetries(t *testing.T) {
+	ctx := testhelpers.NewMockAppContext()
+	assumeRoleInput := AssumeRoleInput{RoleArn: "arn:aws:iam::123456789012:role/deployer", RoleSessionName: "test-session"}
+
+	client, attempts := newFlakyStsClient(t, 1, http.StatusBadRequest, "Throttling", assumeRoleResponse)
+	_, err := client.AssumeRole(ctx, "eu-west-1", testUserCredentials, assumeRoleInput)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), attempts.Load())
+
+	client, attempts = newFlakyStsClient(t, 1, http.StatusServiceUnavailable, "ServiceUnavailable", assumeRoleResponse)
+	_, err = client.AssumeRole(ctx, "eu-west-1", testUserCredentials, assumeRoleInput)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), attempts.Load())
+
+	client, attempts = newFlakyStsClient(t, 3, http.StatusServiceUnavailable, "ServiceUnavailable", assumeRoleResponse)
+	_, err = client.AssumeRole(ctx, "eu-west-1", testUserCredentials, assumeRoleInput)
+	require.Error(t, err)
+	require.Equal(t, int32(testClientOptions.Retry.MaxAttempts), attempts.Load())
+
+	client, attempts = newFlakyStsClient(t, 1, http.StatusForbidden, "AccessDenied", assumeRoleResponse)
+	_, err = client.AssumeRole(ctx, "eu-west-1", testUserCredentials, assumeRoleInput)
+	require.ErrorIs(t, err, ErrAccessDenied)
+	require.Equal(t, int32(1), attempts.Load(), "answers of STS must not be retried")
+}
+
+func TestRetries_MfaCodeIsNotReused(t *testing.T) {
+	ctx := testhelpers.NewMockAppContext()
+	input := GetSessionTokenInput{MfaSerial: "arn:aws:iam::123456789012:mfa/test-user", MfaCode: "123456"}
+
+	client, attempts := newFlakyStsClient(t, 1, http.StatusServiceUnavailable, "ServiceUnavailable", getSessionTokenResponse)
+	_, err := client.GetSessionToken(ctx, "eu-west-1", testUserCredentials, input)
+	require.Error(t, err)
+	require.Equal(t, int32(1), attempts.Load(), "STS may have consumed the MFA code before failing")
+
+	client, attempts = newFlakyStsClient(t, 1, http.StatusBadRequest, "Throttling", getSessionTokenResponse)
+	_, err = client.GetSessionToken(ctx, "eu-west-1", testUserCredentials, input)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), attempts.Load())
+
+	client, attempts = newFlakyStsClient(t, 1, http.StatusServiceUnavailable, "ServiceUnavailable", getSessionTokenResponse)
+	_, err = client.GetSessionToken(ctx, "eu-west-1", testUserCredentials, GetSessionTokenInput{})
+	require.NoError(t, err)
+	require.Equal(t, int32(2), attempts.Load(), "calls without an MFA code are safe to retry")
+}
+
+func TestConfigure(t *testing.T) {
+	client, err := newAwsStsClient(aws.Config{}, testClientOptions, testhelpers.NewMockClock())
+	require.NoError(t, err)
+
+	err = client.Configure(awsnet.NetworkOptions{ProxyMode: awsnet.ProxyExplicit, ProxyUrl: "ftp://proxy"})
+	require.Same(t, awsnet.ErrInvalidProxyUrl, err)
+
+	err = client.Configure(awsnet.NetworkOptions{ProxyMode: awsnet.ProxyNone})
+	require.NoError(t, err)
+}
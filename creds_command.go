@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/abjrcode/swervo/credsserver"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/credsformat"
+	"github.com/abjrcode/swervo/internal/security/vault"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+	"golang.org/x/term"
+)
+
+// credsCommandName is the argument that runs Swervo headless as an AWS credential_process, e.g.
+//
+//	credential_process = swervo creds --instance X --account Y --role Z
+const credsCommandName = "creds"
+
+// vaultPasswordPrompt is shown on the terminal when the vault has to be unlocked by the creds command itself
+const vaultPasswordPrompt = "Swervo vault password: "
+
+// errNoTerminal means that there is no terminal to prompt for the password of the vault on
+var errNoTerminal = errors.New("NO_TERMINAL")
+
+// Exit codes of the creds command, AWS SDKs surface them along with what was written to stderr
+const (
+	credsExitOk = iota
+	credsExitFailure
+	credsExitUsage
+	credsExitVaultLocked
+	credsExitStaleAccessToken
+	credsExitTransientError
+	credsExitNotFound
+)
+
+// credsCommandApp is the running Swervo app, which hands out credentials while its vault is open
+type credsCommandApp interface {
+	GetRoleCredentials(ctx app.Context, instanceId, accountId, roleName string) (*awsidc.RoleCredentials, error)
+}
+
+type credsCommandVault interface {
+	Open(ctx app.Context, plainPassword string) (bool, error)
+}
+
+type credsCommandSource interface {
+	FormatRoleCredentials(ctx app.Context, input awsidc.AwsIdc_CopyRoleCredentialsCommandInput) (string, error)
+}
+
+// credsCommandEnv is what the creds command talks to
+type credsCommandEnv struct {
+	runningApp credsCommandApp
+	vault      credsCommandVault
+	source     credsCommandSource
+	// readPassword prompts for the password of the vault, it fails with errNoTerminal when there is no terminal
+	readPassword func() (string, error)
+
+	stdout, stderr io.Writer
+}
+
+func isCredsCommand(args []string) bool {
+	return len(args) > 1 && args[1] == credsCommandName
+}
+
+// runCredsCommand prints the credentials of a role as credential_process output and returns the exit code of the process.
+// Credentials come from the running app when its vault is open, otherwise the vault is unlocked with a password
+// read from --password-file or prompted for on the terminal.
+func runCredsCommand(ctx app.Context, args []string, env credsCommandEnv) int {
+	flags := flag.NewFlagSet(credsCommandName, flag.ContinueOnError)
+	flags.SetOutput(env.stderr)
+
+	instanceId := flags.String("instance", "", "ID of the AWS IAM Identity Center instance")
+	accountId := flags.String("account", "", "ID of the AWS account")
+	roleName := flags.String("role", "", "name of the role to get credentials of")
+	passwordFile := flags.String("password-file", "", "file holding the password of the vault, used when Swervo is not running with an unlocked vault")
+
+	if err := flags.Parse(args); err != nil {
+		return credsExitUsage
+	}
+
+	if *instanceId == "" || *accountId == "" || *roleName == "" || flags.NArg() > 0 {
+		fmt.Fprintf(env.stderr, "usage: swervo %s --instance <instance-id> --account <account-id> --role <role-name> [--password-file <path>]\n", credsCommandName)
+		return credsExitUsage
+	}
+
+	credentials, err := env.runningApp.GetRoleCredentials(ctx, *instanceId, *accountId, *roleName)
+
+	if err == nil {
+		output, err := credsformat.NewRegistry().Format(credsformat.FormatCredentialProcess, credsformat.Credentials{
+			AccessKeyId:     credentials.AccessKeyId,
+			SecretAccessKey: credentials.SecretAccessKey,
+			SessionToken:    credentials.SessionToken,
+			Expiration:      credentials.ExpiresAt,
+		})
+
+		if err != nil {
+			return credsCommandError(ctx, err, env.stderr)
+		}
+
+		fmt.Fprintln(env.stdout, output)
+
+		return credsExitOk
+	}
+
+	if !app.IsError(err, credsserver.ErrAppNotRunning) {
+		return credsCommandError(ctx, err, env.stderr)
+	}
+
+	password, err := readVaultPassword(*passwordFile, env.readPassword)
+
+	if err != nil {
+		if app.IsError(err, errNoTerminal) {
+			fmt.Fprintln(env.stderr, "the vault is locked, unlock Swervo or pass --password-file")
+		} else {
+			fmt.Fprintf(env.stderr, "failed to read the password of the vault: %s\n", err)
+		}
+
+		return credsExitVaultLocked
+	}
+
+	unlocked, err := env.vault.Open(ctx, password)
+
+	if err != nil {
+		if errors.Is(err, vault.ErrVaultNotConfigured) {
+			fmt.Fprintln(env.stderr, "the vault is not configured yet, set it up by launching Swervo")
+			return credsExitVaultLocked
+		}
+
+		ctx.Logger().Error().Err(err).Msg("failed to open vault")
+		fmt.Fprintln(env.stderr, "failed to open the vault")
+		return credsExitFailure
+	}
+
+	if !unlocked {
+		fmt.Fprintln(env.stderr, "the password does not unlock the vault")
+		return credsExitVaultLocked
+	}
+
+	output, err := env.source.FormatRoleCredentials(ctx, awsidc.AwsIdc_CopyRoleCredentialsCommandInput{
+		InstanceId: *instanceId,
+		AccountId:  *accountId,
+		RoleName:   *roleName,
+		Format:     string(credsformat.FormatCredentialProcess),
+	})
+
+	if err != nil {
+		return credsCommandError(ctx, err, env.stderr)
+	}
+
+	fmt.Fprintln(env.stdout, output)
+
+	return credsExitOk
+}
+
+// readVaultPassword reads the first line of passwordFile, or prompts for the password when no file is given
+func readVaultPassword(passwordFile string, prompt func() (string, error)) (string, error) {
+	if passwordFile == "" {
+		return prompt()
+	}
+
+	content, err := os.ReadFile(passwordFile)
+
+	if err != nil {
+		return "", err
+	}
+
+	password, _, _ := strings.Cut(string(content), "\n")
+
+	return strings.TrimSuffix(password, "\r"), nil
+}
+
+// promptVaultPassword asks for the password on the terminal the command runs in.
+// Tools running credential_process capture its stderr, so the terminal is opened directly where possible.
+func promptVaultPassword() (string, error) {
+	if runtime.GOOS != "windows" {
+		if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+			defer tty.Close()
+
+			return readPasswordFromTerminal(tty, tty)
+		}
+	}
+
+	return readPasswordFromTerminal(os.Stdin, os.Stderr)
+}
+
+func readPasswordFromTerminal(in *os.File, out io.Writer) (string, error) {
+	fd := int(in.Fd())
+
+	if !term.IsTerminal(fd) {
+		return "", errNoTerminal
+	}
+
+	fmt.Fprint(out, vaultPasswordPrompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(out)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(password), nil
+}
+
+func credsCommandError(ctx app.Context, err error, stderr io.Writer) int {
+	switch {
+	case app.IsError(err, awsidc.ErrStaleAwsAccessToken), app.IsError(err, awsidc.ErrRefreshTokenUnavailable):
+		fmt.Fprintln(stderr, "the access token of the instance has expired, log in again with Swervo")
+		return credsExitStaleAccessToken
+	case app.IsError(err, awsidc.ErrTransientAwsClientError):
+		fmt.Fprintln(stderr, "AWS could not be reached, try again later")
+		return credsExitTransientError
+	case app.IsError(err, awsidc.ErrInstanceWasNotFound):
+		fmt.Fprintln(stderr, "the instance was not found")
+		return credsExitNotFound
+	}
+
+	ctx.Logger().Error().Err(err).Msg("failed to get role credentials")
+	fmt.Fprintf(stderr, "failed to get role credentials: %s\n", err)
+
+	return credsExitFailure
+}
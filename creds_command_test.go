@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/abjrcode/swervo/credsserver"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/eventing"
+	"github.com/abjrcode/swervo/internal/migrations"
+	"github.com/abjrcode/swervo/internal/security/vault"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCredsSource struct {
+	input  awsidc.AwsIdc_CopyRoleCredentialsCommandInput
+	output string
+	err    error
+}
+
+func (s *fakeCredsSource) FormatRoleCredentials(ctx app.Context, input awsidc.AwsIdc_CopyRoleCredentialsCommandInput) (string, error) {
+	s.input = input
+	return s.output, s.err
+}
+
+func initCredsCommandVault(t *testing.T, configure bool) vault.Vault {
+	db, err := migrations.NewInMemoryMigratedDatabase(t, "creds-command-tests.db")
+	require.NoError(t, err)
+
+	mockClock := testhelpers.NewMockClock()
+	mockClock.On("NowUnix").Return(1)
+
+	vlt := vault.NewVault(db, eventing.NewEventbus(db, mockClock), mockClock)
+
+	if configure {
+		require.NoError(t, vlt.Configure(testhelpers.NewMockAppContext(), "password"))
+		vlt.Seal()
+	}
+
+	return vlt
+}
+
+type fakeRunningApp struct {
+	credentials *awsidc.RoleCredentials
+	err         error
+}
+
+func (a *fakeRunningApp) GetRoleCredentials(ctx app.Context, instanceId, accountId, roleName string) (*awsidc.RoleCredentials, error) {
+	return a.credentials, a.err
+}
+
+var appNotRunning = &fakeRunningApp{err: credsserver.ErrAppNotRunning}
+
+func promptWithPassword(password string) func() (string, error) {
+	return func() (string, error) {
+		if password == "" {
+			return "", errNoTerminal
+		}
+
+		return password, nil
+	}
+}
+
+var credsArgs = []string{"--instance", "test-instance-id", "--account", "test-account-id", "--role", "test-role-name"}
+
+func TestCredsCommand(t *testing.T) {
+	vlt := initCredsCommandVault(t, true)
+	source := &fakeCredsSource{output: `{"Version":1}`}
+
+	var stdout, stderr bytes.Buffer
+
+	exitCode := runCredsCommand(testhelpers.NewMockAppContext(), credsArgs, credsCommandEnv{
+		runningApp:   appNotRunning,
+		vault:        vlt,
+		source:       source,
+		readPassword: promptWithPassword("password"),
+		stdout:       &stdout,
+		stderr:       &stderr,
+	})
+	require.Equal(t, credsExitOk, exitCode, stderr.String())
+	require.Equal(t, "{\"Version\":1}\n", stdout.String())
+	require.Equal(t, awsidc.AwsIdc_CopyRoleCredentialsCommandInput{
+		InstanceId: "test-instance-id",
+		AccountId:  "test-account-id",
+		RoleName:   "test-role-name",
+		Format:     "credential_process",
+	}, source.input)
+}
+
+func TestCredsCommand_AsksRunningAppFirst(t *testing.T) {
+	source := &fakeCredsSource{err: errors.New("the vault must not be used")}
+	runningApp := &fakeRunningApp{credentials: &awsidc.RoleCredentials{
+		AccessKeyId:     "test-access-key-id",
+		SecretAccessKey: "test-secret-key",
+		SessionToken:    "test-session-token",
+		ExpiresAt:       1700000000,
+	}}
+
+	var stdout, stderr bytes.Buffer
+
+	exitCode := runCredsCommand(testhelpers.NewMockAppContext(), credsArgs, credsCommandEnv{
+		runningApp:   runningApp,
+		vault:        initCredsCommandVault(t, true),
+		source:       source,
+		readPassword: promptWithPassword(""),
+		stdout:       &stdout,
+		stderr:       &stderr,
+	})
+	require.Equal(t, credsExitOk, exitCode, stderr.String())
+	require.JSONEq(t, `{"Version":1,"AccessKeyId":"test-access-key-id","SecretAccessKey":"test-secret-key","SessionToken":"test-session-token","Expiration":"2023-11-14T22:13:20Z"}`, stdout.String())
+
+	runningApp.credentials = nil
+	runningApp.err = awsidc.ErrStaleAwsAccessToken
+	stdout.Reset()
+
+	exitCode = runCredsCommand(testhelpers.NewMockAppContext(), credsArgs, credsCommandEnv{
+		runningApp:   runningApp,
+		vault:        initCredsCommandVault(t, true),
+		source:       source,
+		readPassword: promptWithPassword("password"),
+		stdout:       &stdout,
+		stderr:       &stderr,
+	})
+	require.Equal(t, credsExitStaleAccessToken, exitCode, "answers of the running app must not fall back to the vault")
+	require.Empty(t, stdout.String())
+}
+
+func TestCredsCommand_PasswordFile(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("password\n"), 0600))
+
+	source := &fakeCredsSource{output: `{"Version":1}`}
+
+	var stdout, stderr bytes.Buffer
+
+	exitCode := runCredsCommand(testhelpers.NewMockAppContext(), append([]string{"--password-file", passwordFile}, credsArgs...), credsCommandEnv{
+		runningApp:   appNotRunning,
+		vault:        initCredsCommandVault(t, true),
+		source:       source,
+		readPassword: promptWithPassword(""),
+		stdout:       &stdout,
+		stderr:       &stderr,
+	})
+	require.Equal(t, credsExitOk, exitCode, stderr.String())
+	require.Equal(t, "{\"Version\":1}\n", stdout.String())
+
+	stderr.Reset()
+
+	exitCode = runCredsCommand(testhelpers.NewMockAppContext(), append([]string{"--password-file", passwordFile + ".missing"}, credsArgs...), credsCommandEnv{
+		runningApp:   appNotRunning,
+		vault:        initCredsCommandVault(t, true),
+		source:       source,
+		readPassword: promptWithPassword("password"),
+		stdout:       &stdout,
+		stderr:       &stderr,
+	})
+	require.Equal(t, credsExitVaultLocked, exitCode)
+	require.NotEmpty(t, stderr.String())
+}
+func TestCredsCommand_ExitCodes(t *testing.T) {
+	testCases := []struct {
+		name      string
+		args      []string
+		password  string
+		configure bool
+		sourceErr error
+		expected  int
+	}{
+		{name: "missing role", args: credsArgs[:4], password: "password", configure: true, expected: credsExitUsage},
+		{name: "unknown flag", args: append([]string{"--profile", "x"}, credsArgs...), password: "password", configure: true, expected: credsExitUsage},
+		{name: "no password", args: credsArgs, configure: true, expected: credsExitVaultLocked},
+		{name: "wrong password", args: credsArgs, password: "wrong", configure: true, expected: credsExitVaultLocked},
+		{name: "vault not configured", args: credsArgs, password: "password", expected: credsExitVaultLocked},
+		{name: "stale access token", args: credsArgs, password: "password", configure: true, sourceErr: awsidc.ErrStaleAwsAccessToken, expected: credsExitStaleAccessToken},
+		{name: "no refresh token", args: credsArgs, password: "password", configure: true, sourceErr: awsidc.ErrRefreshTokenUnavailable, expected: credsExitStaleAccessToken},
+		{name: "transient error", args: credsArgs, password: "password", configure: true, sourceErr: awsidc.ErrTransientAwsClientError, expected: credsExitTransientError},
+		{name: "unknown instance", args: credsArgs, password: "password", configure: true, sourceErr: awsidc.ErrInstanceWasNotFound, expected: credsExitNotFound},
+		{name: "other error", args: credsArgs, password: "password", configure: true, sourceErr: awsidc.ErrInvalidAccountRole, expected: credsExitFailure},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			vlt := initCredsCommandVault(t, testCase.configure)
+			source := &fakeCredsSource{output: `{"Version":1}`, err: testCase.sourceErr}
+
+			var stdout, stderr bytes.Buffer
+
+			exitCode := runCredsCommand(testhelpers.NewMockAppContext(), testCase.args, credsCommandEnv{
+				runningApp:   appNotRunning,
+				vault:        vlt,
+				source:       source,
+				readPassword: promptWithPassword(testCase.password),
+				stdout:       &stdout,
+				stderr:       &stderr,
+			})
+			require.Equal(t, testCase.expected, exitCode)
+			require.Empty(t, stdout.String(), "nothing but credentials must be written to stdout")
+			require.NotEmpty(t, stderr.String())
+		})
+	}
+}
+
+func TestIsCredsCommand(t *testing.T) {
+	require.True(t, isCredsCommand([]string{"swervo", "creds", "--instance", "x"}))
+	require.False(t, isCredsCommand([]string{"swervo"}))
+	require.False(t, isCredsCommand([]string{"swervo", "--creds"}))
+}
@@ -0,0 +1,158 @@
+// Package credsserver vends role credentials over HTTP on the loopback interface
+// so that tools can get them from Swervo without anything written to disk.
+package credsserver
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/eventing"
+	"github.com/abjrcode/swervo/internal/utils"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/ksuid"
+)
+
+var (
+	ErrServerAlreadyRunning = app.NewValidationError("CREDENTIALS_SERVER_ALREADY_RUNNING")
+	ErrPortUnavailable      = app.NewValidationError("CREDENTIALS_SERVER_PORT_UNAVAILABLE")
+	ErrInvalidPort          = app.NewValidationError("INVALID_CREDENTIALS_SERVER_PORT")
+	ErrInvalidAddress       = app.NewValidationError("INVALID_CREDENTIALS_SERVER_ADDRESS")
+	ErrInvalidEcsRoute      = app.NewValidationError("INVALID_ECS_ROUTE")
+	ErrEcsRouteExists       = app.NewValidationError("ECS_ROUTE_ALREADY_EXISTS")
+	ErrEcsRouteNotFound     = app.NewValidationError("ECS_ROUTE_NOT_FOUND")
+)
+
+var CredentialsServerEventSource = eventing.EventSource("CredentialsServer")
+
+type ServerKind string
+
+const (
+	ServerKindEcs  ServerKind = "ecs"
+	ServerKindImds ServerKind = "imds"
+)
+
+// CredentialsVendedEvent is recorded every time a server hands out credentials
+type CredentialsVendedEvent struct {
+	Server     ServerKind
+	Path       string
+	RemoteAddr string
+
+	InstanceId string
+	AccountId  string
+	RoleName   string
+}
+
+// RoleCredentialsSource gets the credentials servers hand out, it is implemented by [awsidc.AwsIdentityCenterController].
+type RoleCredentialsSource interface {
+	GetRoleCredentials(ctx app.Context, instanceId, accountId, roleName string) (*awsidc.RoleCredentials, error)
+}
+
+type ServerStatus struct {
+	Running bool `json:"running"`
+	// Url is where the server listens, empty unless it is running
+	Url string `json:"url"`
+	// AuthorizationToken must be sent along with every request, a new one is generated whenever the server starts
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+type CredentialsServerController struct {
+	db     *sql.DB
+	bus    *eventing.Eventbus
+	source RoleCredentialsSource
+	clock  utils.Clock
+
+	ecsMu    sync.Mutex
+	ecs      loopbackServer
+	ecsToken string
+
+	imdsMu   sync.Mutex
+	imds     loopbackServer
+	imdsRole *ImdsRole
+
+	localSocketMu sync.Mutex
+	localSocket   *http.Server
+}
+
+func NewCredentialsServerController(db *sql.DB, bus *eventing.Eventbus, source RoleCredentialsSource, clock utils.Clock) *CredentialsServerController {
+	return &CredentialsServerController{
+		db:     db,
+		bus:    bus,
+		source: source,
+		clock:  clock,
+	}
+}
+
+// StopAll stops every running server, e.g. when the vault is sealed.
+func (c *CredentialsServerController) StopAll() {
+	c.ecsMu.Lock()
+	c.ecs.stop()
+	c.ecsToken = ""
+	c.ecsMu.Unlock()
+
+	c.imdsMu.Lock()
+	c.imds.stop()
+	c.imdsRole = nil
+	c.imdsMu.Unlock()
+
+	c.stopLocalSocket()
+}
+
+func validatePort(port int) error {
+	if port < 0 || port > 65535 {
+		return ErrInvalidPort
+	}
+
+	return nil
+}
+
+// requestContext is the context of a request served on behalf of the user who started the server
+func requestContext(r *http.Request, logger *zerolog.Logger) app.Context {
+	reqId := utils.NewRequestId()
+
+	return app.NewContext(r.Context(), "root", reqId, reqId, reqId, logger)
+}
+
+// audit records that credentials were handed out, failing to do so fails the request.
+// Every vend is a stream of its own because vends of the same server can happen concurrently.
+func (c *CredentialsServerController) audit(ctx app.Context, event CredentialsVendedEvent) error {
+	return c.bus.Publish(ctx, event, eventing.EventMeta{
+		SourceType:   CredentialsServerEventSource,
+		SourceId:     ksuid.New().String(),
+		EventVersion: 1,
+	})
+}
+
+// vend gets credentials and records that they were handed out.
+// When it fails, it answers the request itself and returns nil.
+func (c *CredentialsServerController) vend(ctx app.Context, w http.ResponseWriter, event CredentialsVendedEvent) *awsidc.RoleCredentials {
+	credentials, err := c.source.GetRoleCredentials(ctx, event.InstanceId, event.AccountId, event.RoleName)
+
+	if err != nil {
+		ctx.Logger().Error().Err(err).Msgf("failed to get credentials of role [%s] of account [%s] for [%s]", event.RoleName, event.AccountId, event.Path)
+
+		switch {
+		case app.IsError(err, awsidc.ErrStaleAwsAccessToken), app.IsError(err, awsidc.ErrRefreshTokenUnavailable):
+			http.Error(w, fmt.Sprintf("the access token of AWS IAM Identity Center instance [%s] has expired, reauthorize it in Swervo", event.InstanceId), http.StatusForbidden)
+		case app.IsError(err, awsidc.ErrTransientAwsClientError):
+			http.Error(w, "AWS could not be reached, try again later", http.StatusServiceUnavailable)
+		case app.IsError(err, awsidc.ErrInstanceWasNotFound):
+			http.Error(w, fmt.Sprintf("AWS IAM Identity Center instance [%s] was not found", event.InstanceId), http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return nil
+	}
+
+	if err := c.audit(ctx, event); err != nil {
+		ctx.Logger().Error().Err(err).Msg("failed to record vended credentials")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil
+	}
+
+	return credentials
+}
@@ -0,0 +1,231 @@
+package credsserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/rs/zerolog"
+)
+
+// EcsRoute maps a path of the ECS server to the role whose credentials it serves.
+type EcsRoute struct {
+	Path       string `json:"path"`
+	InstanceId string `json:"instanceId"`
+	AccountId  string `json:"accountId"`
+	RoleName   string `json:"roleName"`
+}
+
+var ecsRoutePathRegex = regexp.MustCompile(`^(/[A-Za-z0-9._~-]+)+$`)
+
+func validateEcsRoute(route EcsRoute) error {
+	if len(route.Path) > 200 || !ecsRoutePathRegex.MatchString(route.Path) {
+		return ErrInvalidEcsRoute
+	}
+
+	if strings.TrimSpace(route.InstanceId) == "" || strings.TrimSpace(route.AccountId) == "" || strings.TrimSpace(route.RoleName) == "" {
+		return ErrInvalidEcsRoute
+	}
+
+	return nil
+}
+
+func (c *CredentialsServerController) ListEcsRoutes(ctx app.Context) ([]EcsRoute, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT path, instance_id, account_id, role_name FROM ecs_credentials_routes ORDER BY path`)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	defer rows.Close()
+
+	routes := make([]EcsRoute, 0)
+
+	for rows.Next() {
+		var route EcsRoute
+
+		if err := rows.Scan(&route.Path, &route.InstanceId, &route.AccountId, &route.RoleName); err != nil {
+			return nil, errors.Join(err, app.ErrFatal)
+		}
+
+		routes = append(routes, route)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	return routes, nil
+}
+
+// AddEcsRoute makes the credentials of a role available at a path, running servers pick it up right away.
+func (c *CredentialsServerController) AddEcsRoute(ctx app.Context, route EcsRoute) error {
+	if err := validateEcsRoute(route); err != nil {
+		return err
+	}
+
+	res, err := c.db.ExecContext(ctx, `INSERT INTO ecs_credentials_routes (path, instance_id, account_id, role_name) VALUES (?, ?, ?, ?)
+	ON CONFLICT(path) DO NOTHING`, route.Path, route.InstanceId, route.AccountId, route.RoleName)
+
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return errors.Join(err, app.ErrFatal)
+	} else if affected == 0 {
+		return ErrEcsRouteExists
+	}
+
+	return nil
+}
+
+func (c *CredentialsServerController) RemoveEcsRoute(ctx app.Context, path string) error {
+	res, err := c.db.ExecContext(ctx, `DELETE FROM ecs_credentials_routes WHERE path = ?`, path)
+
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return errors.Join(err, app.ErrFatal)
+	} else if affected == 0 {
+		return ErrEcsRouteNotFound
+	}
+
+	return nil
+}
+
+func (c *CredentialsServerController) findEcsRoute(ctx app.Context, path string) (*EcsRoute, error) {
+	row := c.db.QueryRowContext(ctx, `SELECT path, instance_id, account_id, role_name FROM ecs_credentials_routes WHERE path = ?`, path)
+
+	var route EcsRoute
+
+	if err := row.Scan(&route.Path, &route.InstanceId, &route.AccountId, &route.RoleName); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrEcsRouteNotFound
+		}
+
+		return nil, err
+	}
+
+	return &route, nil
+}
+
+// ecsCredentials is the response the container credentials provider of AWS SDKs expects
+type ecsCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      string
+}
+
+func (c *CredentialsServerController) ecsHandler(token string, logger *zerolog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !isAuthorized(r.Header.Get("Authorization"), token) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		ctx := requestContext(r, logger)
+
+		route, err := c.findEcsRoute(ctx, r.URL.Path)
+
+		if err != nil {
+			if errors.Is(err, ErrEcsRouteNotFound) {
+				w.WriteHeader(http.StatusNotFound)
+			} else {
+				ctx.Logger().Error().Err(err).Msgf("failed to find ECS route [%s]", r.URL.Path)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+
+			return
+		}
+
+		credentials := c.vend(ctx, w, CredentialsVendedEvent{
+			Server:     ServerKindEcs,
+			Path:       route.Path,
+			RemoteAddr: r.RemoteAddr,
+			InstanceId: route.InstanceId,
+			AccountId:  route.AccountId,
+			RoleName:   route.RoleName,
+		})
+
+		if credentials == nil {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ecsCredentials{
+			AccessKeyId:     credentials.AccessKeyId,
+			SecretAccessKey: credentials.SecretAccessKey,
+			Token:           credentials.SessionToken,
+			Expiration:      time.Unix(credentials.ExpiresAt, 0).UTC().Format(time.RFC3339),
+		})
+	})
+}
+
+// StartEcsServer serves the credentials of the ECS routes to clients that set AWS_CONTAINER_CREDENTIALS_FULL_URI
+// to the url of the server followed by a route path and AWS_CONTAINER_AUTHORIZATION_TOKEN to the returned token.
+// A port of 0 picks a free one.
+func (c *CredentialsServerController) StartEcsServer(ctx app.Context, port int) (*ServerStatus, error) {
+	if err := validatePort(port); err != nil {
+		return nil, err
+	}
+
+	token, err := newAuthorizationToken()
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	c.ecsMu.Lock()
+	defer c.ecsMu.Unlock()
+
+	url, err := c.ecs.start(fmt.Sprintf("127.0.0.1:%d", port), c.ecsHandler(token, ctx.Logger()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.ecsToken = token
+
+	ctx.Logger().Info().Msgf("ECS credentials server listening at [%s]", url)
+
+	return &ServerStatus{Running: true, Url: url, AuthorizationToken: token}, nil
+}
+
+func (c *CredentialsServerController) StopEcsServer(ctx app.Context) {
+	c.ecsMu.Lock()
+	defer c.ecsMu.Unlock()
+
+	if c.ecs.stop() {
+		ctx.Logger().Info().Msg("ECS credentials server stopped")
+	}
+
+	c.ecsToken = ""
+}
+
+func (c *CredentialsServerController) GetEcsServerStatus(ctx app.Context) ServerStatus {
+	c.ecsMu.Lock()
+	defer c.ecsMu.Unlock()
+
+	url, running := c.ecs.currentUrl()
+
+	if !running {
+		return ServerStatus{}
+	}
+
+	return ServerStatus{Running: true, Url: url, AuthorizationToken: c.ecsToken}
+}
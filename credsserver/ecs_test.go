@@ -0,0 +1,189 @@
+package credsserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/eventing"
+	"github.com/abjrcode/swervo/internal/migrations"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type mockRoleCredentialsSource struct {
+	mock.Mock
+}
+
+func (m *mockRoleCredentialsSource) GetRoleCredentials(ctx app.Context, instanceId, accountId, roleName string) (*awsidc.RoleCredentials, error) {
+	args := m.Called(instanceId, accountId, roleName)
+	res, _ := args.Get(0).(*awsidc.RoleCredentials)
+	return res, args.Error(1)
+}
+
+func initController(t *testing.T) (*CredentialsServerController, *mockRoleCredentialsSource, *eventing.Eventbus, *testhelpers.MockClock) {
+	db, err := migrations.NewInMemoryMigratedDatabase(t, "credentials-server-tests.db")
+	require.NoError(t, err)
+
+	mockClock := testhelpers.NewMockClock()
+	mockClock.On("NowUnix").Return(1)
+
+	bus := eventing.NewEventbus(db, mockClock)
+	source := new(mockRoleCredentialsSource)
+
+	controller := NewCredentialsServerController(db, bus, source, mockClock)
+	t.Cleanup(controller.StopAll)
+
+	return controller, source, bus, mockClock
+}
+
+var testRoleCredentials = &awsidc.RoleCredentials{
+	AccessKeyId:     "test-access-key-id",
+	SecretAccessKey: "test-secret-key",
+	SessionToken:    "test-session-token",
+	ExpiresAt:       1700000000,
+}
+
+func get(t *testing.T, url, authorization string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { res.Body.Close() })
+
+	return res
+}
+
+func TestEcsRoutes(t *testing.T) {
+	controller, _, _, _ := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	route := EcsRoute{Path: "/dev/admin", InstanceId: "test-instance-id", AccountId: "test-account-id", RoleName: "test-role-name"}
+
+	require.NoError(t, controller.AddEcsRoute(ctx, route))
+	require.Same(t, ErrEcsRouteExists, controller.AddEcsRoute(ctx, route))
+
+	for _, invalid := range []EcsRoute{
+		{Path: "dev", InstanceId: "i", AccountId: "a", RoleName: "r"},
+		{Path: "/dev/", InstanceId: "i", AccountId: "a", RoleName: "r"},
+		{Path: "/dev?role=x", InstanceId: "i", AccountId: "a", RoleName: "r"},
+		{Path: "/dev", AccountId: "a", RoleName: "r"},
+	} {
+		require.Same(t, ErrInvalidEcsRoute, controller.AddEcsRoute(ctx, invalid), "route [%s] must be rejected", invalid.Path)
+	}
+
+	routes, err := controller.ListEcsRoutes(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []EcsRoute{route}, routes)
+
+	require.NoError(t, controller.RemoveEcsRoute(ctx, route.Path))
+	require.Same(t, ErrEcsRouteNotFound, controller.RemoveEcsRoute(ctx, route.Path))
+}
+
+func TestEcsServer(t *testing.T) {
+	controller, source, bus, _ := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	events := bus.Subscribe(CredentialsServerEventSource)
+
+	require.NoError(t, controller.AddEcsRoute(ctx, EcsRoute{Path: "/dev/admin", InstanceId: "test-instance-id", AccountId: "test-account-id", RoleName: "test-role-name"}))
+	source.On("GetRoleCredentials", "test-instance-id", "test-account-id", "test-role-name").Return(testRoleCredentials, nil)
+
+	status, err := controller.StartEcsServer(ctx, 0)
+	require.NoError(t, err)
+	require.True(t, status.Running)
+	require.Regexp(t, `^http://127\.0\.0\.1:\d+$`, status.Url)
+	require.NotEmpty(t, status.AuthorizationToken)
+	require.Equal(t, *status, controller.GetEcsServerStatus(ctx))
+
+	_, err = controller.StartEcsServer(ctx, 0)
+	require.Same(t, ErrServerAlreadyRunning, err)
+
+	res := get(t, status.Url+"/dev/admin", status.AuthorizationToken)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var credentials map[string]string
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&credentials))
+	require.Equal(t, map[string]string{
+		"AccessKeyId":     "test-access-key-id",
+		"SecretAccessKey": "test-secret-key",
+		"Token":           "test-session-token",
+		"Expiration":      "2023-11-14T22:13:20Z",
+	}, credentials)
+
+	envelope := <-events
+	event := envelope.Event.(CredentialsVendedEvent)
+	require.Equal(t, ServerKindEcs, event.Server)
+	require.Equal(t, "/dev/admin", event.Path)
+	require.Equal(t, "test-role-name", event.RoleName)
+	require.NotEmpty(t, event.RemoteAddr)
+
+	res = get(t, status.Url+"/dev/admin", "Bearer "+status.AuthorizationToken)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	<-events
+
+	require.Equal(t, http.StatusUnauthorized, get(t, status.Url+"/dev/admin", "").StatusCode)
+	require.Equal(t, http.StatusUnauthorized, get(t, status.Url+"/dev/admin", "wrong-token").StatusCode)
+	require.Equal(t, http.StatusNotFound, get(t, status.Url+"/prod/admin", status.AuthorizationToken).StatusCode)
+
+	source.AssertNumberOfCalls(t, "GetRoleCredentials", 2)
+
+	controller.StopEcsServer(ctx)
+	require.Equal(t, ServerStatus{}, controller.GetEcsServerStatus(ctx))
+
+	_, err = http.Get(status.Url + "/dev/admin")
+	require.Error(t, err, "server must not be reachable once stopped")
+
+	restarted, err := controller.StartEcsServer(ctx, 0)
+	require.NoError(t, err)
+	require.NotEqual(t, status.AuthorizationToken, restarted.AuthorizationToken, "a new token must be generated on every start")
+}
+
+func TestEcsServer_Errors(t *testing.T) {
+	controller, source, _, _ := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	require.NoError(t, controller.AddEcsRoute(ctx, EcsRoute{Path: "/stale", InstanceId: "test-instance-id", AccountId: "test-account-id", RoleName: "stale"}))
+	require.NoError(t, controller.AddEcsRoute(ctx, EcsRoute{Path: "/transient", InstanceId: "test-instance-id", AccountId: "test-account-id", RoleName: "transient"}))
+	source.On("GetRoleCredentials", "test-instance-id", "test-account-id", "stale").Return(nil, awsidc.ErrStaleAwsAccessToken)
+	source.On("GetRoleCredentials", "test-instance-id", "test-account-id", "transient").Return(nil, awsidc.ErrTransientAwsClientError)
+
+	status, err := controller.StartEcsServer(ctx, 0)
+	require.NoError(t, err)
+
+	res := get(t, status.Url+"/stale", status.AuthorizationToken)
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "reauthorize it in Swervo")
+
+	require.Equal(t, http.StatusServiceUnavailable, get(t, status.Url+"/transient", status.AuthorizationToken).StatusCode)
+
+	_, err = controller.StartEcsServer(ctx, 70000)
+	require.Same(t, ErrInvalidPort, err)
+}
+
+func TestStopAll(t *testing.T) {
+	controller, _, _, _ := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	status, err := controller.StartEcsServer(ctx, 0)
+	require.NoError(t, err)
+
+	controller.StopAll()
+
+	require.False(t, controller.GetEcsServerStatus(ctx).Running)
+
+	_, err = http.Get(status.Url + "/dev/admin")
+	require.Error(t, err)
+}
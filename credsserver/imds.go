@@ -0,0 +1,256 @@
+package credsserver
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/utils"
+	"github.com/rs/zerolog"
+)
+
+const (
+	imdsTokenPath              = "/latest/api/token"
+	imdsSecurityCredentialsDir = "/latest/meta-data/iam/security-credentials/"
+
+	imdsTokenHeader    = "X-aws-ec2-metadata-token"
+	imdsTokenTtlHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+
+	// imdsMaxTokenTtlSeconds is the longest a session token of IMDSv2 can live
+	imdsMaxTokenTtlSeconds = 21600
+)
+
+var ErrInvalidImdsRole = app.NewValidationError("INVALID_IMDS_ROLE")
+
+// ImdsRole is the role whose credentials the IMDS server serves, like the instance profile of an EC2 instance.
+type ImdsRole struct {
+	InstanceId string `json:"instanceId"`
+	AccountId  string `json:"accountId"`
+	RoleName   string `json:"roleName"`
+}
+
+type ImdsServerStatus struct {
+	Running bool `json:"running"`
+	// Url is where the server listens, clients are pointed at it with AWS_EC2_METADATA_SERVICE_ENDPOINT
+	Url  string    `json:"url"`
+	Role *ImdsRole `json:"role"`
+}
+
+// imdsTokens are the session tokens handed out by the IMDSv2 token handshake
+type imdsTokens struct {
+	clock utils.Clock
+
+	mu     sync.Mutex
+	tokens map[string]int64
+}
+
+func newImdsTokens(clock utils.Clock) *imdsTokens {
+	return &imdsTokens{
+		clock:  clock,
+		tokens: make(map[string]int64),
+	}
+}
+
+func (t *imdsTokens) issue(ttlSeconds int64) (string, error) {
+	token, err := newAuthorizationToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	nowUnix := t.clock.NowUnix()
+
+	for existing, expiresAt := range t.tokens {
+		if expiresAt <= nowUnix {
+			delete(t.tokens, existing)
+		}
+	}
+
+	t.tokens[token] = nowUnix + ttlSeconds
+
+	return token, nil
+}
+
+func (t *imdsTokens) isValid(token string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	expiresAt, ok := t.tokens[token]
+
+	return ok && t.clock.NowUnix() < expiresAt
+}
+
+// imdsCredentials is what IMDS returns for the credentials of an instance profile
+type imdsCredentials struct {
+	Code            string
+	LastUpdated     string
+	Type            string
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      string
+}
+
+// isListenAddress accepts the Host a client sends when it connects to the address the server listens at,
+// so that pages of other sites cannot reach the server by rebinding their domain name to the loopback interface
+func isListenAddress(r *http.Request) bool {
+	localAddr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+
+	if !ok {
+		return false
+	}
+
+	if r.Host == localAddr.String() {
+		return true
+	}
+
+	_, port, err := net.SplitHostPort(localAddr.String())
+
+	return err == nil && r.Host == net.JoinHostPort("localhost", port)
+}
+
+func (c *CredentialsServerController) imdsHandler(role ImdsRole, tokens *imdsTokens, logger *zerolog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// like IMDS, refuse requests that went through a proxy
+		if r.Header.Get("X-Forwarded-For") != "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if !isListenAddress(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if r.URL.Path == imdsTokenPath {
+			if r.Method != http.MethodPut {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			ttlSeconds, err := strconv.ParseInt(r.Header.Get(imdsTokenTtlHeader), 10, 64)
+
+			if err != nil || ttlSeconds < 1 || ttlSeconds > imdsMaxTokenTtlSeconds {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			token, err := tokens.issue(ttlSeconds)
+
+			if err != nil {
+				logger.Error().Err(err).Msg("failed to issue IMDS session token")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set(imdsTokenTtlHeader, strconv.FormatInt(ttlSeconds, 10))
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(token))
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// only IMDSv2 is supported, requests without a session token are refused
+		if !tokens.isValid(r.Header.Get(imdsTokenHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path == imdsSecurityCredentialsDir || r.URL.Path == strings.TrimSuffix(imdsSecurityCredentialsDir, "/") {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(role.RoleName))
+			return
+		}
+
+		if r.URL.Path != imdsSecurityCredentialsDir+role.RoleName {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		ctx := requestContext(r, logger)
+
+		credentials := c.vend(ctx, w, CredentialsVendedEvent{
+			Server:     ServerKindImds,
+			Path:       r.URL.Path,
+			RemoteAddr: r.RemoteAddr,
+			InstanceId: role.InstanceId,
+			AccountId:  role.AccountId,
+			RoleName:   role.RoleName,
+		})
+
+		if credentials == nil {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(imdsCredentials{
+			Code:            "Success",
+			LastUpdated:     time.Unix(c.clock.NowUnix(), 0).UTC().Format(time.RFC3339),
+			Type:            "AWS-HMAC",
+			AccessKeyId:     credentials.AccessKeyId,
+			SecretAccessKey: credentials.SecretAccessKey,
+			Token:           credentials.SessionToken,
+			Expiration:      time.Unix(credentials.ExpiresAt, 0).UTC().Format(time.RFC3339),
+		})
+	})
+}
+
+// StartImdsServer emulates the IMDSv2 credentials of an EC2 instance profile at a loopback address, e.g. 127.0.0.1:1338.
+func (c *CredentialsServerController) StartImdsServer(ctx app.Context, address string, role ImdsRole) (*ImdsServerStatus, error) {
+	if strings.TrimSpace(role.InstanceId) == "" || strings.TrimSpace(role.AccountId) == "" || strings.TrimSpace(role.RoleName) == "" || strings.Contains(role.RoleName, "/") {
+		return nil, ErrInvalidImdsRole
+	}
+
+	c.imdsMu.Lock()
+	defer c.imdsMu.Unlock()
+
+	tokens := newImdsTokens(c.clock)
+
+	url, err := c.imds.start(address, c.imdsHandler(role, tokens, ctx.Logger()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.imdsRole = &role
+
+	ctx.Logger().Info().Msgf("IMDS credentials server listening at [%s]", url)
+
+	return &ImdsServerStatus{Running: true, Url: url, Role: &role}, nil
+}
+
+func (c *CredentialsServerController) StopImdsServer(ctx app.Context) {
+	c.imdsMu.Lock()
+	defer c.imdsMu.Unlock()
+
+	if c.imds.stop() {
+		ctx.Logger().Info().Msg("IMDS credentials server stopped")
+	}
+
+	c.imdsRole = nil
+}
+
+func (c *CredentialsServerController) GetImdsServerStatus(ctx app.Context) ImdsServerStatus {
+	c.imdsMu.Lock()
+	defer c.imdsMu.Unlock()
+
+	url, running := c.imds.currentUrl()
+
+	if !running {
+		return ImdsServerStatus{}
+	}
+
+	return ImdsServerStatus{Running: true, Url: url, Role: c.imdsRole}
+}
@@ -0,0 +1,157 @@
+package credsserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/stretchr/testify/require"
+)
+
+func imdsRequest(t *testing.T, method, url string, headers map[string]string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return res, string(body)
+}
+
+var testImdsRole = ImdsRole{InstanceId: "test-instance-id", AccountId: "test-account-id", RoleName: "test-role-name"}
+
+func TestImdsServer(t *testing.T) {
+	controller, source, bus, mockClock := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	events := bus.Subscribe(CredentialsServerEventSource)
+
+	source.On("GetRoleCredentials", "test-instance-id", "test-account-id", "test-role-name").Return(testRoleCredentials, nil)
+
+	status, err := controller.StartImdsServer(ctx, "127.0.0.1:0", testImdsRole)
+	require.NoError(t, err)
+	require.Regexp(t, `^http://127\.0\.0\.1:\d+$`, status.Url)
+	require.Equal(t, *status, controller.GetImdsServerStatus(ctx))
+
+	credentialsUrl := status.Url + "/latest/meta-data/iam/security-credentials/"
+
+	res, _ := imdsRequest(t, http.MethodGet, credentialsUrl, nil)
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode, "IMDSv1 requests must be refused")
+
+	res, _ = imdsRequest(t, http.MethodPut, status.Url+"/latest/api/token", nil)
+	require.Equal(t, http.StatusBadRequest, res.StatusCode, "a token ttl is required")
+
+	res, _ = imdsRequest(t, http.MethodPut, status.Url+"/latest/api/token", map[string]string{imdsTokenTtlHeader: "21601"})
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res, _ = imdsRequest(t, http.MethodPut, status.Url+"/latest/api/token", map[string]string{imdsTokenTtlHeader: "60", "X-Forwarded-For": "10.0.0.1"})
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res, token := imdsRequest(t, http.MethodPut, status.Url+"/latest/api/token", map[string]string{imdsTokenTtlHeader: "60"})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "60", res.Header.Get(imdsTokenTtlHeader))
+	require.NotEmpty(t, token)
+
+	res, roleName := imdsRequest(t, http.MethodGet, credentialsUrl, map[string]string{imdsTokenHeader: token})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "test-role-name", roleName)
+
+	res, body := imdsRequest(t, http.MethodGet, credentialsUrl+roleName, map[string]string{imdsTokenHeader: token})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var credentials map[string]string
+	require.NoError(t, json.Unmarshal([]byte(body), &credentials))
+	require.Equal(t, map[string]string{
+		"Code":            "Success",
+		"LastUpdated":     "1970-01-01T00:00:01Z",
+		"Type":            "AWS-HMAC",
+		"AccessKeyId":     "test-access-key-id",
+		"SecretAccessKey": "test-secret-key",
+		"Token":           "test-session-token",
+		"Expiration":      "2023-11-14T22:13:20Z",
+	}, credentials)
+
+	envelope := <-events
+	event := envelope.Event.(CredentialsVendedEvent)
+	require.Equal(t, ServerKindImds, event.Server)
+	require.Equal(t, "/latest/meta-data/iam/security-credentials/test-role-name", event.Path)
+	require.Equal(t, "test-account-id", event.AccountId)
+
+	res, _ = imdsRequest(t, http.MethodGet, credentialsUrl+"other-role", map[string]string{imdsTokenHeader: token})
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	mockClock.ExpectedCalls = nil
+	mockClock.On("NowUnix").Return(61)
+
+	res, _ = imdsRequest(t, http.MethodGet, credentialsUrl, map[string]string{imdsTokenHeader: token})
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode, "expired tokens must be refused")
+
+	controller.StopAll()
+	require.Equal(t, ImdsServerStatus{}, controller.GetImdsServerStatus(ctx))
+
+	_, err = http.Get(credentialsUrl)
+	require.Error(t, err, "server must not be reachable once stopped")
+}
+
+func TestImdsServer_RejectsOtherHosts(t *testing.T) {
+	controller, _, _, _ := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	status, err := controller.StartImdsServer(ctx, "127.0.0.1:0", testImdsRole)
+	require.NoError(t, err)
+	t.Cleanup(controller.StopAll)
+
+	port := strings.TrimPrefix(status.Url, "http://127.0.0.1:")
+
+	for _, host := range []string{"attacker.example:" + port, "attacker.example", "127.0.0.2:" + port} {
+		req, err := http.NewRequest(http.MethodPut, status.Url+"/latest/api/token", nil)
+		require.NoError(t, err)
+
+		req.Host = host
+		req.Header.Set(imdsTokenTtlHeader, "60")
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+
+		require.Equal(t, http.StatusForbidden, res.StatusCode, "host [%s] must be refused", host)
+	}
+
+	res, _ := imdsRequest(t, http.MethodPut, "http://localhost:"+port+"/latest/api/token", map[string]string{imdsTokenTtlHeader: "60"})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestImdsServer_InvalidOptions(t *testing.T) {
+	controller, _, _, _ := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	for _, address := range []string{"0.0.0.0:1338", "169.254.169.254:80", "192.168.1.10:1338", "127.0.0.1", "127.0.0.1:99999"} {
+		_, err := controller.StartImdsServer(ctx, address, testImdsRole)
+		require.Same(t, ErrInvalidAddress, err, "address [%s] must be rejected", address)
+	}
+
+	_, err := controller.StartImdsServer(ctx, "127.0.0.1:0", ImdsRole{InstanceId: "test-instance-id", AccountId: "test-account-id", RoleName: "a/b"})
+	require.Same(t, ErrInvalidImdsRole, err)
+
+	status, err := controller.StartImdsServer(ctx, "127.0.0.1:0", testImdsRole)
+	require.NoError(t, err)
+
+	_, err = controller.StartImdsServer(ctx, "127.0.0.1:0", testImdsRole)
+	require.Same(t, ErrServerAlreadyRunning, err)
+
+	controller.StopImdsServer(ctx)
+
+	_, err = controller.StartImdsServer(ctx, status.Url[len("http://"):], testImdsRole)
+	require.NoError(t, err, "the address must be free again once the server stopped")
+}
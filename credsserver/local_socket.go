@@ -0,0 +1,186 @@
+package credsserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/app"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+	"github.com/rs/zerolog"
+)
+
+// LocalSocketName is the file name of the socket in the app data directory,
+// which only the user running Swervo can access.
+const LocalSocketName = "swervo.sock"
+
+const localSocketCredentialsPath = "/role-credentials"
+
+// ErrAppNotRunning means that no unlocked Swervo app listens on the local socket
+var ErrAppNotRunning = errors.New("APP_NOT_RUNNING")
+
+const ServerKindLocalSocket ServerKind = "local_socket"
+
+func (c *CredentialsServerController) localSocketHandler(logger *zerolog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.URL.Path != localSocketCredentialsPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		query := r.URL.Query()
+
+		event := CredentialsVendedEvent{
+			Server:     ServerKindLocalSocket,
+			Path:       r.URL.Path,
+			RemoteAddr: r.RemoteAddr,
+			InstanceId: query.Get("instanceId"),
+			AccountId:  query.Get("accountId"),
+			RoleName:   query.Get("roleName"),
+		}
+
+		if strings.TrimSpace(event.InstanceId) == "" || strings.TrimSpace(event.AccountId) == "" || strings.TrimSpace(event.RoleName) == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		credentials := c.vend(requestContext(r, logger), w, event)
+
+		if credentials == nil {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(credentials)
+	})
+}
+
+// StartLocalSocket lets the creds command of Swervo get credentials from the running app while its vault is open,
+// instead of having to unlock the vault on its own. The socket is removed when the vault is sealed.
+func (c *CredentialsServerController) StartLocalSocket(ctx app.Context, path string) error {
+	c.localSocketMu.Lock()
+	defer c.localSocketMu.Unlock()
+
+	if c.localSocket != nil {
+		return ErrServerAlreadyRunning
+	}
+
+	// a socket left behind by an app that did not exit cleanly would make listening fail
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", path)
+
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           c.localSocketHandler(ctx.Logger()),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go server.Serve(listener)
+
+	c.localSocket = server
+
+	ctx.Logger().Info().Msgf("local socket listening at [%s]", path)
+
+	return nil
+}
+
+func (c *CredentialsServerController) stopLocalSocket() {
+	c.localSocketMu.Lock()
+	defer c.localSocketMu.Unlock()
+
+	if c.localSocket == nil {
+		return
+	}
+
+	// closing the listener removes the socket file as well
+	c.localSocket.Close()
+	c.localSocket = nil
+}
+
+// LocalSocketClient gets credentials from the Swervo app listening on the local socket.
+type LocalSocketClient struct {
+	client *http.Client
+}
+
+func NewLocalSocketClient(path string) *LocalSocketClient {
+	return &LocalSocketClient{
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+	}
+}
+
+// GetRoleCredentials returns [ErrAppNotRunning] when no app listens on the socket,
+// errors the app answered with are mapped back to the errors of [awsidc.AwsIdentityCenterController].
+func (c *LocalSocketClient) GetRoleCredentials(ctx app.Context, instanceId, accountId, roleName string) (*awsidc.RoleCredentials, error) {
+	query := url.Values{}
+	query.Set("instanceId", instanceId)
+	query.Set("accountId", accountId)
+	query.Set("roleName", roleName)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://swervo"+localSocketCredentialsPath+"?"+query.Encode(), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.client.Do(req)
+
+	if err != nil {
+		var opErr *net.OpError
+
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return nil, ErrAppNotRunning
+		}
+
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		var credentials awsidc.RoleCredentials
+
+		if err := json.NewDecoder(res.Body).Decode(&credentials); err != nil {
+			return nil, err
+		}
+
+		return &credentials, nil
+	case http.StatusForbidden:
+		return nil, awsidc.ErrStaleAwsAccessToken
+	case http.StatusNotFound:
+		return nil, awsidc.ErrInstanceWasNotFound
+	case http.StatusServiceUnavailable:
+		return nil, awsidc.ErrTransientAwsClientError
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+
+	return nil, fmt.Errorf("the app answered with status %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+}
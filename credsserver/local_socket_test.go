@@ -0,0 +1,48 @@
+package credsserver
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalSocket(t *testing.T) {
+	controller, source, bus, _ := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	events := bus.Subscribe(CredentialsServerEventSource)
+
+	source.On("GetRoleCredentials", "test-instance-id", "test-account-id", "test-role-name").Return(testRoleCredentials, nil)
+	source.On("GetRoleCredentials", "test-instance-id", "test-account-id", "stale").Return(nil, awsidc.ErrStaleAwsAccessToken)
+
+	path := filepath.Join(t.TempDir(), LocalSocketName)
+	client := NewLocalSocketClient(path)
+
+	_, err := client.GetRoleCredentials(ctx, "test-instance-id", "test-account-id", "test-role-name")
+	require.Same(t, ErrAppNotRunning, err)
+
+	require.NoError(t, controller.StartLocalSocket(ctx, path))
+	require.Same(t, ErrServerAlreadyRunning, controller.StartLocalSocket(ctx, path))
+
+	credentials, err := client.GetRoleCredentials(ctx, "test-instance-id", "test-account-id", "test-role-name")
+	require.NoError(t, err)
+	require.Equal(t, testRoleCredentials, credentials)
+
+	envelope := <-events
+	event := envelope.Event.(CredentialsVendedEvent)
+	require.Equal(t, ServerKindLocalSocket, event.Server)
+	require.Equal(t, "test-role-name", event.RoleName)
+
+	_, err = client.GetRoleCredentials(ctx, "test-instance-id", "test-account-id", "stale")
+	require.Same(t, awsidc.ErrStaleAwsAccessToken, err)
+
+	controller.StopAll()
+
+	_, err = client.GetRoleCredentials(ctx, "test-instance-id", "test-account-id", "test-role-name")
+	require.Same(t, ErrAppNotRunning, err, "the socket must be gone once the vault is sealed")
+
+	require.NoError(t, controller.StartLocalSocket(ctx, path), "a socket left behind must not prevent listening again")
+}
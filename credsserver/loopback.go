@@ -0,0 +1,119 @@
+package credsserver
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loopbackServer serves HTTP on the loopback interface only, so that credentials never leave the machine.
+type loopbackServer struct {
+	mu       sync.Mutex
+	server   *http.Server
+	listener net.Listener
+	url      string
+}
+
+// validateLoopbackAddress accepts host:port addresses whose host is a loopback IP or localhost
+func validateLoopbackAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return ErrInvalidAddress
+	}
+
+	if portNumber, err := strconv.Atoi(port); err != nil || validatePort(portNumber) != nil {
+		return ErrInvalidAddress
+	}
+
+	if host == "localhost" {
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return ErrInvalidAddress
+	}
+
+	return nil
+}
+
+func (s *loopbackServer) start(address string, handler http.Handler) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.server != nil {
+		return "", ErrServerAlreadyRunning
+	}
+
+	if err := validateLoopbackAddress(address); err != nil {
+		return "", err
+	}
+
+	listener, err := net.Listen("tcp", address)
+
+	if err != nil {
+		return "", ErrPortUnavailable
+	}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go server.Serve(listener)
+
+	s.server = server
+	s.listener = listener
+	s.url = "http://" + listener.Addr().String()
+
+	return s.url, nil
+}
+
+// stop closes the server and every open connection right away
+func (s *loopbackServer) stop() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.server == nil {
+		return false
+	}
+
+	s.server.Close()
+	// Serve might not have started tracking the listener yet, the address must be free once stopped either way
+	s.listener.Close()
+	s.server = nil
+	s.listener = nil
+	s.url = ""
+
+	return true
+}
+
+func (s *loopbackServer) currentUrl() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.url, s.server != nil
+}
+
+func newAuthorizationToken() (string, error) {
+	token := make([]byte, 32)
+
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// isAuthorized accepts the token as is, which is how AWS SDKs send AWS_CONTAINER_AUTHORIZATION_TOKEN, or as a bearer token
+func isAuthorized(header, token string) bool {
+	header = strings.TrimPrefix(header, "Bearer ")
+
+	return subtle.ConstantTimeCompare([]byte(header), []byte(token)) == 1
+}
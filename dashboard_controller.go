@@ -6,6 +6,8 @@ import (
 	"github.com/abjrcode/swervo/favorites"
 	"github.com/abjrcode/swervo/internal/app"
 	"github.com/abjrcode/swervo/providers"
+	awsassumerole "github.com/abjrcode/swervo/providers/aws_assume_role"
+	awsiamuser "github.com/abjrcode/swervo/providers/aws_iam_user"
 	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
 )
 
@@ -26,7 +28,9 @@ type CompatibleSink struct {
 }
 
 var ProviderCompatibleSinksMap = map[string][]CompatibleSink{
-	awsidc.ProviderCode: {},
+	awsidc.ProviderCode:        {},
+	awsiamuser.ProviderCode:    {},
+	awsassumerole.ProviderCode: {},
 }
 
 type DashboardController struct {
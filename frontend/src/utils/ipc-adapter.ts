@@ -77,6 +77,9 @@ export function Plumbing_DisconnectSink(input: plumbing.DisconnectSinkCommandInp
 export function AwsCredentialsFile_NewInstance(input: awscredssink.AwsCredentialsSink_NewInstanceCommandInput): Promise<string> {
   return RunAppCommand("AwsCredentialsSink_NewInstance", input)
 }
+export function AwsCredentialsSink_ListInstances(): Promise<awscredssink.AwsCredentialsSinkInstance[]> {
+  return RunAppCommand("AwsCredentialsSink_ListInstances", {})
+}
 export function AwsCredentialsSink_GetInstanceData(instanceId: string): Promise<awscredssink.AwsCredentialsSinkInstance> {
   return RunAppCommand("AwsCredentialsSink_GetInstanceData", { instanceId })
 }
\ No newline at end of file
@@ -34,6 +34,31 @@ func NewValidationError(msg string) error {
 	return &ValidationError{ActualError: errors.New(msg)}
 }
 
+// IsError compares errors by identity, following wrapped and joined errors.
+// errors.Is cannot tell validation errors apart because every validation error matches every other validation error.
+func IsError(err, target error) bool {
+	if err == nil {
+		return false
+	}
+
+	if err == target {
+		return true
+	}
+
+	switch wrapped := err.(type) {
+	case interface{ Unwrap() error }:
+		return IsError(wrapped.Unwrap(), target)
+	case interface{ Unwrap() []error }:
+		for _, err := range wrapped.Unwrap() {
+			if IsError(err, target) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 type ErrorHandler interface {
 	Catch(ctx Context, logger zerolog.Logger, err error)
 	CatchWithMsg(ctx Context, logger zerolog.Logger, err error, msg string)
@@ -0,0 +1,22 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsError(t *testing.T) {
+	errFirst := NewValidationError("FIRST")
+	errSecond := NewValidationError("SECOND")
+
+	require.True(t, errors.Is(errFirst, errSecond), "validation errors match each other with errors.Is")
+
+	require.True(t, IsError(errFirst, errFirst))
+	require.False(t, IsError(errFirst, errSecond))
+	require.True(t, IsError(fmt.Errorf("wrapped: %w", errFirst), errFirst))
+	require.True(t, IsError(errors.Join(errors.New("cause"), ErrFatal), ErrFatal))
+	require.False(t, IsError(nil, errFirst))
+}
@@ -0,0 +1,217 @@
+// Package credsformat renders AWS credentials in the many ways shells and tools accept them.
+package credsformat
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/app"
+)
+
+var (
+	ErrUnsupportedFormat = app.NewValidationError("UNSUPPORTED_CREDENTIALS_FORMAT")
+	// ErrUnsafeCredentials means that the credentials contain characters the format cannot represent safely
+	ErrUnsafeCredentials = app.NewValidationError("CREDENTIALS_UNSAFE_FOR_FORMAT")
+)
+
+type Format string
+
+const (
+	// FormatSh works with any POSIX shell, e.g. sh, bash and zsh
+	FormatSh         Format = "sh"
+	FormatFish       Format = "fish"
+	FormatNushell    Format = "nushell"
+	FormatPowerShell Format = "powershell"
+	// FormatCmd is for the Windows command prompt
+	FormatCmd Format = "cmd"
+	// FormatCredentialProcess is the version 1 output of an AWS CLI credential_process
+	FormatCredentialProcess Format = "credential_process"
+	FormatDotenv            Format = "dotenv"
+)
+
+// Credentials are the AWS credentials to render along with optional extras.
+type Credentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	// SessionToken is left out when empty
+	SessionToken string
+	// Expiration is a Unix time in seconds, it is left out when zero
+	Expiration int64
+	// Region is left out when empty
+	Region string
+}
+
+// Formatter renders credentials in a single format.
+type Formatter func(credentials Credentials) (string, error)
+
+// Registry knows the formats credentials can be rendered in.
+type Registry struct {
+	mu         sync.RWMutex
+	formatters map[Format]Formatter
+}
+
+// NewRegistry returns a registry with every built-in format registered.
+func NewRegistry() *Registry {
+	return &Registry{
+		formatters: map[Format]Formatter{
+			FormatSh:                assignments(func(name, value string) string { return fmt.Sprintf("export %s=%s", name, quoteSh(value)) }),
+			FormatFish:              assignments(func(name, value string) string { return fmt.Sprintf("set -gx %s %s", name, quoteFish(value)) }),
+			FormatNushell:           assignments(func(name, value string) string { return fmt.Sprintf("$env.%s = %s", name, quoteDouble(value)) }),
+			FormatPowerShell:        assignments(func(name, value string) string { return fmt.Sprintf("$Env:%s = %s", name, quotePowerShell(value)) }),
+			FormatCmd:               cmd,
+			FormatDotenv:            assignments(func(name, value string) string { return fmt.Sprintf("%s=%s", name, quoteDouble(value)) }),
+			FormatCredentialProcess: credentialProcess,
+		},
+	}
+}
+
+// Register adds a format or replaces an existing one.
+func (r *Registry) Register(format Format, formatter Formatter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.formatters[format] = formatter
+}
+
+// Formats lists the registered formats sorted by name.
+func (r *Registry) Formats() []Format {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	formats := make([]Format, 0, len(r.formatters))
+
+	for format := range r.formatters {
+		formats = append(formats, format)
+	}
+
+	sort.Slice(formats, func(i, j int) bool { return formats[i] < formats[j] })
+
+	return formats
+}
+
+// Format renders credentials, an empty format falls back to [DefaultFormat].
+func (r *Registry) Format(format Format, credentials Credentials) (string, error) {
+	if format == "" {
+		format = DefaultFormat()
+	}
+
+	r.mu.RLock()
+	formatter, ok := r.formatters[format]
+	r.mu.RUnlock()
+
+	if !ok {
+		return "", ErrUnsupportedFormat
+	}
+
+	return formatter(credentials)
+}
+
+// DefaultFormat is the format of the default shell of the operating system.
+func DefaultFormat() Format {
+	if runtime.GOOS == "windows" {
+		return FormatPowerShell
+	}
+
+	return FormatSh
+}
+
+func formatExpiration(expiration int64) string {
+	return time.Unix(expiration, 0).UTC().Format(time.RFC3339)
+}
+
+// variables are the environment variables AWS SDKs and the AWS CLI read credentials from
+func variables(credentials Credentials) [][2]string {
+	result := [][2]string{
+		{"AWS_ACCESS_KEY_ID", credentials.AccessKeyId},
+		{"AWS_SECRET_ACCESS_KEY", credentials.SecretAccessKey},
+	}
+
+	if credentials.SessionToken != "" {
+		result = append(result, [2]string{"AWS_SESSION_TOKEN", credentials.SessionToken})
+	}
+
+	if credentials.Expiration != 0 {
+		result = append(result, [2]string{"AWS_CREDENTIAL_EXPIRATION", formatExpiration(credentials.Expiration)})
+	}
+
+	if credentials.Region != "" {
+		result = append(result, [2]string{"AWS_REGION", credentials.Region})
+	}
+
+	return result
+}
+
+// assignments renders one line per environment variable
+func assignments(assign func(name, value string) string) Formatter {
+	return func(credentials Credentials) (string, error) {
+		lines := make([]string, 0, 5)
+
+		for _, variable := range variables(credentials) {
+			lines = append(lines, assign(variable[0], variable[1]))
+		}
+
+		return strings.Join(lines, "\n"), nil
+	}
+}
+
+// cmdUnsafeCharacters cannot be escaped inside a quoted set command of the Windows command prompt:
+// variables are expanded between % (and ! with delayed expansion) and a " or a line break ends the quoted assignment
+const cmdUnsafeCharacters = "%!^\"\r\n"
+
+func cmd(credentials Credentials) (string, error) {
+	for _, variable := range variables(credentials) {
+		if strings.ContainsAny(variable[1], cmdUnsafeCharacters) {
+			return "", ErrUnsafeCredentials
+		}
+	}
+
+	return assignments(func(name, value string) string { return fmt.Sprintf(`set "%s=%s"`, name, value) })(credentials)
+}
+
+func credentialProcess(credentials Credentials) (string, error) {
+	output := struct {
+		Version         int
+		AccessKeyId     string
+		SecretAccessKey string
+		SessionToken    string `json:",omitempty"`
+		Expiration      string `json:",omitempty"`
+	}{
+		Version:         1,
+		AccessKeyId:     credentials.AccessKeyId,
+		SecretAccessKey: credentials.SecretAccessKey,
+		SessionToken:    credentials.SessionToken,
+	}
+
+	if credentials.Expiration != 0 {
+		output.Expiration = formatExpiration(credentials.Expiration)
+	}
+
+	result, err := json.Marshal(output)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(result), nil
+}
+
+func quoteSh(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func quoteFish(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+}
+
+func quotePowerShell(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func quoteDouble(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
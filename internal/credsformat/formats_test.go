@@ -0,0 +1,150 @@
+package credsformat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testCredentials = Credentials{
+	AccessKeyId:     "test-access-key-id",
+	SecretAccessKey: "test/secret'key",
+	SessionToken:    "test-session-token",
+	Expiration:      1700000000,
+	Region:          "eu-west-1",
+}
+
+func TestFormat(t *testing.T) {
+	testCases := []struct {
+		format   Format
+		expected string
+	}{
+		{
+			format: FormatSh,
+			expected: `export AWS_ACCESS_KEY_ID='test-access-key-id'
+export AWS_SECRET_ACCESS_KEY='test/secret'\''key'
+export AWS_SESSION_TOKEN='test-session-token'
+export AWS_CREDENTIAL_EXPIRATION='2023-11-14T22:13:20Z'
+export AWS_REGION='eu-west-1'`,
+		},
+		{
+			format: FormatFish,
+			expected: `set -gx AWS_ACCESS_KEY_ID 'test-access-key-id'
+set -gx AWS_SECRET_ACCESS_KEY 'test/secret\'key'
+set -gx AWS_SESSION_TOKEN 'test-session-token'
+set -gx AWS_CREDENTIAL_EXPIRATION '2023-11-14T22:13:20Z'
+set -gx AWS_REGION 'eu-west-1'`,
+		},
+		{
+			format: FormatNushell,
+			expected: `$env.AWS_ACCESS_KEY_ID = "test-access-key-id"
+$env.AWS_SECRET_ACCESS_KEY = "test/secret'key"
+$env.AWS_SESSION_TOKEN = "test-session-token"
+$env.AWS_CREDENTIAL_EXPIRATION = "2023-11-14T22:13:20Z"
+$env.AWS_REGION = "eu-west-1"`,
+		},
+		{
+			format: FormatPowerShell,
+			expected: `$Env:AWS_ACCESS_KEY_ID = 'test-access-key-id'
+$Env:AWS_SECRET_ACCESS_KEY = 'test/secret''key'
+$Env:AWS_SESSION_TOKEN = 'test-session-token'
+$Env:AWS_CREDENTIAL_EXPIRATION = '2023-11-14T22:13:20Z'
+$Env:AWS_REGION = 'eu-west-1'`,
+		},
+		{
+			format: FormatCmd,
+			expected: `set "AWS_ACCESS_KEY_ID=test-access-key-id"
+set "AWS_SECRET_ACCESS_KEY=test/secret'key"
+set "AWS_SESSION_TOKEN=test-session-token"
+set "AWS_CREDENTIAL_EXPIRATION=2023-11-14T22:13:20Z"
+set "AWS_REGION=eu-west-1"`,
+		},
+		{
+			format: FormatDotenv,
+			expected: `AWS_ACCESS_KEY_ID="test-access-key-id"
+AWS_SECRET_ACCESS_KEY="test/secret'key"
+AWS_SESSION_TOKEN="test-session-token"
+AWS_CREDENTIAL_EXPIRATION="2023-11-14T22:13:20Z"
+AWS_REGION="eu-west-1"`,
+		},
+	}
+
+	registry := NewRegistry()
+
+	for _, testCase := range testCases {
+		t.Run(string(testCase.format), func(t *testing.T) {
+			output, err := registry.Format(testCase.format, testCredentials)
+			require.NoError(t, err)
+			require.Equal(t, testCase.expected, output)
+		})
+	}
+}
+
+func TestFormat_OptionalVariables(t *testing.T) {
+	output, err := NewRegistry().Format(FormatSh, Credentials{
+		AccessKeyId:     "test-access-key-id",
+		SecretAccessKey: "test-secret-key",
+	})
+	require.NoError(t, err)
+	require.Equal(t, `export AWS_ACCESS_KEY_ID='test-access-key-id'
+export AWS_SECRET_ACCESS_KEY='test-secret-key'`, output)
+}
+
+func TestFormat_CmdRejectsUnsafeCharacters(t *testing.T) {
+	registry := NewRegistry()
+
+	for _, secretAccessKey := range []string{"test%PATH%key", `test"key`, "test^key", "test!key", "test\nkey"} {
+		_, err := registry.Format(FormatCmd, Credentials{
+			AccessKeyId:     "test-access-key-id",
+			SecretAccessKey: secretAccessKey,
+		})
+		require.Same(t, ErrUnsafeCredentials, err)
+	}
+}
+
+func TestFormat_CredentialProcess(t *testing.T) {
+	registry := NewRegistry()
+
+	output, err := registry.Format(FormatCredentialProcess, testCredentials)
+	require.NoError(t, err)
+
+	var parsed map[string]any
+	require.NoError(t, json.Unmarshal([]byte(output), &parsed))
+	require.Equal(t, map[string]any{
+		"Version":         float64(1),
+		"AccessKeyId":     "test-access-key-id",
+		"SecretAccessKey": "test/secret'key",
+		"SessionToken":    "test-session-token",
+		"Expiration":      "2023-11-14T22:13:20Z",
+	}, parsed)
+
+	output, err = registry.Format(FormatCredentialProcess, Credentials{AccessKeyId: "test-access-key-id", SecretAccessKey: "test-secret-key"})
+	require.NoError(t, err)
+	require.Equal(t, `{"Version":1,"AccessKeyId":"test-access-key-id","SecretAccessKey":"test-secret-key"}`, output)
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	_, err := registry.Format("xonsh", testCredentials)
+	require.Same(t, ErrUnsupportedFormat, err)
+
+	registry.Register("xonsh", func(credentials Credentials) (string, error) {
+		return "$AWS_ACCESS_KEY_ID = '" + credentials.AccessKeyId + "'", nil
+	})
+
+	output, err := registry.Format("xonsh", testCredentials)
+	require.NoError(t, err)
+	require.Equal(t, "$AWS_ACCESS_KEY_ID = 'test-access-key-id'", output)
+
+	require.Contains(t, registry.Formats(), Format("xonsh"))
+	require.Len(t, registry.Formats(), 8)
+
+	defaultOutput, err := registry.Format("", testCredentials)
+	require.NoError(t, err)
+
+	expected, err := registry.Format(DefaultFormat(), testCredentials)
+	require.NoError(t, err)
+	require.Equal(t, expected, defaultOutput)
+}
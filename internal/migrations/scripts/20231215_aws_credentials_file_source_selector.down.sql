@@ -0,0 +1,2 @@
+ALTER TABLE "aws_credentials_file" DROP COLUMN "source_role_name";
+ALTER TABLE "aws_credentials_file" DROP COLUMN "source_account_id";
\ No newline at end of file
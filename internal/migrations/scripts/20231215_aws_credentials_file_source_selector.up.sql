@@ -0,0 +1,2 @@
+ALTER TABLE "aws_credentials_file" ADD COLUMN "source_account_id" TEXT NOT NULL DEFAULT '';
+ALTER TABLE "aws_credentials_file" ADD COLUMN "source_role_name" TEXT NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE "aws_sso_clients" DROP COLUMN "scopes";
\ No newline at end of file
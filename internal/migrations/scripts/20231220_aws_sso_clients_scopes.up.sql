@@ -0,0 +1 @@
+ALTER TABLE "aws_sso_clients" ADD COLUMN "scopes" TEXT NOT NULL DEFAULT '';
\ No newline at end of file
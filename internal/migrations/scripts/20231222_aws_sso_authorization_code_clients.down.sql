@@ -0,0 +1,3 @@
+ALTER TABLE "aws_idc" DROP COLUMN "login_flow";
+
+DROP TABLE IF EXISTS "aws_sso_authorization_code_clients";
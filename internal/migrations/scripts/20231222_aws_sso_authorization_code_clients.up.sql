@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS "aws_sso_authorization_code_clients" (
+	"start_url"	TEXT NOT NULL COLLATE NOCASE,
+	"region"	TEXT NOT NULL,
+	"client_id"	TEXT NOT NULL,
+	"client_secret_enc"	BLOB NOT NULL,
+	"created_at"	INTEGER NOT NULL,
+	"expires_at"	INTEGER NOT NULL,
+	"enc_key_id"	TEXT NOT NULL,
+	PRIMARY KEY("start_url")
+) WITHOUT ROWID;
+
+ALTER TABLE "aws_idc" ADD COLUMN "login_flow" TEXT NOT NULL DEFAULT 'device_code';
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "aws_sso_endpoint_overrides";
+
+DROP TABLE IF EXISTS "network_settings";
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS "network_settings" (
+	"id"	INTEGER NOT NULL CHECK ("id" = 1),
+	"proxy_mode"	TEXT NOT NULL,
+	"proxy_url"	TEXT NOT NULL,
+	"ca_bundle_pem"	TEXT NOT NULL,
+	"request_timeout_seconds"	INTEGER NOT NULL,
+	PRIMARY KEY("id")
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS "aws_sso_endpoint_overrides" (
+	"region"	TEXT NOT NULL,
+	"oidc_endpoint"	TEXT NOT NULL,
+	"portal_endpoint"	TEXT NOT NULL,
+	PRIMARY KEY("region")
+) WITHOUT ROWID;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS "aws_custom_regions";
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS "aws_custom_regions" (
+	"region"	TEXT NOT NULL,
+	PRIMARY KEY("region")
+) WITHOUT ROWID;
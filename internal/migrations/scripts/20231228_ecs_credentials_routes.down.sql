@@ -0,0 +1 @@
+DROP TABLE IF EXISTS "ecs_credentials_routes";
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS "ecs_credentials_routes" (
+	"path"	TEXT NOT NULL,
+	"instance_id"	TEXT NOT NULL,
+	"account_id"	TEXT NOT NULL,
+	"role_name"	TEXT NOT NULL,
+	PRIMARY KEY("path")
+) WITHOUT ROWID;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS "aws_iam_user";
\ No newline at end of file
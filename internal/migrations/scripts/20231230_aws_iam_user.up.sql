@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS "aws_iam_user" (
+	"instance_id"	TEXT NOT NULL UNIQUE COLLATE NOCASE,
+	"version" INTEGER NOT NULL,
+	"label"	TEXT NOT NULL,
+	"region"	TEXT NOT NULL,
+	"access_key_id_enc"	TEXT NOT NULL,
+	"secret_access_key_enc"	TEXT NOT NULL,
+	"mfa_serial"	TEXT NOT NULL,
+	"session_duration_seconds"	INTEGER NOT NULL,
+	"session_access_key_id_enc"	TEXT,
+	"session_secret_access_key_enc"	TEXT,
+	"session_token_enc"	TEXT,
+	"session_expires_at"	INTEGER,
+	"session_enc_key_id"	TEXT,
+	"created_at"	INTEGER NOT NULL,
+	"enc_key_id"	TEXT NOT NULL,
+	PRIMARY KEY("instance_id")
+) WITHOUT ROWID;
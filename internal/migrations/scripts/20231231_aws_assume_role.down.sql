@@ -0,0 +1 @@
+DROP TABLE IF EXISTS "aws_assume_role";
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS "aws_assume_role" (
+	"instance_id"	TEXT NOT NULL UNIQUE COLLATE NOCASE,
+	"version" INTEGER NOT NULL,
+	"label"	TEXT NOT NULL,
+	"region"	TEXT NOT NULL,
+	"source_provider_code"	TEXT NOT NULL,
+	"source_provider_id"	TEXT NOT NULL,
+	"source_account_id"	TEXT NOT NULL,
+	"source_role_name"	TEXT NOT NULL,
+	"hops"	TEXT NOT NULL,
+	"created_at"	INTEGER NOT NULL,
+	PRIMARY KEY("instance_id")
+) WITHOUT ROWID;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS "totp_authenticator";
\ No newline at end of file
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS "totp_authenticator" (
+	"instance_id"	TEXT NOT NULL UNIQUE COLLATE NOCASE,
+	"version" INTEGER NOT NULL,
+	"label"	TEXT NOT NULL,
+	"mfa_serial"	TEXT NOT NULL UNIQUE,
+	"issuer"	TEXT NOT NULL,
+	"account_name"	TEXT NOT NULL,
+	"algorithm"	TEXT NOT NULL,
+	"digits"	INTEGER NOT NULL,
+	"period"	INTEGER NOT NULL,
+	"secret_enc"	TEXT NOT NULL,
+	"last_used_step"	INTEGER NOT NULL,
+	"created_at"	INTEGER NOT NULL,
+	"enc_key_id"	TEXT NOT NULL,
+	PRIMARY KEY("instance_id")
+) WITHOUT ROWID;
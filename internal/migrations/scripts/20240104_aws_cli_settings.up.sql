@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS "aws_cli_settings" (
+	"id"	INTEGER NOT NULL CHECK ("id" = 1),
+	"share_sso_tokens"	INTEGER NOT NULL,
+	PRIMARY KEY("id")
+) WITHOUT ROWID;
@@ -2,9 +2,21 @@ package plumbing
 
 import "github.com/abjrcode/swervo/internal/app"
 
+// SourceSelector picks which identity of a provider instance feeds a sink.
+// For AWS Identity Center it is an account and a role within that account.
+type SourceSelector struct {
+	AccountId string `json:"accountId"`
+	RoleName  string `json:"roleName"`
+}
+
+func (s SourceSelector) IsEmpty() bool {
+	return s.AccountId == "" && s.RoleName == ""
+}
+
 type SinkInstance struct {
-	SinkCode string `json:"sinkCode"`
-	SinkId   string `json:"sinkId"`
+	SinkCode       string         `json:"sinkCode"`
+	SinkId         string         `json:"sinkId"`
+	SourceSelector SourceSelector `json:"sourceSelector"`
 }
 
 type DisconnectSinkCommandInput struct {
@@ -21,3 +33,16 @@ type Plumber[T interface{}] interface {
 
 	FlowData(ctx app.Context, data T, sinkId string) error
 }
+
+// SourceValidator checks that a provider instance is able to feed a sink with the selected identity.
+type SourceValidator interface {
+	ProviderCode() string
+
+	ValidateSourceSelector(ctx app.Context, providerId string, selector SourceSelector) error
+}
+
+// InstanceListener learns about provider instances that changed, e.g. because they got new credentials,
+// so that what is built on top of them can be fed again.
+type InstanceListener interface {
+	InstanceChanged(ctx app.Context, providerCode, providerId string)
+}
@@ -0,0 +1,392 @@
+package plumbing
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/utils"
+)
+
+// Pipe connects a provider instance to one of its sinks.
+type Pipe struct {
+	ProviderCode   string
+	ProviderId     string
+	SinkCode       string
+	SinkId         string
+	SourceSelector SourceSelector
+}
+
+// DesiredPipes lists the pipes a pump should be running along with the plumber each one flows into.
+type DesiredPipes[T interface{}] func(ctx app.Context) (map[Pipe]Plumber[T], error)
+
+// PumpSource produces the data that flows through a pipe
+// along with the Unix time (in seconds) at which that data expires.
+type PumpSource[T interface{}] func(ctx app.Context, pipe Pipe) (data T, expiresAt int64, err error)
+
+type PumpOptions struct {
+	// RefreshBefore is how long before the data expires it is fetched again.
+	RefreshBefore time.Duration
+	// MaxJitter is the upper bound of a random delay subtracted from every refresh
+	// so that pipes created at the same time do not hit the provider in lockstep.
+	MaxJitter time.Duration
+	// MinInterval is the shortest time between two successful flows through the same pipe.
+	MinInterval time.Duration
+
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// IsRetryable reports whether a failed flow should be retried with exponential backoff.
+	IsRetryable func(err error) bool
+	// IsStopping reports whether a failed flow should stop every pipe of the same provider instance.
+	IsStopping func(err error) bool
+}
+
+var DefaultPumpOptions = PumpOptions{
+	RefreshBefore:  5 * time.Minute,
+	MaxJitter:      30 * time.Second,
+	MinInterval:    1 * time.Minute,
+	InitialBackoff: 5 * time.Second,
+	MaxBackoff:     5 * time.Minute,
+	IsRetryable:    func(err error) bool { return false },
+	IsStopping:     func(err error) bool { return false },
+}
+
+type providerInstance struct {
+	providerCode string
+	providerId   string
+}
+
+type runningPipe struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Pump keeps data flowing from a provider into connected sinks by fetching it
+// again shortly before it expires. Every pipe runs on its own goroutine.
+// Pipes that failed for good, and every pipe of an instance that failed with a stopping error, are halted:
+// reconciliation leaves them disconnected until [Pump.Resume] is called for their provider instance.
+type Pump[T interface{}] struct {
+	source  PumpSource[T]
+	clock   utils.Clock
+	options PumpOptions
+
+	mu      sync.Mutex
+	running map[Pipe]*runningPipe
+
+	haltedMu        sync.Mutex
+	haltedProviders map[providerInstance]struct{}
+	haltedPipes     map[Pipe]struct{}
+
+	loopMu   sync.Mutex
+	stopLoop func()
+}
+
+func NewPump[T interface{}](source PumpSource[T], clock utils.Clock, options PumpOptions) *Pump[T] {
+	return &Pump[T]{
+		source:  source,
+		clock:   clock,
+		options: options,
+		running: make(map[Pipe]*runningPipe),
+
+		haltedProviders: make(map[providerInstance]struct{}),
+		haltedPipes:     make(map[Pipe]struct{}),
+	}
+}
+
+// Connect starts pumping data through the pipe into the plumber.
+// It returns false if the pipe is already running.
+func (p *Pump[T]) Connect(ctx app.Context, pipe Pipe, plumber Plumber[T]) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.running[pipe]; ok {
+		return false
+	}
+
+	pipeCtx, cancel := context.WithCancel(ctx)
+
+	logger := ctx.Logger().With().
+		Str("provider_code", pipe.ProviderCode).
+		Str("provider_id", pipe.ProviderId).
+		Str("sink_code", pipe.SinkCode).
+		Str("sink_id", pipe.SinkId).
+		Logger()
+
+	requestId := utils.NewRequestId()
+
+	run := &runningPipe{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	p.running[pipe] = run
+
+	go func() {
+		defer close(run.done)
+
+		p.flow(app.NewContext(logger.WithContext(pipeCtx), ctx.UserId(), requestId, ctx.RequestId(), ctx.CorrelationId(), &logger), pipe, plumber)
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if p.running[pipe] == run {
+			delete(p.running, pipe)
+		}
+	}()
+
+	return true
+}
+
+// Disconnect stops the pipe and waits for it to drain.
+func (p *Pump[T]) Disconnect(pipe Pipe) {
+	p.mu.Lock()
+	run, ok := p.running[pipe]
+	delete(p.running, pipe)
+	p.mu.Unlock()
+
+	if ok {
+		run.cancel()
+		<-run.done
+	}
+}
+
+// DisconnectProvider stops every pipe fed by the given provider instance.
+func (p *Pump[T]) DisconnectProvider(providerCode, providerId string) {
+	for _, pipe := range p.Pipes() {
+		if pipe.ProviderCode == providerCode && pipe.ProviderId == providerId {
+			p.Disconnect(pipe)
+		}
+	}
+}
+
+// Reconcile connects the desired pipes that are not running yet, unless they are halted,
+// and disconnects running pipes that are no longer desired.
+func (p *Pump[T]) Reconcile(ctx app.Context, desired map[Pipe]Plumber[T]) {
+	for _, pipe := range p.Pipes() {
+		if _, ok := desired[pipe]; !ok {
+			p.Disconnect(pipe)
+		}
+	}
+
+	p.forgetHaltedPipes(desired)
+
+	for pipe, plumber := range desired {
+		if p.isHalted(pipe) {
+			continue
+		}
+
+		p.Connect(ctx, pipe, plumber)
+	}
+}
+
+// Resume lets reconciliation connect the pipes of a provider instance again after they were halted,
+// it is meant to be called once the instance changed, e.g. because it got new credentials.
+func (p *Pump[T]) Resume(providerCode, providerId string) {
+	p.haltedMu.Lock()
+	defer p.haltedMu.Unlock()
+
+	delete(p.haltedProviders, providerInstance{providerCode: providerCode, providerId: providerId})
+
+	for pipe := range p.haltedPipes {
+		if pipe.ProviderCode == providerCode && pipe.ProviderId == providerId {
+			delete(p.haltedPipes, pipe)
+		}
+	}
+}
+
+func (p *Pump[T]) haltProvider(providerCode, providerId string) {
+	p.haltedMu.Lock()
+	defer p.haltedMu.Unlock()
+
+	p.haltedProviders[providerInstance{providerCode: providerCode, providerId: providerId}] = struct{}{}
+}
+
+func (p *Pump[T]) haltPipe(pipe Pipe) {
+	p.haltedMu.Lock()
+	defer p.haltedMu.Unlock()
+
+	p.haltedPipes[pipe] = struct{}{}
+}
+
+func (p *Pump[T]) isHalted(pipe Pipe) bool {
+	p.haltedMu.Lock()
+	defer p.haltedMu.Unlock()
+
+	if _, ok := p.haltedProviders[providerInstance{providerCode: pipe.ProviderCode, providerId: pipe.ProviderId}]; ok {
+		return true
+	}
+
+	_, ok := p.haltedPipes[pipe]
+
+	return ok
+}
+
+// forgetHaltedPipes drops halted pipes that are no longer desired, e.g. because their sink was disconnected,
+// so that connecting the same sink again starts afresh.
+func (p *Pump[T]) forgetHaltedPipes(desired map[Pipe]Plumber[T]) {
+	p.haltedMu.Lock()
+	defer p.haltedMu.Unlock()
+
+	for pipe := range p.haltedPipes {
+		if _, ok := desired[pipe]; !ok {
+			delete(p.haltedPipes, pipe)
+		}
+	}
+}
+
+// Start reconciles the pump with the desired pipes right away and then every interval on a goroutine of its own
+// until [Pump.Stop] is called. It returns false if the pump was already started.
+func (p *Pump[T]) Start(ctx app.Context, interval time.Duration, desired DesiredPipes[T]) bool {
+	p.loopMu.Lock()
+	defer p.loopMu.Unlock()
+
+	if p.stopLoop != nil {
+		return false
+	}
+
+	loopCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	appCtx := app.NewContext(loopCtx, ctx.UserId(), ctx.RequestId(), ctx.CausationId(), ctx.CorrelationId(), ctx.Logger())
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			pipes, err := desired(appCtx)
+
+			if err != nil {
+				appCtx.Logger().Error().Err(err).Msg("failed to list desired pipes")
+			} else if appCtx.Err() == nil {
+				p.Reconcile(appCtx, pipes)
+			}
+
+			select {
+			case <-loopCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	p.stopLoop = func() {
+		cancel()
+		<-done
+	}
+
+	return true
+}
+
+// Pipes returns the running pipes ordered by sink.
+func (p *Pump[T]) Pipes() []Pipe {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pipes := make([]Pipe, 0, len(p.running))
+
+	for pipe := range p.running {
+		pipes = append(pipes, pipe)
+	}
+
+	sort.Slice(pipes, func(i, j int) bool {
+		if pipes[i].SinkCode != pipes[j].SinkCode {
+			return pipes[i].SinkCode < pipes[j].SinkCode
+		}
+
+		return pipes[i].SinkId < pipes[j].SinkId
+	})
+
+	return pipes
+}
+
+// Stop ends the reconciliation started by [Pump.Start], if any, then disconnects all pipes and waits for them to drain.
+func (p *Pump[T]) Stop() {
+	p.loopMu.Lock()
+
+	if p.stopLoop != nil {
+		p.stopLoop()
+		p.stopLoop = nil
+	}
+
+	p.loopMu.Unlock()
+
+	for _, pipe := range p.Pipes() {
+		p.Disconnect(pipe)
+	}
+}
+
+func (p *Pump[T]) flow(ctx app.Context, pipe Pipe, plumber Plumber[T]) {
+	backoff := p.options.InitialBackoff
+
+	for {
+		data, expiresAt, err := p.source(ctx, pipe)
+
+		if err == nil {
+			err = plumber.FlowData(ctx, data, pipe.SinkId)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		var wait time.Duration
+
+		switch {
+		case err == nil:
+			backoff = p.options.InitialBackoff
+
+			expiresIn := time.Duration(expiresAt-p.clock.NowUnix()) * time.Second
+			wait = expiresIn - p.options.RefreshBefore - jitter(p.options.MaxJitter)
+
+			if wait < p.options.MinInterval {
+				wait = p.options.MinInterval
+			}
+
+			ctx.Logger().Debug().Msgf("data flowed, next refresh in %s", wait)
+		case p.options.IsStopping(err):
+			ctx.Logger().Info().Err(err).Msg("stopping all pipes of provider instance until it changes")
+
+			p.haltProvider(pipe.ProviderCode, pipe.ProviderId)
+
+			go p.DisconnectProvider(pipe.ProviderCode, pipe.ProviderId)
+			return
+		case p.options.IsRetryable(err):
+			wait = backoff/2 + jitter(backoff/2)
+
+			backoff *= 2
+			if backoff > p.options.MaxBackoff {
+				backoff = p.options.MaxBackoff
+			}
+
+			ctx.Logger().Warn().Err(err).Msgf("flow failed, retrying in %s", wait)
+		default:
+			ctx.Logger().Error().Err(err).Msg("flow failed, disconnecting pipe until its provider instance changes")
+
+			p.haltPipe(pipe)
+			return
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(max)))
+}
@@ -0,0 +1,319 @@
+package plumbing
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	errRetryable = errors.New("retryable")
+	errStopping  = errors.New("stopping")
+	errOther     = errors.New("other")
+)
+
+type fakePlumber struct {
+	flows chan string
+}
+
+func newFakePlumber() *fakePlumber {
+	return &fakePlumber{flows: make(chan string, 100)}
+}
+
+func (p *fakePlumber) SinkCode() string {
+	return "fake-sink"
+}
+
+func (p *fakePlumber) ListConnectedSinks(ctx app.Context, providerCode, providerId string) ([]SinkInstance, error) {
+	return []SinkInstance{}, nil
+}
+
+func (p *fakePlumber) DisconnectSink(ctx app.Context, input DisconnectSinkCommandInput) error {
+	return nil
+}
+
+func (p *fakePlumber) FlowData(ctx app.Context, data string, sinkId string) error {
+	p.flows <- sinkId + ":" + data
+	return nil
+}
+
+func testPumpOptions() PumpOptions {
+	return PumpOptions{
+		RefreshBefore:  0,
+		MaxJitter:      0,
+		MinInterval:    5 * time.Millisecond,
+		InitialBackoff: 2 * time.Millisecond,
+		MaxBackoff:     8 * time.Millisecond,
+		IsRetryable:    func(err error) bool { return errors.Is(err, errRetryable) },
+		IsStopping:     func(err error) bool { return errors.Is(err, errStopping) },
+	}
+}
+
+func receive(t *testing.T, ch <-chan string) string {
+	select {
+	case got := <-ch:
+		return got
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for data to flow")
+		return ""
+	}
+}
+
+func TestPump_FlowsAndRefreshes(t *testing.T) {
+	mockClock := testhelpers.NewMockClock()
+	mockClock.On("NowUnix").Return(100)
+
+	pump := NewPump(func(ctx app.Context, pipe Pipe) (string, int64, error) {
+		return "creds", 100, nil
+	}, mockClock, testPumpOptions())
+	t.Cleanup(pump.Stop)
+
+	plumber := newFakePlumber()
+	pipe := Pipe{ProviderCode: "provider", ProviderId: "p1", SinkCode: "fake-sink", SinkId: "s1"}
+
+	require.True(t, pump.Connect(testhelpers.NewMockAppContext(), pipe, plumber))
+	require.False(t, pump.Connect(testhelpers.NewMockAppContext(), pipe, plumber))
+
+	require.Equal(t, "s1:creds", receive(t, plumber.flows))
+	require.Equal(t, "s1:creds", receive(t, plumber.flows))
+
+	require.Equal(t, []Pipe{pipe}, pump.Pipes())
+}
+
+func TestPump_RetriesTransientErrors(t *testing.T) {
+	mockClock := testhelpers.NewMockClock()
+	mockClock.On("NowUnix").Return(100)
+
+	var mu sync.Mutex
+	attempts := 0
+
+	pump := NewPump(func(ctx app.Context, pipe Pipe) (string, int64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts++
+		if attempts < 3 {
+			return "", 0, errRetryable
+		}
+
+		return "creds", 100, nil
+	}, mockClock, testPumpOptions())
+	t.Cleanup(pump.Stop)
+
+	plumber := newFakePlumber()
+	pipe := Pipe{ProviderCode: "provider", ProviderId: "p1", SinkCode: "fake-sink", SinkId: "s1"}
+
+	pump.Connect(testhelpers.NewMockAppContext(), pipe, plumber)
+
+	require.Equal(t, "s1:creds", receive(t, plumber.flows))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.GreaterOrEqual(t, attempts, 3)
+}
+
+func TestPump_StoppingErrorDisconnectsProvider(t *testing.T) {
+	mockClock := testhelpers.NewMockClock()
+	mockClock.On("NowUnix").Return(100)
+
+	pump := NewPump(func(ctx app.Context, pipe Pipe) (string, int64, error) {
+		if pipe.ProviderId == "p1" && pipe.SinkId == "s2" {
+			return "", 0, errStopping
+		}
+
+		return "creds", 1000, nil
+	}, mockClock, testPumpOptions())
+	t.Cleanup(pump.Stop)
+
+	plumber := newFakePlumber()
+	ctx := testhelpers.NewMockAppContext()
+
+	pipe1 := Pipe{ProviderCode: "provider", ProviderId: "p1", SinkCode: "fake-sink", SinkId: "s1"}
+	pipe2 := Pipe{ProviderCode: "provider", ProviderId: "p1", SinkCode: "fake-sink", SinkId: "s2"}
+	pipe3 := Pipe{ProviderCode: "provider", ProviderId: "p2", SinkCode: "fake-sink", SinkId: "s3"}
+
+	pump.Connect(ctx, pipe1, plumber)
+	pump.Connect(ctx, pipe3, plumber)
+	pump.Connect(ctx, pipe2, plumber)
+
+	require.Eventually(t, func() bool {
+		pipes := pump.Pipes()
+		return len(pipes) == 1 && pipes[0] == pipe3
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestPump_OtherErrorDisconnectsPipe(t *testing.T) {
+	mockClock := testhelpers.NewMockClock()
+	mockClock.On("NowUnix").Return(100)
+
+	pump := NewPump(func(ctx app.Context, pipe Pipe) (string, int64, error) {
+		return "", 0, errOther
+	}, mockClock, testPumpOptions())
+	t.Cleanup(pump.Stop)
+
+	pipe := Pipe{ProviderCode: "provider", ProviderId: "p1", SinkCode: "fake-sink", SinkId: "s1"}
+	pump.Connect(testhelpers.NewMockAppContext(), pipe, newFakePlumber())
+
+	require.Eventually(t, func() bool {
+		return len(pump.Pipes()) == 0
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestPump_Reconcile(t *testing.T) {
+	mockClock := testhelpers.NewMockClock()
+	mockClock.On("NowUnix").Return(100)
+
+	pump := NewPump(func(ctx app.Context, pipe Pipe) (string, int64, error) {
+		return "creds", 1000, nil
+	}, mockClock, testPumpOptions())
+	t.Cleanup(pump.Stop)
+
+	plumber := newFakePlumber()
+	ctx := testhelpers.NewMockAppContext()
+
+	pipe1 := Pipe{ProviderCode: "provider", ProviderId: "p1", SinkCode: "fake-sink", SinkId: "s1"}
+	pipe2 := Pipe{ProviderCode: "provider", ProviderId: "p1", SinkCode: "fake-sink", SinkId: "s2",
+		SourceSelector: SourceSelector{AccountId: "a", RoleName: "r"}}
+
+	pump.Reconcile(ctx, map[Pipe]Plumber[string]{pipe1: plumber})
+	require.Equal(t, []Pipe{pipe1}, pump.Pipes())
+
+	pump.Reconcile(ctx, map[Pipe]Plumber[string]{pipe2: plumber})
+	require.Equal(t, []Pipe{pipe2}, pump.Pipes())
+
+	pump.Stop()
+	require.Empty(t, pump.Pipes())
+}
+
+func TestPump_StartReconcilesPeriodically(t *testing.T) {
+	mockClock := testhelpers.NewMockClock()
+	mockClock.On("NowUnix").Return(100)
+
+	pump := NewPump(func(ctx app.Context, pipe Pipe) (string, int64, error) {
+		return "creds", 1000, nil
+	}, mockClock, testPumpOptions())
+	t.Cleanup(pump.Stop)
+
+	plumber := newFakePlumber()
+	ctx := testhelpers.NewMockAppContext()
+
+	pipe1 := Pipe{ProviderCode: "provider", ProviderId: "p1", SinkCode: "fake-sink", SinkId: "s1"}
+	pipe2 := Pipe{ProviderCode: "provider", ProviderId: "p1", SinkCode: "fake-sink", SinkId: "s2"}
+
+	var mu sync.Mutex
+	desired := map[Pipe]Plumber[string]{pipe1: plumber}
+
+	listDesired := func(ctx app.Context) (map[Pipe]Plumber[string], error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		pipes := make(map[Pipe]Plumber[string], len(desired))
+
+		for pipe, plumber := range desired {
+			pipes[pipe] = plumber
+		}
+
+		return pipes, nil
+	}
+
+	require.True(t, pump.Start(ctx, 5*time.Millisecond, listDesired))
+	require.False(t, pump.Start(ctx, 5*time.Millisecond, listDesired))
+
+	require.Equal(t, "s1:creds", receive(t, plumber.flows))
+
+	mu.Lock()
+	desired = map[Pipe]Plumber[string]{pipe2: plumber}
+	mu.Unlock()
+
+	require.Eventually(t, func() bool {
+		pipes := pump.Pipes()
+		return len(pipes) == 1 && pipes[0] == pipe2
+	}, 2*time.Second, 5*time.Millisecond)
+
+	pump.Stop()
+	require.Empty(t, pump.Pipes())
+
+	mu.Lock()
+	desired = map[Pipe]Plumber[string]{pipe1: plumber}
+	mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+	require.Empty(t, pump.Pipes(), "pipes must not be connected again once stopped")
+
+	require.True(t, pump.Start(ctx, 5*time.Millisecond, listDesired), "a stopped pump can be started again")
+}
+
+func TestPump_StartKeepsHaltedPipesDisconnected(t *testing.T) {
+	mockClock := testhelpers.NewMockClock()
+	mockClock.On("NowUnix").Return(100)
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	failing := true
+
+	pump := NewPump(func(ctx app.Context, pipe Pipe) (string, int64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts[pipe.SinkId]++
+
+		if failing {
+			switch pipe.SinkId {
+			case "s1":
+				return "", 0, errStopping
+			case "s3":
+				return "", 0, errOther
+			}
+		}
+
+		return "creds", 1000, nil
+	}, mockClock, testPumpOptions())
+	t.Cleanup(pump.Stop)
+
+	plumber := newFakePlumber()
+	ctx := testhelpers.NewMockAppContext()
+
+	pipe1 := Pipe{ProviderCode: "provider", ProviderId: "p1", SinkCode: "fake-sink", SinkId: "s1"}
+	pipe2 := Pipe{ProviderCode: "provider", ProviderId: "p1", SinkCode: "fake-sink", SinkId: "s2"}
+	pipe3 := Pipe{ProviderCode: "provider", ProviderId: "p2", SinkCode: "fake-sink", SinkId: "s3"}
+
+	require.True(t, pump.Start(ctx, 5*time.Millisecond, func(ctx app.Context) (map[Pipe]Plumber[string], error) {
+		return map[Pipe]Plumber[string]{pipe1: plumber, pipe2: plumber, pipe3: plumber}, nil
+	}))
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return attempts["s1"] > 0 && attempts["s3"] > 0 && len(pump.Pipes()) == 0
+	}, 2*time.Second, 5*time.Millisecond)
+
+	// several reconcile ticks go by
+	time.Sleep(50 * time.Millisecond)
+
+	require.Empty(t, pump.Pipes(), "halted pipes must not be connected again by reconciliation")
+
+	mu.Lock()
+	require.Equal(t, 1, attempts["s1"])
+	require.Equal(t, 1, attempts["s3"])
+	failing = false
+	mu.Unlock()
+
+	pump.Resume("provider", "p1")
+
+	require.Eventually(t, func() bool {
+		pipes := pump.Pipes()
+		return len(pipes) == 2 && pipes[0] == pipe1 && pipes[1] == pipe2
+	}, 2*time.Second, 5*time.Millisecond)
+
+	pump.Resume("provider", "p2")
+
+	require.Eventually(t, func() bool {
+		return len(pump.Pipes()) == 3
+	}, 2*time.Second, 5*time.Millisecond)
+}
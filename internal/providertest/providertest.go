@@ -0,0 +1,84 @@
+// Package providertest holds the fixtures shared by the tests of credential providers.
+package providertest
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/clients/awssts"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/eventing"
+	"github.com/abjrcode/swervo/internal/plumbing"
+	"github.com/abjrcode/swervo/internal/security/vault"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// NewOpenVault returns a vault that is configured and open, ready to encrypt secrets of a provider.
+func NewOpenVault(t *testing.T, db *sql.DB, bus *eventing.Eventbus, mockClock *testhelpers.MockClock) vault.Vault {
+	vlt := vault.NewVault(db, bus, mockClock)
+
+	timeSetCall := mockClock.On("NowUnix").Return(1)
+	defer timeSetCall.Unset()
+
+	require.NoError(t, vlt.Configure(testhelpers.NewMockAppContext(), "abc"))
+
+	return vlt
+}
+
+// FakePlumber lists a fixed set of connected sinks and hands the data that flows into them over Flows.
+type FakePlumber[T interface{}] struct {
+	Sinks []plumbing.SinkInstance
+	Flows chan T
+}
+
+// NewFakePlumber connects the given sinks, or a single sink with the ID "test-sink" when none are given.
+func NewFakePlumber[T interface{}](sinks ...plumbing.SinkInstance) *FakePlumber[T] {
+	if len(sinks) == 0 {
+		sinks = []plumbing.SinkInstance{{SinkCode: "fake-sink", SinkId: "test-sink"}}
+	}
+
+	return &FakePlumber[T]{
+		Sinks: sinks,
+		Flows: make(chan T, 10),
+	}
+}
+
+func (p *FakePlumber[T]) SinkCode() string {
+	return "fake-sink"
+}
+
+func (p *FakePlumber[T]) ListConnectedSinks(ctx app.Context, providerCode, providerId string) ([]plumbing.SinkInstance, error) {
+	return p.Sinks, nil
+}
+
+func (p *FakePlumber[T]) DisconnectSink(ctx app.Context, input plumbing.DisconnectSinkCommandInput) error {
+	return nil
+}
+
+func (p *FakePlumber[T]) FlowData(ctx app.Context, data T, sinkId string) error {
+	p.Flows <- data
+	return nil
+}
+
+type MockAwsStsClient struct {
+	mock.Mock
+}
+
+func (m *MockAwsStsClient) GetSessionToken(ctx app.Context, region awsnet.AwsRegion, credentials awssts.Credentials, input awssts.GetSessionTokenInput) (*awssts.Credentials, error) {
+	args := m.Called(region, credentials, input)
+	res, _ := args.Get(0).(*awssts.Credentials)
+	return res, args.Error(1)
+}
+
+func (m *MockAwsStsClient) AssumeRole(ctx app.Context, region awsnet.AwsRegion, credentials awssts.Credentials, input awssts.AssumeRoleInput) (*awssts.Credentials, error) {
+	args := m.Called(region, credentials, input)
+	res, _ := args.Get(0).(*awssts.Credentials)
+	return res, args.Error(1)
+}
+
+func (m *MockAwsStsClient) Configure(options awsnet.NetworkOptions) error {
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"io"
+	"sync"
 
 	"github.com/abjrcode/swervo/internal/app"
 	"github.com/abjrcode/swervo/internal/eventing"
@@ -45,6 +46,10 @@ type Vault interface {
 	// Seal closes the vault and purges the key from memory.
 	Seal()
 
+	// OnSeal registers a hook that is called whenever the vault is sealed,
+	// so that secrets which were decrypted with the key can be purged as well.
+	OnSeal(hook func())
+
 	// Vault can be used as an encryption service.
 	encryption.EncryptionService
 }
@@ -55,6 +60,9 @@ type vaultImpl struct {
 	bus           *eventing.Eventbus
 	keyId         *string
 	encryptionKey *memguard.Enclave
+
+	sealHooksMu sync.Mutex
+	sealHooks   []func()
 }
 
 func NewVault(db *sql.DB, bus *eventing.Eventbus, timeSvc utils.Clock) Vault {
@@ -242,11 +250,26 @@ func (v *vaultImpl) Open(ctx app.Context, plainPassword string) (bool, error) {
 }
 
 func (v *vaultImpl) Seal() {
+	v.sealHooksMu.Lock()
+	hooks := append([]func(){}, v.sealHooks...)
+	v.sealHooksMu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+
 	v.keyId = nil
 	v.encryptionKey = nil
 	memguard.Purge()
 }
 
+func (v *vaultImpl) OnSeal(hook func()) {
+	v.sealHooksMu.Lock()
+	defer v.sealHooksMu.Unlock()
+
+	v.sealHooks = append(v.sealHooks, hook)
+}
+
 func (v *vaultImpl) EncryptBinary(plaintext []byte) ([]byte, string, error) {
 	if !v.IsOpen() {
 		return nil, "", ErrVaultNotConfiguredOrSealed
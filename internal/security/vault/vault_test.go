@@ -265,3 +265,24 @@ func TestEncryptDecryptErrorOnSealedVault(t *testing.T) {
 	_, _, err = vault.Encrypt("hello")
 	require.Error(t, err)
 }
+
+func TestSeal_CallsHooks(t *testing.T) {
+	db, err := migrations.NewInMemoryMigratedDatabase(t, "TestSealCallsHooks")
+	require.NoError(t, err)
+	mockClock := testhelpers.NewMockClock()
+
+	mockClock.On("NowUnix").Return(1)
+
+	vault := NewVault(db, eventing.NewEventbus(db, mockClock), mockClock)
+
+	sealed := 0
+	vault.OnSeal(func() {
+		sealed++
+	})
+
+	err = vault.Configure(testhelpers.NewMockAppContext(), "123")
+	require.NoError(t, err)
+
+	vault.Seal()
+	require.Equal(t, 1, sealed)
+}
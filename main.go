@@ -8,8 +8,13 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 
+	"github.com/abjrcode/swervo/clients/awscredsfile"
+	"github.com/abjrcode/swervo/clients/awsnet"
 	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/clients/awssts"
+	"github.com/abjrcode/swervo/credsserver"
 	"github.com/abjrcode/swervo/favorites"
 	"github.com/abjrcode/swervo/internal/app"
 	"github.com/abjrcode/swervo/internal/datastore"
@@ -17,8 +22,12 @@ import (
 	"github.com/abjrcode/swervo/internal/migrations"
 	"github.com/abjrcode/swervo/internal/security/vault"
 	"github.com/abjrcode/swervo/internal/utils"
+	awsassumerole "github.com/abjrcode/swervo/providers/aws_assume_role"
+	awsiamuser "github.com/abjrcode/swervo/providers/aws_iam_user"
 	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+	"github.com/abjrcode/swervo/settings"
 	awscredssink "github.com/abjrcode/swervo/sinks/awscredssink"
+	"github.com/abjrcode/swervo/totp"
 
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
@@ -37,6 +46,15 @@ var CommitSha string = "HEAD"
 var BuildLink string = "http://localhost"
 
 func main() {
+	exitCode := 0
+
+	// deferred first so that it runs last, after everything else was released
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	generateBindingsRun := app.IsWailsRunningAppToGenerateBindings(os.Args)
 
 	pwd, err := os.Executable()
@@ -107,16 +125,86 @@ func main() {
 
 	awsCredentialsFileSinkController := awscredssink.NewAwsCredentialsSinkController(db, eventBus, vault, clock)
 
-	awsIdcController := awsidc.NewAwsIdentityCenterController(db, eventBus, favoritesRepo, vault, awssso.NewAwsSsoOidcClient(), clock)
+	awsSsoClient, err := awssso.NewAwsSsoOidcClient(awsnet.DefaultClientOptions, clock)
+
+	if err != nil {
+		errorHandler.CatchWithMsg(nil, logger, err, "failed to create AWS SSO client")
+	}
+
+	awsStsClient, err := awssts.NewAwsStsClient(awsnet.DefaultClientOptions, clock)
+
+	if err != nil {
+		errorHandler.CatchWithMsg(nil, logger, err, "failed to create AWS STS client")
+	}
+
+	awsRegions := awssso.NewRegionCatalog()
+
+	awsIdcController := awsidc.NewAwsIdentityCenterController(db, eventBus, favoritesRepo, vault, awsSsoClient, awsRegions, clock)
 	awsIdcController.AddPlumbers(awsCredentialsFileSinkController)
+	vault.OnSeal(awsIdcController.PurgeRoleCredentialsCache)
+	awsIdcController.SetAwsCliTokenCache(awscredsfile.NewDefaultSsoCacheManager())
+
+	settingsController := NewSettingsController(settings.NewNetworkSettings(db), settings.NewCustomRegions(db), settings.NewAwsCliSettings(db), awsSsoClient, awsStsClient, awsIdcController, awsIdcController, awsRegions)
+
+	totpController := totp.NewTotpController(db, eventBus, vault, clock)
+
+	awsIamUserController := awsiamuser.NewAwsIamUserController(db, eventBus, favoritesRepo, vault, awsStsClient, awsRegions, clock)
+	awsIamUserController.AddPlumbers(awsCredentialsFileSinkController)
+	awsIamUserController.SetMfaCodeSource(totp.NewMfaCodeSource(totpController))
+
+	awsAssumeRoleController := awsassumerole.NewAwsAssumeRoleController(db, eventBus, favoritesRepo, awsStsClient, awsRegions, clock)
+	awsAssumeRoleController.AddCredentialsSources(awsassumerole.NewAwsIdcSource(awsIdcController), awsassumerole.NewAwsIamUserSource(awsIamUserController))
+	awsAssumeRoleController.AddPlumbers(awsCredentialsFileSinkController)
+	awsIdcController.AddInstanceListeners(awsAssumeRoleController)
+	awsIamUserController.AddInstanceListeners(awsAssumeRoleController)
+
+	awsCredentialsFileSinkController.AddSourceValidators(awsIdcController, awsIamUserController, awsAssumeRoleController)
+
+	credentialsServerController := credsserver.NewCredentialsServerController(db, eventBus, awsIdcController, clock)
+	vault.OnSeal(credentialsServerController.StopAll)
+
+	localSocketPath := filepath.Join(appDataDir, credsserver.LocalSocketName)
+
+	if isCredsCommand(os.Args) {
+		reqId := utils.NewRequestId()
+		appContext := app.NewContext(context.Background(), "root", reqId, reqId, reqId, &logger)
+
+		if err := settingsController.ApplyNetworkSettings(appContext); err != nil {
+			logger.Error().Err(err).Msg("failed to apply network settings")
+		}
+
+		if err := settingsController.LoadCustomAwsRegions(appContext); err != nil {
+			logger.Error().Err(err).Msg("failed to load custom AWS regions")
+		}
+
+		exitCode = runCredsCommand(appContext, os.Args[2:], credsCommandEnv{
+			runningApp:   credsserver.NewLocalSocketClient(localSocketPath),
+			vault:        vault,
+			source:       awsIdcController,
+			readPassword: promptVaultPassword,
+			stdout:       os.Stdout,
+			stderr:       os.Stderr,
+		})
+		return
+	}
 
 	appController := &AppController{
+		eventBus: eventBus,
+
 		authController:      authController,
 		dashboardController: dashboardController,
+		settingsController:  settingsController,
 
-		awsIdcController: awsIdcController,
+		awsIdcController:        awsIdcController,
+		awsIamUserController:    awsIamUserController,
+		awsAssumeRoleController: awsAssumeRoleController,
 
 		awsCredentialsSinkController: awsCredentialsFileSinkController,
+
+		credentialsServerController: credentialsServerController,
+		localSocketPath:             localSocketPath,
+
+		totpController: totpController,
 	}
 
 	logger.Info().Msgf("Launching Swervo - PID [%d]", os.Getpid())
@@ -131,12 +219,23 @@ func main() {
 		OnStartup: func(ctx context.Context) {
 			appController.init(logger.WithContext(ctx), errorHandler)
 		},
+		OnShutdown: func(ctx context.Context) {
+			awsIdcController.StopCredentialsPump()
+			awsIamUserController.StopCredentialsPump()
+			awsAssumeRoleController.StopCredentialsPump()
+			credentialsServerController.StopAll()
+		},
 		Bind: []interface{}{
 			appController,
 			authController,
 			dashboardController,
+			settingsController,
 			awsIdcController,
+			awsIamUserController,
+			awsAssumeRoleController,
 			awsCredentialsFileSinkController,
+			credentialsServerController,
+			totpController,
 		},
 		SingleInstanceLock: &options.SingleInstanceLock{
 			UniqueId: "swervo_473c7f9b-8028-4888-871d-53c669266f80",
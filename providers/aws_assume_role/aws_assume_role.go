@@ -0,0 +1,540 @@
+package awsassumerole
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/clients/awssts"
+	"github.com/abjrcode/swervo/favorites"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/eventing"
+	"github.com/abjrcode/swervo/internal/plumbing"
+	"github.com/abjrcode/swervo/internal/utils"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+	"github.com/segmentio/ksuid"
+)
+
+var ProviderCode = "aws-assume-role"
+
+var (
+	ErrInvalidLabel            = app.NewValidationError("INVALID_LABEL")
+	ErrInvalidAwsRegion        = app.NewValidationError("INVALID_AWS_REGION")
+	ErrInvalidSourceProvider   = app.NewValidationError("INVALID_SOURCE_PROVIDER")
+	ErrInvalidChainLength      = app.NewValidationError("INVALID_CHAIN_LENGTH")
+	ErrInvalidRoleArn          = app.NewValidationError("INVALID_ROLE_ARN")
+	ErrInvalidExternalId       = app.NewValidationError("INVALID_EXTERNAL_ID")
+	ErrInvalidSessionName      = app.NewValidationError("INVALID_SESSION_NAME")
+	ErrInvalidSessionDuration  = app.NewValidationError("INVALID_SESSION_DURATION")
+	ErrInvalidSessionPolicy    = app.NewValidationError("INVALID_SESSION_POLICY")
+	ErrInvalidSessionTags      = app.NewValidationError("INVALID_SESSION_TAGS")
+	ErrInstanceWasNotFound     = app.NewValidationError("INSTANCE_WAS_NOT_FOUND")
+	ErrAssumeRoleDenied        = app.NewValidationError("ASSUME_ROLE_DENIED")
+	ErrStaleSourceCredentials  = app.NewValidationError("STALE_SOURCE_CREDENTIALS")
+	ErrSessionPolicyTooLarge   = app.NewValidationError("SESSION_POLICY_TOO_LARGE")
+	ErrInvalidHop              = app.NewValidationError("INVALID_HOP")
+	ErrInvalidSourceSelector   = app.NewValidationError("INVALID_SOURCE_SELECTOR")
+	ErrStsRegionDisabled       = app.NewValidationError("STS_REGION_DISABLED")
+	ErrTransientAwsClientError = app.NewValidationError("TRANSIENT_AWS_CLIENT_ERROR")
+)
+
+var AwsAssumeRoleEventSource = eventing.EventSource("AwsAssumeRole")
+
+const (
+	maxHops = 5
+
+	// minSessionDurationSeconds and maxSessionDurationSeconds bound the sessions STS issues for roles,
+	// roles assumed with credentials of another role are capped at one hour by AWS
+	minSessionDurationSeconds        = 900
+	maxSessionDurationSeconds        = 43200
+	maxChainedSessionDurationSeconds = 3600
+	defaultSessionDurationSeconds    = 3600
+
+	maxSessionPolicyLength = 2048
+	maxSessionTags         = 50
+
+	defaultSessionNameTemplate = "swervo-{unix}"
+	maxSessionNameLength       = 64
+)
+
+var (
+	roleArnRegex            = regexp.MustCompile(`^arn:aws[\w-]*:iam::\d{12}:role/[\w+=,.@/-]{1,512}$`)
+	externalIdRegex         = regexp.MustCompile(`^[\w+=,.@:/-]{2,}$`)
+	sessionTagKeyRegex      = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+@-]{1,128}$`)
+	sessionTagValueRegex    = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+@-]{0,256}$`)
+	invalidSessionNameRegex = regexp.MustCompile(`[^\w+=,.@-]`)
+)
+
+// Hop is one sts:AssumeRole call of a chain, every hop is signed with the credentials of the previous one.
+type Hop struct {
+	RoleArn string `json:"roleArn"`
+	// ExternalId is required by roles that third parties assume, it is not sent when empty
+	ExternalId string `json:"externalId"`
+	// SessionNameTemplate may refer to {label}, {hop} and {unix}, characters STS does not accept are replaced with dashes
+	SessionNameTemplate string `json:"sessionNameTemplate"`
+	DurationSeconds     int32  `json:"durationSeconds"`
+	// Policy is an inline session policy that further restricts the permissions of the role, it is not sent when empty
+	Policy string            `json:"policy"`
+	Tags   map[string]string `json:"tags"`
+}
+
+type AwsAssumeRoleInstanceCreatedEvent struct {
+	InstanceId string
+
+	Label              string
+	Region             string
+	SourceProviderCode string
+	SourceProviderId   string
+	SourceSelector     plumbing.SourceSelector
+	Hops               []Hop
+}
+
+type AwsAssumeRoleController struct {
+	db            *sql.DB
+	bus           *eventing.Eventbus
+	favoritesRepo favorites.FavoritesRepo
+	awsStsClient  awssts.AwsStsClient
+	regions       *awssso.RegionCatalog
+	clock         utils.Clock
+
+	sources  map[string]CredentialsSource
+	plumbers []plumbing.Plumber[awsidc.AwsCredentials]
+
+	pump *plumbing.Pump[awsidc.AwsCredentials]
+}
+
+func NewAwsAssumeRoleController(db *sql.DB, bus *eventing.Eventbus, favoritesRepo favorites.FavoritesRepo, awsStsClient awssts.AwsStsClient, regions *awssso.RegionCatalog, clock utils.Clock) *AwsAssumeRoleController {
+	controller := &AwsAssumeRoleController{
+		db:            db,
+		bus:           bus,
+		favoritesRepo: favoritesRepo,
+		awsStsClient:  awsStsClient,
+		regions:       regions,
+		clock:         clock,
+
+		sources:  make(map[string]CredentialsSource),
+		plumbers: make([]plumbing.Plumber[awsidc.AwsCredentials], 0),
+	}
+
+	controller.pump = newCredentialsPump(controller)
+
+	return controller
+}
+
+// AddCredentialsSources registers the providers whose instances chains can start from.
+func (c *AwsAssumeRoleController) AddCredentialsSources(sources ...CredentialsSource) {
+	for _, source := range sources {
+		c.sources[source.ProviderCode()] = source
+	}
+}
+
+func (c *AwsAssumeRoleController) AddPlumbers(plumbers ...plumbing.Plumber[awsidc.AwsCredentials]) {
+	c.plumbers = append(c.plumbers, plumbers...)
+}
+
+func (c *AwsAssumeRoleController) ProviderCode() string {
+	return ProviderCode
+}
+
+// ValidateSourceSelector checks that the instance exists, a chain ends in a single role so the selector must be empty.
+func (c *AwsAssumeRoleController) ValidateSourceSelector(ctx app.Context, instanceId string, selector plumbing.SourceSelector) error {
+	if !selector.IsEmpty() {
+		return ErrInvalidSourceSelector
+	}
+
+	_, err := c.loadAssumeRole(ctx, instanceId)
+
+	return err
+}
+
+type AwsAssumeRoleCardData struct {
+	InstanceId         string                  `json:"instanceId"`
+	Label              string                  `json:"label"`
+	Region             string                  `json:"region"`
+	IsFavorite         bool                    `json:"isFavorite"`
+	SourceProviderCode string                  `json:"sourceProviderCode"`
+	SourceProviderId   string                  `json:"sourceProviderId"`
+	SourceSelector     plumbing.SourceSelector `json:"sourceSelector"`
+	Hops               []Hop                   `json:"hops"`
+
+	Sinks []plumbing.SinkInstance `json:"sinks"`
+}
+
+func (c *AwsAssumeRoleController) ListInstances(ctx app.Context) ([]string, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT instance_id FROM aws_assume_role ORDER BY instance_id DESC")
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+	defer rows.Close()
+
+	instances := make([]string, 0)
+
+	for rows.Next() {
+		var instanceId string
+
+		if err := rows.Scan(&instanceId); err != nil {
+			return nil, errors.Join(err, app.ErrFatal)
+		}
+
+		instances = append(instances, instanceId)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	return instances, nil
+}
+
+// assumeRole is an instance as it is stored
+type assumeRole struct {
+	instanceId         string
+	label              string
+	region             string
+	sourceProviderCode string
+	sourceProviderId   string
+	sourceSelector     plumbing.SourceSelector
+	hops               []Hop
+}
+
+func (c *AwsAssumeRoleController) loadAssumeRole(ctx app.Context, instanceId string) (*assumeRole, error) {
+	row := c.db.QueryRowContext(ctx, `SELECT label, region, source_provider_code, source_provider_id, source_account_id, source_role_name, hops
+	FROM aws_assume_role WHERE instance_id = ?`, instanceId)
+
+	instance := assumeRole{instanceId: instanceId}
+
+	var hopsJson string
+
+	err := row.Scan(&instance.label, &instance.region, &instance.sourceProviderCode, &instance.sourceProviderId,
+		&instance.sourceSelector.AccountId, &instance.sourceSelector.RoleName, &hopsJson)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInstanceWasNotFound
+		}
+
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	if err := json.Unmarshal([]byte(hopsJson), &instance.hops); err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	return &instance, nil
+}
+
+func (c *AwsAssumeRoleController) GetInstanceData(ctx app.Context, instanceId string) (*AwsAssumeRoleCardData, error) {
+	instance, err := c.loadAssumeRole(ctx, instanceId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data := AwsAssumeRoleCardData{
+		InstanceId:         instanceId,
+		Label:              instance.label,
+		Region:             instance.region,
+		SourceProviderCode: instance.sourceProviderCode,
+		SourceProviderId:   instance.sourceProviderId,
+		SourceSelector:     instance.sourceSelector,
+		Hops:               instance.hops,
+		Sinks:              make([]plumbing.SinkInstance, 0),
+	}
+
+	isFavorite, err := c.favoritesRepo.IsFavorite(ctx, &favorites.Favorite{
+		ProviderCode: ProviderCode,
+		InstanceId:   instanceId,
+	})
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	data.IsFavorite = isFavorite
+
+	for _, plumber := range c.plumbers {
+		connectedSinks, err := plumber.ListConnectedSinks(ctx, ProviderCode, instanceId)
+
+		if err != nil {
+			ctx.Logger().Error().Err(err).Msg("failed to list pipes")
+			return nil, errors.Join(err, app.ErrFatal)
+		}
+
+		data.Sinks = append(data.Sinks, connectedSinks...)
+	}
+
+	return &data, nil
+}
+
+// renderSessionName fills in the placeholders of a session name template
+// and replaces what STS does not accept in session names.
+func renderSessionName(template, label string, hop int, nowUnix int64) string {
+	sessionName := strings.NewReplacer(
+		"{label}", label,
+		"{hop}", strconv.Itoa(hop),
+		"{unix}", strconv.FormatInt(nowUnix, 10),
+	).Replace(template)
+
+	sessionName = invalidSessionNameRegex.ReplaceAllString(sessionName, "-")
+
+	if len(sessionName) > maxSessionNameLength {
+		sessionName = sessionName[:maxSessionNameLength]
+	}
+
+	return sessionName
+}
+
+// normalizeHop validates a hop and fills in the defaults of its optional fields,
+// a chained hop is signed with credentials of another role
+func normalizeHop(hop Hop, label string, chained bool) (Hop, error) {
+	if !roleArnRegex.MatchString(hop.RoleArn) {
+		return hop, ErrInvalidRoleArn
+	}
+
+	if hop.ExternalId != "" && (len(hop.ExternalId) > 1224 || !externalIdRegex.MatchString(hop.ExternalId)) {
+		return hop, ErrInvalidExternalId
+	}
+
+	if hop.SessionNameTemplate == "" {
+		hop.SessionNameTemplate = defaultSessionNameTemplate
+	}
+
+	if len(hop.SessionNameTemplate) > 256 || len(renderSessionName(hop.SessionNameTemplate, label, maxHops, 0)) < 2 {
+		return hop, ErrInvalidSessionName
+	}
+
+	if hop.DurationSeconds == 0 {
+		hop.DurationSeconds = defaultSessionDurationSeconds
+	}
+
+	maxDurationSeconds := int32(maxSessionDurationSeconds)
+
+	if chained {
+		maxDurationSeconds = maxChainedSessionDurationSeconds
+	}
+
+	if hop.DurationSeconds < minSessionDurationSeconds || hop.DurationSeconds > maxDurationSeconds {
+		return hop, ErrInvalidSessionDuration
+	}
+
+	if hop.Policy != "" && (len(hop.Policy) > maxSessionPolicyLength || !json.Valid([]byte(hop.Policy))) {
+		return hop, ErrInvalidSessionPolicy
+	}
+
+	if len(hop.Tags) > maxSessionTags {
+		return hop, ErrInvalidSessionTags
+	}
+
+	for key, value := range hop.Tags {
+		if !sessionTagKeyRegex.MatchString(key) || !sessionTagValueRegex.MatchString(value) {
+			return hop, ErrInvalidSessionTags
+		}
+	}
+
+	return hop, nil
+}
+
+type AwsAssumeRole_SetupCommandInput struct {
+	Label  string `json:"label"`
+	Region string `json:"region"`
+	// SourceProviderCode and SourceProviderId are the provider instance whose credentials sign the first hop
+	SourceProviderCode string                  `json:"sourceProviderCode"`
+	SourceProviderId   string                  `json:"sourceProviderId"`
+	SourceSelector     plumbing.SourceSelector `json:"sourceSelector"`
+	Hops               []Hop                   `json:"hops"`
+}
+
+// Setup stores a chain of roles on top of a source provider instance and publishes [AwsAssumeRoleInstanceCreatedEvent].
+// Roles are not assumed until sinks are fed.
+func (c *AwsAssumeRoleController) Setup(ctx app.Context, input AwsAssumeRole_SetupCommandInput) (string, error) {
+	if len(input.Label) < 1 || len(input.Label) > 50 {
+		return "", ErrInvalidLabel
+	}
+
+	if _, ok := c.regions.Lookup(input.Region); !ok {
+		return "", ErrInvalidAwsRegion
+	}
+
+	if len(input.Hops) < 1 || len(input.Hops) > maxHops {
+		return "", ErrInvalidChainLength
+	}
+
+	source, ok := c.sources[input.SourceProviderCode]
+
+	if !ok {
+		return "", ErrInvalidSourceProvider
+	}
+
+	hops := make([]Hop, 0, len(input.Hops))
+
+	for i, hop := range input.Hops {
+		hop, err := normalizeHop(hop, input.Label, i > 0 || source.IssuesRoleCredentials())
+
+		if err != nil {
+			return "", err
+		}
+
+		hops = append(hops, hop)
+	}
+
+	if err := source.ValidateSourceSelector(ctx, input.SourceProviderId, input.SourceSelector); err != nil {
+		return "", err
+	}
+
+	hopsJson, err := json.Marshal(hops)
+
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	nowUnix := c.clock.NowUnix()
+
+	uniqueId, err := ksuid.NewRandomWithTime(time.Unix(nowUnix, 0))
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+	instanceId := uniqueId.String()
+	version := 1
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO aws_assume_role
+	(instance_id, version, label, region, source_provider_code, source_provider_id, source_account_id, source_role_name, hops, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		instanceId,
+		version,
+		input.Label,
+		input.Region,
+		input.SourceProviderCode,
+		input.SourceProviderId,
+		input.SourceSelector.AccountId,
+		input.SourceSelector.RoleName,
+		string(hopsJson),
+		nowUnix)
+
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	publish, err := c.bus.PublishTx(ctx, AwsAssumeRoleInstanceCreatedEvent{
+		InstanceId:         instanceId,
+		Label:              input.Label,
+		Region:             input.Region,
+		SourceProviderCode: input.SourceProviderCode,
+		SourceProviderId:   input.SourceProviderId,
+		SourceSelector:     input.SourceSelector,
+		Hops:               hops,
+	}, eventing.EventMeta{
+		SourceType:   AwsAssumeRoleEventSource,
+		SourceId:     instanceId,
+		EventVersion: uint(version),
+	}, tx)
+
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	publish()
+
+	return instanceId, nil
+}
+
+func mapStsError(ctx app.Context, err error, hopNumber int, roleArn string) error {
+	switch {
+	case errors.Is(err, awssts.ErrAccessDenied):
+		ctx.Logger().Warn().Msgf("hop [%d] was denied to assume role [%s]", hopNumber, roleArn)
+		return ErrAssumeRoleDenied
+	case errors.Is(err, awssts.ErrExpiredToken), errors.Is(err, awssts.ErrInvalidClientToken):
+		ctx.Logger().Warn().Msgf("hop [%d] was signed with stale credentials to assume role [%s]", hopNumber, roleArn)
+		return ErrStaleSourceCredentials
+	case errors.Is(err, awssts.ErrMalformedPolicy):
+		return ErrInvalidSessionPolicy
+	case errors.Is(err, awssts.ErrPolicyTooLarge):
+		return ErrSessionPolicyTooLarge
+	case errors.Is(err, awssts.ErrInvalidRequest):
+		ctx.Logger().Warn().Err(err).Msgf("hop [%d] to assume role [%s] was rejected", hopNumber, roleArn)
+		return ErrInvalidHop
+	case errors.Is(err, awssts.ErrRegionDisabled):
+		return ErrStsRegionDisabled
+	}
+
+	ctx.Logger().Error().Err(err).Msgf("aws sts client failed to assume role [%s] of hop [%d]", roleArn, hopNumber)
+
+	return ErrTransientAwsClientError
+}
+
+// getCredentials walks the chain of an instance starting from the credentials of its source
+// and returns the credentials of the role of the last hop.
+func (c *AwsAssumeRoleController) getCredentials(ctx app.Context, instanceId string) (*awssts.Credentials, error) {
+	instance, err := c.loadAssumeRole(ctx, instanceId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	source, ok := c.sources[instance.sourceProviderCode]
+
+	if !ok {
+		ctx.Logger().Error().Msgf("source provider [%s] of instance [%s] is not registered", instance.sourceProviderCode, instanceId)
+		return nil, ErrInvalidSourceProvider
+	}
+
+	credentials, err := source.GetCredentials(ctx, instance.sourceProviderId, instance.sourceSelector)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nowUnix := c.clock.NowUnix()
+
+	for i, hop := range instance.hops {
+		hopNumber := i + 1
+
+		ctx.Logger().Debug().Msgf("assuming role [%s] of hop [%d]", hop.RoleArn, hopNumber)
+
+		credentials, err = c.awsStsClient.AssumeRole(ctx, awsnet.AwsRegion(instance.region), *credentials, awssts.AssumeRoleInput{
+			RoleArn:         hop.RoleArn,
+			RoleSessionName: renderSessionName(hop.SessionNameTemplate, instance.label, hopNumber, nowUnix),
+			ExternalId:      hop.ExternalId,
+			DurationSeconds: hop.DurationSeconds,
+			Policy:          hop.Policy,
+			Tags:            hop.Tags,
+		})
+
+		if err != nil {
+			return nil, mapStsError(ctx, err, hopNumber, hop.RoleArn)
+		}
+	}
+
+	return credentials, nil
+}
+
+func (c *AwsAssumeRoleController) MarkAsFavorite(ctx app.Context, instanceId string) error {
+	return c.favoritesRepo.Add(ctx, &favorites.Favorite{
+		ProviderCode: ProviderCode,
+		InstanceId:   instanceId,
+	})
+}
+
+func (c *AwsAssumeRoleController) UnmarkAsFavorite(ctx app.Context, instanceId string) error {
+	return c.favoritesRepo.Remove(ctx, &favorites.Favorite{
+		ProviderCode: ProviderCode,
+		InstanceId:   instanceId,
+	})
+}
@@ -0,0 +1,360 @@
+package awsassumerole
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/clients/awssts"
+	"github.com/abjrcode/swervo/favorites"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/eventing"
+	"github.com/abjrcode/swervo/internal/migrations"
+	"github.com/abjrcode/swervo/internal/plumbing"
+	"github.com/abjrcode/swervo/internal/providertest"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type mockCredentialsSource struct {
+	mock.Mock
+
+	roleCredentials bool
+}
+
+func (m *mockCredentialsSource) ProviderCode() string {
+	return "fake-source"
+}
+
+func (m *mockCredentialsSource) ValidateSourceSelector(ctx app.Context, providerId string, selector plumbing.SourceSelector) error {
+	args := m.Called(providerId, selector)
+	return args.Error(0)
+}
+
+func (m *mockCredentialsSource) IssuesRoleCredentials() bool {
+	return m.roleCredentials
+}
+
+func (m *mockCredentialsSource) GetCredentials(ctx app.Context, providerId string, selector plumbing.SourceSelector) (*awssts.Credentials, error) {
+	args := m.Called(providerId, selector)
+	res, _ := args.Get(0).(*awssts.Credentials)
+	return res, args.Error(1)
+}
+
+func initController(t *testing.T) (*AwsAssumeRoleController, *providertest.MockAwsStsClient, *mockCredentialsSource, *eventing.Eventbus, *testhelpers.MockClock) {
+	db, err := migrations.NewInMemoryMigratedDatabase(t, "aws-assume-role-controller-tests.db")
+	require.NoError(t, err)
+
+	stsClient := new(providertest.MockAwsStsClient)
+	source := new(mockCredentialsSource)
+	mockClock := testhelpers.NewMockClock()
+
+	bus := eventing.NewEventbus(db, mockClock)
+
+	controller := NewAwsAssumeRoleController(db, bus, favorites.NewFavorites(db), stsClient, awssso.NewRegionCatalog(), mockClock)
+	controller.AddCredentialsSources(source)
+
+	return controller, stsClient, source, bus, mockClock
+}
+
+var testSelector = plumbing.SourceSelector{AccountId: "111111111111", RoleName: "developer"}
+
+var testSourceCredentials = &awssts.Credentials{
+	AccessKeyId:     "ASIASOURCEKEY",
+	SecretAccessKey: "source-secret",
+	SessionToken:    "source-session-token",
+	Expiration:      3600,
+}
+
+var testHops = []Hop{
+	{
+		RoleArn:             "arn:aws:iam::222222222222:role/hub",
+		SessionNameTemplate: "{label} hop {hop}",
+		Tags:                map[string]string{"team": "platform"},
+	},
+	{
+		RoleArn:         "arn:aws:iam::333333333333:role/deployer",
+		ExternalId:      "partner-external-id",
+		DurationSeconds: 900,
+		Policy:          `{"Version":"2012-10-17","Statement":[]}`,
+	},
+}
+
+func simulateSetup(t *testing.T, controller *AwsAssumeRoleController, source *mockCredentialsSource, mockClock *testhelpers.MockClock) string {
+	timeSetCall := mockClock.On("NowUnix").Return(1)
+	defer timeSetCall.Unset()
+
+	validateCall := source.On("ValidateSourceSelector", "source-instance-id", testSelector).Return(nil)
+	defer validateCall.Unset()
+
+	instanceId, err := controller.Setup(testhelpers.NewMockAppContext(), AwsAssumeRole_SetupCommandInput{
+		Label:              "deploy",
+		Region:             "eu-west-1",
+		SourceProviderCode: "fake-source",
+		SourceProviderId:   "source-instance-id",
+		SourceSelector:     testSelector,
+		Hops:               testHops,
+	})
+	require.NoError(t, err)
+
+	return instanceId
+}
+
+func TestSetup(t *testing.T) {
+	controller, _, source, bus, mockClock := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	events := bus.Subscribe(AwsAssumeRoleEventSource)
+
+	instanceId := simulateSetup(t, controller, source, mockClock)
+
+	expectedHops := []Hop{
+		{
+			RoleArn:             "arn:aws:iam::222222222222:role/hub",
+			SessionNameTemplate: "{label} hop {hop}",
+			DurationSeconds:     defaultSessionDurationSeconds,
+			Tags:                map[string]string{"team": "platform"},
+		},
+		{
+			RoleArn:             "arn:aws:iam::333333333333:role/deployer",
+			ExternalId:          "partner-external-id",
+			SessionNameTemplate: defaultSessionNameTemplate,
+			DurationSeconds:     900,
+			Policy:              `{"Version":"2012-10-17","Statement":[]}`,
+		},
+	}
+
+	envelope := <-events
+	require.Equal(t, AwsAssumeRoleInstanceCreatedEvent{
+		InstanceId:         instanceId,
+		Label:              "deploy",
+		Region:             "eu-west-1",
+		SourceProviderCode: "fake-source",
+		SourceProviderId:   "source-instance-id",
+		SourceSelector:     testSelector,
+		Hops:               expectedHops,
+	}, envelope.Event)
+
+	instances, err := controller.ListInstances(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{instanceId}, instances)
+
+	data, err := controller.GetInstanceData(ctx, instanceId)
+	require.NoError(t, err)
+	require.Equal(t, &AwsAssumeRoleCardData{
+		InstanceId:         instanceId,
+		Label:              "deploy",
+		Region:             "eu-west-1",
+		SourceProviderCode: "fake-source",
+		SourceProviderId:   "source-instance-id",
+		SourceSelector:     testSelector,
+		Hops:               expectedHops,
+		Sinks:              []plumbing.SinkInstance{},
+	}, data)
+
+	require.NoError(t, controller.MarkAsFavorite(ctx, instanceId))
+
+	data, err = controller.GetInstanceData(ctx, instanceId)
+	require.NoError(t, err)
+	require.True(t, data.IsFavorite)
+
+	_, err = controller.GetInstanceData(ctx, "unknown-instance-id")
+	require.Same(t, ErrInstanceWasNotFound, err)
+}
+
+func TestValidateSourceSelector(t *testing.T) {
+	controller, _, source, _, mockClock := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	instanceId := simulateSetup(t, controller, source, mockClock)
+
+	require.NoError(t, controller.ValidateSourceSelector(ctx, instanceId, plumbing.SourceSelector{}))
+
+	err := controller.ValidateSourceSelector(ctx, instanceId, testSelector)
+	require.Same(t, ErrInvalidSourceSelector, err)
+
+	err = controller.ValidateSourceSelector(ctx, "unknown-instance-id", plumbing.SourceSelector{})
+	require.Same(t, ErrInstanceWasNotFound, err)
+}
+
+func TestSetup_InvalidInput(t *testing.T) {
+	controller, _, source, _, mockClock := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	mockClock.On("NowUnix").Return(1)
+
+	errInvalidSelector := app.NewValidationError("INVALID_ACCOUNT_ROLE")
+	source.On("ValidateSourceSelector", "source-instance-id", plumbing.SourceSelector{}).Return(errInvalidSelector)
+	source.On("ValidateSourceSelector", mock.Anything, mock.Anything).Return(nil)
+
+	valid := func() AwsAssumeRole_SetupCommandInput {
+		return AwsAssumeRole_SetupCommandInput{
+			Label:              "deploy",
+			Region:             "eu-west-1",
+			SourceProviderCode: "fake-source",
+			SourceProviderId:   "source-instance-id",
+			SourceSelector:     testSelector,
+			Hops:               []Hop{{RoleArn: "arn:aws:iam::222222222222:role/hub"}},
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		modify   func(input *AwsAssumeRole_SetupCommandInput)
+		expected error
+	}{
+		{name: "label", modify: func(input *AwsAssumeRole_SetupCommandInput) { input.Label = "" }, expected: ErrInvalidLabel},
+		{name: "region", modify: func(input *AwsAssumeRole_SetupCommandInput) { input.Region = "mars-north-1" }, expected: ErrInvalidAwsRegion},
+		{name: "no hops", modify: func(input *AwsAssumeRole_SetupCommandInput) { input.Hops = nil }, expected: ErrInvalidChainLength},
+		{name: "too many hops", modify: func(input *AwsAssumeRole_SetupCommandInput) {
+			input.Hops = make([]Hop, maxHops+1)
+		}, expected: ErrInvalidChainLength},
+		{name: "role arn", modify: func(input *AwsAssumeRole_SetupCommandInput) { input.Hops[0].RoleArn = "arn:aws:iam::2222:user/hub" }, expected: ErrInvalidRoleArn},
+		{name: "external id", modify: func(input *AwsAssumeRole_SetupCommandInput) { input.Hops[0].ExternalId = "with space" }, expected: ErrInvalidExternalId},
+		{name: "session name", modify: func(input *AwsAssumeRole_SetupCommandInput) { input.Hops[0].SessionNameTemplate = "x" }, expected: ErrInvalidSessionName},
+		{name: "session duration", modify: func(input *AwsAssumeRole_SetupCommandInput) { input.Hops[0].DurationSeconds = 60 }, expected: ErrInvalidSessionDuration},
+		{name: "chained session duration", modify: func(input *AwsAssumeRole_SetupCommandInput) {
+			input.Hops = append(input.Hops, Hop{RoleArn: "arn:aws:iam::333333333333:role/deployer", DurationSeconds: 7200})
+		}, expected: ErrInvalidSessionDuration},
+		{name: "session policy", modify: func(input *AwsAssumeRole_SetupCommandInput) { input.Hops[0].Policy = "{not json" }, expected: ErrInvalidSessionPolicy},
+		{name: "session tags", modify: func(input *AwsAssumeRole_SetupCommandInput) { input.Hops[0].Tags = map[string]string{"": "empty"} }, expected: ErrInvalidSessionTags},
+		{name: "source provider", modify: func(input *AwsAssumeRole_SetupCommandInput) { input.SourceProviderCode = "aws-unknown" }, expected: ErrInvalidSourceProvider},
+		{name: "source selector", modify: func(input *AwsAssumeRole_SetupCommandInput) { input.SourceSelector = plumbing.SourceSelector{} }, expected: errInvalidSelector},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			input := valid()
+			testCase.modify(&input)
+
+			_, err := controller.Setup(ctx, input)
+			require.Same(t, testCase.expected, err)
+		})
+	}
+}
+
+func TestSetup_SessionDurationOfChains(t *testing.T) {
+	controller, _, source, _, mockClock := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	mockClock.On("NowUnix").Return(1)
+	source.On("ValidateSourceSelector", mock.Anything, mock.Anything).Return(nil)
+
+	input := AwsAssumeRole_SetupCommandInput{
+		Label:              "deploy",
+		Region:             "eu-west-1",
+		SourceProviderCode: "fake-source",
+		SourceProviderId:   "source-instance-id",
+		SourceSelector:     testSelector,
+		Hops:               []Hop{{RoleArn: "arn:aws:iam::222222222222:role/hub", DurationSeconds: maxSessionDurationSeconds}},
+	}
+
+	_, err := controller.Setup(ctx, input)
+	require.NoError(t, err, "the first hop on top of an IAM user is not chained")
+
+	source.roleCredentials = true
+
+	_, err = controller.Setup(ctx, input)
+	require.Same(t, ErrInvalidSessionDuration, err, "the first hop on top of a role is chained")
+
+	input.Hops[0].DurationSeconds = maxChainedSessionDurationSeconds
+
+	_, err = controller.Setup(ctx, input)
+	require.NoError(t, err)
+}
+
+func TestRenderSessionName(t *testing.T) {
+	require.Equal(t, "swervo-1700000000", renderSessionName(defaultSessionNameTemplate, "deploy", 1, 1700000000))
+	require.Equal(t, "my-deploy--prod--2", renderSessionName("my {label}-{hop}", "deploy (prod)", 2, 0))
+	require.Len(t, renderSessionName("{label}", string(make([]byte, 100)), 1, 0), maxSessionNameLength)
+}
+
+func TestGetCredentials(t *testing.T) {
+	controller, stsClient, source, _, mockClock := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	instanceId := simulateSetup(t, controller, source, mockClock)
+
+	mockClock.On("NowUnix").Return(1700000000)
+
+	source.On("GetCredentials", "source-instance-id", testSelector).Return(testSourceCredentials, nil)
+
+	hubCredentials := &awssts.Credentials{AccessKeyId: "ASIAHUBKEY", SecretAccessKey: "hub-secret", SessionToken: "hub-session-token", Expiration: 1700003600}
+	deployerCredentials := &awssts.Credentials{AccessKeyId: "ASIADEPLOYERKEY", SecretAccessKey: "deployer-secret", SessionToken: "deployer-session-token", Expiration: 1700000900}
+
+	stsClient.On("AssumeRole", awsnet.AwsRegion("eu-west-1"), *testSourceCredentials, awssts.AssumeRoleInput{
+		RoleArn:         "arn:aws:iam::222222222222:role/hub",
+		RoleSessionName: "deploy-hop-1",
+		DurationSeconds: defaultSessionDurationSeconds,
+		Tags:            map[string]string{"team": "platform"},
+	}).Return(hubCredentials, nil).Once()
+
+	stsClient.On("AssumeRole", awsnet.AwsRegion("eu-west-1"), *hubCredentials, awssts.AssumeRoleInput{
+		RoleArn:         "arn:aws:iam::333333333333:role/deployer",
+		RoleSessionName: "swervo-1700000000",
+		ExternalId:      "partner-external-id",
+		DurationSeconds: 900,
+		Policy:          `{"Version":"2012-10-17","Statement":[]}`,
+	}).Return(deployerCredentials, nil).Once()
+
+	credentials, err := controller.getCredentials(ctx, instanceId)
+	require.NoError(t, err)
+	require.Equal(t, deployerCredentials, credentials, "every hop is signed with the credentials of the previous one")
+
+	stsClient.AssertExpectations(t)
+}
+
+func TestGetCredentials_Errors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		stsErr   error
+		expected error
+	}{
+		{name: "denied", stsErr: awssts.ErrAccessDenied, expected: ErrAssumeRoleDenied},
+		{name: "expired source", stsErr: awssts.ErrExpiredToken, expected: ErrStaleSourceCredentials},
+		{name: "invalid source", stsErr: awssts.ErrInvalidClientToken, expected: ErrStaleSourceCredentials},
+		{name: "malformed policy", stsErr: awssts.ErrMalformedPolicy, expected: ErrInvalidSessionPolicy},
+		{name: "policy too large", stsErr: awssts.ErrPolicyTooLarge, expected: ErrSessionPolicyTooLarge},
+		{name: "invalid request", stsErr: awssts.ErrInvalidRequest, expected: ErrInvalidHop},
+		{name: "region disabled", stsErr: awssts.ErrRegionDisabled, expected: ErrStsRegionDisabled},
+		{name: "transient", stsErr: errors.New("connection reset"), expected: ErrTransientAwsClientError},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			controller, stsClient, source, _, mockClock := initController(t)
+
+			instanceId := simulateSetup(t, controller, source, mockClock)
+
+			mockClock.On("NowUnix").Return(2)
+			source.On("GetCredentials", mock.Anything, mock.Anything).Return(testSourceCredentials, nil)
+
+			hubCredentials := &awssts.Credentials{AccessKeyId: "ASIAHUBKEY", SecretAccessKey: "hub-secret", SessionToken: "hub-session-token", Expiration: 3600}
+
+			stsClient.On("AssumeRole", mock.Anything, *testSourceCredentials, mock.Anything).Return(hubCredentials, nil)
+			stsClient.On("AssumeRole", mock.Anything, *hubCredentials, mock.Anything).Return(nil, testCase.stsErr)
+
+			_, err := controller.getCredentials(testhelpers.NewMockAppContext(), instanceId)
+			require.Same(t, testCase.expected, err, "failures of later hops are mapped as well")
+		})
+	}
+
+	t.Run("source", func(t *testing.T) {
+		controller, _, source, _, mockClock := initController(t)
+
+		instanceId := simulateSetup(t, controller, source, mockClock)
+
+		errMfaCodeRequired := app.NewValidationError("MFA_CODE_REQUIRED")
+		source.On("GetCredentials", mock.Anything, mock.Anything).Return(nil, errMfaCodeRequired)
+
+		_, err := controller.getCredentials(testhelpers.NewMockAppContext(), instanceId)
+		require.Same(t, errMfaCodeRequired, err, "failures of the source are passed through")
+	})
+
+	controller, _, _, _, _ := initController(t)
+
+	_, err := controller.getCredentials(testhelpers.NewMockAppContext(), "unknown-instance-id")
+	require.Same(t, ErrInstanceWasNotFound, err)
+}
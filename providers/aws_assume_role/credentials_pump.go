@@ -0,0 +1,117 @@
+package awsassumerole
+
+import (
+	"errors"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/plumbing"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+)
+
+var pumpReconcileInterval = 1 * time.Minute
+
+func credentialsPumpOptions() plumbing.PumpOptions {
+	options := plumbing.DefaultPumpOptions
+
+	options.IsRetryable = func(err error) bool {
+		return app.IsError(err, ErrTransientAwsClientError)
+	}
+
+	// every other validation error, whether of a hop or of the source, needs the user to step in
+	options.IsStopping = func(err error) bool {
+		var validationErr *app.ValidationError
+
+		return errors.As(err, &validationErr) && !app.IsError(err, ErrTransientAwsClientError)
+	}
+
+	return options
+}
+
+func newCredentialsPump(c *AwsAssumeRoleController) *plumbing.Pump[awsidc.AwsCredentials] {
+	return plumbing.NewPump(func(ctx app.Context, pipe plumbing.Pipe) (awsidc.AwsCredentials, int64, error) {
+		credentials, err := c.getCredentials(ctx, pipe.ProviderId)
+
+		if err != nil {
+			return awsidc.AwsCredentials{}, 0, err
+		}
+
+		return awsidc.AwsCredentials{
+			AccessKeyID:     credentials.AccessKeyId,
+			SecretAccessKey: credentials.SecretAccessKey,
+			SessionToken:    credentials.SessionToken,
+		}, credentials.Expiration, nil
+	}, c.clock, credentialsPumpOptions())
+}
+
+func (c *AwsAssumeRoleController) desiredPipes(ctx app.Context) (map[plumbing.Pipe]plumbing.Plumber[awsidc.AwsCredentials], error) {
+	instanceIds, err := c.ListInstances(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	desired := make(map[plumbing.Pipe]plumbing.Plumber[awsidc.AwsCredentials])
+
+	for _, instanceId := range instanceIds {
+		for _, plumber := range c.plumbers {
+			sinks, err := plumber.ListConnectedSinks(ctx, ProviderCode, instanceId)
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, sink := range sinks {
+				desired[plumbing.Pipe{
+					ProviderCode:   ProviderCode,
+					ProviderId:     instanceId,
+					SinkCode:       sink.SinkCode,
+					SinkId:         sink.SinkId,
+					SourceSelector: sink.SourceSelector,
+				}] = plumber
+			}
+		}
+	}
+
+	return desired, nil
+}
+
+// StartCredentialsPump keeps the sinks connected to every instance supplied with credentials of its last role
+// until [AwsAssumeRoleController.StopCredentialsPump] is called.
+func (c *AwsAssumeRoleController) StartCredentialsPump(ctx app.Context) {
+	if c.pump.Start(ctx, pumpReconcileInterval, c.desiredPipes) {
+		ctx.Logger().Info().Msg("started credentials pump")
+	}
+}
+
+// InstanceChanged lets the pump feed the sinks of every instance whose chain starts from the given source instance again,
+// after they were stopped because the source or a hop failed.
+func (c *AwsAssumeRoleController) InstanceChanged(ctx app.Context, providerCode, providerId string) {
+	rows, err := c.db.QueryContext(ctx, "SELECT instance_id FROM aws_assume_role WHERE source_provider_code = ? AND source_provider_id = ?", providerCode, providerId)
+
+	if err != nil {
+		ctx.Logger().Error().Err(err).Msgf("failed to list instances of source [%s/%s]", providerCode, providerId)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var instanceId string
+
+		if err := rows.Scan(&instanceId); err != nil {
+			ctx.Logger().Error().Err(err).Msgf("failed to list instances of source [%s/%s]", providerCode, providerId)
+			return
+		}
+
+		c.pump.Resume(ProviderCode, instanceId)
+	}
+
+	if err := rows.Err(); err != nil {
+		ctx.Logger().Error().Err(err).Msgf("failed to list instances of source [%s/%s]", providerCode, providerId)
+	}
+}
+
+// StopCredentialsPump stops refreshing sinks and waits for in-flight refreshes to finish.
+func (c *AwsAssumeRoleController) StopCredentialsPump() {
+	c.pump.Stop()
+}
@@ -0,0 +1,124 @@
+package awsassumerole
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abjrcode/swervo/clients/awssts"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/plumbing"
+	"github.com/abjrcode/swervo/internal/providertest"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialsPump_FlowsCredentialsOfLastHopIntoSinks(t *testing.T) {
+	controller, stsClient, source, _, mockClock := initController(t)
+
+	instanceId := simulateSetup(t, controller, source, mockClock)
+
+	mockClock.On("NowUnix").Return(2)
+	source.On("GetCredentials", "source-instance-id", testSelector).Return(testSourceCredentials, nil)
+
+	hubCredentials := &awssts.Credentials{AccessKeyId: "ASIAHUBKEY", SecretAccessKey: "hub-secret", SessionToken: "hub-session-token", Expiration: 3600}
+	deployerCredentials := &awssts.Credentials{AccessKeyId: "ASIADEPLOYERKEY", SecretAccessKey: "deployer-secret", SessionToken: "deployer-session-token", Expiration: 900}
+
+	stsClient.On("AssumeRole", mock.Anything, *testSourceCredentials, mock.Anything).Return(hubCredentials, nil)
+	stsClient.On("AssumeRole", mock.Anything, *hubCredentials, mock.Anything).Return(deployerCredentials, nil)
+
+	plumber := providertest.NewFakePlumber[awsidc.AwsCredentials]()
+	controller.AddPlumbers(plumber)
+
+	controller.StartCredentialsPump(testhelpers.NewMockAppContext())
+	t.Cleanup(controller.StopCredentialsPump)
+
+	select {
+	case creds := <-plumber.Flows:
+		require.Equal(t, awsidc.AwsCredentials{
+			AccessKeyID:     "ASIADEPLOYERKEY",
+			SecretAccessKey: "deployer-secret",
+			SessionToken:    "deployer-session-token",
+		}, creds)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for credentials to flow")
+	}
+
+	require.Equal(t, []plumbing.Pipe{{
+		ProviderCode: ProviderCode,
+		ProviderId:   instanceId,
+		SinkCode:     "fake-sink",
+		SinkId:       "test-sink",
+	}}, controller.pump.Pipes())
+}
+
+func TestCredentialsPumpOptions(t *testing.T) {
+	options := credentialsPumpOptions()
+
+	require.True(t, options.IsStopping(ErrAssumeRoleDenied))
+	require.True(t, options.IsStopping(app.NewValidationError("MFA_CODE_REQUIRED")), "failures of sources stop pipes too")
+	require.False(t, options.IsStopping(ErrTransientAwsClientError))
+	require.False(t, options.IsStopping(errors.New("unexpected")))
+
+	require.True(t, options.IsRetryable(ErrTransientAwsClientError))
+	require.False(t, options.IsRetryable(ErrAssumeRoleDenied))
+}
+
+func TestCredentialsPump_StaysStoppedUntilSourceChanges(t *testing.T) {
+	previousInterval := pumpReconcileInterval
+	pumpReconcileInterval = 5 * time.Millisecond
+	t.Cleanup(func() { pumpReconcileInterval = previousInterval })
+
+	controller, stsClient, source, _, mockClock := initController(t)
+
+	simulateSetup(t, controller, source, mockClock)
+
+	mockClock.On("NowUnix").Return(2)
+
+	failed := make(chan struct{})
+	source.On("GetCredentials", "source-instance-id", testSelector).Return(nil, app.NewValidationError("STALE_AWS_ACCESS_TOKEN")).Once().
+		Run(func(args mock.Arguments) { close(failed) })
+
+	plumber := providertest.NewFakePlumber[awsidc.AwsCredentials]()
+	controller.AddPlumbers(plumber)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	controller.StartCredentialsPump(ctx)
+	t.Cleanup(controller.StopCredentialsPump)
+
+	select {
+	case <-failed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for source credentials to be requested")
+	}
+
+	require.Eventually(t, func() bool {
+		return len(controller.pump.Pipes()) == 0
+	}, 2*time.Second, 5*time.Millisecond)
+
+	// several reconcile ticks go by
+	time.Sleep(50 * time.Millisecond)
+
+	require.Empty(t, controller.pump.Pipes(), "pipes stopped by a failing source are not connected again")
+	source.AssertNumberOfCalls(t, "GetCredentials", 1)
+
+	source.On("GetCredentials", "source-instance-id", testSelector).Return(testSourceCredentials, nil)
+	stsClient.On("AssumeRole", mock.Anything, mock.Anything, mock.Anything).Return(testSourceCredentials, nil)
+
+	controller.InstanceChanged(ctx, "fake-source", "other-source-instance-id")
+
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, controller.pump.Pipes(), "changes of other source instances do not matter")
+
+	controller.InstanceChanged(ctx, "fake-source", "source-instance-id")
+
+	select {
+	case creds := <-plumber.Flows:
+		require.Equal(t, "source-session-token", creds.SessionToken)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for credentials to flow")
+	}
+}
@@ -0,0 +1,107 @@
+package awsassumerole
+
+import (
+	"github.com/abjrcode/swervo/clients/awssts"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/plumbing"
+	awsiamuser "github.com/abjrcode/swervo/providers/aws_iam_user"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+)
+
+// CredentialsSource hands out the credentials the first hop of a chain is signed with.
+// Failures of a source are its own validation errors, except for transient ones which become [ErrTransientAwsClientError].
+type CredentialsSource interface {
+	plumbing.SourceValidator
+
+	GetCredentials(ctx app.Context, providerId string, selector plumbing.SourceSelector) (*awssts.Credentials, error)
+
+	// IssuesRoleCredentials tells whether the credentials of the source belong to a role, which makes the first hop a role chain already
+	IssuesRoleCredentials() bool
+}
+
+type awsIdcRoleCredentials interface {
+	plumbing.SourceValidator
+
+	GetRoleCredentials(ctx app.Context, instanceId, accountId, roleName string) (*awsidc.RoleCredentials, error)
+}
+
+type awsIdcSource struct {
+	idc awsIdcRoleCredentials
+}
+
+// NewAwsIdcSource starts chains from an account and role of an AWS Identity Center instance.
+func NewAwsIdcSource(idc *awsidc.AwsIdentityCenterController) CredentialsSource {
+	return &awsIdcSource{idc: idc}
+}
+
+func (s *awsIdcSource) ProviderCode() string {
+	return s.idc.ProviderCode()
+}
+
+func (s *awsIdcSource) ValidateSourceSelector(ctx app.Context, providerId string, selector plumbing.SourceSelector) error {
+	return s.idc.ValidateSourceSelector(ctx, providerId, selector)
+}
+
+func (s *awsIdcSource) IssuesRoleCredentials() bool {
+	return true
+}
+
+func (s *awsIdcSource) GetCredentials(ctx app.Context, providerId string, selector plumbing.SourceSelector) (*awssts.Credentials, error) {
+	credentials, err := s.idc.GetRoleCredentials(ctx, providerId, selector.AccountId, selector.RoleName)
+
+	if err != nil {
+		if app.IsError(err, awsidc.ErrTransientAwsClientError) {
+			return nil, ErrTransientAwsClientError
+		}
+
+		return nil, err
+	}
+
+	return &awssts.Credentials{
+		AccessKeyId:     credentials.AccessKeyId,
+		SecretAccessKey: credentials.SecretAccessKey,
+		SessionToken:    credentials.SessionToken,
+		Expiration:      credentials.ExpiresAt,
+	}, nil
+}
+
+type awsIamUserSessions interface {
+	plumbing.SourceValidator
+
+	GetSessionCredentials(ctx app.Context, instanceId string) (*awssts.Credentials, error)
+}
+
+type awsIamUserSource struct {
+	iamUser awsIamUserSessions
+}
+
+// NewAwsIamUserSource starts chains from the session credentials of an IAM user instance.
+func NewAwsIamUserSource(iamUser *awsiamuser.AwsIamUserController) CredentialsSource {
+	return &awsIamUserSource{iamUser: iamUser}
+}
+
+func (s *awsIamUserSource) ProviderCode() string {
+	return s.iamUser.ProviderCode()
+}
+
+func (s *awsIamUserSource) ValidateSourceSelector(ctx app.Context, providerId string, selector plumbing.SourceSelector) error {
+	return s.iamUser.ValidateSourceSelector(ctx, providerId, selector)
+}
+
+func (s *awsIamUserSource) IssuesRoleCredentials() bool {
+	return false
+}
+
+func (s *awsIamUserSource) GetCredentials(ctx app.Context, providerId string, selector plumbing.SourceSelector) (*awssts.Credentials, error) {
+	credentials, err := s.iamUser.GetSessionCredentials(ctx, providerId)
+
+	if err != nil {
+		if app.IsError(err, awsiamuser.ErrTransientAwsClientError) || app.IsError(err, awsiamuser.ErrMfaCodeUnavailable) {
+			return nil, ErrTransientAwsClientError
+		}
+
+		return nil, err
+	}
+
+	return credentials, nil
+}
@@ -0,0 +1,102 @@
+package awsassumerole
+
+import (
+	"testing"
+
+	"github.com/abjrcode/swervo/clients/awssts"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/plumbing"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	awsiamuser "github.com/abjrcode/swervo/providers/aws_iam_user"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAwsIdc struct {
+	credentials *awsidc.RoleCredentials
+	err         error
+}
+
+func (f *fakeAwsIdc) ProviderCode() string {
+	return awsidc.ProviderCode
+}
+
+func (f *fakeAwsIdc) ValidateSourceSelector(ctx app.Context, providerId string, selector plumbing.SourceSelector) error {
+	return nil
+}
+
+func (f *fakeAwsIdc) GetRoleCredentials(ctx app.Context, instanceId, accountId, roleName string) (*awsidc.RoleCredentials, error) {
+	return f.credentials, f.err
+}
+
+type fakeAwsIamUser struct {
+	credentials *awssts.Credentials
+	err         error
+}
+
+func (f *fakeAwsIamUser) ProviderCode() string {
+	return awsiamuser.ProviderCode
+}
+
+func (f *fakeAwsIamUser) ValidateSourceSelector(ctx app.Context, providerId string, selector plumbing.SourceSelector) error {
+	return nil
+}
+
+func (f *fakeAwsIamUser) GetSessionCredentials(ctx app.Context, instanceId string) (*awssts.Credentials, error) {
+	return f.credentials, f.err
+}
+
+func TestAwsIdcSource(t *testing.T) {
+	ctx := testhelpers.NewMockAppContext()
+
+	source := &awsIdcSource{idc: &fakeAwsIdc{credentials: &awsidc.RoleCredentials{
+		AccessKeyId:     "ASIAROLEKEY",
+		SecretAccessKey: "role-secret",
+		SessionToken:    "role-session-token",
+		ExpiresAt:       3600,
+	}}}
+
+	credentials, err := source.GetCredentials(ctx, "idc-instance-id", testSelector)
+	require.NoError(t, err)
+	require.Equal(t, &awssts.Credentials{
+		AccessKeyId:     "ASIAROLEKEY",
+		SecretAccessKey: "role-secret",
+		SessionToken:    "role-session-token",
+		Expiration:      3600,
+	}, credentials)
+
+	source = &awsIdcSource{idc: &fakeAwsIdc{err: awsidc.ErrTransientAwsClientError}}
+
+	_, err = source.GetCredentials(ctx, "idc-instance-id", testSelector)
+	require.Same(t, ErrTransientAwsClientError, err)
+
+	source = &awsIdcSource{idc: &fakeAwsIdc{err: awsidc.ErrStaleAwsAccessToken}}
+
+	_, err = source.GetCredentials(ctx, "idc-instance-id", testSelector)
+	require.Same(t, awsidc.ErrStaleAwsAccessToken, err)
+}
+
+func TestAwsIamUserSource(t *testing.T) {
+	ctx := testhelpers.NewMockAppContext()
+
+	source := &awsIamUserSource{iamUser: &fakeAwsIamUser{credentials: testSourceCredentials}}
+
+	credentials, err := source.GetCredentials(ctx, "iam-user-instance-id", plumbing.SourceSelector{})
+	require.NoError(t, err)
+	require.Equal(t, testSourceCredentials, credentials)
+
+	source = &awsIamUserSource{iamUser: &fakeAwsIamUser{err: awsiamuser.ErrTransientAwsClientError}}
+
+	_, err = source.GetCredentials(ctx, "iam-user-instance-id", plumbing.SourceSelector{})
+	require.Same(t, ErrTransientAwsClientError, err)
+
+	source = &awsIamUserSource{iamUser: &fakeAwsIamUser{err: awsiamuser.ErrMfaCodeUnavailable}}
+
+	_, err = source.GetCredentials(ctx, "iam-user-instance-id", plumbing.SourceSelector{})
+	require.Same(t, ErrTransientAwsClientError, err, "codes of MFA devices become available again as time moves on")
+
+	source = &awsIamUserSource{iamUser: &fakeAwsIamUser{err: awsiamuser.ErrMfaCodeRequired}}
+
+	_, err = source.GetCredentials(ctx, "iam-user-instance-id", plumbing.SourceSelector{})
+	require.Same(t, awsiamuser.ErrMfaCodeRequired, err)
+}
@@ -0,0 +1,696 @@
+package awsiamuser
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/clients/awssts"
+	"github.com/abjrcode/swervo/favorites"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/eventing"
+	"github.com/abjrcode/swervo/internal/plumbing"
+	"github.com/abjrcode/swervo/internal/security/encryption"
+	"github.com/abjrcode/swervo/internal/utils"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+	"github.com/dustin/go-humanize"
+	"github.com/segmentio/ksuid"
+)
+
+var ProviderCode = "aws-iam-user"
+
+var (
+	ErrInvalidLabel            = app.NewValidationError("INVALID_LABEL")
+	ErrInvalidAwsRegion        = app.NewValidationError("INVALID_AWS_REGION")
+	ErrInvalidAccessKeyId      = app.NewValidationError("INVALID_ACCESS_KEY_ID")
+	ErrInvalidSecretAccessKey  = app.NewValidationError("INVALID_SECRET_ACCESS_KEY")
+	ErrInvalidMfaSerial        = app.NewValidationError("INVALID_MFA_SERIAL")
+	ErrInvalidMfaCode          = app.NewValidationError("INVALID_MFA_CODE")
+	ErrInvalidSessionDuration  = app.NewValidationError("INVALID_SESSION_DURATION")
+	ErrInstanceWasNotFound     = app.NewValidationError("INSTANCE_WAS_NOT_FOUND")
+	ErrInvalidSourceSelector   = app.NewValidationError("INVALID_SOURCE_SELECTOR")
+	ErrMfaCodeRequired         = app.NewValidationError("MFA_CODE_REQUIRED")
+	ErrMfaCodeUnavailable      = app.NewValidationError("MFA_CODE_UNAVAILABLE")
+	ErrMfaAuthenticationFailed = app.NewValidationError("MFA_AUTHENTICATION_FAILED")
+	ErrInvalidAccessKeys       = app.NewValidationError("INVALID_ACCESS_KEYS")
+	ErrSessionTokenDenied      = app.NewValidationError("SESSION_TOKEN_DENIED")
+	ErrStsRegionDisabled       = app.NewValidationError("STS_REGION_DISABLED")
+	ErrTransientAwsClientError = app.NewValidationError("TRANSIENT_AWS_CLIENT_ERROR")
+)
+
+var AwsIamUserEventSource = eventing.EventSource("AwsIamUser")
+
+const (
+	// minSessionDurationSeconds and maxSessionDurationSeconds bound the sessions STS issues to IAM users
+	minSessionDurationSeconds     = 900
+	maxSessionDurationSeconds     = 129600
+	defaultSessionDurationSeconds = 43200
+)
+
+var (
+	accessKeyIdRegex = regexp.MustCompile(`^[A-Z0-9]{16,128}$`)
+	mfaSerialRegex   = regexp.MustCompile(`^[\w+=/:,.@-]{9,256}$`)
+	mfaCodeRegex     = regexp.MustCompile(`^[0-9]{6}$`)
+)
+
+type AwsIamUserInstanceCreatedEvent struct {
+	InstanceId string
+
+	Label     string
+	Region    string
+	MfaSerial string
+}
+
+// AwsIamUserSessionStartedEvent records that session credentials were minted for an instance, never the credentials themselves.
+type AwsIamUserSessionStartedEvent struct {
+	InstanceId string
+
+	WithMfa   bool
+	ExpiresAt int64
+}
+
+type AwsIamUserController struct {
+	db                *sql.DB
+	bus               *eventing.Eventbus
+	favoritesRepo     favorites.FavoritesRepo
+	encryptionService encryption.EncryptionService
+	awsStsClient      awssts.AwsStsClient
+	regions           *awssso.RegionCatalog
+	clock             utils.Clock
+	mfaCodeSource     MfaCodeSource
+
+	plumbers          []plumbing.Plumber[awsidc.AwsCredentials]
+	instanceListeners []plumbing.InstanceListener
+
+	sessionMu sync.Mutex
+
+	pump *plumbing.Pump[awsidc.AwsCredentials]
+}
+
+func NewAwsIamUserController(db *sql.DB, bus *eventing.Eventbus, favoritesRepo favorites.FavoritesRepo, encryptionService encryption.EncryptionService, awsStsClient awssts.AwsStsClient, regions *awssso.RegionCatalog, clock utils.Clock) *AwsIamUserController {
+	controller := &AwsIamUserController{
+		db:                db,
+		bus:               bus,
+		favoritesRepo:     favoritesRepo,
+		encryptionService: encryptionService,
+		awsStsClient:      awsStsClient,
+		regions:           regions,
+		clock:             clock,
+
+		plumbers:          make([]plumbing.Plumber[awsidc.AwsCredentials], 0),
+		instanceListeners: make([]plumbing.InstanceListener, 0),
+	}
+
+	controller.pump = newCredentialsPump(controller)
+
+	return controller
+}
+
+// MfaCodeSource generates codes of MFA devices on behalf of the user, it is implemented by [totp.MfaCodeSource].
+type MfaCodeSource interface {
+	// GetMfaCode returns a code that was not used before, or an empty code when it cannot generate codes of the device
+	GetMfaCode(ctx app.Context, mfaSerial string) (string, error)
+	ListMfaSerials(ctx app.Context) ([]string, error)
+}
+
+// SetMfaCodeSource lets sessions of instances with MFA start without prompting the user
+// whenever the source can generate codes of their device.
+func (c *AwsIamUserController) SetMfaCodeSource(source MfaCodeSource) {
+	c.mfaCodeSource = source
+}
+
+// hasMfaCodes reports whether codes of an MFA device can be generated without the user.
+func (c *AwsIamUserController) hasMfaCodes(ctx app.Context, mfaSerial string) (bool, error) {
+	if c.mfaCodeSource == nil {
+		return false, nil
+	}
+
+	mfaSerials, err := c.mfaCodeSource.ListMfaSerials(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, serial := range mfaSerials {
+		if serial == mfaSerial {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (c *AwsIamUserController) AddPlumbers(plumbers ...plumbing.Plumber[awsidc.AwsCredentials]) {
+	c.plumbers = append(c.plumbers, plumbers...)
+}
+
+// AddInstanceListeners lets others know whenever a session of an instance is started by the user.
+func (c *AwsIamUserController) AddInstanceListeners(listeners ...plumbing.InstanceListener) {
+	c.instanceListeners = append(c.instanceListeners, listeners...)
+}
+
+func (c *AwsIamUserController) ProviderCode() string {
+	return ProviderCode
+}
+
+type AwsIamUserCardData struct {
+	InstanceId string `json:"instanceId"`
+	Label      string `json:"label"`
+	Region     string `json:"region"`
+	IsFavorite bool   `json:"isFavorite"`
+	// MfaSerial is empty when sessions are started without MFA
+	MfaSerial              string `json:"mfaSerial"`
+	SessionDurationSeconds int32  `json:"sessionDurationSeconds"`
+	IsSessionExpired       bool   `json:"isSessionExpired"`
+	SessionExpiresIn       string `json:"sessionExpiresIn"`
+
+	Sinks []plumbing.SinkInstance `json:"sinks"`
+}
+
+func (c *AwsIamUserController) ListInstances(ctx app.Context) ([]string, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT instance_id FROM aws_iam_user ORDER BY instance_id DESC")
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+	defer rows.Close()
+
+	instances := make([]string, 0)
+
+	for rows.Next() {
+		var instanceId string
+
+		if err := rows.Scan(&instanceId); err != nil {
+			return nil, errors.Join(err, app.ErrFatal)
+		}
+
+		instances = append(instances, instanceId)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	return instances, nil
+}
+
+// GetInstanceData describes an instance and its current session, it never returns keys or credentials.
+func (c *AwsIamUserController) GetInstanceData(ctx app.Context, instanceId string) (*AwsIamUserCardData, error) {
+	row := c.db.QueryRowContext(ctx, "SELECT label, region, mfa_serial, session_duration_seconds, session_expires_at FROM aws_iam_user WHERE instance_id = ?", instanceId)
+
+	data := AwsIamUserCardData{
+		InstanceId: instanceId,
+		Sinks:      make([]plumbing.SinkInstance, 0),
+	}
+
+	var sessionExpiresAt sql.NullInt64
+
+	if err := row.Scan(&data.Label, &data.Region, &data.MfaSerial, &data.SessionDurationSeconds, &sessionExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInstanceWasNotFound
+		}
+
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	isFavorite, err := c.favoritesRepo.IsFavorite(ctx, &favorites.Favorite{
+		ProviderCode: ProviderCode,
+		InstanceId:   instanceId,
+	})
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	data.IsFavorite = isFavorite
+
+	if sessionExpiresAt.Valid && sessionExpiresAt.Int64 > c.clock.NowUnix() {
+		data.SessionExpiresIn = humanize.Time(time.Unix(sessionExpiresAt.Int64, 0))
+	} else {
+		data.IsSessionExpired = true
+	}
+
+	for _, plumber := range c.plumbers {
+		connectedSinks, err := plumber.ListConnectedSinks(ctx, ProviderCode, instanceId)
+
+		if err != nil {
+			ctx.Logger().Error().Err(err).Msg("failed to list pipes")
+			return nil, errors.Join(err, app.ErrFatal)
+		}
+
+		data.Sinks = append(data.Sinks, connectedSinks...)
+	}
+
+	return &data, nil
+}
+
+func (c *AwsIamUserController) validateLabel(label string) error {
+	if len(label) < 1 || len(label) > 50 {
+		return ErrInvalidLabel
+	}
+
+	return nil
+}
+
+func (c *AwsIamUserController) validateAwsRegion(region string) error {
+	if _, ok := c.regions.Lookup(region); !ok {
+		return ErrInvalidAwsRegion
+	}
+
+	return nil
+}
+
+type AwsIamUser_SetupCommandInput struct {
+	Label           string `json:"label"`
+	Region          string `json:"region"`
+	AccessKeyId     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	// MfaSerial is the ARN of a virtual MFA device or the serial number of a hardware one,
+	// sessions are started without MFA when empty
+	MfaSerial string `json:"mfaSerial"`
+	// SessionDurationSeconds is how long session credentials last, 12 hours when zero
+	SessionDurationSeconds int32 `json:"sessionDurationSeconds"`
+}
+
+// Setup stores the long-lived access keys of an IAM user encrypted with the vault and publishes [AwsIamUserInstanceCreatedEvent].
+// Keys are not checked with AWS until the first session is started.
+func (c *AwsIamUserController) Setup(ctx app.Context, input AwsIamUser_SetupCommandInput) (string, error) {
+	if err := c.validateLabel(input.Label); err != nil {
+		return "", err
+	}
+
+	if err := c.validateAwsRegion(input.Region); err != nil {
+		return "", err
+	}
+
+	if !accessKeyIdRegex.MatchString(input.AccessKeyId) {
+		return "", ErrInvalidAccessKeyId
+	}
+
+	if len(input.SecretAccessKey) < 1 || len(input.SecretAccessKey) > 128 || strings.ContainsAny(input.SecretAccessKey, " \t\r\n") {
+		return "", ErrInvalidSecretAccessKey
+	}
+
+	if input.MfaSerial != "" && !mfaSerialRegex.MatchString(input.MfaSerial) {
+		return "", ErrInvalidMfaSerial
+	}
+
+	sessionDurationSeconds := input.SessionDurationSeconds
+
+	if sessionDurationSeconds == 0 {
+		sessionDurationSeconds = defaultSessionDurationSeconds
+	}
+
+	if sessionDurationSeconds < minSessionDurationSeconds || sessionDurationSeconds > maxSessionDurationSeconds {
+		return "", ErrInvalidSessionDuration
+	}
+
+	accessKeyIdEnc, keyId, err := c.encryptionService.Encrypt(input.AccessKeyId)
+
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	secretAccessKeyEnc, _, err := c.encryptionService.Encrypt(input.SecretAccessKey)
+
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	nowUnix := c.clock.NowUnix()
+
+	uniqueId, err := ksuid.NewRandomWithTime(time.Unix(nowUnix, 0))
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+	instanceId := uniqueId.String()
+	version := 1
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO aws_iam_user
+	(instance_id, version, label, region, access_key_id_enc, secret_access_key_enc, mfa_serial, session_duration_seconds, created_at, enc_key_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		instanceId,
+		version,
+		input.Label,
+		input.Region,
+		accessKeyIdEnc,
+		secretAccessKeyEnc,
+		input.MfaSerial,
+		sessionDurationSeconds,
+		nowUnix,
+		keyId)
+
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	publish, err := c.bus.PublishTx(ctx, AwsIamUserInstanceCreatedEvent{
+		InstanceId: instanceId,
+		Label:      input.Label,
+		Region:     input.Region,
+		MfaSerial:  input.MfaSerial,
+	}, eventing.EventMeta{
+		SourceType:   AwsIamUserEventSource,
+		SourceId:     instanceId,
+		EventVersion: uint(version),
+	}, tx)
+
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	publish()
+
+	return instanceId, nil
+}
+
+// iamUser is an instance as it is stored, with its keys and session still encrypted
+type iamUser struct {
+	instanceId             string
+	version                int
+	region                 string
+	accessKeyIdEnc         string
+	secretAccessKeyEnc     string
+	mfaSerial              string
+	sessionDurationSeconds int32
+	encKeyId               string
+
+	sessionAccessKeyIdEnc     sql.NullString
+	sessionSecretAccessKeyEnc sql.NullString
+	sessionTokenEnc           sql.NullString
+	sessionExpiresAt          sql.NullInt64
+	sessionEncKeyId           sql.NullString
+}
+
+func (c *AwsIamUserController) loadIamUser(ctx app.Context, instanceId string) (*iamUser, error) {
+	row := c.db.QueryRowContext(ctx, `SELECT version, region, access_key_id_enc, secret_access_key_enc, mfa_serial, session_duration_seconds, enc_key_id,
+	session_access_key_id_enc, session_secret_access_key_enc, session_token_enc, session_expires_at, session_enc_key_id
+	FROM aws_iam_user WHERE instance_id = ?`, instanceId)
+
+	user := iamUser{instanceId: instanceId}
+
+	err := row.Scan(&user.version, &user.region, &user.accessKeyIdEnc, &user.secretAccessKeyEnc, &user.mfaSerial, &user.sessionDurationSeconds, &user.encKeyId,
+		&user.sessionAccessKeyIdEnc, &user.sessionSecretAccessKeyEnc, &user.sessionTokenEnc, &user.sessionExpiresAt, &user.sessionEncKeyId)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInstanceWasNotFound
+		}
+
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	return &user, nil
+}
+
+func (c *AwsIamUserController) decryptSession(user *iamUser) (*awssts.Credentials, error) {
+	keyId := user.sessionEncKeyId.String
+
+	accessKeyId, err := c.encryptionService.Decrypt(user.sessionAccessKeyIdEnc.String, keyId)
+
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to decrypt session credentials"), err, app.ErrFatal)
+	}
+
+	secretAccessKey, err := c.encryptionService.Decrypt(user.sessionSecretAccessKeyEnc.String, keyId)
+
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to decrypt session credentials"), err, app.ErrFatal)
+	}
+
+	sessionToken, err := c.encryptionService.Decrypt(user.sessionTokenEnc.String, keyId)
+
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to decrypt session credentials"), err, app.ErrFatal)
+	}
+
+	return &awssts.Credentials{
+		AccessKeyId:     accessKeyId,
+		SecretAccessKey: secretAccessKey,
+		SessionToken:    sessionToken,
+		Expiration:      user.sessionExpiresAt.Int64,
+	}, nil
+}
+
+// getSessionCredentials returns the stored session of an instance unless it expires within minValiditySeconds.
+// Instances without MFA or with generated MFA codes start a new session then, others keep handing out the stored one until it expires.
+func (c *AwsIamUserController) getSessionCredentials(ctx app.Context, instanceId string, minValiditySeconds int64) (*awssts.Credentials, error) {
+	user, err := c.loadIamUser(ctx, instanceId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nowUnix := c.clock.NowUnix()
+	hasSession := user.sessionExpiresAt.Valid
+
+	if hasSession && user.sessionExpiresAt.Int64-nowUnix > minValiditySeconds {
+		return c.decryptSession(user)
+	}
+
+	if user.mfaSerial == "" {
+		return c.startSession(ctx, instanceId, "")
+	}
+
+	hasMfaCodes, err := c.hasMfaCodes(ctx, user.mfaSerial)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if hasMfaCodes {
+		return c.startSession(ctx, instanceId, "")
+	}
+
+	if hasSession && user.sessionExpiresAt.Int64 > nowUnix {
+		return c.decryptSession(user)
+	}
+
+	return nil, ErrMfaCodeRequired
+}
+
+// GetSessionCredentials returns session credentials of an instance that stay valid for a few more minutes at least,
+// for other providers that build on top of IAM users.
+func (c *AwsIamUserController) GetSessionCredentials(ctx app.Context, instanceId string) (*awssts.Credentials, error) {
+	return c.getSessionCredentials(ctx, instanceId, minValiditySeconds(plumbing.DefaultPumpOptions))
+}
+
+// ValidateSourceSelector checks that the instance exists, an IAM user has a single identity so the selector must be empty.
+func (c *AwsIamUserController) ValidateSourceSelector(ctx app.Context, instanceId string, selector plumbing.SourceSelector) error {
+	if !selector.IsEmpty() {
+		return ErrInvalidSourceSelector
+	}
+
+	_, err := c.loadIamUser(ctx, instanceId)
+
+	return err
+}
+
+type AwsIamUser_StartSessionCommandInput struct {
+	InstanceId string `json:"instanceId"`
+	// MfaCode is the current code of the MFA device of the instance, ignored when the instance does not use MFA.
+	// It is generated when empty and the MFA device is known to the MFA code source.
+	MfaCode string `json:"mfaCode"`
+}
+
+// StartSession mints session credentials with sts:GetSessionToken and keeps them for the sinks of the instance.
+func (c *AwsIamUserController) StartSession(ctx app.Context, input AwsIamUser_StartSessionCommandInput) error {
+	if _, err := c.startSession(ctx, input.InstanceId, input.MfaCode); err != nil {
+		return err
+	}
+
+	// pipes still fed by the previous session pick up the new one once the pump reconnects them,
+	// including the ones halted because the instance failed to start a session on its own
+	c.pump.DisconnectProvider(ProviderCode, input.InstanceId)
+	c.pump.Resume(ProviderCode, input.InstanceId)
+
+	for _, listener := range c.instanceListeners {
+		listener.InstanceChanged(ctx, ProviderCode, input.InstanceId)
+	}
+
+	return nil
+}
+
+func mapStsError(ctx app.Context, err error, withMfa bool) error {
+	switch {
+	case errors.Is(err, awssts.ErrInvalidClientToken):
+		return ErrInvalidAccessKeys
+	case errors.Is(err, awssts.ErrAccessDenied):
+		// STS answers a wrong or reused MFA code with access denied
+		if withMfa {
+			return ErrMfaAuthenticationFailed
+		}
+
+		return ErrSessionTokenDenied
+	case errors.Is(err, awssts.ErrRegionDisabled):
+		return ErrStsRegionDisabled
+	}
+
+	ctx.Logger().Error().Err(err).Msg("aws sts client failed to get session token")
+
+	return ErrTransientAwsClientError
+}
+
+func (c *AwsIamUserController) startSession(ctx app.Context, instanceId, mfaCode string) (*awssts.Credentials, error) {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+
+	user, err := c.loadIamUser(ctx, instanceId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	withMfa := user.mfaSerial != ""
+
+	if withMfa && mfaCode == "" && c.mfaCodeSource != nil {
+		mfaCode, err = c.mfaCodeSource.GetMfaCode(ctx, user.mfaSerial)
+
+		if err != nil {
+			if errors.Is(err, app.ErrFatal) {
+				return nil, err
+			}
+
+			ctx.Logger().Warn().Err(err).Msgf("failed to generate a code of MFA device [%s]", user.mfaSerial)
+			return nil, ErrMfaCodeUnavailable
+		}
+
+		if mfaCode == "" {
+			return nil, ErrMfaCodeRequired
+		}
+	}
+
+	if withMfa && !mfaCodeRegex.MatchString(mfaCode) {
+		return nil, ErrInvalidMfaCode
+	}
+
+	accessKeyId, err := c.encryptionService.Decrypt(user.accessKeyIdEnc, user.encKeyId)
+
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to decrypt access keys"), err, app.ErrFatal)
+	}
+
+	secretAccessKey, err := c.encryptionService.Decrypt(user.secretAccessKeyEnc, user.encKeyId)
+
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to decrypt access keys"), err, app.ErrFatal)
+	}
+
+	sessionInput := awssts.GetSessionTokenInput{
+		DurationSeconds: user.sessionDurationSeconds,
+	}
+
+	if withMfa {
+		sessionInput.MfaSerial = user.mfaSerial
+		sessionInput.MfaCode = mfaCode
+	}
+
+	ctx.Logger().Info().Msgf("starting session of instance [%s] with MFA=%t", instanceId, withMfa)
+
+	session, err := c.awsStsClient.GetSessionToken(ctx, awsnet.AwsRegion(user.region), awssts.Credentials{
+		AccessKeyId:     accessKeyId,
+		SecretAccessKey: secretAccessKey,
+	}, sessionInput)
+
+	if err != nil {
+		return nil, mapStsError(ctx, err, withMfa)
+	}
+
+	sessionAccessKeyIdEnc, sessionKeyId, err := c.encryptionService.Encrypt(session.AccessKeyId)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	sessionSecretAccessKeyEnc, _, err := c.encryptionService.Encrypt(session.SecretAccessKey)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	sessionTokenEnc, _, err := c.encryptionService.Encrypt(session.SessionToken)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	newVersion := user.version + 1
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE aws_iam_user SET version = ?, session_access_key_id_enc = ?, session_secret_access_key_enc = ?, session_token_enc = ?,
+	session_expires_at = ?, session_enc_key_id = ? WHERE instance_id = ? AND version = ?`,
+		newVersion, sessionAccessKeyIdEnc, sessionSecretAccessKeyEnc, sessionTokenEnc, session.Expiration, sessionKeyId, instanceId, user.version)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	if rowsAffected != 1 {
+		ctx.Logger().Warn().Msgf("instance [%s] was removed or modified while its session was being started", instanceId)
+		return nil, ErrInstanceWasNotFound
+	}
+
+	publish, err := c.bus.PublishTx(ctx, AwsIamUserSessionStartedEvent{
+		InstanceId: instanceId,
+		WithMfa:    withMfa,
+		ExpiresAt:  session.Expiration,
+	}, eventing.EventMeta{
+		SourceType:   AwsIamUserEventSource,
+		SourceId:     instanceId,
+		EventVersion: uint(newVersion),
+	}, tx)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	publish()
+
+	return session, nil
+}
+
+func (c *AwsIamUserController) MarkAsFavorite(ctx app.Context, instanceId string) error {
+	return c.favoritesRepo.Add(ctx, &favorites.Favorite{
+		ProviderCode: ProviderCode,
+		InstanceId:   instanceId,
+	})
+}
+
+func (c *AwsIamUserController) UnmarkAsFavorite(ctx app.Context, instanceId string) error {
+	return c.favoritesRepo.Remove(ctx, &favorites.Favorite{
+		ProviderCode: ProviderCode,
+		InstanceId:   instanceId,
+	})
+}
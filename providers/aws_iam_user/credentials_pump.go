@@ -0,0 +1,141 @@
+package awsiamuser
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/plumbing"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+)
+
+var pumpReconcileInterval = 1 * time.Minute
+
+// minValiditySeconds is how long session credentials must stay valid to not be replaced before the next refresh of a pump,
+// a new session is started right away where MFA allows it.
+func minValiditySeconds(options plumbing.PumpOptions) int64 {
+	return int64((options.RefreshBefore + options.MaxJitter) / time.Second)
+}
+
+func newCredentialsPump(c *AwsIamUserController) *plumbing.Pump[awsidc.AwsCredentials] {
+	options := plumbing.DefaultPumpOptions
+
+	options.IsRetryable = func(err error) bool {
+		return app.IsError(err, ErrTransientAwsClientError) || app.IsError(err, ErrMfaCodeUnavailable)
+	}
+
+	options.IsStopping = func(err error) bool {
+		for _, stopping := range []error{ErrMfaCodeRequired, ErrInstanceWasNotFound, ErrInvalidAccessKeys, ErrSessionTokenDenied, ErrStsRegionDisabled} {
+			if app.IsError(err, stopping) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	minValidity := minValiditySeconds(options)
+
+	return plumbing.NewPump(func(ctx app.Context, pipe plumbing.Pipe) (awsidc.AwsCredentials, int64, error) {
+		session, err := c.getSessionCredentials(ctx, pipe.ProviderId, minValidity)
+
+		if err != nil {
+			return awsidc.AwsCredentials{}, 0, err
+		}
+
+		return awsidc.AwsCredentials{
+			AccessKeyID:     session.AccessKeyId,
+			SecretAccessKey: session.SecretAccessKey,
+			SessionToken:    session.SessionToken,
+		}, session.Expiration, nil
+	}, c.clock, options)
+}
+
+// desiredPipes lists the connected sinks of every instance that either holds a valid session
+// or can start one on its own because it does not use MFA or codes of its MFA device are generated.
+func (c *AwsIamUserController) desiredPipes(ctx app.Context) (map[plumbing.Pipe]plumbing.Plumber[awsidc.AwsCredentials], error) {
+	mfaSerials := []string{}
+
+	if c.mfaCodeSource != nil {
+		var err error
+
+		if mfaSerials, err = c.mfaCodeSource.ListMfaSerials(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	query := "SELECT instance_id FROM aws_iam_user WHERE mfa_serial = '' OR session_expires_at > ?"
+	args := []any{c.clock.NowUnix()}
+
+	if len(mfaSerials) > 0 {
+		query += " OR mfa_serial IN (?" + strings.Repeat(", ?", len(mfaSerials)-1) + ")"
+
+		for _, mfaSerial := range mfaSerials {
+			args = append(args, mfaSerial)
+		}
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+	defer rows.Close()
+
+	instanceIds := make([]string, 0)
+
+	for rows.Next() {
+		var instanceId string
+
+		if err := rows.Scan(&instanceId); err != nil {
+			return nil, errors.Join(err, app.ErrFatal)
+		}
+
+		instanceIds = append(instanceIds, instanceId)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	rows.Close()
+
+	desired := make(map[plumbing.Pipe]plumbing.Plumber[awsidc.AwsCredentials])
+
+	for _, instanceId := range instanceIds {
+		for _, plumber := range c.plumbers {
+			sinks, err := plumber.ListConnectedSinks(ctx, ProviderCode, instanceId)
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, sink := range sinks {
+				desired[plumbing.Pipe{
+					ProviderCode:   ProviderCode,
+					ProviderId:     instanceId,
+					SinkCode:       sink.SinkCode,
+					SinkId:         sink.SinkId,
+					SourceSelector: sink.SourceSelector,
+				}] = plumber
+			}
+		}
+	}
+
+	return desired, nil
+}
+
+// StartCredentialsPump keeps the sinks connected to every instance supplied with session credentials
+// until [AwsIamUserController.StopCredentialsPump] is called.
+// It requires an unsealed vault to decrypt access keys and sessions.
+func (c *AwsIamUserController) StartCredentialsPump(ctx app.Context) {
+	if c.pump.Start(ctx, pumpReconcileInterval, c.desiredPipes) {
+		ctx.Logger().Info().Msg("started credentials pump")
+	}
+}
+
+// StopCredentialsPump stops refreshing sinks and waits for in-flight refreshes to finish.
+func (c *AwsIamUserController) StopCredentialsPump() {
+	c.pump.Stop()
+}
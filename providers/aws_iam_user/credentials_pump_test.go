@@ -0,0 +1,163 @@
+package awsiamuser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abjrcode/swervo/clients/awssts"
+	"github.com/abjrcode/swervo/internal/plumbing"
+	"github.com/abjrcode/swervo/internal/providertest"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialsPump_FlowsSessionCredentialsIntoSinks(t *testing.T) {
+	controller, stsClient, _, _, mockClock := initController(t)
+
+	instanceId := simulateSetup(t, controller, mockClock, "")
+
+	mockClock.On("NowUnix").Return(2)
+	stsClient.On("GetSessionToken", mock.Anything, testUserKeys, mock.Anything).Return(testSession, nil)
+
+	plumber := providertest.NewFakePlumber[awsidc.AwsCredentials]()
+	controller.AddPlumbers(plumber)
+
+	controller.StartCredentialsPump(testhelpers.NewMockAppContext())
+	t.Cleanup(controller.StopCredentialsPump)
+
+	select {
+	case creds := <-plumber.Flows:
+		require.Equal(t, awsidc.AwsCredentials{
+			AccessKeyID:     "ASIATESTSESSIONKEY",
+			SecretAccessKey: "test-session-secret",
+			SessionToken:    "test-session-token",
+		}, creds)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for credentials to flow")
+	}
+
+	require.Equal(t, []plumbing.Pipe{{
+		ProviderCode: ProviderCode,
+		ProviderId:   instanceId,
+		SinkCode:     "fake-sink",
+		SinkId:       "test-sink",
+	}}, controller.pump.Pipes())
+}
+
+func TestCredentialsPump_WaitsForMfaCode(t *testing.T) {
+	controller, stsClient, _, _, mockClock := initController(t)
+
+	instanceId := simulateSetup(t, controller, mockClock, "arn:aws:iam::123456789012:mfa/legacy")
+
+	mockClock.On("NowUnix").Return(2)
+
+	plumber := providertest.NewFakePlumber[awsidc.AwsCredentials]()
+	controller.AddPlumbers(plumber)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	desired, err := controller.desiredPipes(ctx)
+	require.NoError(t, err)
+	require.Empty(t, desired, "sinks of instances with MFA are not fed before a session was started")
+
+	stsClient.On("GetSessionToken", mock.Anything, mock.Anything, mock.Anything).Return(testSession, nil).Once()
+
+	err = controller.StartSession(ctx, AwsIamUser_StartSessionCommandInput{InstanceId: instanceId, MfaCode: "123456"})
+	require.NoError(t, err)
+
+	desired, err = controller.desiredPipes(ctx)
+	require.NoError(t, err)
+
+	controller.pump.Reconcile(ctx, desired)
+
+	select {
+	case creds := <-plumber.Flows:
+		require.Equal(t, "test-session-token", creds.SessionToken)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for credentials to flow")
+	}
+
+	controller.pump.Stop()
+
+	mockClock.ExpectedCalls = nil
+	mockClock.On("NowUnix").Return(3600)
+
+	desired, err = controller.desiredPipes(ctx)
+	require.NoError(t, err)
+	require.Empty(t, desired, "sinks of instances with MFA are no longer fed once their session expired")
+
+	stsClient.AssertExpectations(t)
+}
+
+func TestCredentialsPump_FeedsInstancesWithGeneratedMfaCodes(t *testing.T) {
+	controller, _, _, _, mockClock := initController(t)
+
+	instanceId := simulateSetup(t, controller, mockClock, "arn:aws:iam::123456789012:mfa/legacy")
+	simulateSetup(t, controller, mockClock, "arn:aws:iam::123456789012:mfa/other")
+
+	mockClock.On("NowUnix").Return(2)
+
+	controller.AddPlumbers(providertest.NewFakePlumber[awsidc.AwsCredentials]())
+	controller.SetMfaCodeSource(&fakeMfaCodeSource{mfaSerial: "arn:aws:iam::123456789012:mfa/legacy"})
+
+	desired, err := controller.desiredPipes(testhelpers.NewMockAppContext())
+	require.NoError(t, err)
+	require.Len(t, desired, 1)
+
+	for pipe := range desired {
+		require.Equal(t, instanceId, pipe.ProviderId, "only sinks of instances whose MFA codes are generated are fed without a session")
+	}
+}
+
+func TestCredentialsPump_StaysStoppedUntilSessionIsStarted(t *testing.T) {
+	previousInterval := pumpReconcileInterval
+	pumpReconcileInterval = 5 * time.Millisecond
+	t.Cleanup(func() { pumpReconcileInterval = previousInterval })
+
+	controller, stsClient, _, _, mockClock := initController(t)
+
+	instanceId := simulateSetup(t, controller, mockClock, "")
+
+	mockClock.On("NowUnix").Return(2)
+	denied := make(chan struct{})
+	stsClient.On("GetSessionToken", mock.Anything, testUserKeys, mock.Anything).Return(nil, awssts.ErrInvalidClientToken).Once().
+		Run(func(args mock.Arguments) { close(denied) })
+
+	plumber := providertest.NewFakePlumber[awsidc.AwsCredentials]()
+	controller.AddPlumbers(plumber)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	controller.StartCredentialsPump(ctx)
+	t.Cleanup(controller.StopCredentialsPump)
+
+	select {
+	case <-denied:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a session to be started")
+	}
+
+	require.Eventually(t, func() bool {
+		return len(controller.pump.Pipes()) == 0
+	}, 2*time.Second, 5*time.Millisecond)
+
+	// several reconcile ticks go by
+	time.Sleep(50 * time.Millisecond)
+
+	require.Empty(t, controller.pump.Pipes(), "pipes of an instance with invalid access keys are not connected again")
+	stsClient.AssertNumberOfCalls(t, "GetSessionToken", 1)
+
+	stsClient.On("GetSessionToken", mock.Anything, testUserKeys, mock.Anything).Return(testSession, nil)
+
+	err := controller.StartSession(ctx, AwsIamUser_StartSessionCommandInput{InstanceId: instanceId})
+	require.NoError(t, err)
+
+	select {
+	case creds := <-plumber.Flows:
+		require.Equal(t, "test-session-token", creds.SessionToken)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for credentials to flow")
+	}
+}
@@ -0,0 +1,472 @@
+package awsidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/utils"
+	"github.com/segmentio/ksuid"
+)
+
+var (
+	// ErrAuthCodeFlowUnavailable means that the authorization code flow cannot be used, the device flow still can
+	ErrAuthCodeFlowUnavailable = app.NewValidationError("AUTH_CODE_FLOW_UNAVAILABLE")
+	ErrAuthCodeFlowNotFound    = app.NewValidationError("AUTH_CODE_FLOW_NOT_FOUND")
+	ErrAuthCodeFlowDenied      = app.NewValidationError("AUTH_CODE_FLOW_DENIED")
+	ErrAuthCodeFlowTimedOut    = app.NewValidationError("AUTH_CODE_FLOW_TIMED_OUT")
+	ErrAuthCodeFlowCancelled   = app.NewValidationError("AUTH_CODE_FLOW_CANCELLED")
+)
+
+const (
+	// authCodeRedirectPath is where the loopback listener receives the authorization code
+	authCodeRedirectPath = "/oauth/callback"
+	// authCodeRegisteredRedirectUri is registered for every client, the port of loopback redirect URIs is chosen when a flow starts
+	authCodeRegisteredRedirectUri = "http://127.0.0.1" + authCodeRedirectPath
+)
+
+// authCodeFlowTimeout is how long the user has to sign in before the loopback listener is closed
+var authCodeFlowTimeout = 10 * time.Minute
+
+const authCodeCallbackPage = `<!DOCTYPE html>
+<html>
+<head><title>Swervo</title></head>
+<body><p>%s You can close this window and return to Swervo.</p></body>
+</html>`
+
+type authCodeCallback struct {
+	code string
+	err  error
+}
+
+// authCodeFlow is an authorization code flow waiting for the browser to be redirected to its loopback listener
+type authCodeFlow struct {
+	flowId       string
+	clientId     string
+	region       string
+	state        string
+	codeVerifier string
+	redirectUri  string
+
+	server   *http.Server
+	callback chan authCodeCallback
+	done     chan struct{}
+	stop     sync.Once
+}
+
+func (flow *authCodeFlow) close() {
+	flow.stop.Do(func() {
+		close(flow.done)
+		flow.server.Close()
+	})
+}
+
+func (flow *authCodeFlow) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != authCodeRedirectPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+
+	// a request without the state of the flow did not come from the authorization page we opened
+	if query.Get("state") != flow.state {
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+
+	var callback authCodeCallback
+	var message string
+
+	switch {
+	case query.Get("error") != "":
+		callback.err = ErrAuthCodeFlowDenied
+		message = "Access was not granted."
+	case query.Get("code") == "":
+		http.Error(w, "missing authorization code", http.StatusBadRequest)
+		return
+	default:
+		callback.code = query.Get("code")
+		message = "You are signed in."
+	}
+
+	select {
+	case flow.callback <- callback:
+	default:
+		// only the first redirect of a flow counts
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, authCodeCallbackPage, message)
+}
+
+// newPkceVerifier returns a code verifier and its S256 code challenge, see RFC 7636
+func newPkceVerifier() (string, string, error) {
+	verifier, err := randomUrlSafeString(32)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	return verifier, base64.RawURLEncoding.EncodeToString(challenge[:]), nil
+}
+
+func randomUrlSafeString(byteCount int) (string, error) {
+	buffer := make([]byte, byteCount)
+
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// getOrRegisterAuthCodeClient returns the client of the authorization code flow for a start URL.
+// Unlike device flow clients, these are bound to the IAM Identity Center instance they were registered for.
+func (c *AwsIdentityCenterController) getOrRegisterAuthCodeClient(ctx app.Context, startUrl, awsRegion string) (*awssso.RegistrationResponse, error) {
+	row := c.db.QueryRowContext(ctx, "SELECT region, client_id, client_secret_enc, created_at, expires_at, enc_key_id FROM aws_sso_authorization_code_clients WHERE start_url = ?", startUrl)
+
+	var region string
+	var encKeyId string
+	var result awssso.RegistrationResponse
+
+	err := row.Scan(&region, &result.ClientId, &result.ClientSecret, &result.CreatedAt, &result.ExpiresAt, &encKeyId)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	if err == nil && region == awsRegion && c.clock.NowUnix() <= result.ExpiresAt {
+		result.ClientSecret, err = c.encryptionService.Decrypt(result.ClientSecret, encKeyId)
+
+		if err != nil {
+			return nil, errors.Join(err, app.ErrFatal)
+		}
+
+		return &result, nil
+	}
+
+	friendlyClientName := fmt.Sprintf("swervo_%s", utils.RandomString(6))
+	ctx.Logger().Info().Msgf("registering new authorization code client [%s] for [%s]", friendlyClientName, startUrl)
+
+	output, err := c.awsSsoClient.RegisterAuthorizationCodeClient(ctx, awsnet.AwsRegion(awsRegion), friendlyClientName, startUrl, authCodeRegisteredRedirectUri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	clientSecretEnc, encKeyId, err := c.encryptionService.Encrypt(output.ClientSecret)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	_, err = c.db.ExecContext(ctx, `INSERT OR REPLACE INTO aws_sso_authorization_code_clients
+		(start_url, region, client_id, client_secret_enc, created_at, expires_at, enc_key_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		startUrl, awsRegion, output.ClientId, clientSecretEnc, output.CreatedAt, output.ExpiresAt, encKeyId)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	ctx.Logger().Info().Msgf("client [%s] registered successfully", friendlyClientName)
+
+	return output, nil
+}
+
+type AuthorizeCodeFlowResult struct {
+	FlowId           string `json:"flowId"`
+	InstanceId       string `json:"instanceId"`
+	StartUrl         string `json:"startUrl"`
+	Region           string `json:"region"`
+	Label            string `json:"label"`
+	AuthorizationUrl string `json:"authorizationUrl"`
+	ExpiresIn        int32  `json:"expiresIn"`
+}
+
+// startAuthCodeFlow starts a loopback listener and returns the page the user has to open to sign in.
+// The listener is closed once the flow is awaited, cancelled, or has timed out.
+func (c *AwsIdentityCenterController) startAuthCodeFlow(ctx app.Context, startUrl, awsRegion string) (*authCodeFlow, string, error) {
+	regRes, err := c.getOrRegisterAuthCodeClient(ctx, startUrl, awsRegion)
+
+	if err != nil {
+		if errors.Is(err, app.ErrFatal) {
+			return nil, "", err
+		}
+
+		ctx.Logger().Error().Err(err).Msg("failed to register authorization code client")
+		return nil, "", ErrAuthCodeFlowUnavailable
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		ctx.Logger().Error().Err(err).Msg("failed to start loopback listener")
+		return nil, "", ErrAuthCodeFlowUnavailable
+	}
+
+	codeVerifier, codeChallenge, err := newPkceVerifier()
+
+	if err != nil {
+		listener.Close()
+		return nil, "", errors.Join(err, app.ErrFatal)
+	}
+
+	state, err := randomUrlSafeString(16)
+
+	if err != nil {
+		listener.Close()
+		return nil, "", errors.Join(err, app.ErrFatal)
+	}
+
+	flow := &authCodeFlow{
+		flowId:       ksuid.New().String(),
+		clientId:     regRes.ClientId,
+		region:       awsRegion,
+		state:        state,
+		codeVerifier: codeVerifier,
+		redirectUri:  fmt.Sprintf("http://%s%s", listener.Addr().String(), authCodeRedirectPath),
+		callback:     make(chan authCodeCallback, 1),
+		done:         make(chan struct{}),
+	}
+
+	flow.server = &http.Server{
+		Handler:           flow,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go flow.server.Serve(listener)
+
+	c.authCodeFlowsMu.Lock()
+	c.authCodeFlows[flow.flowId] = flow
+	c.authCodeFlowsMu.Unlock()
+
+	// a flow that is never awaited must not keep its listener open
+	time.AfterFunc(authCodeFlowTimeout, func() {
+		c.removeAuthCodeFlow(flow.flowId)
+	})
+
+	ctx.Logger().Info().Msgf("authorization code flow [%s] is listening on [%s]", flow.flowId, flow.redirectUri)
+
+	return flow, c.awsSsoClient.AuthorizationUrl(awsnet.AwsRegion(awsRegion), regRes.ClientId, flow.redirectUri, state, codeChallenge), nil
+}
+
+func (c *AwsIdentityCenterController) removeAuthCodeFlow(flowId string) {
+	c.authCodeFlowsMu.Lock()
+	flow, ok := c.authCodeFlows[flowId]
+	delete(c.authCodeFlows, flowId)
+	c.authCodeFlowsMu.Unlock()
+
+	if ok {
+		flow.close()
+	}
+}
+
+func (c *AwsIdentityCenterController) hasAuthCodeFlow(flowId string) bool {
+	c.authCodeFlowsMu.Lock()
+	defer c.authCodeFlowsMu.Unlock()
+
+	_, ok := c.authCodeFlows[flowId]
+
+	return ok
+}
+
+// awaitAuthCodeFlow waits for the browser to be redirected with an authorization code and exchanges it for an access token.
+func (c *AwsIdentityCenterController) awaitAuthCodeFlow(ctx app.Context, flowId, clientId, clientSecret string) (*awssso.GetTokenResponse, error) {
+	c.authCodeFlowsMu.Lock()
+	flow, ok := c.authCodeFlows[flowId]
+	c.authCodeFlowsMu.Unlock()
+
+	// the code can only be exchanged by the client it was issued to
+	if !ok || flow.clientId != clientId {
+		return nil, ErrAuthCodeFlowNotFound
+	}
+
+	defer c.removeAuthCodeFlow(flowId)
+
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	timedOut := make(chan error, 1)
+
+	go func() {
+		timedOut <- c.wait(waitCtx, authCodeFlowTimeout)
+	}()
+
+	var callback authCodeCallback
+
+	select {
+	case callback = <-flow.callback:
+	case <-flow.done:
+		ctx.Logger().Info().Msgf("authorization code flow [%s] was cancelled", flowId)
+		return nil, ErrAuthCodeFlowCancelled
+	case <-ctx.Done():
+		ctx.Logger().Info().Msgf("authorization code flow [%s] was cancelled", flowId)
+		return nil, ErrAuthCodeFlowCancelled
+	case err := <-timedOut:
+		if err != nil {
+			return nil, ErrAuthCodeFlowCancelled
+		}
+
+		ctx.Logger().Info().Msgf("authorization code flow [%s] timed out", flowId)
+		return nil, ErrAuthCodeFlowTimedOut
+	}
+
+	if callback.err != nil {
+		return nil, callback.err
+	}
+
+	tokenRes, err := c.awsSsoClient.CreateTokenWithAuthorizationCode(ctx, awsnet.AwsRegion(flow.region), clientId, clientSecret, callback.code, flow.codeVerifier, flow.redirectUri)
+
+	if err != nil {
+		if errors.Is(err, awssso.ErrInvalidAuthorizationCode) {
+			ctx.Logger().Debug().Err(err).Msg("authorization code was rejected")
+			return nil, ErrAuthCodeFlowDenied
+		}
+
+		ctx.Logger().Error().Err(err).Msg("failed to exchange authorization code")
+		return nil, ErrTransientAwsClientError
+	}
+
+	return tokenRes, nil
+}
+
+// CancelAuthCodeFlow closes the loopback listener of a flow. It does nothing if the flow is not in progress.
+func (c *AwsIdentityCenterController) CancelAuthCodeFlow(ctx app.Context, flowId string) {
+	ctx.Logger().Info().Msgf("cancelling authorization code flow [%s]", flowId)
+
+	c.removeAuthCodeFlow(flowId)
+}
+
+// SetupWithAuthCode is the browser based alternative to [AwsIdentityCenterController.Setup].
+// The user signs in at the returned authorization URL without having to confirm a user code,
+// then [AwsIdentityCenterController.AwaitSetupWithAuthCode] creates the instance.
+// [ErrAuthCodeFlowUnavailable] means that the device flow has to be used instead.
+func (c *AwsIdentityCenterController) SetupWithAuthCode(ctx app.Context, input AwsIdc_SetupCommandInput) (*AuthorizeCodeFlowResult, error) {
+	if err := c.validateStartUrl(input.StartUrl); err != nil {
+		return nil, err
+	}
+
+	if err := c.validateAwsRegion(input.AwsRegion); err != nil {
+		return nil, err
+	}
+
+	if err := c.validateLabel(input.Label); err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	err := c.db.QueryRowContext(ctx, "SELECT 1 FROM aws_idc WHERE start_url = ?", input.StartUrl).Scan(&exists)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	if exists {
+		ctx.Logger().Warn().Msgf("instance [%s] already exists", input.StartUrl)
+		return nil, ErrInstanceAlreadyRegistered
+	}
+
+	flow, authorizationUrl, err := c.startAuthCodeFlow(ctx, input.StartUrl, input.AwsRegion)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthorizeCodeFlowResult{
+		FlowId:           flow.flowId,
+		StartUrl:         input.StartUrl,
+		Region:           input.AwsRegion,
+		Label:            input.Label,
+		AuthorizationUrl: authorizationUrl,
+		ExpiresIn:        int32(authCodeFlowTimeout / time.Second),
+	}, nil
+}
+
+type AwsIdc_AwaitSetupWithAuthCodeCommandInput struct {
+	FlowId    string `json:"flowId"`
+	StartUrl  string `json:"startUrl"`
+	AwsRegion string `json:"awsRegion"`
+	Label     string `json:"label"`
+}
+
+func (c *AwsIdentityCenterController) AwaitSetupWithAuthCode(ctx app.Context, input AwsIdc_AwaitSetupWithAuthCodeCommandInput) (string, error) {
+	if !c.hasAuthCodeFlow(input.FlowId) {
+		return "", ErrAuthCodeFlowNotFound
+	}
+
+	return c.finalizeSetup(ctx, AwsIdc_FinalizeSetupCommandInput{
+		StartUrl:  input.StartUrl,
+		AwsRegion: input.AwsRegion,
+		Label:     input.Label,
+	}, LoginFlowAuthorizationCode, func(clientId, clientSecret string) (*awssso.GetTokenResponse, error) {
+		return c.awaitAuthCodeFlow(ctx, input.FlowId, clientId, clientSecret)
+	})
+}
+
+// RefreshAccessTokenWithAuthCode is the browser based alternative to [AwsIdentityCenterController.RefreshAccessToken].
+func (c *AwsIdentityCenterController) RefreshAccessTokenWithAuthCode(ctx app.Context, instanceId string) (*AuthorizeCodeFlowResult, error) {
+	var startUrl string
+	var awsRegion string
+	var label string
+
+	row := c.db.QueryRowContext(ctx, "SELECT start_url, region, label FROM aws_idc WHERE instance_id = ?", instanceId)
+
+	if err := row.Scan(&startUrl, &awsRegion, &label); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.Logger().Debug().Msgf("instance [%s] was not found", instanceId)
+			return nil, ErrInstanceWasNotFound
+		}
+
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	flow, authorizationUrl, err := c.startAuthCodeFlow(ctx, startUrl, awsRegion)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthorizeCodeFlowResult{
+		FlowId:           flow.flowId,
+		InstanceId:       instanceId,
+		StartUrl:         startUrl,
+		Region:           awsRegion,
+		Label:            label,
+		AuthorizationUrl: authorizationUrl,
+		ExpiresIn:        int32(authCodeFlowTimeout / time.Second),
+	}, nil
+}
+
+type AwsIdc_AwaitRefreshAccessTokenWithAuthCodeCommandInput struct {
+	FlowId     string `json:"flowId"`
+	InstanceId string `json:"instanceId"`
+	Region     string `json:"region"`
+}
+
+func (c *AwsIdentityCenterController) AwaitRefreshAccessTokenWithAuthCode(ctx app.Context, input AwsIdc_AwaitRefreshAccessTokenWithAuthCodeCommandInput) error {
+	if !c.hasAuthCodeFlow(input.FlowId) {
+		return ErrAuthCodeFlowNotFound
+	}
+
+	return c.finalizeRefreshAccessToken(ctx, AwsIdc_FinalizeRefreshAccessTokenCommandInput{
+		InstanceId: input.InstanceId,
+		Region:     input.Region,
+	}, LoginFlowAuthorizationCode, func(clientId, clientSecret string) (*awssso.GetTokenResponse, error) {
+		return c.awaitAuthCodeFlow(ctx, input.FlowId, clientId, clientSecret)
+	})
+}
@@ -0,0 +1,275 @@
+package awsidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/stretchr/testify/require"
+)
+
+func startAuthCodeSetup(t *testing.T, controller *AwsIdentityCenterController, mockAws *mockAwsSsoOidcClient) *AuthorizeCodeFlowResult {
+	mockAws.On("RegisterAuthorizationCodeClient").Return(&awssso.RegistrationResponse{
+		ClientId:     "test-client-id",
+		ClientSecret: "test-client-secret",
+		CreatedAt:    1,
+		ExpiresAt:    20,
+	}, nil)
+
+	result, err := controller.SetupWithAuthCode(testhelpers.NewMockAppContext(), AwsIdc_SetupCommandInput{
+		StartUrl:  "https://test-start-url.aws-apps.com/start",
+		AwsRegion: "eu-west-1",
+		Label:     "test_label",
+	})
+	require.NoError(t, err)
+
+	return result
+}
+
+// redirectBrowser simulates the authorization page redirecting the browser to the loopback listener
+func redirectBrowser(t *testing.T, authorizationUrl string, query url.Values) int {
+	parsed, err := url.Parse(authorizationUrl)
+	require.NoError(t, err)
+
+	redirectUri := parsed.Query().Get("redirect_uri")
+	require.Contains(t, redirectUri, "http://127.0.0.1:")
+
+	if !query.Has("state") {
+		query.Set("state", parsed.Query().Get("state"))
+	}
+
+	res, err := http.Get(redirectUri + "?" + query.Encode())
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	return res.StatusCode
+}
+
+func TestSetupWithAuthCode(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	mockTimeProvider.On("NowUnix").Return(1)
+
+	setupResult := startAuthCodeSetup(t, controller, mockAws)
+
+	status := redirectBrowser(t, setupResult.AuthorizationUrl, url.Values{"code": {"test-code"}})
+	require.Equal(t, http.StatusOK, status)
+
+	mockAws.On("CreateTokenWithAuthorizationCode", "test-code").Return(&awssso.GetTokenResponse{
+		AccessToken:  "test-access-token",
+		RefreshToken: "test-refresh-token",
+		TokenType:    "Bearer",
+		ExpiresIn:    100,
+	}, nil)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	instanceId, err := controller.AwaitSetupWithAuthCode(ctx, AwsIdc_AwaitSetupWithAuthCodeCommandInput{
+		FlowId:    setupResult.FlowId,
+		StartUrl:  setupResult.StartUrl,
+		AwsRegion: setupResult.Region,
+		Label:     setupResult.Label,
+	})
+	require.NoError(t, err)
+
+	var loginFlow LoginFlow
+	err = controller.db.QueryRowContext(ctx, "SELECT login_flow FROM aws_idc WHERE instance_id = ?", instanceId).Scan(&loginFlow)
+	require.NoError(t, err)
+	require.Equal(t, LoginFlowAuthorizationCode, loginFlow)
+
+	require.False(t, controller.hasAuthCodeFlow(setupResult.FlowId), "listener must be closed once the flow is done")
+}
+
+func TestSetupWithAuthCode_IgnoresRedirectWithWrongState(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	mockTimeProvider.On("NowUnix").Return(1)
+
+	setupResult := startAuthCodeSetup(t, controller, mockAws)
+
+	status := redirectBrowser(t, setupResult.AuthorizationUrl, url.Values{"code": {"forged-code"}, "state": {"forged-state"}})
+	require.Equal(t, http.StatusBadRequest, status)
+
+	status = redirectBrowser(t, setupResult.AuthorizationUrl, url.Values{"code": {"test-code"}})
+	require.Equal(t, http.StatusOK, status)
+
+	mockAws.On("CreateTokenWithAuthorizationCode", "test-code").Return(&awssso.GetTokenResponse{
+		AccessToken: "test-access-token",
+		TokenType:   "Bearer",
+		ExpiresIn:   100,
+	}, nil)
+
+	_, err := controller.AwaitSetupWithAuthCode(testhelpers.NewMockAppContext(), AwsIdc_AwaitSetupWithAuthCodeCommandInput{
+		FlowId:    setupResult.FlowId,
+		StartUrl:  setupResult.StartUrl,
+		AwsRegion: setupResult.Region,
+		Label:     setupResult.Label,
+	})
+	require.NoError(t, err)
+
+	mockAws.AssertNotCalled(t, "CreateTokenWithAuthorizationCode", "forged-code")
+}
+
+func TestSetupWithAuthCode_Errors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		redirect url.Values
+		tokenErr error
+		expected error
+	}{
+		{name: "access denied", redirect: url.Values{"error": {"access_denied"}}, expected: ErrAuthCodeFlowDenied},
+		{name: "invalid code", redirect: url.Values{"code": {"test-code"}}, tokenErr: awssso.ErrInvalidAuthorizationCode, expected: ErrAuthCodeFlowDenied},
+		{name: "aws failure", redirect: url.Values{"code": {"test-code"}}, tokenErr: errors.New("boom"), expected: ErrTransientAwsClientError},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			controller, mockAws, mockTimeProvider := initController(t)
+
+			mockTimeProvider.On("NowUnix").Return(1)
+
+			setupResult := startAuthCodeSetup(t, controller, mockAws)
+
+			redirectBrowser(t, setupResult.AuthorizationUrl, testCase.redirect)
+
+			mockAws.On("CreateTokenWithAuthorizationCode", "test-code").Return(nil, testCase.tokenErr)
+
+			_, err := controller.AwaitSetupWithAuthCode(testhelpers.NewMockAppContext(), AwsIdc_AwaitSetupWithAuthCodeCommandInput{
+				FlowId:    setupResult.FlowId,
+				StartUrl:  setupResult.StartUrl,
+				AwsRegion: setupResult.Region,
+				Label:     setupResult.Label,
+			})
+			require.True(t, app.IsError(err, testCase.expected), "expected %v, got %v", testCase.expected, err)
+		})
+	}
+}
+
+func TestSetupWithAuthCode_TimedOut(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	mockTimeProvider.On("NowUnix").Return(1)
+
+	controller.wait = func(ctx context.Context, d time.Duration) error {
+		return nil
+	}
+
+	setupResult := startAuthCodeSetup(t, controller, mockAws)
+
+	_, err := controller.AwaitSetupWithAuthCode(testhelpers.NewMockAppContext(), AwsIdc_AwaitSetupWithAuthCodeCommandInput{
+		FlowId:    setupResult.FlowId,
+		StartUrl:  setupResult.StartUrl,
+		AwsRegion: setupResult.Region,
+		Label:     setupResult.Label,
+	})
+	require.True(t, app.IsError(err, ErrAuthCodeFlowTimedOut))
+}
+
+func TestSetupWithAuthCode_Cancelled(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	mockTimeProvider.On("NowUnix").Return(1)
+
+	setupResult := startAuthCodeSetup(t, controller, mockAws)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	controller.wait = func(waitCtx context.Context, d time.Duration) error {
+		controller.CancelAuthCodeFlow(ctx, setupResult.FlowId)
+
+		<-waitCtx.Done()
+		return waitCtx.Err()
+	}
+
+	_, err := controller.AwaitSetupWithAuthCode(ctx, AwsIdc_AwaitSetupWithAuthCodeCommandInput{
+		FlowId:    setupResult.FlowId,
+		StartUrl:  setupResult.StartUrl,
+		AwsRegion: setupResult.Region,
+		Label:     setupResult.Label,
+	})
+	require.True(t, app.IsError(err, ErrAuthCodeFlowCancelled))
+
+	parsed, err := url.Parse(setupResult.AuthorizationUrl)
+	require.NoError(t, err)
+
+	_, err = http.Get(parsed.Query().Get("redirect_uri"))
+	require.Error(t, err, "listener must be closed")
+}
+
+func TestSetupWithAuthCode_UnknownFlow(t *testing.T) {
+	controller, _, _ := initController(t)
+
+	_, err := controller.AwaitSetupWithAuthCode(testhelpers.NewMockAppContext(), AwsIdc_AwaitSetupWithAuthCodeCommandInput{
+		FlowId:    "unknown-flow",
+		StartUrl:  "https://test-start-url.aws-apps.com/start",
+		AwsRegion: "eu-west-1",
+		Label:     "test_label",
+	})
+	require.True(t, app.IsError(err, ErrAuthCodeFlowNotFound))
+}
+
+func TestSetupWithAuthCode_FallsBackToDeviceFlowWhenRegistrationFails(t *testing.T) {
+	controller, mockAws, _ := initController(t)
+
+	mockAws.On("RegisterAuthorizationCodeClient").Return(nil, awssso.ErrInvalidRequest)
+
+	_, err := controller.SetupWithAuthCode(testhelpers.NewMockAppContext(), AwsIdc_SetupCommandInput{
+		StartUrl:  "https://test-start-url.aws-apps.com/start",
+		AwsRegion: "eu-west-1",
+		Label:     "test_label",
+	})
+	require.True(t, app.IsError(err, ErrAuthCodeFlowUnavailable))
+}
+
+func TestRefreshAccessTokenWithAuthCode(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	mockTimeProvider.On("NowUnix").Return(10)
+
+	mockAws.On("RegisterAuthorizationCodeClient").Return(&awssso.RegistrationResponse{
+		ClientId:     "test-auth-code-client-id",
+		ClientSecret: "test-auth-code-client-secret",
+		CreatedAt:    10,
+		ExpiresAt:    200,
+	}, nil)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	refreshRes, err := controller.RefreshAccessTokenWithAuthCode(ctx, instanceId)
+	require.NoError(t, err)
+	require.Equal(t, instanceId, refreshRes.InstanceId)
+
+	redirectBrowser(t, refreshRes.AuthorizationUrl, url.Values{"code": {"test-code"}})
+
+	mockAws.On("CreateTokenWithAuthorizationCode", "test-code").Return(&awssso.GetTokenResponse{
+		AccessToken: "test-access-token-2",
+		TokenType:   "Bearer",
+		ExpiresIn:   100,
+	}, nil)
+
+	err = controller.AwaitRefreshAccessTokenWithAuthCode(ctx, AwsIdc_AwaitRefreshAccessTokenWithAuthCodeCommandInput{
+		FlowId:     refreshRes.FlowId,
+		InstanceId: instanceId,
+		Region:     refreshRes.Region,
+	})
+	require.NoError(t, err)
+
+	var loginFlow LoginFlow
+	var expiresIn int64
+	err = controller.db.QueryRowContext(ctx, "SELECT login_flow, access_token_expires_in FROM aws_idc WHERE instance_id = ?", instanceId).Scan(&loginFlow, &expiresIn)
+	require.NoError(t, err)
+	require.Equal(t, LoginFlowAuthorizationCode, loginFlow)
+	require.Equal(t, int64(100), expiresIn)
+}
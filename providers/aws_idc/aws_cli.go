@@ -0,0 +1,263 @@
+package awsidc
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/abjrcode/swervo/clients/awscredsfile"
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/internal/app"
+)
+
+var (
+	ErrAwsCliSessionNotFound = app.NewValidationError("AWS_CLI_SESSION_NOT_FOUND")
+	ErrAwsCliSessionExpired  = app.NewValidationError("AWS_CLI_SESSION_EXPIRED")
+)
+
+// AwsCliTokenCache shares access tokens with the AWS CLI and SDKs in both directions
+type AwsCliTokenCache interface {
+	WriteToken(token awscredsfile.SsoCachedToken) error
+	ListSessions() ([]awscredsfile.AwsCliSsoSession, error)
+}
+
+// SetAwsCliTokenCache allows importing the sessions the AWS CLI is logged into
+// and, once enabled with [AwsIdentityCenterController.SetAwsCliTokenSharing], makes access tokens available to the AWS CLI and SDKs.
+func (c *AwsIdentityCenterController) SetAwsCliTokenCache(cache AwsCliTokenCache) {
+	c.awsCliTokenCache = cache
+}
+
+// SetAwsCliTokenSharing controls whether access tokens obtained from now on are written to the AWS CLI cache.
+// Access tokens are not shared unless enabled.
+func (c *AwsIdentityCenterController) SetAwsCliTokenSharing(enabled bool) {
+	c.shareWithAwsCli.Store(enabled)
+}
+
+// shareAccessToken writes the token to the AWS CLI cache if one was set and sharing is enabled.
+// Failing to do so does not fail the login, it is only logged.
+func (c *AwsIdentityCenterController) shareAccessToken(ctx app.Context, token awscredsfile.SsoCachedToken) {
+	if c.awsCliTokenCache == nil || !c.shareWithAwsCli.Load() {
+		return
+	}
+
+	if err := c.awsCliTokenCache.WriteToken(token); err != nil {
+		ctx.Logger().Warn().Err(err).Msgf("failed to share access token of [%s] with the AWS CLI", token.StartUrl)
+	}
+}
+
+type AwsCliSession struct {
+	SessionName string `json:"sessionName"`
+	StartUrl    string `json:"startUrl"`
+	Region      string `json:"region"`
+	// ExpiresAt is zero when the AWS CLI is not logged into the session
+	ExpiresAt int64 `json:"expiresAt"`
+
+	IsExpired       bool `json:"isExpired"`
+	AlreadyImported bool `json:"alreadyImported"`
+}
+
+func (c *AwsIdentityCenterController) listAwsCliSessions(ctx app.Context) ([]awscredsfile.AwsCliSsoSession, error) {
+	if c.awsCliTokenCache == nil {
+		return []awscredsfile.AwsCliSsoSession{}, nil
+	}
+
+	sessions, err := c.awsCliTokenCache.ListSessions()
+
+	if err != nil {
+		ctx.Logger().Error().Err(err).Msg("failed to list AWS CLI sessions")
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// ListAwsCliSessions lists the [sso-session] blocks of the AWS CLI config along with the expiry of their cached tokens.
+// Tokens never leave the backend.
+func (c *AwsIdentityCenterController) ListAwsCliSessions(ctx app.Context) ([]AwsCliSession, error) {
+	sessions, err := c.listAwsCliSessions(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nowUnix := c.clock.NowUnix()
+
+	result := make([]AwsCliSession, 0, len(sessions))
+
+	for _, session := range sessions {
+		var exists bool
+
+		err := c.db.QueryRowContext(ctx, "SELECT 1 FROM aws_idc WHERE start_url = ?", session.StartUrl).Scan(&exists)
+
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.Join(err, app.ErrFatal)
+		}
+
+		cliSession := AwsCliSession{
+			SessionName:     session.Name,
+			StartUrl:        session.StartUrl,
+			Region:          session.Region,
+			IsExpired:       true,
+			AlreadyImported: exists,
+		}
+
+		if session.Token != nil {
+			cliSession.ExpiresAt = session.Token.ExpiresAt
+			cliSession.IsExpired = session.Token.ExpiresAt <= nowUnix
+		}
+
+		result = append(result, cliSession)
+	}
+
+	return result, nil
+}
+
+type AwsIdc_ImportFromAwsCliCommandInput struct {
+	SessionName string `json:"sessionName"`
+	Label       string `json:"label"`
+}
+
+// ImportFromAwsCli creates an instance out of an [sso-session] the AWS CLI is logged into
+// without going through the device authorization flow.
+// Only the access token is imported, renewing it requires [AwsIdentityCenterController.RefreshAccessToken].
+func (c *AwsIdentityCenterController) ImportFromAwsCli(ctx app.Context, input AwsIdc_ImportFromAwsCliCommandInput) (string, error) {
+	if err := c.validateLabel(input.Label); err != nil {
+		return "", err
+	}
+
+	sessions, err := c.listAwsCliSessions(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	var session *awscredsfile.AwsCliSsoSession
+
+	for i := range sessions {
+		if sessions[i].Name == input.SessionName {
+			session = &sessions[i]
+			break
+		}
+	}
+
+	if session == nil {
+		ctx.Logger().Debug().Msgf("AWS CLI session [%s] was not found", input.SessionName)
+		return "", ErrAwsCliSessionNotFound
+	}
+
+	if err := c.validateStartUrl(session.StartUrl); err != nil {
+		return "", err
+	}
+
+	if err := c.validateAwsRegion(session.Region); err != nil {
+		return "", err
+	}
+
+	nowUnix := c.clock.NowUnix()
+
+	if session.Token == nil || session.Token.ExpiresAt <= nowUnix {
+		ctx.Logger().Debug().Msgf("AWS CLI session [%s] has no valid access token", input.SessionName)
+		return "", ErrAwsCliSessionExpired
+	}
+
+	var exists bool
+	err = c.db.QueryRowContext(ctx, "SELECT 1 FROM aws_idc WHERE start_url = ?", session.StartUrl).Scan(&exists)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	if exists {
+		ctx.Logger().Warn().Msgf("instance [%s] already exists", session.StartUrl)
+		return "", ErrInstanceAlreadyRegistered
+	}
+
+	ctx.Logger().Info().Msgf("importing AWS CLI session [%s]", input.SessionName)
+
+	// the refresh token of the AWS CLI was issued to its own client, which cannot redeem it on our behalf,
+	// so the instance has to be reauthorized interactively once the imported access token expires
+	return c.createInstance(ctx, session.StartUrl, session.Region, input.Label, LoginFlowDeviceCode, &awssso.GetTokenResponse{
+		AccessToken: session.Token.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int32(session.Token.ExpiresAt - nowUnix),
+	}, nowUnix)
+}
+
+// AwsConfigFile generates named profiles in the AWS config file
+type AwsConfigFile interface {
+	WriteSsoSession(sessionName string, session awscredsfile.SsoSessionConfig) error
+	WriteProfile(profileName string, profile awscredsfile.ProfileConfig) error
+}
+
+type AwsIdc_WriteAwsCliProfileCommandInput struct {
+	InstanceId string `json:"instanceId"`
+
+	AccountId  string `json:"accountId"`
+	RoleName   string `json:"roleName"`
+	AwsProfile string `json:"awsProfile"`
+	// SsoSession defaults to the label of the instance
+	SsoSession string `json:"ssoSession"`
+	// Region is the default region of the profile, it is left untouched when empty
+	Region string `json:"region"`
+}
+
+// WriteAwsCliProfile generates a named profile of an account role along with the [sso-session] block of the instance,
+// so that the AWS CLI and SDKs can use the role without a hand-maintained config.
+// Combined with [AwsIdentityCenterController.SetAwsCliTokenCache] they also use the access token of the instance.
+func (c *AwsIdentityCenterController) WriteAwsCliProfile(ctx app.Context, input AwsIdc_WriteAwsCliProfileCommandInput) error {
+	if input.AccountId == "" || input.RoleName == "" {
+		return ErrInvalidAccountRole
+	}
+
+	if input.Region != "" {
+		if err := c.validateAwsRegion(input.Region); err != nil {
+			return err
+		}
+	}
+
+	var startUrl, region, label string
+
+	err := c.db.QueryRowContext(ctx, "SELECT start_url, region, label FROM aws_idc WHERE instance_id = ?", input.InstanceId).Scan(&startUrl, &region, &label)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInstanceWasNotFound
+		}
+
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	sessionName := input.SsoSession
+
+	if sessionName == "" {
+		// session names cannot contain whitespace as they are part of the section header
+		sessionName = strings.Join(strings.Fields(label), "-")
+	}
+
+	err = c.awsConfigFile.WriteSsoSession(sessionName, awscredsfile.SsoSessionConfig{
+		SsoStartUrl:           startUrl,
+		SsoRegion:             region,
+		SsoRegistrationScopes: strings.Join(awssso.ClientScopes, ","),
+	})
+
+	if err == nil {
+		err = c.awsConfigFile.WriteProfile(input.AwsProfile, awscredsfile.ProfileConfig{
+			Region:       input.Region,
+			SsoSession:   sessionName,
+			SsoAccountId: input.AccountId,
+			SsoRoleName:  input.RoleName,
+		})
+	}
+
+	if err != nil {
+		if errors.Is(err, app.ErrValidation) {
+			return err
+		}
+
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	ctx.Logger().Info().Msgf("wrote AWS CLI profile [%s] of instance [%s]", input.AwsProfile, input.InstanceId)
+
+	return nil
+}
@@ -0,0 +1,342 @@
+package awsidc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/abjrcode/swervo/clients/awscredsfile"
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAwsCliTokenCache struct {
+	tokens   []awscredsfile.SsoCachedToken
+	sessions []awscredsfile.AwsCliSsoSession
+}
+
+func (c *fakeAwsCliTokenCache) WriteToken(token awscredsfile.SsoCachedToken) error {
+	c.tokens = append(c.tokens, token)
+	return nil
+}
+
+func (c *fakeAwsCliTokenCache) ListSessions() ([]awscredsfile.AwsCliSsoSession, error) {
+	return c.sessions, nil
+}
+
+func TestAccessTokensAreSharedWithAwsCli(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	tokenCache := &fakeAwsCliTokenCache{}
+	controller.SetAwsCliTokenCache(tokenCache)
+	controller.SetAwsCliTokenSharing(true)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	require.Equal(t, []awscredsfile.SsoCachedToken{{
+		StartUrl:              startUrl,
+		Region:                region,
+		AccessToken:           "test-access-token",
+		ExpiresAt:             6,
+		ClientId:              "test-client-id",
+		ClientSecret:          "test-client-secret",
+		RegistrationExpiresAt: 20,
+	}}, tokenCache.tokens)
+
+	mockAws.On("StartDeviceAuthorization").Return(&awssso.AuthorizationResponse{
+		DeviceCode: "test-device-code-2",
+		UserCode:   "test-user-code-2",
+		ExpiresIn:  20,
+	}, nil)
+
+	mockTimeProvider.On("NowUnix").Return(10)
+	refreshRes, err := controller.RefreshAccessToken(ctx, instanceId)
+	require.NoError(t, err)
+
+	mockAws.On("CreateToken").Return(&awssso.GetTokenResponse{
+		AccessToken: "test-access-token-2",
+		TokenType:   "test-token-type-2",
+		ExpiresIn:   15,
+	}, nil)
+
+	err = controller.FinalizeRefreshAccessToken(ctx, AwsIdc_FinalizeRefreshAccessTokenCommandInput{
+		InstanceId: instanceId,
+		Region:     region,
+		UserCode:   refreshRes.UserCode,
+		DeviceCode: refreshRes.DeviceCode,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, tokenCache.tokens, 2)
+	require.Equal(t, awscredsfile.SsoCachedToken{
+		StartUrl:              startUrl,
+		Region:                region,
+		AccessToken:           "test-access-token-2",
+		ExpiresAt:             25,
+		ClientId:              "test-client-id",
+		ClientSecret:          "test-client-secret",
+		RegistrationExpiresAt: 20,
+	}, tokenCache.tokens[1])
+}
+
+func TestAccessTokensAreNotSharedWithAwsCliByDefault(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	tokenCache := &fakeAwsCliTokenCache{}
+	controller.SetAwsCliTokenCache(tokenCache)
+
+	_ = simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, "https://test-start-url.aws-apps.com/start", "eu-west-1", "test_label")
+
+	require.Empty(t, tokenCache.tokens)
+}
+
+func newFakeAwsCliTokenCache() *fakeAwsCliTokenCache {
+	return &fakeAwsCliTokenCache{
+		sessions: []awscredsfile.AwsCliSsoSession{{
+			Name:     "expired",
+			StartUrl: "https://expired.awsapps.com/start",
+			Region:   "eu-west-1",
+			Token: &awscredsfile.SsoCachedToken{
+				StartUrl:    "https://expired.awsapps.com/start",
+				Region:      "eu-west-1",
+				AccessToken: "expired-access-token",
+				ExpiresAt:   50,
+			},
+		}, {
+			Name:     "logged-in",
+			StartUrl: "https://logged-in.awsapps.com/start",
+			Region:   "us-east-1",
+			Token: &awscredsfile.SsoCachedToken{
+				StartUrl:    "https://logged-in.awsapps.com/start",
+				Region:      "us-east-1",
+				AccessToken: "cli-access-token",
+				ExpiresAt:   3700,
+			},
+		}, {
+			Name:     "logged-out",
+			StartUrl: "https://logged-out.awsapps.com/start",
+			Region:   "us-east-1",
+		}},
+	}
+}
+
+func TestListAwsCliSessions(t *testing.T) {
+	controller, _, mockTimeProvider := initController(t)
+	controller.SetAwsCliTokenCache(newFakeAwsCliTokenCache())
+
+	ctx := testhelpers.NewMockAppContext()
+
+	mockTimeProvider.On("NowUnix").Return(100)
+
+	sessions, err := controller.ListAwsCliSessions(ctx)
+	require.NoError(t, err)
+
+	require.Equal(t, []AwsCliSession{{
+		SessionName: "expired",
+		StartUrl:    "https://expired.awsapps.com/start",
+		Region:      "eu-west-1",
+		ExpiresAt:   50,
+		IsExpired:   true,
+	}, {
+		SessionName: "logged-in",
+		StartUrl:    "https://logged-in.awsapps.com/start",
+		Region:      "us-east-1",
+		ExpiresAt:   3700,
+		IsExpired:   false,
+	}, {
+		SessionName: "logged-out",
+		StartUrl:    "https://logged-out.awsapps.com/start",
+		Region:      "us-east-1",
+		IsExpired:   true,
+	}}, sessions)
+
+	_, err = controller.ImportFromAwsCli(ctx, AwsIdc_ImportFromAwsCliCommandInput{
+		SessionName: "logged-in",
+		Label:       "imported",
+	})
+	require.NoError(t, err)
+
+	sessions, err = controller.ListAwsCliSessions(ctx)
+	require.NoError(t, err)
+
+	require.False(t, sessions[0].AlreadyImported)
+	require.True(t, sessions[1].AlreadyImported)
+}
+
+func TestListAwsCliSessions_WithoutTokenCache(t *testing.T) {
+	controller, _, mockTimeProvider := initController(t)
+
+	mockTimeProvider.On("NowUnix").Return(100)
+
+	sessions, err := controller.ListAwsCliSessions(testhelpers.NewMockAppContext())
+	require.NoError(t, err)
+
+	require.Empty(t, sessions)
+}
+
+func TestImportFromAwsCli(t *testing.T) {
+	controller, _, mockTimeProvider := initController(t)
+	controller.SetAwsCliTokenCache(newFakeAwsCliTokenCache())
+
+	ctx := testhelpers.NewMockAppContext()
+
+	mockTimeProvider.On("NowUnix").Return(100)
+
+	instanceId, err := controller.ImportFromAwsCli(ctx, AwsIdc_ImportFromAwsCliCommandInput{
+		SessionName: "logged-in",
+		Label:       "imported",
+	})
+	require.NoError(t, err)
+
+	instances, err := controller.ListInstances(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{instanceId}, instances)
+
+	var startUrl, region, label, accessTokenEnc, refreshTokenEnc, encKeyId string
+	var createdAt, expiresIn int64
+
+	err = controller.db.QueryRowContext(ctx, `SELECT start_url, region, label, access_token_enc, refresh_token_enc, enc_key_id,
+		access_token_created_at, access_token_expires_in FROM aws_idc WHERE instance_id = ?`, instanceId).
+		Scan(&startUrl, &region, &label, &accessTokenEnc, &refreshTokenEnc, &encKeyId, &createdAt, &expiresIn)
+	require.NoError(t, err)
+
+	require.Equal(t, "https://logged-in.awsapps.com/start", startUrl)
+	require.Equal(t, "us-east-1", region)
+	require.Equal(t, "imported", label)
+	require.Equal(t, int64(100), createdAt)
+	require.Equal(t, int64(3600), expiresIn)
+
+	accessToken, err := controller.encryptionService.Decrypt(accessTokenEnc, encKeyId)
+	require.NoError(t, err)
+	require.Equal(t, "cli-access-token", accessToken)
+
+	refreshToken, err := controller.encryptionService.Decrypt(refreshTokenEnc, encKeyId)
+	require.NoError(t, err)
+	require.Empty(t, refreshToken, "refresh tokens of the AWS CLI can only be redeemed by its own client")
+
+	mockTimeProvider.ExpectedCalls = nil
+	mockTimeProvider.On("NowUnix").Return(3800)
+
+	err = controller.RefreshAccessTokenSilently(ctx, instanceId)
+	require.Same(t, ErrRefreshTokenUnavailable, err, "imported instances are reauthorized interactively once their access token expires")
+}
+
+func TestImportFromAwsCli_Errors(t *testing.T) {
+	testCases := []struct {
+		name        string
+		sessionName string
+		label       string
+		expectedErr error
+	}{
+		{name: "invalid label", sessionName: "logged-in", label: "", expectedErr: ErrInvalidLabel},
+		{name: "unknown session", sessionName: "unknown", label: "imported", expectedErr: ErrAwsCliSessionNotFound},
+		{name: "expired token", sessionName: "expired", label: "imported", expectedErr: ErrAwsCliSessionExpired},
+		{name: "no token", sessionName: "logged-out", label: "imported", expectedErr: ErrAwsCliSessionExpired},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			controller, _, mockTimeProvider := initController(t)
+			controller.SetAwsCliTokenCache(newFakeAwsCliTokenCache())
+
+			mockTimeProvider.On("NowUnix").Return(100)
+
+			_, err := controller.ImportFromAwsCli(testhelpers.NewMockAppContext(), AwsIdc_ImportFromAwsCliCommandInput{
+				SessionName: tc.sessionName,
+				Label:       tc.label,
+			})
+
+			require.True(t, app.IsError(err, tc.expectedErr), "expected %v, got %v", tc.expectedErr, err)
+		})
+	}
+}
+
+func TestImportFromAwsCli_Error_AlreadyRegistered(t *testing.T) {
+	controller, _, mockTimeProvider := initController(t)
+	controller.SetAwsCliTokenCache(newFakeAwsCliTokenCache())
+
+	ctx := testhelpers.NewMockAppContext()
+
+	mockTimeProvider.On("NowUnix").Return(100)
+
+	input := AwsIdc_ImportFromAwsCliCommandInput{
+		SessionName: "logged-in",
+		Label:       "imported",
+	}
+
+	_, err := controller.ImportFromAwsCli(ctx, input)
+	require.NoError(t, err)
+
+	_, err = controller.ImportFromAwsCli(ctx, input)
+	require.True(t, app.IsError(err, ErrInstanceAlreadyRegistered))
+}
+
+func TestWriteAwsCliProfile(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	configFilePath := filepath.Join(t.TempDir(), "config")
+	controller.awsConfigFile = awscredsfile.NewConfigFileManager(configFilePath)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	err := controller.WriteAwsCliProfile(ctx, AwsIdc_WriteAwsCliProfileCommandInput{
+		InstanceId: instanceId,
+		AccountId:  "123456789012",
+		RoleName:   "ReadOnly",
+		AwsProfile: "prod-readonly",
+		Region:     "us-east-1",
+	})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(configFilePath)
+	require.NoError(t, err)
+	require.Equal(t, `[sso-session test-label]
+sso_start_url = https://test-start-url.aws-apps.com/start
+sso_region = eu-west-1
+sso_registration_scopes = sso:account:access
+
+[profile prod-readonly]
+sso_session = test-label
+sso_account_id = 123456789012
+sso_role_name = ReadOnly
+region = us-east-1
+`, string(content))
+
+	err = controller.WriteAwsCliProfile(ctx, AwsIdc_WriteAwsCliProfileCommandInput{
+		InstanceId: "non-existent",
+		AccountId:  "123456789012",
+		RoleName:   "ReadOnly",
+		AwsProfile: "prod-readonly",
+	})
+	require.Same(t, ErrInstanceWasNotFound, err)
+
+	err = controller.WriteAwsCliProfile(ctx, AwsIdc_WriteAwsCliProfileCommandInput{
+		InstanceId: instanceId,
+		AccountId:  "123456789012",
+		AwsProfile: "prod-readonly",
+	})
+	require.Same(t, ErrInvalidAccountRole, err)
+
+	err = controller.WriteAwsCliProfile(ctx, AwsIdc_WriteAwsCliProfileCommandInput{
+		InstanceId: instanceId,
+		AccountId:  "123456789012",
+		RoleName:   "ReadOnly",
+		AwsProfile: "prod-readonly",
+		Region:     "moon-1",
+	})
+	require.Same(t, ErrInvalidAwsRegion, err)
+}
@@ -2,18 +2,24 @@ package awsidc
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
-	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/abjrcode/swervo/clients/awscredsfile"
+	"github.com/abjrcode/swervo/clients/awsnet"
 	"github.com/abjrcode/swervo/clients/awssso"
 	"github.com/abjrcode/swervo/favorites"
 	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/credsformat"
 	"github.com/abjrcode/swervo/internal/eventing"
 	"github.com/abjrcode/swervo/internal/plumbing"
 	"github.com/abjrcode/swervo/internal/security/encryption"
@@ -36,10 +42,23 @@ var (
 	ErrInstanceAlreadyRegistered   = app.NewValidationError("INSTANCE_ALREADY_REGISTERED")
 	ErrStaleAwsAccessToken         = app.NewValidationError("STALE_AWS_ACCESS_TOKEN")
 	ErrTransientAwsClientError     = app.NewValidationError("TRANSIENT_AWS_CLIENT_ERROR")
+	ErrInvalidAccountRole          = app.NewValidationError("INVALID_ACCOUNT_ROLE")
+	ErrDeviceAuthFlowDenied        = app.NewValidationError("DEVICE_AUTH_FLOW_DENIED")
+	ErrDeviceAuthFlowCancelled     = app.NewValidationError("DEVICE_AUTH_FLOW_CANCELLED")
+	ErrRefreshTokenUnavailable     = app.NewValidationError("REFRESH_TOKEN_UNAVAILABLE")
 )
 
 var AwsIdcEventSource = eventing.EventSource("AwsIdc")
 
+// LoginFlow is how the tokens of an instance were obtained.
+// Refresh tokens can only be redeemed by the client of the flow that issued them.
+type LoginFlow string
+
+const (
+	LoginFlowDeviceCode        LoginFlow = "device_code"
+	LoginFlowAuthorizationCode LoginFlow = "authorization_code"
+)
+
 type AwsIdcInstanceCreatedEvent struct {
 	InstanceId string
 
@@ -60,32 +79,80 @@ type AwsIdentityCenterController struct {
 	favoritesRepo     favorites.FavoritesRepo
 	encryptionService encryption.EncryptionService
 	awsSsoClient      awssso.AwsSsoOidcClient
+	regions           *awssso.RegionCatalog
 	clock             utils.Clock
 	cache             *freecache.Cache
+	roleCredentials   *roleCredentialsCache
+
+	credentialsFormats *credsformat.Registry
+
+	// federationEndpoint is where console sign-in tokens of a partition are issued
+	federationEndpoint func(partition awssso.Partition) string
+
+	federationClientMu sync.RWMutex
+	federationClient   *http.Client
+
+	plumbers          []plumbing.Plumber[AwsCredentials]
+	instanceListeners []plumbing.InstanceListener
+
+	awsCliTokenCache AwsCliTokenCache
+	shareWithAwsCli  atomic.Bool
+	awsConfigFile    AwsConfigFile
 
-	plumbers []plumbing.Plumber[AwsCredentials]
+	refreshMu sync.Mutex
+
+	wait          func(ctx context.Context, d time.Duration) error
+	deviceFlowsMu sync.Mutex
+	deviceFlows   map[string]context.CancelFunc
+
+	authCodeFlowsMu sync.Mutex
+	authCodeFlows   map[string]*authCodeFlow
+
+	pump *plumbing.Pump[AwsCredentials]
 }
 
-func NewAwsIdentityCenterController(db *sql.DB, bus *eventing.Eventbus, favoritesRepo favorites.FavoritesRepo, encryptionService encryption.EncryptionService, awsSsoClient awssso.AwsSsoOidcClient, datetime utils.Clock) *AwsIdentityCenterController {
+func NewAwsIdentityCenterController(db *sql.DB, bus *eventing.Eventbus, favoritesRepo favorites.FavoritesRepo, encryptionService encryption.EncryptionService, awsSsoClient awssso.AwsSsoOidcClient, regions *awssso.RegionCatalog, datetime utils.Clock) *AwsIdentityCenterController {
 	fiveHundredTwelveKilobytes := 512 * 1024
 	cache := freecache.NewCache(fiveHundredTwelveKilobytes)
 
-	return &AwsIdentityCenterController{
+	controller := &AwsIdentityCenterController{
 		db:                db,
 		bus:               bus,
 		favoritesRepo:     favoritesRepo,
 		encryptionService: encryptionService,
 		awsSsoClient:      awsSsoClient,
+		regions:           regions,
 		clock:             datetime,
 		cache:             cache,
+		roleCredentials:   newRoleCredentialsCache(datetime),
+
+		credentialsFormats: credsformat.NewRegistry(),
+		awsConfigFile:      awscredsfile.NewDefaultConfigFileManager(),
+
+		federationEndpoint: defaultFederationEndpoint,
+		federationClient:   newFederationClient(),
+
 		plumbers:          make([]plumbing.Plumber[AwsCredentials], 0),
+		instanceListeners: make([]plumbing.InstanceListener, 0),
+		wait:              waitFor,
+		deviceFlows:       make(map[string]context.CancelFunc),
+		authCodeFlows:     make(map[string]*authCodeFlow),
 	}
+
+	controller.pump = newCredentialsPump(controller)
+
+	return controller
 }
 
 func (c *AwsIdentityCenterController) AddPlumbers(plumbers ...plumbing.Plumber[AwsCredentials]) {
 	c.plumbers = append(c.plumbers, plumbers...)
 }
 
+// AddInstanceListeners lets others know whenever an instance gets a new access token.
+func (c *AwsIdentityCenterController) AddInstanceListeners(listeners ...plumbing.InstanceListener) {
+	c.instanceListeners = append(c.instanceListeners, listeners...)
+}
+
 type AwsIdentityCenterAccountRole struct {
 	RoleName string `json:"roleName"`
 }
@@ -94,6 +161,8 @@ type AwsIdentityCenterAccount struct {
 	AccountId   string                         `json:"accountId"`
 	AccountName string                         `json:"accountName"`
 	Roles       []AwsIdentityCenterAccountRole `json:"roles"`
+	// Error is set when the roles of the account could not be listed
+	Error string `json:"error,omitempty"`
 }
 
 type AwsIdentityCenterCardData struct {
@@ -138,6 +207,7 @@ func (c *AwsIdentityCenterController) invalidateStaleAccessToken(ctx app.Context
 	ctx.Logger().Trace().Msgf("invalidating stale access token for instance [%s]", instanceId)
 
 	c.cache.Del([]byte(instanceId))
+	c.roleCredentials.evictInstance(instanceId)
 
 	_, err := c.db.ExecContext(ctx, "UPDATE aws_idc SET access_token_expires_in = 0 WHERE instance_id = ?", instanceId)
 
@@ -149,6 +219,11 @@ func (c *AwsIdentityCenterController) invalidateStaleAccessToken(ctx app.Context
 }
 
 func (c *AwsIdentityCenterController) GetInstanceData(ctx app.Context, instanceId string, forceRefresh bool) (*AwsIdentityCenterCardData, error) {
+	return c.getInstanceData(ctx, instanceId, forceRefresh, true)
+}
+
+// getInstanceData renews an expired access token with the refresh token of the instance when silentRefresh is set
+func (c *AwsIdentityCenterController) getInstanceData(ctx app.Context, instanceId string, forceRefresh, silentRefresh bool) (*AwsIdentityCenterCardData, error) {
 	row := c.db.QueryRowContext(ctx, "SELECT region, label, access_token_enc, access_token_created_at, access_token_expires_in, enc_key_id FROM aws_idc WHERE instance_id = ?", instanceId)
 
 	var region string
@@ -181,6 +256,18 @@ func (c *AwsIdentityCenterController) GetInstanceData(ctx app.Context, instanceI
 	if now > accessTokenCreatedAt+accessTokenExpiresIn {
 		ctx.Logger().Info().Msgf("token for instance [%s] has expired", instanceId)
 
+		if silentRefresh {
+			refreshed, err := c.tryRefreshAccessTokenSilently(ctx, instanceId)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if refreshed {
+				return c.getInstanceData(ctx, instanceId, forceRefresh, false)
+			}
+		}
+
 		return &AwsIdentityCenterCardData{
 			Enabled:              true,
 			InstanceId:           instanceId,
@@ -202,12 +289,7 @@ func (c *AwsIdentityCenterController) GetInstanceData(ctx app.Context, instanceI
 			return nil, errors.Join(err, app.ErrFatal)
 		}
 
-		for _, pipe := range connectedSinks {
-			sinks = append(sinks, plumbing.SinkInstance{
-				SinkCode: pipe.SinkCode,
-				SinkId:   pipe.SinkId,
-			})
-		}
+		sinks = append(sinks, connectedSinks...)
 	}
 
 	if !forceRefresh {
@@ -247,7 +329,7 @@ func (c *AwsIdentityCenterController) GetInstanceData(ctx app.Context, instanceI
 	}
 
 	ctx.Logger().Debug().Msgf("fetching accounts for instance [%s] with refresh=%t", instanceId, forceRefresh)
-	accountsOut, err := c.awsSsoClient.ListAccounts(ctx, awssso.AwsRegion(region), accessToken)
+	accountsOut, err := c.awsSsoClient.ListAccounts(ctx, awsnet.AwsRegion(region), accessToken)
 
 	if err != nil {
 		if errors.Is(err, awssso.ErrAccessTokenExpired) || errors.Is(err, awssso.ErrUnauthorizedAccessToken) {
@@ -255,6 +337,18 @@ func (c *AwsIdentityCenterController) GetInstanceData(ctx app.Context, instanceI
 
 			c.invalidateStaleAccessToken(ctx, instanceId)
 
+			if silentRefresh {
+				refreshed, err := c.tryRefreshAccessTokenSilently(ctx, instanceId)
+
+				if err != nil {
+					return nil, err
+				}
+
+				if refreshed {
+					return c.getInstanceData(ctx, instanceId, true, false)
+				}
+			}
+
 			return &AwsIdentityCenterCardData{
 				Enabled:              true,
 				InstanceId:           instanceId,
@@ -273,19 +367,8 @@ func (c *AwsIdentityCenterController) GetInstanceData(ctx app.Context, instanceI
 		return nil, ErrTransientAwsClientError
 	}
 
-	buffer := bytes.NewBuffer([]byte{})
-
-	err = gob.NewEncoder(buffer).Encode(accountsOut.Accounts)
-
-	if err != nil {
-		ctx.Logger().Error().Err(err).Msg("failed to encode accounts to cache")
-		return nil, errors.Join(err, app.ErrFatal)
-	}
-
-	cacheTtl := accessTokenCreatedAt + accessTokenExpiresIn - now
-	c.cache.Set([]byte(instanceId), buffer.Bytes(), int(cacheTtl))
-
 	accounts := make([]AwsIdentityCenterAccount, 0)
+	isPartial := false
 
 	for _, account := range accountsOut.Accounts {
 		accountRoles := make([]AwsIdentityCenterAccountRole, 0)
@@ -296,13 +379,38 @@ func (c *AwsIdentityCenterController) GetInstanceData(ctx app.Context, instanceI
 			})
 		}
 
+		var accountErr string
+
+		if account.Err != nil {
+			ctx.Logger().Warn().Err(account.Err).Msgf("failed to list roles of account [%s]", account.AccountId)
+
+			accountErr = ErrTransientAwsClientError.Error()
+			isPartial = true
+		}
+
 		accounts = append(accounts, AwsIdentityCenterAccount{
 			AccountId:   account.AccountId,
 			AccountName: account.AccountName,
 			Roles:       accountRoles,
+			Error:       accountErr,
 		})
 	}
 
+	// accounts with missing roles are listed again next time
+	if !isPartial {
+		buffer := bytes.NewBuffer([]byte{})
+
+		err = gob.NewEncoder(buffer).Encode(accounts)
+
+		if err != nil {
+			ctx.Logger().Error().Err(err).Msg("failed to encode accounts to cache")
+			return nil, errors.Join(err, app.ErrFatal)
+		}
+
+		cacheTtl := accessTokenCreatedAt + accessTokenExpiresIn - now
+		c.cache.Set([]byte(instanceId), buffer.Bytes(), int(cacheTtl))
+	}
+
 	return &AwsIdentityCenterCardData{
 		Enabled:              true,
 		InstanceId:           instanceId,
@@ -323,7 +431,79 @@ type awsRoleCredentials struct {
 	Expiration      int64
 }
 
-func (c *AwsIdentityCenterController) getRoleCredentials(ctx app.Context, instanceId, accountId, roleName string) (*awsRoleCredentials, error) {
+// RoleCredentials are the credentials of a role handed out to other parts of Swervo.
+type RoleCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	// ExpiresAt is a Unix time in seconds
+	ExpiresAt int64
+}
+
+// GetRoleCredentials returns the credentials of a role, cached ones are reused until shortly before they expire.
+func (c *AwsIdentityCenterController) GetRoleCredentials(ctx app.Context, instanceId, accountId, roleName string) (*RoleCredentials, error) {
+	res, err := c.getRoleCredentials(ctx, instanceId, accountId, roleName, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &RoleCredentials{
+		AccessKeyId:     res.AccessKeyId,
+		SecretAccessKey: res.SecretAccessKey,
+		SessionToken:    res.SessionToken,
+		ExpiresAt:       time.UnixMilli(res.Expiration).Unix(),
+	}, nil
+}
+
+// PurgeRoleCredentialsCache forgets every cached role credentials, e.g. when the vault is sealed.
+func (c *AwsIdentityCenterController) PurgeRoleCredentialsCache() {
+	c.roleCredentials.purge()
+}
+
+// getRoleCredentials hands out cached role credentials until shortly before they expire, unless forceRefresh is set.
+func (c *AwsIdentityCenterController) getRoleCredentials(ctx app.Context, instanceId, accountId, roleName string, forceRefresh bool) (*awsRoleCredentials, error) {
+	key := roleCredentialsKey{instanceId: instanceId, accountId: accountId, roleName: roleName}
+
+	if !forceRefresh {
+		if cached, ok := c.roleCredentials.get(key); ok {
+			ctx.Logger().Debug().Msgf("using cached credentials of role [%s] of account [%s]", roleName, accountId)
+			return cached, nil
+		}
+	}
+
+	res, err := c.fetchRoleCredentials(ctx, instanceId, accountId, roleName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.roleCredentials.put(key, res)
+
+	return res, nil
+}
+
+func (c *AwsIdentityCenterController) fetchRoleCredentials(ctx app.Context, instanceId, accountId, roleName string) (*awsRoleCredentials, error) {
+	res, err := c.getRoleCredentialsWithAccessToken(ctx, instanceId, accountId, roleName)
+
+	if !app.IsError(err, ErrStaleAwsAccessToken) {
+		return res, err
+	}
+
+	refreshed, refreshErr := c.tryRefreshAccessTokenSilently(ctx, instanceId)
+
+	if refreshErr != nil {
+		return nil, refreshErr
+	}
+
+	if !refreshed {
+		return nil, err
+	}
+
+	return c.getRoleCredentialsWithAccessToken(ctx, instanceId, accountId, roleName)
+}
+
+func (c *AwsIdentityCenterController) getRoleCredentialsWithAccessToken(ctx app.Context, instanceId, accountId, roleName string) (*awsRoleCredentials, error) {
 	row := c.db.QueryRowContext(ctx, "SELECT region, access_token_enc, access_token_created_at, access_token_expires_in, enc_key_id FROM aws_idc WHERE instance_id = ?", instanceId)
 
 	var region string
@@ -346,7 +526,7 @@ func (c *AwsIdentityCenterController) getRoleCredentials(ctx app.Context, instan
 		return nil, errors.Join(errors.New("failed to decrypt access token"), err, app.ErrFatal)
 	}
 
-	res, err := c.awsSsoClient.GetRoleCredentials(ctx, awssso.AwsRegion(region), accountId, roleName, accessToken)
+	res, err := c.awsSsoClient.GetRoleCredentials(ctx, awsnet.AwsRegion(region), accountId, roleName, accessToken)
 
 	if err != nil {
 		if errors.Is(err, awssso.ErrAccessTokenExpired) || errors.Is(err, awssso.ErrUnauthorizedAccessToken) {
@@ -357,8 +537,8 @@ func (c *AwsIdentityCenterController) getRoleCredentials(ctx app.Context, instan
 			return nil, ErrStaleAwsAccessToken
 		}
 
-		ctx.Logger().Error().Err(err).Msg("failed to get role credentials")
-		return nil, errors.Join(err, app.ErrFatal)
+		ctx.Logger().Error().Err(err).Msg("aws sso client failed to get role credentials")
+		return nil, ErrTransientAwsClientError
 	}
 
 	return &awsRoleCredentials{
@@ -373,25 +553,54 @@ type AwsIdc_CopyRoleCredentialsCommandInput struct {
 	InstanceId string `json:"instanceId"`
 	AccountId  string `json:"accountId"`
 	RoleName   string `json:"roleName"`
+	// ForceRefresh bypasses cached credentials
+	ForceRefresh bool `json:"forceRefresh"`
+	// Format is one of [AwsIdentityCenterController.ListCredentialsFormats], the one of the default shell of the OS when empty
+	Format string `json:"format"`
+	// Region is exported as AWS_REGION unless empty
+	Region string `json:"region"`
 }
 
-func (c *AwsIdentityCenterController) CopyRoleCredentials(ctx app.Context, input AwsIdc_CopyRoleCredentialsCommandInput) error {
-	res, err := c.getRoleCredentials(ctx, input.InstanceId, input.AccountId, input.RoleName)
+// ListCredentialsFormats lists the formats role credentials can be copied in.
+func (c *AwsIdentityCenterController) ListCredentialsFormats() []string {
+	formats := c.credentialsFormats.Formats()
+	result := make([]string, 0, len(formats))
+
+	for _, format := range formats {
+		result = append(result, string(format))
+	}
+
+	return result
+}
+
+// FormatRoleCredentials renders the credentials of a role, e.g. as shell variables or as credential_process output.
+func (c *AwsIdentityCenterController) FormatRoleCredentials(ctx app.Context, input AwsIdc_CopyRoleCredentialsCommandInput) (string, error) {
+	if input.Region != "" {
+		if err := c.validateAwsRegion(input.Region); err != nil {
+			return "", err
+		}
+	}
+
+	res, err := c.getRoleCredentials(ctx, input.InstanceId, input.AccountId, input.RoleName, input.ForceRefresh)
 
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	output := ""
+	return c.credentialsFormats.Format(credsformat.Format(input.Format), credsformat.Credentials{
+		AccessKeyId:     res.AccessKeyId,
+		SecretAccessKey: res.SecretAccessKey,
+		SessionToken:    res.SessionToken,
+		Expiration:      time.UnixMilli(res.Expiration).Unix(),
+		Region:          input.Region,
+	})
+}
+
+func (c *AwsIdentityCenterController) CopyRoleCredentials(ctx app.Context, input AwsIdc_CopyRoleCredentialsCommandInput) error {
+	output, err := c.FormatRoleCredentials(ctx, input)
 
-	if runtime.GOOS == "windows" {
-		output = fmt.Sprintf(`$Env:AWS_ACCESS_KEY_ID="%s"
-$Env:AWS_SECRET_ACCESS_KEY="%s"
-$Env:AWS_SESSION_TOKEN="%s"`, res.AccessKeyId, res.SecretAccessKey, res.SessionToken)
-	} else {
-		output = fmt.Sprintf(`export AWS_ACCESS_KEY_ID="%s"
-export AWS_SECRET_ACCESS_KEY="%s"
-export AWS_SESSION_TOKEN="%s"`, res.AccessKeyId, res.SecretAccessKey, res.SessionToken)
+	if err != nil {
+		return err
 	}
 
 	err = wailsRuntime.ClipboardSetText(ctx, output)
@@ -410,10 +619,12 @@ type AwsIdc_SaveRoleCredentialsCommandInput struct {
 	AccountId  string `json:"accountId"`
 	RoleName   string `json:"roleName"`
 	AwsProfile string `json:"awsProfile"`
+	// ForceRefresh bypasses cached credentials
+	ForceRefresh bool `json:"forceRefresh"`
 }
 
 func (c *AwsIdentityCenterController) SaveRoleCredentials(ctx app.Context, input AwsIdc_SaveRoleCredentialsCommandInput) error {
-	result, err := c.getRoleCredentials(ctx, input.InstanceId, input.AccountId, input.RoleName)
+	result, err := c.getRoleCredentials(ctx, input.InstanceId, input.AccountId, input.RoleName, input.ForceRefresh)
 
 	if err != nil {
 		return err
@@ -438,6 +649,47 @@ func (c *AwsIdentityCenterController) SaveRoleCredentials(ctx app.Context, input
 	return nil
 }
 
+func (c *AwsIdentityCenterController) ProviderCode() string {
+	return ProviderCode
+}
+
+// ValidateSourceSelector checks that the selected account and role are assigned to the user of the instance.
+func (c *AwsIdentityCenterController) ValidateSourceSelector(ctx app.Context, instanceId string, selector plumbing.SourceSelector) error {
+	if selector.AccountId == "" || selector.RoleName == "" {
+		return ErrInvalidAccountRole
+	}
+
+	instanceData, err := c.GetInstanceData(ctx, instanceId, false)
+
+	if err != nil {
+		return err
+	}
+
+	if instanceData.IsAccessTokenExpired {
+		return ErrStaleAwsAccessToken
+	}
+
+	for _, account := range instanceData.Accounts {
+		if account.AccountId != selector.AccountId {
+			continue
+		}
+
+		if account.Error != "" {
+			return ErrTransientAwsClientError
+		}
+
+		for _, role := range account.Roles {
+			if role.RoleName == selector.RoleName {
+				return nil
+			}
+		}
+	}
+
+	ctx.Logger().Debug().Msgf("role [%s] of account [%s] is not available in instance [%s]", selector.RoleName, selector.AccountId, instanceId)
+
+	return ErrInvalidAccountRole
+}
+
 func (c *AwsIdentityCenterController) validateStartUrl(startUrl string) error {
 	_, err := url.ParseRequestURI(startUrl)
 
@@ -449,7 +701,7 @@ func (c *AwsIdentityCenterController) validateStartUrl(startUrl string) error {
 }
 
 func (c *AwsIdentityCenterController) validateAwsRegion(region string) error {
-	if _, ok := awssso.SupportedAwsRegions[region]; !ok {
+	if _, ok := c.regions.Lookup(region); !ok {
 		return ErrInvalidAwsRegion
 	}
 
@@ -474,6 +726,9 @@ type AuthorizeDeviceFlowResult struct {
 	UserCode        string `json:"userCode"`
 	ExpiresIn       int32  `json:"expiresIn"`
 	DeviceCode      string `json:"deviceCode"`
+	// FlowId identifies the flow in progress events while the backend polls it
+	FlowId   string `json:"flowId"`
+	Interval int32  `json:"interval"`
 }
 
 type AwsIdc_SetupCommandInput struct {
@@ -539,6 +794,8 @@ func (c *AwsIdentityCenterController) Setup(ctx app.Context, input AwsIdc_SetupC
 		UserCode:        authorizeRes.UserCode,
 		ExpiresIn:       authorizeRes.ExpiresIn,
 		DeviceCode:      authorizeRes.DeviceCode,
+		FlowId:          ksuid.New().String(),
+		Interval:        authorizeRes.Interval,
 	}, nil
 }
 
@@ -552,6 +809,13 @@ type AwsIdc_FinalizeSetupCommandInput struct {
 }
 
 func (c *AwsIdentityCenterController) FinalizeSetup(ctx app.Context, input AwsIdc_FinalizeSetupCommandInput) (string, error) {
+	return c.finalizeSetup(ctx, input, LoginFlowDeviceCode, func(clientId, clientSecret string) (*awssso.GetTokenResponse, error) {
+		return c.getDeviceFlowToken(ctx, input.AwsRegion, clientId, clientSecret, input.DeviceCode, input.UserCode)
+	})
+}
+
+// finalizeSetup creates the instance out of the access token returned by getToken
+func (c *AwsIdentityCenterController) finalizeSetup(ctx app.Context, input AwsIdc_FinalizeSetupCommandInput, loginFlow LoginFlow, getToken func(clientId, clientSecret string) (*awssso.GetTokenResponse, error)) (string, error) {
 	if err := c.validateLabel(input.Label); err != nil {
 		return "", err
 	}
@@ -564,38 +828,41 @@ func (c *AwsIdentityCenterController) FinalizeSetup(ctx app.Context, input AwsId
 		return "", err
 	}
 
-	row := c.db.QueryRowContext(ctx, "SELECT client_secret_enc, enc_key_id FROM aws_sso_clients")
+	client, err := c.loadClient(ctx, loginFlow, input.StartUrl)
 
-	var clientSecretEnc string
-	var encKeyId string
-
-	if err := row.Scan(&clientSecretEnc, &encKeyId); err != nil {
-		return "", errors.Join(err, app.ErrFatal)
+	if err != nil {
+		return "", err
 	}
 
-	clientSecret, err := c.encryptionService.Decrypt(clientSecretEnc, encKeyId)
+	tokenRes, err := getToken(client.clientId, client.clientSecret)
 
 	if err != nil {
-		return "", errors.Join(err, app.ErrFatal)
+		return "", err
 	}
 
-	tokenRes, err := c.getToken(ctx, input.AwsRegion, input.ClientId, clientSecret, input.DeviceCode, input.UserCode)
+	nowUnix := c.clock.NowUnix()
+
+	instanceId, err := c.createInstance(ctx, input.StartUrl, input.AwsRegion, input.Label, loginFlow, tokenRes, nowUnix)
 
 	if err != nil {
-		if errors.Is(err, awssso.ErrDeviceFlowNotAuthorized) {
-			ctx.Logger().Debug().Err(err).Msg("failed to get token because user did not authorize device")
-			return "", ErrDeviceAuthFlowNotAuthorized
-		}
+		return "", err
+	}
 
-		if errors.Is(err, awssso.ErrDeviceCodeExpired) {
-			ctx.Logger().Debug().Err(err).Msg("failed to get token because user and device code expired")
-			return "", ErrDeviceAuthFlowTimedOut
-		}
+	c.shareAccessToken(ctx, awscredsfile.SsoCachedToken{
+		StartUrl:              input.StartUrl,
+		Region:                input.AwsRegion,
+		AccessToken:           tokenRes.AccessToken,
+		ExpiresAt:             nowUnix + int64(tokenRes.ExpiresIn),
+		ClientId:              client.clientId,
+		ClientSecret:          client.clientSecret,
+		RegistrationExpiresAt: client.expiresAt,
+	})
 
-		ctx.Logger().Error().Err(err).Msg("failed to get token")
-		return "", ErrTransientAwsClientError
-	}
+	return instanceId, nil
+}
 
+// createInstance stores a new instance along with its tokens and publishes [AwsIdcInstanceCreatedEvent].
+func (c *AwsIdentityCenterController) createInstance(ctx app.Context, startUrl, awsRegion, label string, loginFlow LoginFlow, tokenRes *awssso.GetTokenResponse, nowUnix int64) (string, error) {
 	idTokenEnc, keyId, err := c.encryptionService.Encrypt(tokenRes.IdToken)
 
 	if err != nil {
@@ -613,8 +880,6 @@ func (c *AwsIdentityCenterController) FinalizeSetup(ctx app.Context, input AwsId
 		return "", errors.Join(err, app.ErrFatal)
 	}
 
-	nowUnix := c.clock.NowUnix()
-
 	uniqueId, err := ksuid.NewRandomWithTime(time.Unix(nowUnix, 0))
 	if err != nil {
 		return "", errors.Join(err, app.ErrFatal)
@@ -630,14 +895,14 @@ func (c *AwsIdentityCenterController) FinalizeSetup(ctx app.Context, input AwsId
 
 	sql := `INSERT INTO aws_idc
 	(instance_id, version, start_url, region, label, enabled, id_token_enc, access_token_enc, token_type, access_token_created_at,
-		access_token_expires_in, refresh_token_enc, enc_key_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		access_token_expires_in, refresh_token_enc, login_flow, enc_key_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	_, err = tx.ExecContext(ctx, sql,
 		instanceId,
 		version,
-		input.StartUrl,
-		input.AwsRegion,
-		input.Label,
+		startUrl,
+		awsRegion,
+		label,
 		true,
 		idTokenEnc,
 		accessTokenEnc,
@@ -645,6 +910,7 @@ func (c *AwsIdentityCenterController) FinalizeSetup(ctx app.Context, input AwsId
 		nowUnix,
 		tokenRes.ExpiresIn,
 		refreshTokenEnc,
+		loginFlow,
 		keyId)
 
 	if err != nil {
@@ -653,9 +919,9 @@ func (c *AwsIdentityCenterController) FinalizeSetup(ctx app.Context, input AwsId
 
 	publish, err := c.bus.PublishTx(ctx, AwsIdcInstanceCreatedEvent{
 		InstanceId: instanceId,
-		StartUrl:   input.StartUrl,
-		Region:     input.AwsRegion,
-		Label:      input.Label,
+		StartUrl:   startUrl,
+		Region:     awsRegion,
+		Label:      label,
 	}, eventing.EventMeta{
 		SourceType:   AwsIdcEventSource,
 		SourceId:     instanceId,
@@ -728,6 +994,8 @@ func (c *AwsIdentityCenterController) RefreshAccessToken(ctx app.Context, instan
 		UserCode:        authorizeRes.UserCode,
 		ExpiresIn:       authorizeRes.ExpiresIn,
 		DeviceCode:      authorizeRes.DeviceCode,
+		FlowId:          ksuid.New().String(),
+		Interval:        authorizeRes.Interval,
 	}, nil
 }
 
@@ -739,41 +1007,39 @@ type AwsIdc_FinalizeRefreshAccessTokenCommandInput struct {
 }
 
 func (c *AwsIdentityCenterController) FinalizeRefreshAccessToken(ctx app.Context, input AwsIdc_FinalizeRefreshAccessTokenCommandInput) error {
-	if err := c.validateAwsRegion(input.Region); err != nil {
-		return err
-	}
+	return c.finalizeRefreshAccessToken(ctx, input, LoginFlowDeviceCode, func(clientId, clientSecret string) (*awssso.GetTokenResponse, error) {
+		return c.getDeviceFlowToken(ctx, input.Region, clientId, clientSecret, input.DeviceCode, input.UserCode)
+	})
+}
 
-	row := c.db.QueryRowContext(ctx, "SELECT client_id, client_secret_enc, enc_key_id FROM aws_sso_clients")
+// finalizeRefreshAccessToken stores the access token returned by getToken for the client of loginFlow
+func (c *AwsIdentityCenterController) finalizeRefreshAccessToken(ctx app.Context, input AwsIdc_FinalizeRefreshAccessTokenCommandInput, loginFlow LoginFlow, getToken func(clientId, clientSecret string) (*awssso.GetTokenResponse, error)) error {
+	var startUrl, region string
 
-	var clientId string
-	var clientSecretEnc string
-	var encKeyId string
+	if err := c.db.QueryRowContext(ctx, "SELECT start_url, region FROM aws_idc WHERE instance_id = ?", input.InstanceId).Scan(&startUrl, &region); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInstanceWasNotFound
+		}
 
-	if err := row.Scan(&clientId, &clientSecretEnc, &encKeyId); err != nil {
 		return errors.Join(err, app.ErrFatal)
 	}
 
-	clientSecret, err := c.encryptionService.Decrypt(clientSecretEnc, encKeyId)
-
-	if err != nil {
-		return errors.Join(err, app.ErrFatal)
+	// the region of a stored instance is not checked against the catalog, it was valid when the instance was set up
+	// and removing a custom region later on must not lock the user out of it
+	if input.Region != region {
+		return ErrInvalidAwsRegion
 	}
 
-	tokenRes, err := c.getToken(ctx, input.Region, clientId, clientSecret, input.DeviceCode, input.UserCode)
+	client, err := c.loadClient(ctx, loginFlow, startUrl)
 
 	if err != nil {
-		if errors.Is(err, awssso.ErrDeviceFlowNotAuthorized) {
-			ctx.Logger().Debug().Err(err).Msg("failed to get token because user did not authorize device")
-			return ErrDeviceAuthFlowNotAuthorized
-		}
+		return err
+	}
 
-		if errors.Is(err, awssso.ErrDeviceCodeExpired) {
-			ctx.Logger().Debug().Err(err).Msg("failed to get token because user and device code expired")
-			return ErrDeviceAuthFlowTimedOut
-		}
+	tokenRes, err := getToken(client.clientId, client.clientSecret)
 
-		ctx.Logger().Error().Err(err).Msg("failed to get token")
-		return ErrTransientAwsClientError
+	if err != nil {
+		return err
 	}
 
 	idTokenEnc, keyId, err := c.encryptionService.Encrypt(tokenRes.IdToken)
@@ -803,19 +1069,23 @@ func (c *AwsIdentityCenterController) FinalizeRefreshAccessToken(ctx app.Context
 		access_token_created_at = ?,
 		access_token_expires_in = ?,
 		refresh_token_enc = ?,
+		login_flow = ?,
 		enc_key_id = ?
 		WHERE instance_id = ?;
 		
 		SELECT changes();
 		`
 
+	nowUnix := c.clock.NowUnix()
+
 	res, err := c.db.ExecContext(ctx, sql,
 		idTokenEnc,
 		accessTokenEnc,
 		tokenRes.TokenType,
-		c.clock.NowUnix(),
+		nowUnix,
 		tokenRes.ExpiresIn,
 		refreshTokenEnc,
+		loginFlow,
 		keyId, input.InstanceId)
 
 	if err != nil {
@@ -832,18 +1102,82 @@ func (c *AwsIdentityCenterController) FinalizeRefreshAccessToken(ctx app.Context
 		return ErrInstanceWasNotFound
 	}
 
+	c.shareAccessToken(ctx, awscredsfile.SsoCachedToken{
+		StartUrl:              startUrl,
+		Region:                input.Region,
+		AccessToken:           tokenRes.AccessToken,
+		ExpiresAt:             nowUnix + int64(tokenRes.ExpiresIn),
+		ClientId:              client.clientId,
+		ClientSecret:          client.clientSecret,
+		RegistrationExpiresAt: client.expiresAt,
+	})
+
+	// pipes halted because the previous access token went stale are connected again on the next reconciliation
+	c.pump.Resume(ProviderCode, input.InstanceId)
+
+	for _, listener := range c.instanceListeners {
+		listener.InstanceChanged(ctx, ProviderCode, input.InstanceId)
+	}
+
 	return nil
 }
 
+// registeredClient is a client registered with IAM Identity Center along with its decrypted secret
+type registeredClient struct {
+	clientId     string
+	clientSecret string
+	expiresAt    int64
+}
+
+// loadClient returns the client that tokens of loginFlow are issued to.
+// Clients of the authorization code flow are registered per start URL.
+// [ErrStaleAwsAccessToken] means that no such client was registered yet.
+func (c *AwsIdentityCenterController) loadClient(ctx app.Context, loginFlow LoginFlow, startUrl string) (*registeredClient, error) {
+	var row *sql.Row
+
+	switch loginFlow {
+	case LoginFlowAuthorizationCode:
+		row = c.db.QueryRowContext(ctx, "SELECT client_id, client_secret_enc, expires_at, enc_key_id FROM aws_sso_authorization_code_clients WHERE start_url = ?", startUrl)
+	default:
+		row = c.db.QueryRowContext(ctx, "SELECT client_id, client_secret_enc, expires_at, enc_key_id FROM aws_sso_clients")
+	}
+
+	var client registeredClient
+	var clientSecretEnc string
+	var encKeyId string
+
+	if err := row.Scan(&client.clientId, &clientSecretEnc, &client.expiresAt, &encKeyId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// e.g. instances imported from the AWS CLI were never authorized through a client of ours
+			ctx.Logger().Info().Msgf("no client of login flow [%s] was registered, the instance has to be reauthorized", loginFlow)
+			return nil, ErrStaleAwsAccessToken
+		}
+
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	var err error
+	client.clientSecret, err = c.encryptionService.Decrypt(clientSecretEnc, encKeyId)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	return &client, nil
+}
+
 func (c *AwsIdentityCenterController) getOrRegisterClient(ctx app.Context, awsRegion string) (*awssso.RegistrationResponse, error) {
-	row := c.db.QueryRowContext(ctx, "SELECT client_id, client_secret_enc, created_at, expires_at, enc_key_id FROM aws_sso_clients")
+	clientScopes := strings.Join(awssso.ClientScopes, " ")
+
+	row := c.db.QueryRowContext(ctx, "SELECT client_id, client_secret_enc, created_at, expires_at, scopes, enc_key_id FROM aws_sso_clients")
 
 	var encKeyId string
+	var scopes string
 	var result awssso.RegistrationResponse
 
 	shouldRegisterClient := false
 
-	if err := row.Scan(&result.ClientId, &result.ClientSecret, &result.CreatedAt, &result.ExpiresAt, &encKeyId); err != nil {
+	if err := row.Scan(&result.ClientId, &result.ClientSecret, &result.CreatedAt, &result.ExpiresAt, &scopes, &encKeyId); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			shouldRegisterClient = true
 		} else {
@@ -855,7 +1189,7 @@ func (c *AwsIdentityCenterController) getOrRegisterClient(ctx app.Context, awsRe
 		friendlyClientName := fmt.Sprintf("swervo_%s", utils.RandomString(6))
 		ctx.Logger().Info().Msgf("registering new client [%s]", friendlyClientName)
 
-		output, err := c.awsSsoClient.RegisterClient(ctx, awssso.AwsRegion(awsRegion), friendlyClientName)
+		output, err := c.awsSsoClient.RegisterClient(ctx, awsnet.AwsRegion(awsRegion), friendlyClientName)
 		if err != nil {
 			ctx.Logger().Error().Err(err).Msg("failed to register client")
 			return nil, err
@@ -868,9 +1202,9 @@ func (c *AwsIdentityCenterController) getOrRegisterClient(ctx app.Context, awsRe
 		}
 
 		_, err = c.db.ExecContext(ctx, `INSERT INTO aws_sso_clients
-			(client_id, client_secret_enc, created_at, expires_at, enc_key_id)
-			VALUES (?, ?, ?, ?, ?)`,
-			output.ClientId, clientSecretEnc, output.CreatedAt, output.ExpiresAt, encKeyId)
+			(client_id, client_secret_enc, created_at, expires_at, scopes, enc_key_id)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			output.ClientId, clientSecretEnc, output.CreatedAt, output.ExpiresAt, clientScopes, encKeyId)
 
 		if err != nil {
 			return nil, errors.Join(err, app.ErrFatal)
@@ -881,13 +1215,14 @@ func (c *AwsIdentityCenterController) getOrRegisterClient(ctx app.Context, awsRe
 		return output, nil
 	}
 
-	if c.clock.NowUnix() > result.ExpiresAt {
-		ctx.Logger().Info().Msg("client expired. registering new client")
+	// clients registered without scopes are not issued refresh tokens
+	if c.clock.NowUnix() > result.ExpiresAt || scopes != clientScopes {
+		ctx.Logger().Info().Msg("client expired or lacks scopes. registering new client")
 
 		friendlyClientName := fmt.Sprintf("swervo_%s", utils.RandomString(6))
 		ctx.Logger().Info().Msgf("registering new client [%s]", friendlyClientName)
 
-		output, err := c.awsSsoClient.RegisterClient(ctx, awssso.AwsRegion(awsRegion), friendlyClientName)
+		output, err := c.awsSsoClient.RegisterClient(ctx, awsnet.AwsRegion(awsRegion), friendlyClientName)
 		if err != nil {
 			ctx.Logger().Error().Err(err).Msg("failed to register client")
 			return nil, err
@@ -904,9 +1239,10 @@ func (c *AwsIdentityCenterController) getOrRegisterClient(ctx app.Context, awsRe
 			client_secret_enc = ?,
 			created_at = ?,
 			expires_at = ?,
+			scopes = ?,
 			enc_key_id = ?
 			WHERE client_id = ?`,
-			output.ClientId, clientSecretEnc, output.CreatedAt, output.ExpiresAt, encKeyId, result.ClientId)
+			output.ClientId, clientSecretEnc, output.CreatedAt, output.ExpiresAt, clientScopes, encKeyId, result.ClientId)
 
 		if err != nil {
 			return nil, errors.Join(err, app.ErrFatal)
@@ -930,7 +1266,7 @@ func (c *AwsIdentityCenterController) getOrRegisterClient(ctx app.Context, awsRe
 func (c *AwsIdentityCenterController) authorizeDevice(ctx app.Context, startUrl, region string, clientId, clientSecret string) (*awssso.AuthorizationResponse, error) {
 	ctx.Logger().Info().Msg("Authorizing Device")
 
-	output, err := c.awsSsoClient.StartDeviceAuthorization(ctx, awssso.AwsRegion(region), startUrl, clientId, clientSecret)
+	output, err := c.awsSsoClient.StartDeviceAuthorization(ctx, awsnet.AwsRegion(region), startUrl, clientId, clientSecret)
 
 	if err != nil {
 		return nil, err
@@ -941,10 +1277,32 @@ func (c *AwsIdentityCenterController) authorizeDevice(ctx app.Context, startUrl,
 	return output, nil
 }
 
+// getDeviceFlowToken exchanges the codes of an authorized device for an access token.
+func (c *AwsIdentityCenterController) getDeviceFlowToken(ctx app.Context, awsRegion, clientId, clientSecret, deviceCode, userCode string) (*awssso.GetTokenResponse, error) {
+	tokenRes, err := c.getToken(ctx, awsRegion, clientId, clientSecret, deviceCode, userCode)
+
+	if err != nil {
+		if errors.Is(err, awssso.ErrDeviceFlowNotAuthorized) {
+			ctx.Logger().Debug().Err(err).Msg("failed to get token because user did not authorize device")
+			return nil, ErrDeviceAuthFlowNotAuthorized
+		}
+
+		if errors.Is(err, awssso.ErrDeviceCodeExpired) {
+			ctx.Logger().Debug().Err(err).Msg("failed to get token because user and device code expired")
+			return nil, ErrDeviceAuthFlowTimedOut
+		}
+
+		ctx.Logger().Error().Err(err).Msg("failed to get token")
+		return nil, ErrTransientAwsClientError
+	}
+
+	return tokenRes, nil
+}
+
 func (c *AwsIdentityCenterController) getToken(ctx app.Context, awsRegion, clientId, clientSecret, deviceCode, userCode string) (*awssso.GetTokenResponse, error) {
 	ctx.Logger().Info().Msg("getting access token")
 	output, err := c.awsSsoClient.CreateToken(ctx,
-		awssso.AwsRegion(awsRegion),
+		awsnet.AwsRegion(awsRegion),
 		clientId,
 		clientSecret,
 		userCode,
@@ -1,14 +1,19 @@
 package awsidc
 
 import (
+	"errors"
+	"net/url"
 	"testing"
 
+	"github.com/abjrcode/swervo/clients/awsnet"
 	"github.com/abjrcode/swervo/clients/awssso"
 	"github.com/abjrcode/swervo/favorites"
 	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/credsformat"
 	"github.com/abjrcode/swervo/internal/eventing"
 	"github.com/abjrcode/swervo/internal/migrations"
-	"github.com/abjrcode/swervo/internal/security/vault"
+	"github.com/abjrcode/swervo/internal/plumbing"
+	"github.com/abjrcode/swervo/internal/providertest"
 	"github.com/abjrcode/swervo/internal/testhelpers"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -18,36 +23,67 @@ type mockAwsSsoOidcClient struct {
 	mock.Mock
 }
 
-func (m *mockAwsSsoOidcClient) RegisterClient(ctx app.Context, region awssso.AwsRegion, friendlyClientName string) (*awssso.RegistrationResponse, error) {
+func (m *mockAwsSsoOidcClient) RegisterClient(ctx app.Context, region awsnet.AwsRegion, friendlyClientName string) (*awssso.RegistrationResponse, error) {
 	args := m.Called()
 	res, _ := args.Get(0).(*awssso.RegistrationResponse)
 	return res, args.Error(1)
 }
 
-func (m *mockAwsSsoOidcClient) StartDeviceAuthorization(ctx app.Context, region awssso.AwsRegion, startUrl string, clientId, clientSecret string) (*awssso.AuthorizationResponse, error) {
+func (m *mockAwsSsoOidcClient) StartDeviceAuthorization(ctx app.Context, region awsnet.AwsRegion, startUrl string, clientId, clientSecret string) (*awssso.AuthorizationResponse, error) {
 	args := m.Called()
 	res, _ := args.Get(0).(*awssso.AuthorizationResponse)
 	return res, args.Error(1)
 }
 
-func (m *mockAwsSsoOidcClient) CreateToken(ctx app.Context, region awssso.AwsRegion, clientId, clientSecret, userCode, deviceCode string) (*awssso.GetTokenResponse, error) {
+func (m *mockAwsSsoOidcClient) CreateToken(ctx app.Context, region awsnet.AwsRegion, clientId, clientSecret, userCode, deviceCode string) (*awssso.GetTokenResponse, error) {
 	args := m.Called()
 	res, _ := args.Get(0).(*awssso.GetTokenResponse)
 	return res, args.Error(1)
 }
 
-func (m *mockAwsSsoOidcClient) ListAccounts(ctx app.Context, region awssso.AwsRegion, accessToken string) (*awssso.ListAccountsResponse, error) {
+func (m *mockAwsSsoOidcClient) RefreshToken(ctx app.Context, region awsnet.AwsRegion, clientId, clientSecret, refreshToken string) (*awssso.GetTokenResponse, error) {
+	args := m.Called()
+	res, _ := args.Get(0).(*awssso.GetTokenResponse)
+	return res, args.Error(1)
+}
+
+func (m *mockAwsSsoOidcClient) RegisterAuthorizationCodeClient(ctx app.Context, region awsnet.AwsRegion, friendlyClientName, issuerUrl, redirectUri string) (*awssso.RegistrationResponse, error) {
+	args := m.Called()
+	res, _ := args.Get(0).(*awssso.RegistrationResponse)
+	return res, args.Error(1)
+}
+
+func (m *mockAwsSsoOidcClient) AuthorizationUrl(region awsnet.AwsRegion, clientId, redirectUri, state, codeChallenge string) string {
+	query := url.Values{}
+	query.Set("client_id", clientId)
+	query.Set("redirect_uri", redirectUri)
+	query.Set("state", state)
+
+	return "https://test-authorization-url?" + query.Encode()
+}
+
+func (m *mockAwsSsoOidcClient) CreateTokenWithAuthorizationCode(ctx app.Context, region awsnet.AwsRegion, clientId, clientSecret, code, codeVerifier, redirectUri string) (*awssso.GetTokenResponse, error) {
+	args := m.Called(code)
+	res, _ := args.Get(0).(*awssso.GetTokenResponse)
+	return res, args.Error(1)
+}
+
+func (m *mockAwsSsoOidcClient) ListAccounts(ctx app.Context, region awsnet.AwsRegion, accessToken string) (*awssso.ListAccountsResponse, error) {
 	args := m.Called()
 	res, _ := args.Get(0).(*awssso.ListAccountsResponse)
 	return res, args.Error(1)
 }
 
-func (m *mockAwsSsoOidcClient) GetRoleCredentials(ctx app.Context, region awssso.AwsRegion, accountId, roleName, accessToken string) (*awssso.GetRoleCredentialsResponse, error) {
+func (m *mockAwsSsoOidcClient) GetRoleCredentials(ctx app.Context, region awsnet.AwsRegion, accountId, roleName, accessToken string) (*awssso.GetRoleCredentialsResponse, error) {
 	args := m.Called()
 	res, _ := args.Get(0).(*awssso.GetRoleCredentialsResponse)
 	return res, args.Error(1)
 }
 
+func (m *mockAwsSsoOidcClient) Configure(options awsnet.NetworkOptions) error {
+	return nil
+}
+
 func initController(t *testing.T) (*AwsIdentityCenterController, *mockAwsSsoOidcClient, *testhelpers.MockClock) {
 	db, err := migrations.NewInMemoryMigratedDatabase(t, "aws-idc-controller-tests.db")
 	require.NoError(t, err)
@@ -59,13 +95,7 @@ func initController(t *testing.T) (*AwsIdentityCenterController, *mockAwsSsoOidc
 
 	favoritesRepo := favorites.NewFavorites(db)
 
-	vault := vault.NewVault(db, bus, mockDatetime)
-	timeSetCall := mockDatetime.On("NowUnix").Return(1)
-	err = vault.Configure(testhelpers.NewMockAppContext(), "abc")
-	require.NoError(t, err)
-	controller := NewAwsIdentityCenterController(db, bus, favoritesRepo, vault, awsClient, mockDatetime)
-
-	timeSetCall.Unset()
+	controller := NewAwsIdentityCenterController(db, bus, favoritesRepo, providertest.NewOpenVault(t, db, bus, mockDatetime), awsClient, awssso.NewRegionCatalog(), mockDatetime)
 
 	return controller, awsClient, mockDatetime
 }
@@ -149,6 +179,27 @@ func TestNewAccountSetupErrorInvalidRegion(t *testing.T) {
 	require.Error(t, err, ErrInvalidAwsRegion)
 }
 
+func TestNewAccountSetup_CustomRegion(t *testing.T) {
+	controller, mockAws, _ := initController(t)
+
+	input := AwsIdc_SetupCommandInput{
+		StartUrl:  "https://test-start-url.aws-apps.com/start",
+		AwsRegion: "ap-southeast-7",
+		Label:     "test-label",
+	}
+
+	_, err := controller.Setup(testhelpers.NewMockAppContext(), input)
+	require.True(t, app.IsError(err, ErrInvalidAwsRegion))
+
+	_, err = controller.regions.AddCustomRegion("ap-southeast-7")
+	require.NoError(t, err)
+
+	mockAws.On("RegisterClient").Return(nil, awssso.ErrInvalidRequest)
+
+	_, err = controller.Setup(testhelpers.NewMockAppContext(), input)
+	require.False(t, app.IsError(err, ErrInvalidAwsRegion))
+}
+
 func TestNewAccountSetup_Error_InvalidLabel(t *testing.T) {
 	controller, _, _ := initController(t)
 
@@ -250,6 +301,7 @@ func TestNewAccount_FullSetup_Success(t *testing.T) {
 		DeviceCode:      "test-device-code",
 		ExpiresIn:       5,
 		VerificationUri: "https://test-verification-url",
+		FlowId:          setupResult.FlowId,
 	})
 
 	event := <-ch
@@ -584,6 +636,59 @@ func TestGetInstanceData(t *testing.T) {
 	require.Equal(t, "test-account-name-2", instanceData.Accounts[1].AccountName)
 }
 
+func TestGetInstanceData_AccountRolesPartiallyListed(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	mockTimeProvider.On("NowUnix").Return(3)
+
+	mockListAccountsRes := awssso.ListAccountsResponse{
+		Accounts: []awssso.AwsAccount{
+			{
+				AccountId:   "test-account-id",
+				AccountName: "test-account-name",
+				Roles: []awssso.AwsAccountRole{
+					{
+						RoleName: "test-role-name",
+					},
+				},
+			},
+			{
+				AccountId:   "test-account-id-2",
+				AccountName: "test-account-name-2",
+				Err:         errors.New("throttled"),
+			},
+		},
+	}
+	mockAws.On("ListAccounts").Return(&mockListAccountsRes, nil)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	instanceData, err := controller.GetInstanceData(ctx, instanceId, false)
+	require.NoError(t, err)
+
+	require.Len(t, instanceData.Accounts, 2)
+	require.Empty(t, instanceData.Accounts[0].Error)
+	require.Equal(t, ErrTransientAwsClientError.Error(), instanceData.Accounts[1].Error)
+	require.Empty(t, instanceData.Accounts[1].Roles)
+
+	_, err = controller.GetInstanceData(ctx, instanceId, false)
+	require.NoError(t, err)
+
+	mockAws.AssertNumberOfCalls(t, "ListAccounts", 2)
+
+	err = controller.ValidateSourceSelector(ctx, instanceId, plumbing.SourceSelector{
+		AccountId: "test-account-id-2",
+		RoleName:  "test-role-name",
+	})
+	require.True(t, app.IsError(err, ErrTransientAwsClientError))
+}
+
 func TestGetInstance_AccessTokenExpired(t *testing.T) {
 	startUrl := "https://test-start-url.aws-apps.com/start"
 	region := "eu-west-1"
@@ -594,6 +699,7 @@ func TestGetInstance_AccessTokenExpired(t *testing.T) {
 	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
 
 	mockTimeProvider.On("NowUnix").Return(10)
+	mockAws.On("RefreshToken").Return(nil, awssso.ErrInvalidRefreshToken)
 
 	ctx := testhelpers.NewMockAppContext()
 
@@ -619,6 +725,7 @@ func TestGetInstanceData_StaleAccessToken_DueToIncoherentCache(t *testing.T) {
 	mockTimeProvider.On("NowUnix").Return(3)
 
 	mockAws.On("ListAccounts").Return(nil, awssso.ErrAccessTokenExpired)
+	mockAws.On("RefreshToken").Return(nil, awssso.ErrInvalidRefreshToken)
 
 	ctx := testhelpers.NewMockAppContext()
 
@@ -643,6 +750,7 @@ func TestGetInstanceData_StaleAccessToken_DueToUserAction(t *testing.T) {
 	mockTimeProvider.On("NowUnix").Return(3)
 
 	mockAws.On("ListAccounts").Return(nil, awssso.ErrUnauthorizedAccessToken)
+	mockAws.On("RefreshToken").Return(nil, awssso.ErrInvalidRefreshToken)
 
 	ctx := testhelpers.NewMockAppContext()
 
@@ -717,6 +825,7 @@ func TestGetRoleCredentials(t *testing.T) {
 		instanceId,
 		accountId,
 		roleName,
+		false,
 	)
 
 	require.NoError(t, err)
@@ -741,6 +850,7 @@ func TestGetRoleCredentials_StaleAccessToken_DueToIncoherentCache(t *testing.T)
 	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
 
 	mockTimeProvider.On("NowUnix").Return(3)
+	mockAws.On("RefreshToken").Return(nil, awssso.ErrInvalidRefreshToken)
 
 	mockListAccountsRes := awssso.ListAccountsResponse{
 		Accounts: []awssso.AwsAccount{
@@ -767,6 +877,7 @@ func TestGetRoleCredentials_StaleAccessToken_DueToIncoherentCache(t *testing.T)
 		instanceId,
 		accountId,
 		roleName,
+		false,
 	)
 
 	require.Error(t, ErrStaleAwsAccessToken, err)
@@ -785,6 +896,7 @@ func TestGetRoleCredentials_StaleAccessToken_DueToUserAction(t *testing.T) {
 	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
 
 	mockTimeProvider.On("NowUnix").Return(3)
+	mockAws.On("RefreshToken").Return(nil, awssso.ErrInvalidRefreshToken)
 
 	mockListAccountsRes := awssso.ListAccountsResponse{
 		Accounts: []awssso.AwsAccount{
@@ -811,11 +923,82 @@ func TestGetRoleCredentials_StaleAccessToken_DueToUserAction(t *testing.T) {
 		instanceId,
 		accountId,
 		roleName,
+		false,
 	)
 
 	require.Error(t, ErrStaleAwsAccessToken, err)
 }
 
+func TestValidateSourceSelector(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	mockTimeProvider.On("NowUnix").Return(3)
+
+	mockListAccountsRes := awssso.ListAccountsResponse{
+		Accounts: []awssso.AwsAccount{
+			{
+				AccountId:    "test-account-id",
+				AccountName:  "test-account-name",
+				AccountEmail: "test-account-email",
+				Roles: []awssso.AwsAccountRole{
+					{
+						RoleName: "test-role-name",
+					},
+				},
+			},
+		},
+	}
+	mockAws.On("ListAccounts").Return(&mockListAccountsRes, nil)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	err := controller.ValidateSourceSelector(ctx, instanceId, plumbing.SourceSelector{
+		AccountId: "test-account-id",
+		RoleName:  "test-role-name",
+	})
+	require.NoError(t, err)
+
+	err = controller.ValidateSourceSelector(ctx, instanceId, plumbing.SourceSelector{
+		AccountId: "test-account-id",
+		RoleName:  "some-other-role-name",
+	})
+	require.Equal(t, ErrInvalidAccountRole, err)
+
+	err = controller.ValidateSourceSelector(ctx, instanceId, plumbing.SourceSelector{
+		AccountId: "some-other-account-id",
+		RoleName:  "test-role-name",
+	})
+	require.Equal(t, ErrInvalidAccountRole, err)
+
+	err = controller.ValidateSourceSelector(ctx, instanceId, plumbing.SourceSelector{})
+	require.Equal(t, ErrInvalidAccountRole, err)
+}
+
+func TestValidateSourceSelector_AccessTokenExpired(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	mockTimeProvider.On("NowUnix").Return(10)
+	mockAws.On("RefreshToken").Return(nil, awssso.ErrInvalidRefreshToken)
+
+	err := controller.ValidateSourceSelector(testhelpers.NewMockAppContext(), instanceId, plumbing.SourceSelector{
+		AccountId: "test-account-id",
+		RoleName:  "test-role-name",
+	})
+	require.Equal(t, ErrStaleAwsAccessToken, err)
+}
+
 func TestMarkInstanceAsFavorite(t *testing.T) {
 	controller, mockAws, mockTimeProvider := initController(t)
 
@@ -931,6 +1114,7 @@ func TestRefreshAccessToken(t *testing.T) {
 		UserCode:        "test-user-code-2",
 		DeviceCode:      "test-device-code-2",
 		ExpiresIn:       20,
+		FlowId:          refreshRes.FlowId,
 	}, refreshRes)
 }
 
@@ -977,6 +1161,61 @@ func TestFinalizeRefreshAccessToken(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestFinalizeRefreshAccessToken_CustomRegionWasRemoved(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "ap-southeast-7"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	_, err := controller.regions.AddCustomRegion(region)
+	require.NoError(t, err)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	require.NoError(t, controller.regions.RemoveCustomRegion(region))
+
+	mockAuthRes := awssso.AuthorizationResponse{
+		DeviceCode:              "test-device-code-2",
+		UserCode:                "test-user-code-2",
+		VerificationUriComplete: "https://test-verification-url-2",
+		ExpiresIn:               20,
+	}
+	mockAws.On("StartDeviceAuthorization").Return(&mockAuthRes, nil)
+
+	mockTimeProvider.On("NowUnix").Return(10)
+	refreshRes, err := controller.RefreshAccessToken(ctx, instanceId)
+	require.NoError(t, err)
+	require.Equal(t, region, refreshRes.Region)
+
+	mockTokenRes := awssso.GetTokenResponse{
+		IdToken:      "test-id-token-2",
+		AccessToken:  "test-access-token-2",
+		RefreshToken: "test-refresh-token-2",
+		TokenType:    "test-token-type-2",
+		ExpiresIn:    15,
+	}
+	mockAws.On("CreateToken").Return(&mockTokenRes, nil)
+
+	err = controller.FinalizeRefreshAccessToken(ctx, AwsIdc_FinalizeRefreshAccessTokenCommandInput{
+		InstanceId: instanceId,
+		Region:     region,
+		UserCode:   refreshRes.UserCode,
+		DeviceCode: refreshRes.DeviceCode,
+	})
+	require.NoError(t, err)
+
+	err = controller.FinalizeRefreshAccessToken(ctx, AwsIdc_FinalizeRefreshAccessTokenCommandInput{
+		InstanceId: instanceId,
+		Region:     "eu-west-1",
+		UserCode:   refreshRes.UserCode,
+		DeviceCode: refreshRes.DeviceCode,
+	})
+	require.True(t, app.IsError(err, ErrInvalidAwsRegion))
+}
+
 func TestRefresh_NonExistentInstance(t *testing.T) {
 	startUrl := "https://test-start-url.aws-apps.com/start"
 	region := "eu-west-1"
@@ -1155,3 +1394,52 @@ func TestAwsClientExpires(t *testing.T) {
 	})
 	require.NoError(t, err)
 }
+
+func TestFormatRoleCredentials(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, "https://test-start-url.aws-apps.com/start", "eu-west-1", "test_label")
+
+	mockTimeProvider.On("NowUnix").Return(3)
+
+	mockAws.On("GetRoleCredentials").Return(&awssso.GetRoleCredentialsResponse{
+		AccessKeyId:     "test-access-key-id",
+		SecretAccessKey: "test-secret-key",
+		SessionToken:    "test-session-token",
+		Expiration:      1700000000 * 1000,
+	}, nil)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	output, err := controller.FormatRoleCredentials(ctx, AwsIdc_CopyRoleCredentialsCommandInput{
+		InstanceId: instanceId,
+		AccountId:  "test-account-id",
+		RoleName:   "test-role-name",
+		Format:     "fish",
+		Region:     "eu-central-1",
+	})
+	require.NoError(t, err)
+	require.Equal(t, `set -gx AWS_ACCESS_KEY_ID 'test-access-key-id'
+set -gx AWS_SECRET_ACCESS_KEY 'test-secret-key'
+set -gx AWS_SESSION_TOKEN 'test-session-token'
+set -gx AWS_CREDENTIAL_EXPIRATION '2023-11-14T22:13:20Z'
+set -gx AWS_REGION 'eu-central-1'`, output)
+
+	_, err = controller.FormatRoleCredentials(ctx, AwsIdc_CopyRoleCredentialsCommandInput{
+		InstanceId: instanceId,
+		AccountId:  "test-account-id",
+		RoleName:   "test-role-name",
+		Format:     "tcsh",
+	})
+	require.Same(t, credsformat.ErrUnsupportedFormat, err)
+
+	_, err = controller.FormatRoleCredentials(ctx, AwsIdc_CopyRoleCredentialsCommandInput{
+		InstanceId: instanceId,
+		AccountId:  "test-account-id",
+		RoleName:   "test-role-name",
+		Region:     "mars-north-1",
+	})
+	require.Same(t, ErrInvalidAwsRegion, err)
+
+	require.Contains(t, controller.ListCredentialsFormats(), "credential_process")
+}
@@ -0,0 +1,231 @@
+package awsidc
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/internal/app"
+	wailsRuntime "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+var (
+	ErrInvalidConsoleDestination = app.NewValidationError("INVALID_CONSOLE_DESTINATION")
+	ErrConsoleSigninFailed       = app.NewValidationError("CONSOLE_SIGNIN_FAILED")
+)
+
+// consoleIssuer is shown by the console as where the user signed in from
+const consoleIssuer = "https://github.com/abjrcode/swervo"
+
+var consoleServiceRegex = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+var federationEndpoints = map[awssso.Partition]string{
+	awssso.PartitionAws:      "https://signin.aws.amazon.com/federation",
+	awssso.PartitionAwsCn:    "https://signin.amazonaws.cn/federation",
+	awssso.PartitionAwsUsGov: "https://signin.amazonaws-us-gov.com/federation",
+}
+
+var consoleDomains = map[awssso.Partition]string{
+	awssso.PartitionAws:      "console.aws.amazon.com",
+	awssso.PartitionAwsCn:    "console.amazonaws.cn",
+	awssso.PartitionAwsUsGov: "console.amazonaws-us-gov.com",
+}
+
+func defaultFederationEndpoint(partition awssso.Partition) string {
+	return federationEndpoints[partition]
+}
+
+// consoleDestination is where the console lands after signing in,
+// the home page unless a service is given, in the region if one is given
+func consoleDestination(partition awssso.Partition, service, region string) string {
+	domain := consoleDomains[partition]
+
+	if region != "" {
+		domain = region + "." + domain
+	}
+
+	if service == "" {
+		if region == "" {
+			return "https://" + domain + "/"
+		}
+
+		return fmt.Sprintf("https://%s/console/home?region=%s", domain, region)
+	}
+
+	if region == "" {
+		return fmt.Sprintf("https://%s/%s/home", domain, service)
+	}
+
+	return fmt.Sprintf("https://%s/%s/home?region=%s", domain, service, region)
+}
+
+// firefoxContainerUrl opens a url in a container tab through the "Open external links in a container" extension
+func firefoxContainerUrl(container, target string) string {
+	return "ext+container:" + url.Values{"name": {container}, "url": {target}}.Encode()
+}
+
+type AwsIdc_OpenConsoleCommandInput struct {
+	InstanceId string `json:"instanceId"`
+	AccountId  string `json:"accountId"`
+	RoleName   string `json:"roleName"`
+
+	// Service deep links into the console of a service, e.g. "s3" or "ec2"
+	Service string `json:"service"`
+	// Region of the console, the default one of the console when empty
+	Region string `json:"region"`
+
+	// FirefoxContainer opens the console in the named Firefox container tab unless empty
+	FirefoxContainer string `json:"firefoxContainer"`
+}
+
+func (c *AwsIdentityCenterController) getSigninToken(ctx app.Context, endpoint string, credentials *awsRoleCredentials) (string, error) {
+	session, err := json.Marshal(map[string]string{
+		"sessionId":    credentials.AccessKeyId,
+		"sessionKey":   credentials.SecretAccessKey,
+		"sessionToken": credentials.SessionToken,
+	})
+
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	// credentials are sent in the body rather than the query so that they do not end up in logs along the way
+	body := url.Values{"Action": {"getSigninToken"}, "Session": {string(session)}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(body.Encode()))
+
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := c.getFederationClient().Do(req)
+
+	if err != nil {
+		ctx.Logger().Error().Err(err).Msg("failed to reach the federation endpoint")
+		return "", ErrTransientAwsClientError
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		ctx.Logger().Error().Msgf("federation endpoint refused to issue a sign-in token with status [%d]", res.StatusCode)
+		return "", ErrConsoleSigninFailed
+	}
+
+	var result struct {
+		SigninToken string
+	}
+
+	if err := json.NewDecoder(io.LimitReader(res.Body, 64*1024)).Decode(&result); err != nil || result.SigninToken == "" {
+		ctx.Logger().Error().Err(err).Msg("federation endpoint returned an invalid response")
+		return "", ErrConsoleSigninFailed
+	}
+
+	return result.SigninToken, nil
+}
+
+// consoleUrl returns a url that signs into the AWS console with the credentials of a role.
+// Whoever has the url is signed in, it must not be shown or logged.
+func (c *AwsIdentityCenterController) consoleUrl(ctx app.Context, input AwsIdc_OpenConsoleCommandInput) (string, error) {
+	if input.Service != "" && !consoleServiceRegex.MatchString(input.Service) {
+		return "", ErrInvalidConsoleDestination
+	}
+
+	if input.Region != "" {
+		if err := c.validateAwsRegion(input.Region); err != nil {
+			return "", ErrInvalidConsoleDestination
+		}
+	}
+
+	var instanceRegion string
+
+	if err := c.db.QueryRowContext(ctx, "SELECT region FROM aws_idc WHERE instance_id = ?", input.InstanceId).Scan(&instanceRegion); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrInstanceWasNotFound
+		}
+
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	partition := awssso.PartitionOf(awsnet.AwsRegion(instanceRegion))
+
+	credentials, err := c.getRoleCredentials(ctx, input.InstanceId, input.AccountId, input.RoleName, false)
+
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := c.federationEndpoint(partition)
+
+	signinToken, err := c.getSigninToken(ctx, endpoint, credentials)
+
+	if err != nil {
+		return "", err
+	}
+
+	login := endpoint + "?" + url.Values{
+		"Action":      {"login"},
+		"Issuer":      {consoleIssuer},
+		"Destination": {consoleDestination(partition, input.Service, input.Region)},
+		"SigninToken": {signinToken},
+	}.Encode()
+
+	if input.FirefoxContainer != "" {
+		return firefoxContainerUrl(input.FirefoxContainer, login), nil
+	}
+
+	return login, nil
+}
+
+// OpenConsole signs into the AWS console with the credentials of a role in the default browser.
+func (c *AwsIdentityCenterController) OpenConsole(ctx app.Context, input AwsIdc_OpenConsoleCommandInput) error {
+	signinUrl, err := c.consoleUrl(ctx, input)
+
+	if err != nil {
+		return err
+	}
+
+	wailsRuntime.BrowserOpenURL(ctx, signinUrl)
+
+	return nil
+}
+
+func newFederationClient() *http.Client {
+	// the default network options are always valid
+	httpClient, _ := awsnet.NewHttpClient(awsnet.DefaultNetworkOptions)
+
+	return httpClient
+}
+
+// ConfigureConsoleNetwork applies the proxy, CA bundle and timeout of network options to console sign-ins that start from now on.
+func (c *AwsIdentityCenterController) ConfigureConsoleNetwork(options awsnet.NetworkOptions) error {
+	httpClient, err := awsnet.NewHttpClient(options)
+
+	if err != nil {
+		return err
+	}
+
+	c.federationClientMu.Lock()
+	defer c.federationClientMu.Unlock()
+
+	c.federationClient = httpClient
+
+	return nil
+}
+
+func (c *AwsIdentityCenterController) getFederationClient() *http.Client {
+	c.federationClientMu.RLock()
+	defer c.federationClientMu.RUnlock()
+
+	return c.federationClient
+}
@@ -0,0 +1,192 @@
+package awsidc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/stretchr/testify/require"
+)
+
+func newFederationServer(t *testing.T, status int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Empty(t, r.URL.RawQuery, "credentials must not be sent in the query")
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "getSigninToken", r.PostForm.Get("Action"))
+
+		var session map[string]string
+		require.NoError(t, json.Unmarshal([]byte(r.PostForm.Get("Session")), &session))
+		require.Equal(t, map[string]string{
+			"sessionId":    "test-access-key-id",
+			"sessionKey":   "test-secret-key",
+			"sessionToken": "test-session-token",
+		}, session)
+
+		w.WriteHeader(status)
+
+		if status == http.StatusOK {
+			w.Write([]byte(`{"SigninToken":"test-signin-token"}`))
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func initConsoleController(t *testing.T, region string, federationStatus int) (*AwsIdentityCenterController, string, *awssso.Partition) {
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, "https://test-start-url.aws-apps.com/start", region, "test_label")
+
+	mockTimeProvider.On("NowUnix").Return(3)
+	mockAws.On("GetRoleCredentials").Return(&awssso.GetRoleCredentialsResponse{
+		AccessKeyId:     "test-access-key-id",
+		SecretAccessKey: "test-secret-key",
+		SessionToken:    "test-session-token",
+		Expiration:      3600 * 1000,
+	}, nil)
+
+	server := newFederationServer(t, federationStatus)
+
+	var requestedPartition awssso.Partition
+
+	controller.federationEndpoint = func(partition awssso.Partition) string {
+		requestedPartition = partition
+		return server.URL + "/federation"
+	}
+
+	return controller, instanceId, &requestedPartition
+}
+
+func TestConsoleUrl(t *testing.T) {
+	controller, instanceId, partition := initConsoleController(t, "eu-west-1", http.StatusOK)
+	ctx := testhelpers.NewMockAppContext()
+
+	testCases := []struct {
+		name        string
+		service     string
+		region      string
+		destination string
+	}{
+		{name: "home", destination: "https://console.aws.amazon.com/"},
+		{name: "region", region: "eu-central-1", destination: "https://eu-central-1.console.aws.amazon.com/console/home?region=eu-central-1"},
+		{name: "service", service: "s3", destination: "https://console.aws.amazon.com/s3/home"},
+		{name: "service in region", service: "ec2", region: "us-east-1", destination: "https://us-east-1.console.aws.amazon.com/ec2/home?region=us-east-1"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			signinUrl, err := controller.consoleUrl(ctx, AwsIdc_OpenConsoleCommandInput{
+				InstanceId: instanceId,
+				AccountId:  "test-account-id",
+				RoleName:   "test-role-name",
+				Service:    testCase.service,
+				Region:     testCase.region,
+			})
+			require.NoError(t, err)
+			require.Equal(t, awssso.PartitionAws, *partition)
+
+			parsed, err := url.Parse(signinUrl)
+			require.NoError(t, err)
+			require.Equal(t, "/federation", parsed.Path)
+			require.Equal(t, "login", parsed.Query().Get("Action"))
+			require.Equal(t, "test-signin-token", parsed.Query().Get("SigninToken"))
+			require.Equal(t, consoleIssuer, parsed.Query().Get("Issuer"))
+			require.Equal(t, testCase.destination, parsed.Query().Get("Destination"))
+		})
+	}
+}
+
+func TestConsoleUrl_FirefoxContainer(t *testing.T) {
+	controller, instanceId, _ := initConsoleController(t, "eu-west-1", http.StatusOK)
+
+	signinUrl, err := controller.consoleUrl(testhelpers.NewMockAppContext(), AwsIdc_OpenConsoleCommandInput{
+		InstanceId:       instanceId,
+		AccountId:        "test-account-id",
+		RoleName:         "test-role-name",
+		FirefoxContainer: "prod admin",
+	})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(signinUrl, "ext+container:"))
+
+	params, err := url.ParseQuery(strings.TrimPrefix(signinUrl, "ext+container:"))
+	require.NoError(t, err)
+	require.Equal(t, "prod admin", params.Get("name"))
+
+	target, err := url.Parse(params.Get("url"))
+	require.NoError(t, err)
+	require.Equal(t, "test-signin-token", target.Query().Get("SigninToken"))
+}
+
+func TestConsoleUrl_OtherPartitions(t *testing.T) {
+	controller, instanceId, partition := initConsoleController(t, "us-gov-west-1", http.StatusOK)
+
+	signinUrl, err := controller.consoleUrl(testhelpers.NewMockAppContext(), AwsIdc_OpenConsoleCommandInput{
+		InstanceId: instanceId,
+		AccountId:  "test-account-id",
+		RoleName:   "test-role-name",
+		Service:    "iam",
+	})
+	require.NoError(t, err)
+	require.Equal(t, awssso.PartitionAwsUsGov, *partition)
+
+	parsed, err := url.Parse(signinUrl)
+	require.NoError(t, err)
+	require.Equal(t, "https://console.amazonaws-us-gov.com/iam/home", parsed.Query().Get("Destination"))
+
+	require.Equal(t, "https://signin.amazonaws.cn/federation", defaultFederationEndpoint(awssso.PartitionAwsCn))
+	require.Equal(t, "https://cn-north-1.console.amazonaws.cn/console/home?region=cn-north-1", consoleDestination(awssso.PartitionAwsCn, "", "cn-north-1"))
+}
+
+func TestConsoleUrl_Errors(t *testing.T) {
+	controller, instanceId, _ := initConsoleController(t, "eu-west-1", http.StatusBadRequest)
+	ctx := testhelpers.NewMockAppContext()
+
+	input := AwsIdc_OpenConsoleCommandInput{
+		InstanceId: instanceId,
+		AccountId:  "test-account-id",
+		RoleName:   "test-role-name",
+	}
+
+	_, err := controller.consoleUrl(ctx, input)
+	require.Same(t, ErrConsoleSigninFailed, err)
+
+	invalidService := input
+	invalidService.Service = "s3/../../evil"
+	_, err = controller.consoleUrl(ctx, invalidService)
+	require.Same(t, ErrInvalidConsoleDestination, err)
+
+	invalidRegion := input
+	invalidRegion.Region = "mars-north-1"
+	_, err = controller.consoleUrl(ctx, invalidRegion)
+	require.Same(t, ErrInvalidConsoleDestination, err)
+
+	unknownInstance := input
+	unknownInstance.InstanceId = "unknown-instance-id"
+	_, err = controller.consoleUrl(ctx, unknownInstance)
+	require.Same(t, ErrInstanceWasNotFound, err)
+}
+
+func TestConfigureConsoleNetwork(t *testing.T) {
+	controller, _, _ := initConsoleController(t, "eu-west-1", http.StatusOK)
+	defaultClient := controller.getFederationClient()
+
+	invalidCaBundle := awsnet.DefaultNetworkOptions
+	invalidCaBundle.CaBundlePem = "not a certificate"
+	require.Same(t, awsnet.ErrInvalidCaBundle, controller.ConfigureConsoleNetwork(invalidCaBundle))
+	require.Same(t, defaultClient, controller.getFederationClient())
+
+	longerTimeout := awsnet.DefaultNetworkOptions
+	longerTimeout.RequestTimeout = 2 * time.Minute
+	require.NoError(t, controller.ConfigureConsoleNetwork(longerTimeout))
+	require.Equal(t, 2*time.Minute, controller.getFederationClient().Timeout)
+}
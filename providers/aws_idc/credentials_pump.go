@@ -0,0 +1,130 @@
+package awsidc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/plumbing"
+)
+
+var pumpReconcileInterval = 1 * time.Minute
+
+func newCredentialsPump(c *AwsIdentityCenterController) *plumbing.Pump[AwsCredentials] {
+	options := plumbing.DefaultPumpOptions
+
+	options.IsRetryable = func(err error) bool {
+		return app.IsError(err, ErrTransientAwsClientError)
+	}
+
+	options.IsStopping = func(err error) bool {
+		return app.IsError(err, ErrStaleAwsAccessToken) || app.IsError(err, ErrInstanceWasNotFound)
+	}
+
+	return plumbing.NewPump(c.pumpRoleCredentials, c.clock, options)
+}
+
+func (c *AwsIdentityCenterController) pumpRoleCredentials(ctx app.Context, pipe plumbing.Pipe) (AwsCredentials, int64, error) {
+	// the pump already refreshes shortly before credentials expire, cached ones would be too close to expiring
+	res, err := c.getRoleCredentials(ctx, pipe.ProviderId, pipe.SourceSelector.AccountId, pipe.SourceSelector.RoleName, true)
+
+	if err != nil {
+		return AwsCredentials{}, 0, err
+	}
+
+	return AwsCredentials{
+		AccessKeyID:     res.AccessKeyId,
+		SecretAccessKey: res.SecretAccessKey,
+		SessionToken:    res.SessionToken,
+	}, time.UnixMilli(res.Expiration).Unix(), nil
+}
+
+// desiredPipes lists the connected sinks of every instance that still holds a valid access token.
+// Expired access tokens are renewed with the refresh token of their instance where possible.
+func (c *AwsIdentityCenterController) desiredPipes(ctx app.Context) (map[plumbing.Pipe]plumbing.Plumber[AwsCredentials], error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT instance_id, access_token_created_at + access_token_expires_in > ? FROM aws_idc", c.clock.NowUnix())
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+	defer rows.Close()
+
+	instanceIds := make([]string, 0)
+	expiredInstanceIds := make([]string, 0)
+
+	for rows.Next() {
+		var instanceId string
+		var isAccessTokenValid bool
+
+		if err := rows.Scan(&instanceId, &isAccessTokenValid); err != nil {
+			return nil, errors.Join(err, app.ErrFatal)
+		}
+
+		if isAccessTokenValid {
+			instanceIds = append(instanceIds, instanceId)
+		} else {
+			expiredInstanceIds = append(expiredInstanceIds, instanceId)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	rows.Close()
+
+	for _, instanceId := range expiredInstanceIds {
+		refreshed, err := c.tryRefreshAccessTokenSilently(ctx, instanceId)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if refreshed {
+			instanceIds = append(instanceIds, instanceId)
+		}
+	}
+
+	desired := make(map[plumbing.Pipe]plumbing.Plumber[AwsCredentials])
+
+	for _, instanceId := range instanceIds {
+		for _, plumber := range c.plumbers {
+			sinks, err := plumber.ListConnectedSinks(ctx, ProviderCode, instanceId)
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, sink := range sinks {
+				if sink.SourceSelector.IsEmpty() {
+					ctx.Logger().Debug().Msgf("sink [%s] of instance [%s] has no source selector, skipping", sink.SinkId, instanceId)
+					continue
+				}
+
+				desired[plumbing.Pipe{
+					ProviderCode:   ProviderCode,
+					ProviderId:     instanceId,
+					SinkCode:       sink.SinkCode,
+					SinkId:         sink.SinkId,
+					SourceSelector: sink.SourceSelector,
+				}] = plumber
+			}
+		}
+	}
+
+	return desired, nil
+}
+
+// StartCredentialsPump keeps the sinks connected to every instance supplied with fresh role credentials
+// until [AwsIdentityCenterController.StopCredentialsPump] is called.
+// It requires an unsealed vault to decrypt access tokens.
+func (c *AwsIdentityCenterController) StartCredentialsPump(ctx app.Context) {
+	if c.pump.Start(ctx, pumpReconcileInterval, c.desiredPipes) {
+		ctx.Logger().Info().Msg("started credentials pump")
+	}
+}
+
+// StopCredentialsPump stops refreshing sinks and waits for in-flight refreshes to finish.
+func (c *AwsIdentityCenterController) StopCredentialsPump() {
+	c.pump.Stop()
+}
@@ -0,0 +1,110 @@
+package awsidc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/internal/plumbing"
+	"github.com/abjrcode/swervo/internal/providertest"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialsPump_FlowsRoleCredentialsIntoSinks(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	mockTimeProvider.On("NowUnix").Return(3)
+
+	mockAws.On("GetRoleCredentials").Return(&awssso.GetRoleCredentialsResponse{
+		AccessKeyId:     "test-access-key-id",
+		SecretAccessKey: "test-secret-key",
+		SessionToken:    "test-session-token",
+		Expiration:      3600 * 1000,
+	}, nil)
+
+	plumber := providertest.NewFakePlumber[AwsCredentials](plumbing.SinkInstance{
+		SinkCode: "fake-sink",
+		SinkId:   "selected-sink",
+		SourceSelector: plumbing.SourceSelector{
+			AccountId: "test-account-id",
+			RoleName:  "test-role-name",
+		},
+	}, plumbing.SinkInstance{
+		SinkCode: "fake-sink",
+		SinkId:   "unselected-sink",
+	})
+	controller.AddPlumbers(plumber)
+
+	controller.StartCredentialsPump(testhelpers.NewMockAppContext())
+	t.Cleanup(controller.StopCredentialsPump)
+
+	select {
+	case creds := <-plumber.Flows:
+		require.Equal(t, AwsCredentials{
+			AccessKeyID:     "test-access-key-id",
+			SecretAccessKey: "test-secret-key",
+			SessionToken:    "test-session-token",
+		}, creds)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for credentials to flow")
+	}
+
+	require.Equal(t, []plumbing.Pipe{{
+		ProviderCode: ProviderCode,
+		ProviderId:   instanceId,
+		SinkCode:     "fake-sink",
+		SinkId:       "selected-sink",
+		SourceSelector: plumbing.SourceSelector{
+			AccountId: "test-account-id",
+			RoleName:  "test-role-name",
+		},
+	}}, controller.pump.Pipes())
+}
+
+func TestCredentialsPump_StopsWhenAccessTokenGoesStale(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	_ = simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	mockTimeProvider.On("NowUnix").Return(3)
+
+	mockAws.On("GetRoleCredentials").Return(nil, awssso.ErrAccessTokenExpired)
+	mockAws.On("RefreshToken").Return(nil, awssso.ErrInvalidRefreshToken)
+
+	plumber := providertest.NewFakePlumber[AwsCredentials](plumbing.SinkInstance{
+		SinkCode: "fake-sink",
+		SinkId:   "selected-sink",
+		SourceSelector: plumbing.SourceSelector{
+			AccountId: "test-account-id",
+			RoleName:  "test-role-name",
+		},
+	})
+	controller.AddPlumbers(plumber)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	desired, err := controller.desiredPipes(ctx)
+	require.NoError(t, err)
+
+	controller.pump.Reconcile(ctx, desired)
+	t.Cleanup(controller.pump.Stop)
+
+	require.Eventually(t, func() bool {
+		return len(controller.pump.Pipes()) == 0
+	}, 2*time.Second, 5*time.Millisecond)
+
+	desired, err = controller.desiredPipes(ctx)
+	require.NoError(t, err)
+	require.Empty(t, desired)
+}
@@ -0,0 +1,253 @@
+package awsidc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/eventing"
+	"github.com/segmentio/ksuid"
+)
+
+var AwsIdcDeviceFlowEventSource = eventing.EventSource("AwsIdcDeviceFlow")
+
+const (
+	// defaultDeviceFlowInterval is used when the server does not specify a polling interval
+	defaultDeviceFlowInterval = 5 * time.Second
+	// slowDownIncrement is added to the polling interval whenever the server asks to slow down, see RFC 8628
+	slowDownIncrement = 5 * time.Second
+)
+
+type DeviceFlowStatus string
+
+const (
+	DeviceFlowPending    DeviceFlowStatus = "PENDING"
+	DeviceFlowSlowDown   DeviceFlowStatus = "SLOW_DOWN"
+	DeviceFlowAuthorized DeviceFlowStatus = "AUTHORIZED"
+	DeviceFlowExpired    DeviceFlowStatus = "EXPIRED"
+	DeviceFlowDenied     DeviceFlowStatus = "DENIED"
+	DeviceFlowCancelled  DeviceFlowStatus = "CANCELLED"
+	DeviceFlowFailed     DeviceFlowStatus = "FAILED"
+)
+
+// AwsIdcDeviceFlowProgressEvent is published after every attempt to get a token while polling a device flow.
+type AwsIdcDeviceFlowProgressEvent struct {
+	FlowId string
+
+	Status DeviceFlowStatus
+	// Attempt is the number of times a token was requested so far
+	Attempt int
+	// IntervalSeconds is the time until the next attempt
+	IntervalSeconds int
+}
+
+type deviceFlow struct {
+	flowId     string
+	region     string
+	deviceCode string
+	userCode   string
+	interval   int32
+	expiresIn  int32
+}
+
+func waitFor(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// publishDeviceFlowProgress tells the UI how the flow is going.
+// Progress is best effort and does not affect the flow, so it is published even if the flow was cancelled.
+func (c *AwsIdentityCenterController) publishDeviceFlowProgress(ctx app.Context, event AwsIdcDeviceFlowProgressEvent, version *uint) {
+	*version++
+
+	publishCtx := app.NewContext(context.WithoutCancel(ctx), ctx.UserId(), ctx.RequestId(), ctx.CausationId(), ctx.CorrelationId(), ctx.Logger())
+
+	err := c.bus.Publish(publishCtx, event, eventing.EventMeta{
+		SourceType:   AwsIdcDeviceFlowEventSource,
+		SourceId:     event.FlowId,
+		EventVersion: *version,
+	})
+
+	if err != nil {
+		ctx.Logger().Warn().Err(err).Msgf("failed to publish progress of device flow [%s]", event.FlowId)
+	}
+}
+
+// pollDeviceFlow requests a token at the interval specified by the server until the user authorizes the device,
+// the device code expires, the user denies access, or the flow is cancelled through ctx or [AwsIdentityCenterController.CancelDeviceFlow].
+func (c *AwsIdentityCenterController) pollDeviceFlow(ctx app.Context, flow deviceFlow, clientId, clientSecret string) (*awssso.GetTokenResponse, error) {
+	if flow.flowId == "" {
+		flow.flowId = ksuid.New().String()
+	}
+
+	// a flow that is polled again after being cancelled continues its event stream
+	var version uint
+	err := c.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(event_version), 0) FROM event_log WHERE source_type = ? AND source_id = ?",
+		AwsIdcDeviceFlowEventSource, flow.flowId).Scan(&version)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	flowCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c.deviceFlowsMu.Lock()
+	c.deviceFlows[flow.flowId] = cancel
+	c.deviceFlowsMu.Unlock()
+
+	defer func() {
+		c.deviceFlowsMu.Lock()
+		delete(c.deviceFlows, flow.flowId)
+		c.deviceFlowsMu.Unlock()
+	}()
+
+	appCtx := app.NewContext(flowCtx, ctx.UserId(), ctx.RequestId(), ctx.CausationId(), ctx.CorrelationId(), ctx.Logger())
+
+	interval := time.Duration(flow.interval) * time.Second
+	if interval <= 0 {
+		interval = defaultDeviceFlowInterval
+	}
+
+	var deadline int64
+	if flow.expiresIn > 0 {
+		deadline = c.clock.NowUnix() + int64(flow.expiresIn)
+	}
+
+	progress := func(status DeviceFlowStatus, attempt int) {
+		c.publishDeviceFlowProgress(ctx, AwsIdcDeviceFlowProgressEvent{
+			FlowId:          flow.flowId,
+			Status:          status,
+			Attempt:         attempt,
+			IntervalSeconds: int(interval / time.Second),
+		}, &version)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := c.wait(flowCtx, interval); err != nil {
+			ctx.Logger().Info().Msgf("device flow [%s] was cancelled", flow.flowId)
+			progress(DeviceFlowCancelled, attempt-1)
+			return nil, ErrDeviceAuthFlowCancelled
+		}
+
+		if deadline != 0 && c.clock.NowUnix() >= deadline {
+			ctx.Logger().Info().Msgf("device flow [%s] expired", flow.flowId)
+			progress(DeviceFlowExpired, attempt-1)
+			return nil, ErrDeviceAuthFlowTimedOut
+		}
+
+		tokenRes, err := c.awsSsoClient.CreateToken(appCtx, awsnet.AwsRegion(flow.region), clientId, clientSecret, flow.userCode, flow.deviceCode)
+
+		switch {
+		case err == nil:
+			progress(DeviceFlowAuthorized, attempt)
+			return tokenRes, nil
+		case flowCtx.Err() != nil:
+			ctx.Logger().Info().Msgf("device flow [%s] was cancelled", flow.flowId)
+			progress(DeviceFlowCancelled, attempt)
+			return nil, ErrDeviceAuthFlowCancelled
+		case errors.Is(err, awssso.ErrDeviceFlowNotAuthorized):
+			progress(DeviceFlowPending, attempt)
+		case errors.Is(err, awssso.ErrSlowDown):
+			interval += slowDownIncrement
+			ctx.Logger().Debug().Msgf("slowing down device flow [%s] to %s", flow.flowId, interval)
+			progress(DeviceFlowSlowDown, attempt)
+		case errors.Is(err, awssso.ErrDeviceCodeExpired):
+			progress(DeviceFlowExpired, attempt)
+			return nil, ErrDeviceAuthFlowTimedOut
+		case errors.Is(err, awssso.ErrDeviceFlowAccessDenied):
+			progress(DeviceFlowDenied, attempt)
+			return nil, ErrDeviceAuthFlowDenied
+		default:
+			ctx.Logger().Error().Err(err).Msgf("failed to poll device flow [%s]", flow.flowId)
+			progress(DeviceFlowFailed, attempt)
+			return nil, ErrTransientAwsClientError
+		}
+	}
+}
+
+// CancelDeviceFlow stops polling a device flow. It does nothing if the flow is not being polled.
+func (c *AwsIdentityCenterController) CancelDeviceFlow(ctx app.Context, flowId string) {
+	c.deviceFlowsMu.Lock()
+	cancel, ok := c.deviceFlows[flowId]
+	c.deviceFlowsMu.Unlock()
+
+	if ok {
+		ctx.Logger().Info().Msgf("cancelling device flow [%s]", flowId)
+		cancel()
+	}
+}
+
+type AwsIdc_AwaitSetupCommandInput struct {
+	FlowId     string `json:"flowId"`
+	ClientId   string `json:"clientId"`
+	StartUrl   string `json:"startUrl"`
+	AwsRegion  string `json:"awsRegion"`
+	Label      string `json:"label"`
+	UserCode   string `json:"userCode"`
+	DeviceCode string `json:"deviceCode"`
+	Interval   int32  `json:"interval"`
+	ExpiresIn  int32  `json:"expiresIn"`
+}
+
+// AwaitSetup polls the device flow started by [AwsIdentityCenterController.Setup]
+// and creates the instance as soon as the user authorizes the device.
+func (c *AwsIdentityCenterController) AwaitSetup(ctx app.Context, input AwsIdc_AwaitSetupCommandInput) (string, error) {
+	return c.finalizeSetup(ctx, AwsIdc_FinalizeSetupCommandInput{
+		ClientId:   input.ClientId,
+		StartUrl:   input.StartUrl,
+		AwsRegion:  input.AwsRegion,
+		Label:      input.Label,
+		UserCode:   input.UserCode,
+		DeviceCode: input.DeviceCode,
+	}, LoginFlowDeviceCode, func(clientId, clientSecret string) (*awssso.GetTokenResponse, error) {
+		return c.pollDeviceFlow(ctx, deviceFlow{
+			flowId:     input.FlowId,
+			region:     input.AwsRegion,
+			deviceCode: input.DeviceCode,
+			userCode:   input.UserCode,
+			interval:   input.Interval,
+			expiresIn:  input.ExpiresIn,
+		}, clientId, clientSecret)
+	})
+}
+
+type AwsIdc_AwaitRefreshAccessTokenCommandInput struct {
+	FlowId     string `json:"flowId"`
+	InstanceId string `json:"instanceId"`
+	Region     string `json:"region"`
+	UserCode   string `json:"userCode"`
+	DeviceCode string `json:"deviceCode"`
+	Interval   int32  `json:"interval"`
+	ExpiresIn  int32  `json:"expiresIn"`
+}
+
+// AwaitRefreshAccessToken polls the device flow started by [AwsIdentityCenterController.RefreshAccessToken]
+// and stores the new access token as soon as the user authorizes the device.
+func (c *AwsIdentityCenterController) AwaitRefreshAccessToken(ctx app.Context, input AwsIdc_AwaitRefreshAccessTokenCommandInput) error {
+	return c.finalizeRefreshAccessToken(ctx, AwsIdc_FinalizeRefreshAccessTokenCommandInput{
+		InstanceId: input.InstanceId,
+		Region:     input.Region,
+		UserCode:   input.UserCode,
+		DeviceCode: input.DeviceCode,
+	}, LoginFlowDeviceCode, func(clientId, clientSecret string) (*awssso.GetTokenResponse, error) {
+		return c.pollDeviceFlow(ctx, deviceFlow{
+			flowId:     input.FlowId,
+			region:     input.Region,
+			deviceCode: input.DeviceCode,
+			userCode:   input.UserCode,
+			interval:   input.Interval,
+			expiresIn:  input.ExpiresIn,
+		}, clientId, clientSecret)
+	})
+}
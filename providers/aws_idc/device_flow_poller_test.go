@@ -0,0 +1,302 @@
+package awsidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/stretchr/testify/require"
+)
+
+func startDeviceFlow(t *testing.T, controller *AwsIdentityCenterController, mockAws *mockAwsSsoOidcClient, interval int32) *AuthorizeDeviceFlowResult {
+	mockAws.On("RegisterClient").Return(&awssso.RegistrationResponse{
+		ClientId:     "test-client-id",
+		ClientSecret: "test-client-secret",
+		CreatedAt:    1,
+		ExpiresAt:    1000,
+	}, nil)
+
+	mockAws.On("StartDeviceAuthorization").Return(&awssso.AuthorizationResponse{
+		DeviceCode:              "test-device-code",
+		UserCode:                "test-user-code",
+		VerificationUriComplete: "https://test-verification-url",
+		ExpiresIn:               600,
+		Interval:                interval,
+	}, nil)
+
+	setupResult, err := controller.Setup(testhelpers.NewMockAppContext(), AwsIdc_SetupCommandInput{
+		StartUrl:  "https://test-start-url.aws-apps.com/start",
+		AwsRegion: "eu-west-1",
+		Label:     "test_label",
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, setupResult.FlowId)
+	require.Equal(t, interval, setupResult.Interval)
+
+	return setupResult
+}
+
+func awaitSetupInput(setupResult *AuthorizeDeviceFlowResult) AwsIdc_AwaitSetupCommandInput {
+	return AwsIdc_AwaitSetupCommandInput{
+		FlowId:     setupResult.FlowId,
+		ClientId:   setupResult.ClientId,
+		StartUrl:   setupResult.StartUrl,
+		AwsRegion:  setupResult.Region,
+		Label:      setupResult.Label,
+		UserCode:   setupResult.UserCode,
+		DeviceCode: setupResult.DeviceCode,
+		Interval:   setupResult.Interval,
+		ExpiresIn:  setupResult.ExpiresIn,
+	}
+}
+
+// recordWaits replaces waiting with recording how long the poller would have waited
+func recordWaits(controller *AwsIdentityCenterController) *[]time.Duration {
+	waits := make([]time.Duration, 0)
+
+	controller.wait = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return ctx.Err()
+	}
+
+	return &waits
+}
+
+func deviceFlowProgress(t *testing.T, controller *AwsIdentityCenterController, flowId string) []AwsIdcDeviceFlowProgressEvent {
+	rows, err := controller.db.Query("SELECT data FROM event_log WHERE source_type = ? AND source_id = ? ORDER BY event_version",
+		AwsIdcDeviceFlowEventSource, flowId)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	events := make([]AwsIdcDeviceFlowProgressEvent, 0)
+
+	for rows.Next() {
+		var data []byte
+		require.NoError(t, rows.Scan(&data))
+
+		var event AwsIdcDeviceFlowProgressEvent
+		require.NoError(t, json.Unmarshal(data, &event))
+
+		events = append(events, event)
+	}
+
+	require.NoError(t, rows.Err())
+
+	return events
+}
+
+func TestAwaitSetup(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+	mockTimeProvider.On("NowUnix").Return(1)
+
+	setupResult := startDeviceFlow(t, controller, mockAws, 2)
+	waits := recordWaits(controller)
+
+	mockAws.On("CreateToken").Once().Return(nil, awssso.ErrDeviceFlowNotAuthorized)
+	mockAws.On("CreateToken").Once().Return(nil, awssso.ErrSlowDown)
+	mockAws.On("CreateToken").Once().Return(nil, awssso.ErrDeviceFlowNotAuthorized)
+	mockAws.On("CreateToken").Once().Return(&awssso.GetTokenResponse{
+		AccessToken: "test-access-token",
+		TokenType:   "Bearer",
+		ExpiresIn:   3600,
+	}, nil)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	instanceId, err := controller.AwaitSetup(ctx, awaitSetupInput(setupResult))
+	require.NoError(t, err)
+
+	instances, err := controller.ListInstances(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{instanceId}, instances)
+
+	require.Equal(t, []time.Duration{2 * time.Second, 2 * time.Second, 7 * time.Second, 7 * time.Second}, *waits)
+
+	require.Equal(t, []AwsIdcDeviceFlowProgressEvent{
+		{FlowId: setupResult.FlowId, Status: DeviceFlowPending, Attempt: 1, IntervalSeconds: 2},
+		{FlowId: setupResult.FlowId, Status: DeviceFlowSlowDown, Attempt: 2, IntervalSeconds: 7},
+		{FlowId: setupResult.FlowId, Status: DeviceFlowPending, Attempt: 3, IntervalSeconds: 7},
+		{FlowId: setupResult.FlowId, Status: DeviceFlowAuthorized, Attempt: 4, IntervalSeconds: 7},
+	}, deviceFlowProgress(t, controller, setupResult.FlowId))
+}
+
+func TestAwaitSetup_DefaultInterval(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+	mockTimeProvider.On("NowUnix").Return(1)
+
+	setupResult := startDeviceFlow(t, controller, mockAws, 0)
+	waits := recordWaits(controller)
+
+	mockAws.On("CreateToken").Once().Return(&awssso.GetTokenResponse{AccessToken: "test-access-token"}, nil)
+
+	_, err := controller.AwaitSetup(testhelpers.NewMockAppContext(), awaitSetupInput(setupResult))
+	require.NoError(t, err)
+
+	require.Equal(t, []time.Duration{defaultDeviceFlowInterval}, *waits)
+}
+
+func TestAwaitSetup_Errors(t *testing.T) {
+	testCases := []struct {
+		name           string
+		createTokenErr error
+		expectedErr    error
+		expectedStatus DeviceFlowStatus
+	}{
+		{name: "access denied", createTokenErr: awssso.ErrDeviceFlowAccessDenied, expectedErr: ErrDeviceAuthFlowDenied, expectedStatus: DeviceFlowDenied},
+		{name: "device code expired", createTokenErr: awssso.ErrDeviceCodeExpired, expectedErr: ErrDeviceAuthFlowTimedOut, expectedStatus: DeviceFlowExpired},
+		{name: "unexpected error", createTokenErr: errors.New("boom"), expectedErr: ErrTransientAwsClientError, expectedStatus: DeviceFlowFailed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			controller, mockAws, mockTimeProvider := initController(t)
+			mockTimeProvider.On("NowUnix").Return(1)
+
+			setupResult := startDeviceFlow(t, controller, mockAws, 1)
+			recordWaits(controller)
+
+			mockAws.On("CreateToken").Once().Return(nil, awssso.ErrDeviceFlowNotAuthorized)
+			mockAws.On("CreateToken").Once().Return(nil, tc.createTokenErr)
+
+			_, err := controller.AwaitSetup(testhelpers.NewMockAppContext(), awaitSetupInput(setupResult))
+			require.True(t, app.IsError(err, tc.expectedErr), "expected %v, got %v", tc.expectedErr, err)
+
+			progress := deviceFlowProgress(t, controller, setupResult.FlowId)
+			require.Len(t, progress, 2)
+			require.Equal(t, tc.expectedStatus, progress[1].Status)
+		})
+	}
+}
+
+func TestAwaitSetup_Deadline(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+	mockTimeProvider.On("NowUnix").Once().Return(1)
+	mockTimeProvider.On("NowUnix").Once().Return(1)
+	mockTimeProvider.On("NowUnix").Return(601)
+
+	setupResult := startDeviceFlow(t, controller, mockAws, 1)
+	recordWaits(controller)
+
+	mockAws.On("CreateToken").Once().Return(nil, awssso.ErrDeviceFlowNotAuthorized)
+
+	_, err := controller.AwaitSetup(testhelpers.NewMockAppContext(), awaitSetupInput(setupResult))
+	require.True(t, app.IsError(err, ErrDeviceAuthFlowTimedOut))
+
+	require.Equal(t, []AwsIdcDeviceFlowProgressEvent{
+		{FlowId: setupResult.FlowId, Status: DeviceFlowPending, Attempt: 1, IntervalSeconds: 1},
+		{FlowId: setupResult.FlowId, Status: DeviceFlowExpired, Attempt: 1, IntervalSeconds: 1},
+	}, deviceFlowProgress(t, controller, setupResult.FlowId))
+}
+
+func TestAwaitSetup_CancelDeviceFlow(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+	mockTimeProvider.On("NowUnix").Return(1)
+
+	setupResult := startDeviceFlow(t, controller, mockAws, 1)
+
+	waiting := make(chan struct{})
+	controller.wait = func(ctx context.Context, d time.Duration) error {
+		close(waiting)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	ctx := testhelpers.NewMockAppContext()
+
+	go func() {
+		<-waiting
+		controller.CancelDeviceFlow(ctx, setupResult.FlowId)
+	}()
+
+	_, err := controller.AwaitSetup(ctx, awaitSetupInput(setupResult))
+	require.True(t, app.IsError(err, ErrDeviceAuthFlowCancelled))
+
+	mockAws.AssertNotCalled(t, "CreateToken")
+
+	require.Equal(t, []AwsIdcDeviceFlowProgressEvent{
+		{FlowId: setupResult.FlowId, Status: DeviceFlowCancelled, Attempt: 0, IntervalSeconds: 1},
+	}, deviceFlowProgress(t, controller, setupResult.FlowId))
+
+	// polling the same flow again continues its progress
+	recordWaits(controller)
+	mockAws.On("CreateToken").Once().Return(&awssso.GetTokenResponse{AccessToken: "test-access-token"}, nil)
+
+	_, err = controller.AwaitSetup(ctx, awaitSetupInput(setupResult))
+	require.NoError(t, err)
+
+	require.Len(t, deviceFlowProgress(t, controller, setupResult.FlowId), 2)
+}
+
+func TestAwaitSetup_ContextCancelled(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+	mockTimeProvider.On("NowUnix").Return(1)
+
+	setupResult := startDeviceFlow(t, controller, mockAws, 1)
+
+	ctx := testhelpers.NewMockAppContext()
+	parentCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	appCtx := app.NewContext(parentCtx, ctx.UserId(), ctx.RequestId(), ctx.CausationId(), ctx.CorrelationId(), ctx.Logger())
+
+	controller.wait = func(ctx context.Context, d time.Duration) error {
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	_, err := controller.AwaitSetup(appCtx, awaitSetupInput(setupResult))
+	require.True(t, app.IsError(err, ErrDeviceAuthFlowCancelled))
+
+	require.Equal(t, DeviceFlowCancelled, deviceFlowProgress(t, controller, setupResult.FlowId)[0].Status)
+}
+
+func TestAwaitRefreshAccessToken(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, "https://test-start-url.aws-apps.com/start", "eu-west-1", "test_label")
+
+	mockTimeProvider.On("NowUnix").Return(10)
+
+	mockAws.On("StartDeviceAuthorization").Return(&awssso.AuthorizationResponse{
+		DeviceCode: "test-device-code-2",
+		UserCode:   "test-user-code-2",
+		ExpiresIn:  600,
+		Interval:   1,
+	}, nil)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	refreshRes, err := controller.RefreshAccessToken(ctx, instanceId)
+	require.NoError(t, err)
+
+	recordWaits(controller)
+
+	mockAws.On("CreateToken").Once().Return(nil, awssso.ErrDeviceFlowNotAuthorized)
+	mockAws.On("CreateToken").Once().Return(&awssso.GetTokenResponse{
+		AccessToken: "test-access-token-2",
+		TokenType:   "Bearer",
+		ExpiresIn:   3600,
+	}, nil)
+
+	err = controller.AwaitRefreshAccessToken(ctx, AwsIdc_AwaitRefreshAccessTokenCommandInput{
+		FlowId:     refreshRes.FlowId,
+		InstanceId: instanceId,
+		Region:     refreshRes.Region,
+		UserCode:   refreshRes.UserCode,
+		DeviceCode: refreshRes.DeviceCode,
+		Interval:   refreshRes.Interval,
+		ExpiresIn:  refreshRes.ExpiresIn,
+	})
+	require.NoError(t, err)
+
+	var expiresIn int32
+	err = controller.db.QueryRow("SELECT access_token_expires_in FROM aws_idc WHERE instance_id = ?", instanceId).Scan(&expiresIn)
+	require.NoError(t, err)
+	require.Equal(t, int32(3600), expiresIn)
+}
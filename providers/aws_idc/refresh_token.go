@@ -0,0 +1,121 @@
+package awsidc
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/internal/app"
+)
+
+// RefreshAccessTokenSilently renews the access token of an instance with its refresh token, without any user interaction.
+// It does nothing if the access token has not expired yet, e.g. because it was renewed concurrently.
+// [ErrRefreshTokenUnavailable] means that the instance has no usable refresh token anymore
+// and the access token has to be refreshed through [AwsIdentityCenterController.RefreshAccessToken].
+func (c *AwsIdentityCenterController) RefreshAccessTokenSilently(ctx app.Context, instanceId string) error {
+	// refresh tokens may be rotated on use, so only one renewal can be in flight
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	row := c.db.QueryRowContext(ctx, "SELECT region, access_token_created_at, access_token_expires_in, refresh_token_enc, login_flow, enc_key_id FROM aws_idc WHERE instance_id = ?", instanceId)
+
+	var region string
+	var accessTokenCreatedAt int64
+	var accessTokenExpiresIn int64
+	var refreshTokenEnc string
+	var loginFlow LoginFlow
+	var encKeyId string
+
+	if err := row.Scan(&region, &accessTokenCreatedAt, &accessTokenExpiresIn, &refreshTokenEnc, &loginFlow, &encKeyId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInstanceWasNotFound
+		}
+
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	if c.clock.NowUnix() <= accessTokenCreatedAt+accessTokenExpiresIn {
+		ctx.Logger().Debug().Msgf("access token of instance [%s] is still valid", instanceId)
+		return nil
+	}
+
+	refreshToken, err := c.encryptionService.Decrypt(refreshTokenEnc, encKeyId)
+
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	if refreshToken == "" {
+		ctx.Logger().Debug().Msgf("instance [%s] has no refresh token", instanceId)
+		return ErrRefreshTokenUnavailable
+	}
+
+	return c.finalizeRefreshAccessToken(ctx, AwsIdc_FinalizeRefreshAccessTokenCommandInput{
+		InstanceId: instanceId,
+		Region:     region,
+	}, loginFlow, func(clientId, clientSecret string) (*awssso.GetTokenResponse, error) {
+		ctx.Logger().Info().Msgf("renewing access token of instance [%s] with its refresh token", instanceId)
+
+		tokenRes, err := c.awsSsoClient.RefreshToken(ctx, awsnet.AwsRegion(region), clientId, clientSecret, refreshToken)
+
+		if err != nil {
+			if errors.Is(err, awssso.ErrInvalidRefreshToken) {
+				ctx.Logger().Info().Msgf("refresh token of instance [%s] was rejected", instanceId)
+
+				if err := c.forgetRefreshToken(ctx, instanceId); err != nil {
+					return nil, err
+				}
+
+				return nil, ErrRefreshTokenUnavailable
+			}
+
+			ctx.Logger().Error().Err(err).Msg("failed to renew access token")
+			return nil, ErrTransientAwsClientError
+		}
+
+		// the refresh token is not necessarily rotated
+		if tokenRes.RefreshToken == "" {
+			tokenRes.RefreshToken = refreshToken
+		}
+
+		return tokenRes, nil
+	})
+}
+
+// forgetRefreshToken drops a refresh token that was rejected so that it is not tried again.
+func (c *AwsIdentityCenterController) forgetRefreshToken(ctx app.Context, instanceId string) error {
+	refreshTokenEnc, _, err := c.encryptionService.Encrypt("")
+
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	_, err = c.db.ExecContext(ctx, "UPDATE aws_idc SET refresh_token_enc = ? WHERE instance_id = ?", refreshTokenEnc, instanceId)
+
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	return nil
+}
+
+// tryRefreshAccessTokenSilently reports whether the access token of an instance was renewed with its refresh token.
+// Only fatal errors are returned, any other failure leaves the access token expired.
+func (c *AwsIdentityCenterController) tryRefreshAccessTokenSilently(ctx app.Context, instanceId string) (bool, error) {
+	err := c.RefreshAccessTokenSilently(ctx, instanceId)
+
+	if err == nil {
+		return true, nil
+	}
+
+	if errors.Is(err, app.ErrFatal) {
+		return false, err
+	}
+
+	if !app.IsError(err, ErrRefreshTokenUnavailable) {
+		ctx.Logger().Warn().Err(err).Msgf("failed to renew access token of instance [%s]", instanceId)
+	}
+
+	return false, nil
+}
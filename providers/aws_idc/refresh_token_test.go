@@ -0,0 +1,298 @@
+package awsidc
+
+import (
+	"testing"
+
+	"github.com/abjrcode/swervo/clients/awscredsfile"
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshAccessTokenSilently(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	mockTimeProvider.On("NowUnix").Return(10)
+
+	mockAws.On("RefreshToken").Return(&awssso.GetTokenResponse{
+		AccessToken: "test-access-token-2",
+		TokenType:   "test-token-type",
+		ExpiresIn:   100,
+	}, nil)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	err := controller.RefreshAccessTokenSilently(ctx, instanceId)
+	require.NoError(t, err)
+
+	var accessTokenEnc, refreshTokenEnc, encKeyId string
+	var createdAt, expiresIn int64
+
+	err = controller.db.QueryRowContext(ctx, `SELECT access_token_enc, refresh_token_enc, enc_key_id, access_token_created_at, access_token_expires_in
+		FROM aws_idc WHERE instance_id = ?`, instanceId).
+		Scan(&accessTokenEnc, &refreshTokenEnc, &encKeyId, &createdAt, &expiresIn)
+	require.NoError(t, err)
+
+	accessToken, err := controller.encryptionService.Decrypt(accessTokenEnc, encKeyId)
+	require.NoError(t, err)
+	require.Equal(t, "test-access-token-2", accessToken)
+
+	refreshToken, err := controller.encryptionService.Decrypt(refreshTokenEnc, encKeyId)
+	require.NoError(t, err)
+	require.Equal(t, "test-refresh-token", refreshToken, "refresh token must be kept when it is not rotated")
+
+	require.Equal(t, int64(10), createdAt)
+	require.Equal(t, int64(100), expiresIn)
+}
+
+func TestRefreshAccessTokenSilently_SharesAccessTokenWithAwsCli(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	tokenCache := &fakeAwsCliTokenCache{}
+	controller.SetAwsCliTokenCache(tokenCache)
+	controller.SetAwsCliTokenSharing(true)
+
+	mockTimeProvider.On("NowUnix").Return(10)
+
+	mockAws.On("RefreshToken").Return(&awssso.GetTokenResponse{
+		AccessToken:  "test-access-token-2",
+		RefreshToken: "test-refresh-token-2",
+		TokenType:    "test-token-type",
+		ExpiresIn:    100,
+	}, nil)
+
+	err := controller.RefreshAccessTokenSilently(testhelpers.NewMockAppContext(), instanceId)
+	require.NoError(t, err)
+
+	require.Equal(t, []awscredsfile.SsoCachedToken{{
+		StartUrl:              startUrl,
+		Region:                region,
+		AccessToken:           "test-access-token-2",
+		ExpiresAt:             110,
+		ClientId:              "test-client-id",
+		ClientSecret:          "test-client-secret",
+		RegistrationExpiresAt: 20,
+	}}, tokenCache.tokens, "renewed access tokens are shared without their refresh token")
+}
+
+func TestRefreshAccessTokenSilently_AccessTokenStillValid(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	mockTimeProvider.On("NowUnix").Return(3)
+
+	err := controller.RefreshAccessTokenSilently(testhelpers.NewMockAppContext(), instanceId)
+	require.NoError(t, err)
+
+	mockAws.AssertNotCalled(t, "RefreshToken")
+}
+
+func TestRefreshAccessTokenSilently_RefreshTokenRejected(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	mockTimeProvider.On("NowUnix").Return(10)
+
+	mockAws.On("RefreshToken").Return(nil, awssso.ErrInvalidRefreshToken)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	err := controller.RefreshAccessTokenSilently(ctx, instanceId)
+	require.True(t, app.IsError(err, ErrRefreshTokenUnavailable))
+
+	err = controller.RefreshAccessTokenSilently(ctx, instanceId)
+	require.True(t, app.IsError(err, ErrRefreshTokenUnavailable))
+
+	mockAws.AssertNumberOfCalls(t, "RefreshToken", 1)
+}
+
+func TestRefreshAccessTokenSilently_TransientError(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	mockTimeProvider.On("NowUnix").Return(10)
+
+	mockAws.On("RefreshToken").Return(nil, awssso.ErrInvalidRequest)
+
+	err := controller.RefreshAccessTokenSilently(testhelpers.NewMockAppContext(), instanceId)
+	require.True(t, app.IsError(err, ErrTransientAwsClientError))
+}
+
+func TestRefreshAccessTokenSilently_ClientWasNotRegistered(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	_, err := controller.db.ExecContext(ctx, "DELETE FROM aws_sso_clients")
+	require.NoError(t, err)
+
+	mockTimeProvider.On("NowUnix").Return(10)
+
+	err = controller.RefreshAccessTokenSilently(ctx, instanceId)
+	require.Same(t, ErrStaleAwsAccessToken, err)
+
+	mockAws.AssertNotCalled(t, "RefreshToken")
+}
+
+func TestRefreshAccessTokenSilently_ImportedFromAwsCli(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+	controller.SetAwsCliTokenCache(newFakeAwsCliTokenCache())
+
+	ctx := testhelpers.NewMockAppContext()
+
+	mockTimeProvider.On("NowUnix").Return(100)
+
+	instanceId, err := controller.ImportFromAwsCli(ctx, AwsIdc_ImportFromAwsCliCommandInput{
+		SessionName: "logged-in",
+		Label:       "imported",
+	})
+	require.NoError(t, err)
+
+	mockTimeProvider.ExpectedCalls = nil
+	mockTimeProvider.On("NowUnix").Return(3800)
+
+	err = controller.RefreshAccessTokenSilently(ctx, instanceId)
+	require.Same(t, ErrRefreshTokenUnavailable, err)
+
+	instanceData, err := controller.GetInstanceData(ctx, instanceId, false)
+	require.NoError(t, err, "imported instances must not bring the app down once their access token expires")
+	require.True(t, instanceData.IsAccessTokenExpired)
+
+	mockAws.AssertNotCalled(t, "RefreshToken")
+}
+
+func TestRefreshAccessTokenSilently_NonExistentInstance(t *testing.T) {
+	controller, _, _ := initController(t)
+
+	err := controller.RefreshAccessTokenSilently(testhelpers.NewMockAppContext(), "well-if-u-can-find-me-it-sucks")
+	require.True(t, app.IsError(err, ErrInstanceWasNotFound))
+}
+
+func TestGetInstanceData_RenewsExpiredAccessToken(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	mockTimeProvider.On("NowUnix").Return(10)
+
+	mockAws.On("RefreshToken").Return(&awssso.GetTokenResponse{
+		AccessToken:  "test-access-token-2",
+		RefreshToken: "test-refresh-token-2",
+		TokenType:    "test-token-type",
+		ExpiresIn:    100,
+	}, nil)
+
+	mockAws.On("ListAccounts").Return(&awssso.ListAccountsResponse{
+		Accounts: []awssso.AwsAccount{
+			{
+				AccountId:   "test-account-id",
+				AccountName: "test-account-name",
+			},
+		},
+	}, nil)
+
+	data, err := controller.GetInstanceData(testhelpers.NewMockAppContext(), instanceId, false)
+	require.NoError(t, err)
+
+	require.False(t, data.IsAccessTokenExpired)
+	require.Len(t, data.Accounts, 1)
+	mockAws.AssertNumberOfCalls(t, "RefreshToken", 1)
+}
+
+func TestGetRoleCredentials_RenewsStaleAccessToken(t *testing.T) {
+	startUrl := "https://test-start-url.aws-apps.com/start"
+	region := "eu-west-1"
+	label := "test_label"
+
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, startUrl, region, label)
+
+	mockTimeProvider.On("NowUnix").Return(3)
+
+	mockAws.On("GetRoleCredentials").Once().Return(nil, awssso.ErrAccessTokenExpired)
+	mockAws.On("RefreshToken").Return(&awssso.GetTokenResponse{
+		AccessToken: "test-access-token-2",
+		TokenType:   "test-token-type",
+		ExpiresIn:   100,
+	}, nil)
+	mockAws.On("GetRoleCredentials").Once().Return(&awssso.GetRoleCredentialsResponse{
+		AccessKeyId:     "test-access-key-id",
+		SecretAccessKey: "test-secret-key",
+		SessionToken:    "test-session-token",
+		Expiration:      100,
+	}, nil)
+
+	roleCredentials, err := controller.getRoleCredentials(testhelpers.NewMockAppContext(), instanceId, "test-account-id", "test-role-name", false)
+	require.NoError(t, err)
+	require.Equal(t, "test-access-key-id", roleCredentials.AccessKeyId)
+}
+
+func TestUnscopedClientIsRegisteredAgain(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	mockTimeProvider.On("NowUnix").Return(1)
+
+	mockAws.On("RegisterClient").Return(&awssso.RegistrationResponse{
+		ClientId:     "test-client-id",
+		ClientSecret: "test-client-secret",
+		CreatedAt:    1,
+		ExpiresAt:    20,
+	}, nil)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	_, err := controller.getOrRegisterClient(ctx, "eu-west-1")
+	require.NoError(t, err)
+
+	_, err = controller.getOrRegisterClient(ctx, "eu-west-1")
+	require.NoError(t, err)
+
+	mockAws.AssertNumberOfCalls(t, "RegisterClient", 1)
+
+	_, err = controller.db.ExecContext(ctx, "UPDATE aws_sso_clients SET scopes = ''")
+	require.NoError(t, err)
+
+	_, err = controller.getOrRegisterClient(ctx, "eu-west-1")
+	require.NoError(t, err)
+
+	mockAws.AssertNumberOfCalls(t, "RegisterClient", 2)
+}
@@ -0,0 +1,117 @@
+package awsidc
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/utils"
+	"github.com/awnumar/memguard"
+)
+
+// roleCredentialsCacheMargin is how long before they expire cached role credentials stop being handed out,
+// so that whoever receives them can still use them for a while
+var roleCredentialsCacheMargin = 10 * time.Minute
+
+type roleCredentialsKey struct {
+	instanceId, accountId, roleName string
+}
+
+type cachedRoleCredentials struct {
+	// sealed holds the JSON encoded credentials encrypted in memory
+	sealed *memguard.Enclave
+	// expiresAt is the Unix time in seconds at which the credentials expire
+	expiresAt int64
+}
+
+// roleCredentialsCache keeps role credentials encrypted in memory until shortly before they expire.
+type roleCredentialsCache struct {
+	clock utils.Clock
+
+	mu      sync.Mutex
+	entries map[roleCredentialsKey]cachedRoleCredentials
+}
+
+func newRoleCredentialsCache(clock utils.Clock) *roleCredentialsCache {
+	return &roleCredentialsCache{
+		clock:   clock,
+		entries: make(map[roleCredentialsKey]cachedRoleCredentials),
+	}
+}
+
+func (c *roleCredentialsCache) get(key roleCredentialsKey) (*awsRoleCredentials, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+
+	if !ok {
+		return nil, false
+	}
+
+	if c.clock.NowUnix()+int64(roleCredentialsCacheMargin/time.Second) >= entry.expiresAt {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	buffer, err := entry.sealed.Open()
+
+	if err != nil {
+		// the enclave cannot be opened anymore once memory was purged
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	defer buffer.Destroy()
+
+	var credentials awsRoleCredentials
+
+	if err := json.Unmarshal(buffer.Bytes(), &credentials); err != nil {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return &credentials, true
+}
+
+func (c *roleCredentialsCache) put(key roleCredentialsKey, credentials *awsRoleCredentials) {
+	plaintext, err := json.Marshal(credentials)
+
+	if err != nil {
+		return
+	}
+
+	// NewEnclave wipes the plaintext
+	sealed := memguard.NewEnclave(plaintext)
+
+	if sealed == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = cachedRoleCredentials{
+		sealed:    sealed,
+		expiresAt: time.UnixMilli(credentials.Expiration).Unix(),
+	}
+}
+
+// evictInstance drops the credentials of every role of an instance.
+func (c *roleCredentialsCache) evictInstance(instanceId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.entries {
+		if key.instanceId == instanceId {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *roleCredentialsCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[roleCredentialsKey]cachedRoleCredentials)
+}
@@ -0,0 +1,128 @@
+package awsidc
+
+import (
+	"testing"
+
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoleCredentialsCache(t *testing.T) {
+	clock := testhelpers.NewMockClock()
+	cache := newRoleCredentialsCache(clock)
+
+	key := roleCredentialsKey{instanceId: "test-instance-id", accountId: "test-account-id", roleName: "test-role-name"}
+	otherKey := roleCredentialsKey{instanceId: "test-instance-id", accountId: "test-account-id", roleName: "test-other-role-name"}
+
+	credentials := &awsRoleCredentials{
+		AccessKeyId:     "test-access-key-id",
+		SecretAccessKey: "test-secret-key",
+		SessionToken:    "test-session-token",
+		Expiration:      3600 * 1000,
+	}
+
+	cache.put(key, credentials)
+
+	clock.On("NowUnix").Once().Return(1)
+	cached, ok := cache.get(key)
+	require.True(t, ok)
+	require.Equal(t, credentials, cached)
+
+	_, ok = cache.get(otherKey)
+	require.False(t, ok)
+
+	clock.On("NowUnix").Once().Return(3600 - 600)
+	_, ok = cache.get(key)
+	require.False(t, ok, "credentials about to expire must not be handed out")
+
+	clock.ExpectedCalls = nil
+	clock.On("NowUnix").Return(1)
+
+	cache.put(key, credentials)
+	cache.put(otherKey, credentials)
+	cache.evictInstance("test-other-instance-id")
+
+	_, ok = cache.get(key)
+	require.True(t, ok)
+
+	cache.evictInstance("test-instance-id")
+
+	_, ok = cache.get(key)
+	require.False(t, ok)
+	_, ok = cache.get(otherKey)
+	require.False(t, ok)
+
+	cache.put(key, credentials)
+	cache.purge()
+
+	_, ok = cache.get(key)
+	require.False(t, ok)
+}
+
+func TestGetRoleCredentials_Cached(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, "https://test-start-url.aws-apps.com/start", "eu-west-1", "test_label")
+
+	mockTimeProvider.On("NowUnix").Return(3)
+
+	mockGetRoleCredentialsRes := awssso.GetRoleCredentialsResponse{
+		AccessKeyId:     "test-access-key-id",
+		SecretAccessKey: "test-secret-key",
+		SessionToken:    "test-session-token",
+		Expiration:      3600 * 1000,
+	}
+
+	mockAws.On("GetRoleCredentials").Return(&mockGetRoleCredentialsRes, nil)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	first, err := controller.getRoleCredentials(ctx, instanceId, "test-account-id", "test-role-name", false)
+	require.NoError(t, err)
+
+	second, err := controller.getRoleCredentials(ctx, instanceId, "test-account-id", "test-role-name", false)
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+	mockAws.AssertNumberOfCalls(t, "GetRoleCredentials", 1)
+
+	_, err = controller.getRoleCredentials(ctx, instanceId, "test-account-id", "test-role-name", true)
+	require.NoError(t, err)
+	mockAws.AssertNumberOfCalls(t, "GetRoleCredentials", 2)
+
+	controller.PurgeRoleCredentialsCache()
+
+	_, err = controller.getRoleCredentials(ctx, instanceId, "test-account-id", "test-role-name", false)
+	require.NoError(t, err)
+	mockAws.AssertNumberOfCalls(t, "GetRoleCredentials", 3)
+}
+
+func TestGetRoleCredentials_StaleAccessToken_EvictsCache(t *testing.T) {
+	controller, mockAws, mockTimeProvider := initController(t)
+
+	instanceId := simulateSuccessfulSetup(t, controller, mockAws, mockTimeProvider, "https://test-start-url.aws-apps.com/start", "eu-west-1", "test_label")
+
+	mockTimeProvider.On("NowUnix").Return(3)
+
+	mockGetRoleCredentialsRes := awssso.GetRoleCredentialsResponse{
+		AccessKeyId:     "test-access-key-id",
+		SecretAccessKey: "test-secret-key",
+		SessionToken:    "test-session-token",
+		Expiration:      3600 * 1000,
+	}
+
+	mockAws.On("RefreshToken").Return(nil, awssso.ErrInvalidRefreshToken)
+	mockAws.On("GetRoleCredentials").Once().Return(&mockGetRoleCredentialsRes, nil)
+	mockAws.On("GetRoleCredentials").Return(nil, awssso.ErrUnauthorizedAccessToken)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	_, err := controller.getRoleCredentials(ctx, instanceId, "test-account-id", "test-role-name", false)
+	require.NoError(t, err)
+
+	_, err = controller.getRoleCredentials(ctx, instanceId, "test-account-id", "test-role-name", true)
+	require.Same(t, ErrStaleAwsAccessToken, err)
+
+	_, err = controller.getRoleCredentials(ctx, instanceId, "test-account-id", "test-role-name", false)
+	require.Same(t, ErrStaleAwsAccessToken, err, "cached credentials must be evicted along with the stale access token")
+}
@@ -1,6 +1,10 @@
 package providers
 
-import awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+import (
+	awsassumerole "github.com/abjrcode/swervo/providers/aws_assume_role"
+	awsiamuser "github.com/abjrcode/swervo/providers/aws_iam_user"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
+)
 
 type ProviderMeta struct {
 	Code          string
@@ -14,5 +18,13 @@ var (
 			Code: awsidc.ProviderCode,
 			Name: "AWS Identity Center",
 		},
+		awsiamuser.ProviderCode: {
+			Code: awsiamuser.ProviderCode,
+			Name: "AWS IAM User",
+		},
+		awsassumerole.ProviderCode: {
+			Code: awsassumerole.ProviderCode,
+			Name: "AWS Assume Role",
+		},
 	}
 )
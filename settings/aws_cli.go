@@ -0,0 +1,55 @@
+package settings
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/abjrcode/swervo/internal/app"
+)
+
+// AwsCliSettings control what is shared with the AWS CLI and SDKs.
+type AwsCliSettings struct {
+	// ShareSsoTokens writes the access tokens of IAM Identity Center instances to the SSO token cache of the AWS CLI
+	ShareSsoTokens bool `json:"shareSsoTokens"`
+}
+
+// DefaultAwsCliSettings are used until AWS CLI settings are saved for the first time,
+// nothing is written to the files of the AWS CLI unless the user opts in.
+var DefaultAwsCliSettings = AwsCliSettings{
+	ShareSsoTokens: false,
+}
+
+type AwsCliSettingsRepo interface {
+	Get(ctx app.Context) (*AwsCliSettings, error)
+	Save(ctx app.Context, settings *AwsCliSettings) error
+}
+
+type awsCliSettingsImpl struct {
+	db *sql.DB
+}
+
+func NewAwsCliSettings(db *sql.DB) AwsCliSettingsRepo {
+	return &awsCliSettingsImpl{
+		db: db,
+	}
+}
+
+func (r *awsCliSettingsImpl) Get(ctx app.Context) (*AwsCliSettings, error) {
+	settings := DefaultAwsCliSettings
+
+	err := r.db.QueryRowContext(ctx, `SELECT share_sso_tokens FROM aws_cli_settings WHERE id = 1`).Scan(&settings.ShareSsoTokens)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+func (r *awsCliSettingsImpl) Save(ctx app.Context, settings *AwsCliSettings) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO aws_cli_settings (id, share_sso_tokens) VALUES (1, ?)
+		ON CONFLICT(id) DO UPDATE SET share_sso_tokens = excluded.share_sso_tokens`,
+		settings.ShareSsoTokens)
+
+	return err
+}
@@ -0,0 +1,138 @@
+package settings
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/internal/app"
+)
+
+type EndpointOverride struct {
+	Region         string `json:"region"`
+	OidcEndpoint   string `json:"oidcEndpoint"`
+	PortalEndpoint string `json:"portalEndpoint"`
+}
+
+type NetworkSettings struct {
+	ProxyMode             awsnet.ProxyMode   `json:"proxyMode"`
+	ProxyUrl              string             `json:"proxyUrl"`
+	CaBundlePem           string             `json:"caBundlePem"`
+	RequestTimeoutSeconds int                `json:"requestTimeoutSeconds"`
+	EndpointOverrides     []EndpointOverride `json:"endpointOverrides"`
+}
+
+// DefaultNetworkSettings are used until network settings are saved for the first time
+var DefaultNetworkSettings = NetworkSettings{
+	ProxyMode:             awsnet.DefaultNetworkOptions.ProxyMode,
+	RequestTimeoutSeconds: int(awsnet.DefaultNetworkOptions.RequestTimeout / time.Second),
+	EndpointOverrides:     []EndpointOverride{},
+}
+
+// ClientOptions translates the settings into what the clients of AWS services understand.
+func (s *NetworkSettings) ClientOptions() awsnet.NetworkOptions {
+	endpoints := make(map[awsnet.AwsRegion]awsnet.Endpoints, len(s.EndpointOverrides))
+
+	for _, override := range s.EndpointOverrides {
+		endpoints[awsnet.AwsRegion(override.Region)] = awsnet.Endpoints{
+			Oidc:   override.OidcEndpoint,
+			Portal: override.PortalEndpoint,
+		}
+	}
+
+	return awsnet.NetworkOptions{
+		Endpoints:      endpoints,
+		ProxyMode:      s.ProxyMode,
+		ProxyUrl:       s.ProxyUrl,
+		CaBundlePem:    s.CaBundlePem,
+		RequestTimeout: time.Duration(s.RequestTimeoutSeconds) * time.Second,
+	}
+}
+
+type NetworkSettingsRepo interface {
+	Get(ctx app.Context) (*NetworkSettings, error)
+	Save(ctx app.Context, settings *NetworkSettings) error
+}
+
+type networkSettingsImpl struct {
+	db *sql.DB
+}
+
+func NewNetworkSettings(db *sql.DB) NetworkSettingsRepo {
+	return &networkSettingsImpl{
+		db: db,
+	}
+}
+
+func (n *networkSettingsImpl) Get(ctx app.Context) (*NetworkSettings, error) {
+	settings := DefaultNetworkSettings
+
+	row := n.db.QueryRowContext(ctx, `SELECT proxy_mode, proxy_url, ca_bundle_pem, request_timeout_seconds FROM network_settings WHERE id = 1`)
+
+	err := row.Scan(&settings.ProxyMode, &settings.ProxyUrl, &settings.CaBundlePem, &settings.RequestTimeoutSeconds)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	rows, err := n.db.QueryContext(ctx, `SELECT region, oidc_endpoint, portal_endpoint FROM aws_sso_endpoint_overrides ORDER BY region`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	settings.EndpointOverrides = make([]EndpointOverride, 0)
+
+	for rows.Next() {
+		var override EndpointOverride
+
+		if err := rows.Scan(&override.Region, &override.OidcEndpoint, &override.PortalEndpoint); err != nil {
+			return nil, err
+		}
+
+		settings.EndpointOverrides = append(settings.EndpointOverrides, override)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+// Save replaces the network settings and every endpoint override.
+func (n *networkSettingsImpl) Save(ctx app.Context, settings *NetworkSettings) error {
+	tx, err := n.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO network_settings (id, proxy_mode, proxy_url, ca_bundle_pem, request_timeout_seconds) VALUES (1, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET proxy_mode = excluded.proxy_mode, proxy_url = excluded.proxy_url, ca_bundle_pem = excluded.ca_bundle_pem, request_timeout_seconds = excluded.request_timeout_seconds`,
+		settings.ProxyMode, settings.ProxyUrl, settings.CaBundlePem, settings.RequestTimeoutSeconds)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM aws_sso_endpoint_overrides`); err != nil {
+		return err
+	}
+
+	for _, override := range settings.EndpointOverrides {
+		_, err := tx.ExecContext(ctx, `INSERT INTO aws_sso_endpoint_overrides (region, oidc_endpoint, portal_endpoint) VALUES (?, ?, ?)`,
+			override.Region, override.OidcEndpoint, override.PortalEndpoint)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
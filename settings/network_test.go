@@ -0,0 +1,75 @@
+package settings
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/internal/migrations"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/stretchr/testify/require"
+)
+
+func initNetworkSettings(t *testing.T) NetworkSettingsRepo {
+	db, err := migrations.NewInMemoryMigratedDatabase(t, "network-settings-tests.db")
+	require.NoError(t, err)
+
+	return NewNetworkSettings(db)
+}
+
+func TestGetDefaults(t *testing.T) {
+	repo := initNetworkSettings(t)
+
+	settings, err := repo.Get(testhelpers.NewMockAppContext())
+	require.NoError(t, err)
+	require.Equal(t, DefaultNetworkSettings, *settings)
+}
+
+func TestSaveAndGet(t *testing.T) {
+	repo := initNetworkSettings(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	expected := NetworkSettings{
+		ProxyMode:             awsnet.ProxyExplicit,
+		ProxyUrl:              "http://proxy.corp.internal:3128",
+		CaBundlePem:           "test-pem",
+		RequestTimeoutSeconds: 10,
+		EndpointOverrides: []EndpointOverride{
+			{Region: "eu-west-1", OidcEndpoint: "https://oidc.vpce.internal"},
+			{Region: "us-east-1", PortalEndpoint: "https://portal.vpce.internal"},
+		},
+	}
+
+	require.NoError(t, repo.Save(ctx, &expected))
+
+	settings, err := repo.Get(ctx)
+	require.NoError(t, err)
+	require.Equal(t, expected, *settings)
+
+	expected.ProxyMode = awsnet.ProxyNone
+	expected.EndpointOverrides = expected.EndpointOverrides[1:]
+
+	require.NoError(t, repo.Save(ctx, &expected))
+
+	settings, err = repo.Get(ctx)
+	require.NoError(t, err)
+	require.Equal(t, expected, *settings)
+}
+
+func TestClientOptions(t *testing.T) {
+	settings := NetworkSettings{
+		ProxyMode:             awsnet.ProxyNone,
+		RequestTimeoutSeconds: 15,
+		EndpointOverrides: []EndpointOverride{
+			{Region: "eu-west-1", OidcEndpoint: "https://oidc.vpce.internal", PortalEndpoint: "https://portal.vpce.internal"},
+		},
+	}
+
+	require.Equal(t, awsnet.NetworkOptions{
+		ProxyMode:      awsnet.ProxyNone,
+		RequestTimeout: 15 * time.Second,
+		Endpoints: map[awsnet.AwsRegion]awsnet.Endpoints{
+			"eu-west-1": {Oidc: "https://oidc.vpce.internal", Portal: "https://portal.vpce.internal"},
+		},
+	}, settings.ClientOptions())
+}
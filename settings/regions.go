@@ -0,0 +1,60 @@
+package settings
+
+import (
+	"database/sql"
+
+	"github.com/abjrcode/swervo/internal/app"
+)
+
+// CustomRegionsRepo persists the AWS regions added by the user on top of the ones Swervo knows about.
+type CustomRegionsRepo interface {
+	ListAll(ctx app.Context) ([]string, error)
+	Add(ctx app.Context, region string) error
+	Remove(ctx app.Context, region string) error
+}
+
+type customRegionsImpl struct {
+	db *sql.DB
+}
+
+func NewCustomRegions(db *sql.DB) CustomRegionsRepo {
+	return &customRegionsImpl{
+		db: db,
+	}
+}
+
+func (r *customRegionsImpl) ListAll(ctx app.Context) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT region FROM aws_custom_regions ORDER BY region`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	regions := make([]string, 0)
+
+	for rows.Next() {
+		var region string
+
+		if err := rows.Scan(&region); err != nil {
+			return nil, err
+		}
+
+		regions = append(regions, region)
+	}
+
+	return regions, rows.Err()
+}
+
+func (r *customRegionsImpl) Add(ctx app.Context, region string) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO aws_custom_regions (region) VALUES (?) ON CONFLICT(region) DO NOTHING`, region)
+
+	return err
+}
+
+func (r *customRegionsImpl) Remove(ctx app.Context, region string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM aws_custom_regions WHERE region = ?`, region)
+
+	return err
+}
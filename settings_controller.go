@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/clients/awssts"
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/settings"
+)
+
+var (
+	ErrInvalidEndpointOverride = app.NewValidationError("INVALID_ENDPOINT_OVERRIDE")
+)
+
+const maxRequestTimeoutSeconds = 300
+
+// AwsCliTokenSharing is told whether access tokens may be written to the SSO token cache of the AWS CLI
+type AwsCliTokenSharing interface {
+	SetAwsCliTokenSharing(enabled bool)
+}
+
+// ConsoleNetwork is configured with the network settings that console sign-ins go through
+type ConsoleNetwork interface {
+	ConfigureConsoleNetwork(options awsnet.NetworkOptions) error
+}
+
+type SettingsController struct {
+	networkSettingsRepo settings.NetworkSettingsRepo
+	customRegionsRepo   settings.CustomRegionsRepo
+	awsCliSettingsRepo  settings.AwsCliSettingsRepo
+	awsSsoClient        awssso.AwsSsoOidcClient
+	awsStsClient        awssts.AwsStsClient
+	awsCliTokenSharing  AwsCliTokenSharing
+	consoleNetwork      ConsoleNetwork
+	regions             *awssso.RegionCatalog
+}
+
+func NewSettingsController(networkSettingsRepo settings.NetworkSettingsRepo, customRegionsRepo settings.CustomRegionsRepo, awsCliSettingsRepo settings.AwsCliSettingsRepo, awsSsoClient awssso.AwsSsoOidcClient, awsStsClient awssts.AwsStsClient, awsCliTokenSharing AwsCliTokenSharing, consoleNetwork ConsoleNetwork, regions *awssso.RegionCatalog) *SettingsController {
+	return &SettingsController{
+		networkSettingsRepo: networkSettingsRepo,
+		customRegionsRepo:   customRegionsRepo,
+		awsCliSettingsRepo:  awsCliSettingsRepo,
+		awsSsoClient:        awsSsoClient,
+		awsStsClient:        awsStsClient,
+		awsCliTokenSharing:  awsCliTokenSharing,
+		consoleNetwork:      consoleNetwork,
+		regions:             regions,
+	}
+}
+
+func (c *SettingsController) GetNetworkSettings(ctx app.Context) (*settings.NetworkSettings, error) {
+	networkSettings, err := c.networkSettingsRepo.Get(ctx)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	return networkSettings, nil
+}
+
+func (c *SettingsController) validateNetworkSettings(networkSettings *settings.NetworkSettings) error {
+	if networkSettings.RequestTimeoutSeconds < 1 || networkSettings.RequestTimeoutSeconds > maxRequestTimeoutSeconds {
+		return awsnet.ErrInvalidRequestTimeout
+	}
+
+	regions := make(map[string]bool, len(networkSettings.EndpointOverrides))
+
+	for _, override := range networkSettings.EndpointOverrides {
+		if _, ok := c.regions.Lookup(override.Region); !ok || regions[override.Region] {
+			return ErrInvalidEndpointOverride
+		}
+
+		if override.OidcEndpoint == "" && override.PortalEndpoint == "" {
+			return ErrInvalidEndpointOverride
+		}
+
+		regions[override.Region] = true
+	}
+
+	return awsnet.ValidateNetworkOptions(networkSettings.ClientOptions())
+}
+
+// SaveNetworkSettings persists network settings and applies them to AWS SSO and STS calls and console sign-ins right away.
+func (c *SettingsController) SaveNetworkSettings(ctx app.Context, networkSettings settings.NetworkSettings) error {
+	if networkSettings.EndpointOverrides == nil {
+		networkSettings.EndpointOverrides = []settings.EndpointOverride{}
+	}
+
+	if err := c.validateNetworkSettings(&networkSettings); err != nil {
+		return err
+	}
+
+	if err := c.networkSettingsRepo.Save(ctx, &networkSettings); err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	if err := c.awsSsoClient.Configure(networkSettings.ClientOptions()); err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	if err := c.awsStsClient.Configure(networkSettings.ClientOptions()); err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	if err := c.consoleNetwork.ConfigureConsoleNetwork(networkSettings.ClientOptions()); err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	ctx.Logger().Info().Msgf("network settings were updated, proxy mode is [%s]", networkSettings.ProxyMode)
+
+	return nil
+}
+
+// ApplyNetworkSettings configures AWS SSO and STS calls and console sign-ins with the persisted network settings.
+// Settings that can no longer be applied, e.g. because the CA bundle has become invalid, are logged and skipped.
+func (c *SettingsController) ApplyNetworkSettings(ctx app.Context) error {
+	networkSettings, err := c.networkSettingsRepo.Get(ctx)
+
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	if err := c.awsSsoClient.Configure(networkSettings.ClientOptions()); err != nil {
+		ctx.Logger().Error().Err(err).Msg("failed to apply network settings, using the defaults")
+	}
+
+	if err := c.awsStsClient.Configure(networkSettings.ClientOptions()); err != nil {
+		ctx.Logger().Error().Err(err).Msg("failed to apply network settings to AWS STS calls, using the defaults")
+	}
+
+	if err := c.consoleNetwork.ConfigureConsoleNetwork(networkSettings.ClientOptions()); err != nil {
+		ctx.Logger().Error().Err(err).Msg("failed to apply network settings to console sign-ins, using the defaults")
+	}
+
+	return nil
+}
+
+func (c *SettingsController) GetAwsCliSettings(ctx app.Context) (*settings.AwsCliSettings, error) {
+	awsCliSettings, err := c.awsCliSettingsRepo.Get(ctx)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	return awsCliSettings, nil
+}
+
+// SaveAwsCliSettings persists AWS CLI settings and applies them to access tokens obtained from now on.
+func (c *SettingsController) SaveAwsCliSettings(ctx app.Context, awsCliSettings settings.AwsCliSettings) error {
+	if err := c.awsCliSettingsRepo.Save(ctx, &awsCliSettings); err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	c.awsCliTokenSharing.SetAwsCliTokenSharing(awsCliSettings.ShareSsoTokens)
+
+	ctx.Logger().Info().Msgf("AWS CLI settings were updated, sharing SSO tokens is [%t]", awsCliSettings.ShareSsoTokens)
+
+	return nil
+}
+
+// ApplyAwsCliSettings shares access tokens with the AWS CLI only if the user opted in.
+func (c *SettingsController) ApplyAwsCliSettings(ctx app.Context) error {
+	awsCliSettings, err := c.awsCliSettingsRepo.Get(ctx)
+
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	c.awsCliTokenSharing.SetAwsCliTokenSharing(awsCliSettings.ShareSsoTokens)
+
+	return nil
+}
+
+func (c *SettingsController) ListAwsRegions(ctx app.Context) []awssso.Region {
+	return c.regions.List()
+}
+
+// AddCustomAwsRegion makes a region that was launched after this version of Swervo available for new instances.
+func (c *SettingsController) AddCustomAwsRegion(ctx app.Context, region string) (*awssso.Region, error) {
+	customRegion, err := c.regions.AddCustomRegion(region)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.customRegionsRepo.Add(ctx, region); err != nil {
+		c.regions.RemoveCustomRegion(region)
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	ctx.Logger().Info().Msgf("custom region [%s] of partition [%s] was added", region, customRegion.Partition)
+
+	return &customRegion, nil
+}
+
+// RemoveCustomAwsRegion stops offering a custom region for new instances, existing instances keep working.
+func (c *SettingsController) RemoveCustomAwsRegion(ctx app.Context, region string) error {
+	if err := c.regions.RemoveCustomRegion(region); err != nil {
+		return err
+	}
+
+	if err := c.customRegionsRepo.Remove(ctx, region); err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	return nil
+}
+
+// LoadCustomAwsRegions adds the persisted custom regions to the region catalog.
+func (c *SettingsController) LoadCustomAwsRegions(ctx app.Context) error {
+	regions, err := c.customRegionsRepo.ListAll(ctx)
+
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	for _, region := range regions {
+		if _, err := c.regions.AddCustomRegion(region); err != nil {
+			// the region may have become a built-in one since it was added
+			ctx.Logger().Info().Err(err).Msgf("skipping custom region [%s]", region)
+		}
+	}
+
+	return nil
+}
+
+// networkSettingsFromCommandInput reads network settings as sent by the frontend
+func networkSettingsFromCommandInput(commandInput map[string]any) settings.NetworkSettings {
+	networkSettings := settings.NetworkSettings{
+		ProxyMode:             awsnet.ProxyMode(commandInput["proxyMode"].(string)),
+		ProxyUrl:              commandInput["proxyUrl"].(string),
+		CaBundlePem:           commandInput["caBundlePem"].(string),
+		RequestTimeoutSeconds: int(commandInput["requestTimeoutSeconds"].(float64)),
+		EndpointOverrides:     []settings.EndpointOverride{},
+	}
+
+	overrides, _ := commandInput["endpointOverrides"].([]any)
+
+	for _, override := range overrides {
+		fields, _ := override.(map[string]any)
+
+		region, _ := fields["region"].(string)
+		oidcEndpoint, _ := fields["oidcEndpoint"].(string)
+		portalEndpoint, _ := fields["portalEndpoint"].(string)
+
+		networkSettings.EndpointOverrides = append(networkSettings.EndpointOverrides, settings.EndpointOverride{
+			Region:         region,
+			OidcEndpoint:   oidcEndpoint,
+			PortalEndpoint: portalEndpoint,
+		})
+	}
+
+	return networkSettings
+}
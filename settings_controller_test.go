@@ -0,0 +1,183 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/abjrcode/swervo/clients/awsnet"
+	"github.com/abjrcode/swervo/clients/awssso"
+	"github.com/abjrcode/swervo/clients/awssts"
+	"github.com/abjrcode/swervo/internal/migrations"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/abjrcode/swervo/settings"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAwsCliTokenSharing struct {
+	enabled bool
+}
+
+func (f *fakeAwsCliTokenSharing) SetAwsCliTokenSharing(enabled bool) {
+	f.enabled = enabled
+}
+
+type fakeConsoleNetwork struct {
+	options *awsnet.NetworkOptions
+}
+
+func (f *fakeConsoleNetwork) ConfigureConsoleNetwork(options awsnet.NetworkOptions) error {
+	f.options = &options
+	return nil
+}
+
+func initSettingsController(t *testing.T) *SettingsController {
+	db, err := migrations.NewInMemoryMigratedDatabase(t, "settings-controller-tests.db")
+	require.NoError(t, err)
+
+	awsSsoClient, err := awssso.NewAwsSsoOidcClient(awsnet.DefaultClientOptions, testhelpers.NewMockClock())
+	require.NoError(t, err)
+
+	awsStsClient, err := awssts.NewAwsStsClient(awsnet.DefaultClientOptions, testhelpers.NewMockClock())
+	require.NoError(t, err)
+
+	return NewSettingsController(settings.NewNetworkSettings(db), settings.NewCustomRegions(db), settings.NewAwsCliSettings(db), awsSsoClient, awsStsClient, &fakeAwsCliTokenSharing{}, &fakeConsoleNetwork{}, awssso.NewRegionCatalog())
+}
+
+func TestSaveNetworkSettings(t *testing.T) {
+	controller := initSettingsController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	savedConsoleNetwork := &fakeConsoleNetwork{}
+	controller.consoleNetwork = savedConsoleNetwork
+
+	err := controller.SaveNetworkSettings(ctx, settings.NetworkSettings{
+		ProxyMode:             awsnet.ProxyExplicit,
+		ProxyUrl:              "http://proxy.corp.internal:3128",
+		RequestTimeoutSeconds: 20,
+		EndpointOverrides: []settings.EndpointOverride{
+			{Region: "eu-west-1", OidcEndpoint: "https://oidc.vpce.internal"},
+		},
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, savedConsoleNetwork.options, "console sign-ins use saved settings right away")
+	require.Equal(t, awsnet.ProxyExplicit, savedConsoleNetwork.options.ProxyMode)
+	require.Equal(t, "http://proxy.corp.internal:3128", savedConsoleNetwork.options.ProxyUrl)
+
+	networkSettings, err := controller.GetNetworkSettings(ctx)
+	require.NoError(t, err)
+	require.Equal(t, awsnet.ProxyExplicit, networkSettings.ProxyMode)
+	require.Equal(t, 20, networkSettings.RequestTimeoutSeconds)
+	require.Len(t, networkSettings.EndpointOverrides, 1)
+
+	consoleNetwork := &fakeConsoleNetwork{}
+	controller.consoleNetwork = consoleNetwork
+
+	require.NoError(t, controller.ApplyNetworkSettings(ctx))
+	require.NotNil(t, consoleNetwork.options)
+	require.Equal(t, "http://proxy.corp.internal:3128", consoleNetwork.options.ProxyUrl)
+}
+
+func TestSaveNetworkSettings_Invalid(t *testing.T) {
+	testCases := []struct {
+		name     string
+		settings settings.NetworkSettings
+		expected error
+	}{
+		{name: "timeout too short", settings: settings.NetworkSettings{RequestTimeoutSeconds: 0}, expected: awsnet.ErrInvalidRequestTimeout},
+		{name: "timeout too long", settings: settings.NetworkSettings{RequestTimeoutSeconds: maxRequestTimeoutSeconds + 1}, expected: awsnet.ErrInvalidRequestTimeout},
+		{name: "invalid proxy url", settings: settings.NetworkSettings{ProxyMode: awsnet.ProxyExplicit, ProxyUrl: "proxy", RequestTimeoutSeconds: 30}, expected: awsnet.ErrInvalidProxyUrl},
+		{name: "invalid ca bundle", settings: settings.NetworkSettings{CaBundlePem: "garbage", RequestTimeoutSeconds: 30}, expected: awsnet.ErrInvalidCaBundle},
+		{
+			name: "unknown region",
+			settings: settings.NetworkSettings{RequestTimeoutSeconds: 30, EndpointOverrides: []settings.EndpointOverride{
+				{Region: "mars-north-1", OidcEndpoint: "https://oidc.vpce.internal"},
+			}},
+			expected: ErrInvalidEndpointOverride,
+		},
+		{
+			name: "duplicate region",
+			settings: settings.NetworkSettings{RequestTimeoutSeconds: 30, EndpointOverrides: []settings.EndpointOverride{
+				{Region: "eu-west-1", OidcEndpoint: "https://oidc.vpce.internal"},
+				{Region: "eu-west-1", PortalEndpoint: "https://portal.vpce.internal"},
+			}},
+			expected: ErrInvalidEndpointOverride,
+		},
+		{
+			name: "empty override",
+			settings: settings.NetworkSettings{RequestTimeoutSeconds: 30, EndpointOverrides: []settings.EndpointOverride{
+				{Region: "eu-west-1"},
+			}},
+			expected: ErrInvalidEndpointOverride,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			controller := initSettingsController(t)
+			ctx := testhelpers.NewMockAppContext()
+
+			err := controller.SaveNetworkSettings(ctx, testCase.settings)
+			require.Same(t, testCase.expected, err)
+
+			networkSettings, err := controller.GetNetworkSettings(ctx)
+			require.NoError(t, err)
+			require.Equal(t, settings.DefaultNetworkSettings, *networkSettings, "invalid settings must not be saved")
+		})
+	}
+}
+
+func TestCustomAwsRegions(t *testing.T) {
+	controller := initSettingsController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	region, err := controller.AddCustomAwsRegion(ctx, "ap-southeast-7")
+	require.NoError(t, err)
+	require.Equal(t, awssso.PartitionAws, region.Partition)
+
+	_, err = controller.AddCustomAwsRegion(ctx, "not a region")
+	require.Same(t, awssso.ErrInvalidCustomRegion, err)
+
+	require.Contains(t, controller.ListAwsRegions(ctx), *region)
+
+	restarted := NewSettingsController(controller.networkSettingsRepo, controller.customRegionsRepo, controller.awsCliSettingsRepo, controller.awsSsoClient, controller.awsStsClient, controller.awsCliTokenSharing, controller.consoleNetwork, awssso.NewRegionCatalog())
+	require.NoError(t, restarted.LoadCustomAwsRegions(ctx))
+	require.Contains(t, restarted.ListAwsRegions(ctx), *region)
+
+	err = restarted.SaveNetworkSettings(ctx, settings.NetworkSettings{
+		RequestTimeoutSeconds: 30,
+		EndpointOverrides: []settings.EndpointOverride{
+			{Region: "ap-southeast-7", OidcEndpoint: "https://oidc.vpce.internal"},
+		},
+	})
+	require.NoError(t, err, "custom regions can have endpoint overrides")
+
+	require.NoError(t, restarted.RemoveCustomAwsRegion(ctx, "ap-southeast-7"))
+	require.Same(t, awssso.ErrRegionNotCustom, restarted.RemoveCustomAwsRegion(ctx, "eu-west-1"))
+
+	customRegions, err := restarted.customRegionsRepo.ListAll(ctx)
+	require.NoError(t, err)
+	require.Empty(t, customRegions)
+}
+
+func TestAwsCliSettings(t *testing.T) {
+	controller := initSettingsController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	tokenSharing := &fakeAwsCliTokenSharing{enabled: true}
+	controller.awsCliTokenSharing = tokenSharing
+
+	awsCliSettings, err := controller.GetAwsCliSettings(ctx)
+	require.NoError(t, err)
+	require.Equal(t, settings.DefaultAwsCliSettings, *awsCliSettings)
+
+	require.NoError(t, controller.ApplyAwsCliSettings(ctx))
+	require.False(t, tokenSharing.enabled, "tokens are not shared with the AWS CLI unless the user opts in")
+
+	require.NoError(t, controller.SaveAwsCliSettings(ctx, settings.AwsCliSettings{ShareSsoTokens: true}))
+	require.True(t, tokenSharing.enabled)
+
+	tokenSharing.enabled = false
+
+	require.NoError(t, controller.ApplyAwsCliSettings(ctx))
+	require.True(t, tokenSharing.enabled)
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/abjrcode/swervo/clients/awscredsfile"
 	"github.com/abjrcode/swervo/internal/app"
 	"github.com/abjrcode/swervo/internal/eventing"
 	"github.com/abjrcode/swervo/internal/plumbing"
@@ -25,8 +26,18 @@ var (
 	ErrInvalidProviderId         = app.NewValidationError("INVALID_PROVIDER_ID")
 	ErrInstanceWasNotFound       = app.NewValidationError("INSTANCE_WAS_NOT_FOUND")
 	ErrInstanceAlreadyRegistered = app.NewValidationError("INSTANCE_ALREADY_REGISTERED")
+	ErrInvalidSourceSelector     = app.NewValidationError("INVALID_SOURCE_SELECTOR")
 )
 
+var AwsCredentialsSinkEventSource = eventing.EventSource("AwsCredentialsSink")
+
+type AwsCredentialsSinkDrainedEvent struct {
+	InstanceId string
+
+	ProviderCode string
+	ProviderId   string
+}
+
 type ProfileCreds struct {
 	AwsAccessKeyId     string
 	AwsSecretAccessKey string
@@ -39,7 +50,7 @@ type AwsCredentialsSinkController struct {
 	encryptionService encryption.EncryptionService
 	clock             utils.Clock
 
-	instances map[string]*AwsCredentialsSinkInstance
+	sourceValidators map[string]plumbing.SourceValidator
 }
 
 func NewAwsCredentialsSinkController(db *sql.DB, bus *eventing.Eventbus, encryptionService encryption.EncryptionService, clock utils.Clock) *AwsCredentialsSinkController {
@@ -49,7 +60,15 @@ func NewAwsCredentialsSinkController(db *sql.DB, bus *eventing.Eventbus, encrypt
 		encryptionService: encryptionService,
 		clock:             clock,
 
-		instances: make(map[string]*AwsCredentialsSinkInstance),
+		sourceValidators: make(map[string]plumbing.SourceValidator),
+	}
+}
+
+// AddSourceValidators registers the providers whose source selectors are checked when a sink is created.
+// Sinks of providers without a validator cannot carry a source selector.
+func (c *AwsCredentialsSinkController) AddSourceValidators(validators ...plumbing.SourceValidator) {
+	for _, validator := range validators {
+		c.sourceValidators[validator.ProviderCode()] = validator
 	}
 }
 
@@ -63,16 +82,68 @@ type AwsCredentialsSinkInstance struct {
 	ProviderId     string `json:"providerId"`
 	CreatedAt      int64  `json:"createdAt"`
 	LastDrainedAt  *int64 `json:"lastDrainedAt"`
+
+	SourceSelector plumbing.SourceSelector `json:"sourceSelector"`
+}
+
+const instanceColumns = "instance_id, version, file_path, aws_profile_name, label, provider_code, provider_id, created_at, last_drained_at, source_account_id, source_role_name"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanInstance(row rowScanner) (*AwsCredentialsSinkInstance, error) {
+	var instance AwsCredentialsSinkInstance
+	var lastDrainedAt sql.NullInt64
+
+	err := row.Scan(&instance.InstanceId, &instance.Version, &instance.FilePath, &instance.AwsProfileName, &instance.Label,
+		&instance.ProviderCode, &instance.ProviderId, &instance.CreatedAt, &lastDrainedAt,
+		&instance.SourceSelector.AccountId, &instance.SourceSelector.RoleName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if lastDrainedAt.Valid {
+		instance.LastDrainedAt = &lastDrainedAt.Int64
+	}
+
+	return &instance, nil
+}
+
+func (c *AwsCredentialsSinkController) ListInstances(ctx app.Context) ([]AwsCredentialsSinkInstance, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT "+instanceColumns+" FROM aws_credentials_file ORDER BY instance_id DESC")
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+	defer rows.Close()
+
+	instances := make([]AwsCredentialsSinkInstance, 0)
+
+	for rows.Next() {
+		instance, err := scanInstance(rows)
+
+		if err != nil {
+			return nil, errors.Join(err, app.ErrFatal)
+		}
+
+		instances = append(instances, *instance)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	return instances, nil
 }
 
 func (c *AwsCredentialsSinkController) GetInstanceData(ctx app.Context, instanceId string) (*AwsCredentialsSinkInstance, error) {
-	row := c.db.QueryRowContext(ctx, "SELECT file_path, aws_profile_name, label FROM aws_credentials_file WHERE instance_id = ?", instanceId)
+	row := c.db.QueryRowContext(ctx, "SELECT "+instanceColumns+" FROM aws_credentials_file WHERE instance_id = ?", instanceId)
 
-	var filePath string
-	var awsProfileName string
-	var label string
+	instance, err := scanInstance(row)
 
-	if err := row.Scan(&filePath, &awsProfileName, &label); err != nil {
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrInstanceWasNotFound
 		}
@@ -80,12 +151,7 @@ func (c *AwsCredentialsSinkController) GetInstanceData(ctx app.Context, instance
 		return nil, errors.Join(err, app.ErrFatal)
 	}
 
-	return &AwsCredentialsSinkInstance{
-		InstanceId:     instanceId,
-		FilePath:       filePath,
-		AwsProfileName: awsProfileName,
-		Label:          label,
-	}, nil
+	return instance, nil
 }
 
 func (c *AwsCredentialsSinkController) validateLabel(label string) error {
@@ -101,8 +167,23 @@ type AwsCredentialsSink_NewInstanceCommandInput struct {
 	AwsProfileName string `json:"awsProfileName"`
 	Label          string `json:"label"`
 
-	ProviderCode string `json:"providerCode"`
-	ProviderId   string `json:"providerId"`
+	ProviderCode   string                  `json:"providerCode"`
+	ProviderId     string                  `json:"providerId"`
+	SourceSelector plumbing.SourceSelector `json:"sourceSelector"`
+}
+
+func (c *AwsCredentialsSinkController) validateSourceSelector(ctx app.Context, providerCode, providerId string, selector plumbing.SourceSelector) error {
+	validator, ok := c.sourceValidators[providerCode]
+
+	if !ok {
+		if !selector.IsEmpty() {
+			return ErrInvalidSourceSelector
+		}
+
+		return nil
+	}
+
+	return validator.ValidateSourceSelector(ctx, providerId, selector)
 }
 
 func (c *AwsCredentialsSinkController) NewInstance(ctx app.Context, input AwsCredentialsSink_NewInstanceCommandInput) (string, error) {
@@ -127,6 +208,10 @@ func (c *AwsCredentialsSinkController) NewInstance(ctx app.Context, input AwsCre
 		return "", ErrInvalidProviderId
 	}
 
+	if err := c.validateSourceSelector(ctx, input.ProviderCode, input.ProviderId, input.SourceSelector); err != nil {
+		return "", err
+	}
+
 	nowUnix := c.clock.NowUnix()
 
 	uniqueId, err := ksuid.NewRandomWithTime(time.Unix(nowUnix, 0))
@@ -138,24 +223,14 @@ func (c *AwsCredentialsSinkController) NewInstance(ctx app.Context, input AwsCre
 	version := 1
 
 	_, err = c.db.ExecContext(ctx,
-		"INSERT INTO aws_credentials_file (instance_id, version, file_path, aws_profile_name, label, provider_code, provider_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
-		instanceId, version, filePath, awsProfileName, input.Label, input.ProviderCode, input.ProviderId, nowUnix)
+		"INSERT INTO aws_credentials_file (instance_id, version, file_path, aws_profile_name, label, provider_code, provider_id, created_at, source_account_id, source_role_name) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		instanceId, version, filePath, awsProfileName, input.Label, input.ProviderCode, input.ProviderId, nowUnix,
+		input.SourceSelector.AccountId, input.SourceSelector.RoleName)
 
 	if err != nil {
 		return "", errors.Join(err, app.ErrFatal)
 	}
 
-	c.instances[instanceId] = &AwsCredentialsSinkInstance{
-		InstanceId:     instanceId,
-		Version:        version,
-		FilePath:       filePath,
-		AwsProfileName: awsProfileName,
-		Label:          input.Label,
-		ProviderCode:   input.ProviderCode,
-		ProviderId:     input.ProviderId,
-		CreatedAt:      nowUnix,
-	}
-
 	return instanceId, nil
 }
 
@@ -164,15 +239,33 @@ func (c *AwsCredentialsSinkController) SinkCode() string {
 }
 
 func (c *AwsCredentialsSinkController) ListConnectedSinks(ctx app.Context, providerCode, providerId string) ([]plumbing.SinkInstance, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT instance_id, source_account_id, source_role_name FROM aws_credentials_file WHERE provider_code = ? AND provider_id = ? ORDER BY instance_id",
+		providerCode, providerId)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+	defer rows.Close()
+
 	pipes := make([]plumbing.SinkInstance, 0)
 
-	for _, instance := range c.instances {
-		if instance.ProviderCode == providerCode && instance.ProviderId == providerId {
-			pipes = append(pipes, plumbing.SinkInstance{
-				SinkCode: SinkCode,
-				SinkId:   instance.InstanceId,
-			})
+	for rows.Next() {
+		var instanceId string
+		var selector plumbing.SourceSelector
+
+		if err := rows.Scan(&instanceId, &selector.AccountId, &selector.RoleName); err != nil {
+			return nil, errors.Join(err, app.ErrFatal)
 		}
+
+		pipes = append(pipes, plumbing.SinkInstance{
+			SinkCode:       SinkCode,
+			SinkId:         instanceId,
+			SourceSelector: selector,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
 	}
 
 	return pipes, nil
@@ -182,14 +275,102 @@ func (c *AwsCredentialsSinkController) DisconnectSink(ctx app.Context, input plu
 	_, err := c.db.ExecContext(ctx, "DELETE FROM aws_credentials_file WHERE instance_id = ?", input.SinkId)
 
 	if err != nil {
-		return err
+		return errors.Join(err, app.ErrFatal)
 	}
 
-	delete(c.instances, input.SinkId)
-
 	return nil
 }
 
-func (c *AwsCredentialsSinkController) FlowData(ctx app.Context, creds awsidc.AwsCredentials, pipeId string) error {
+// FlowData writes the given credentials into the profile of the credentials file the sink points to.
+// Every successful write bumps the sink version and is recorded as an [AwsCredentialsSinkDrainedEvent].
+func (c *AwsCredentialsSinkController) FlowData(ctx app.Context, creds awsidc.AwsCredentials, sinkId string) error {
+	row := c.db.QueryRowContext(ctx, "SELECT version, file_path, aws_profile_name, provider_code, provider_id FROM aws_credentials_file WHERE instance_id = ?", sinkId)
+
+	var version int
+	var filePath string
+	var awsProfileName string
+	var providerCode string
+	var providerId string
+
+	if err := row.Scan(&version, &filePath, &awsProfileName, &providerCode, &providerId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInstanceWasNotFound
+		}
+
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	var sessionToken *string
+
+	if creds.SessionToken != "" {
+		sessionToken = &creds.SessionToken
+	}
+
+	ctx.Logger().Info().Msgf("writing credentials to profile [%s] of file [%s]", awsProfileName, filePath)
+
+	credsFile := awscredsfile.NewCredentialsFileManager(filePath)
+
+	err := credsFile.WriteProfileCredentials(awsProfileName, awscredsfile.ProfileCreds{
+		AwsAccessKeyId:     creds.AccessKeyID,
+		AwsSecretAccessKey: creds.SecretAccessKey,
+		AwsSessionToken:    sessionToken,
+	})
+
+	if err != nil {
+		if errors.Is(err, app.ErrValidation) {
+			return err
+		}
+
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	nowUnix := c.clock.NowUnix()
+	newVersion := version + 1
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE aws_credentials_file SET version = ?, last_drained_at = ? WHERE instance_id = ? AND version = ?",
+		newVersion, nowUnix, sinkId, version)
+
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	if rowsAffected != 1 {
+		ctx.Logger().Warn().Msgf("sink [%s] was removed or modified while credentials were being written", sinkId)
+		return ErrInstanceWasNotFound
+	}
+
+	publish, err := c.bus.PublishTx(ctx, AwsCredentialsSinkDrainedEvent{
+		InstanceId:   sinkId,
+		ProviderCode: providerCode,
+		ProviderId:   providerId,
+	}, eventing.EventMeta{
+		SourceType:   AwsCredentialsSinkEventSource,
+		SourceId:     sinkId,
+		EventVersion: uint(newVersion),
+	}, tx)
+
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	publish()
+
 	return nil
 }
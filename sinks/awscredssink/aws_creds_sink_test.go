@@ -1,17 +1,52 @@
 package awscredssink
 
 import (
+	"errors"
+	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/abjrcode/swervo/internal/app"
 	"github.com/abjrcode/swervo/internal/eventing"
 	"github.com/abjrcode/swervo/internal/migrations"
 	"github.com/abjrcode/swervo/internal/plumbing"
 	"github.com/abjrcode/swervo/internal/security/vault"
 	"github.com/abjrcode/swervo/internal/testhelpers"
+	awsidc "github.com/abjrcode/swervo/providers/aws_idc"
 	"github.com/stretchr/testify/require"
 )
 
+var errFakeInvalidSelector = app.NewValidationError("FAKE_INVALID_SELECTOR")
+
+type fakeSourceValidator struct{}
+
+func (v *fakeSourceValidator) ProviderCode() string {
+	return "some-provider-code"
+}
+
+func (v *fakeSourceValidator) ValidateSourceSelector(ctx app.Context, providerId string, selector plumbing.SourceSelector) error {
+	if selector.AccountId != "test-account-id" || selector.RoleName == "" {
+		return errFakeInvalidSelector
+	}
+
+	return nil
+}
+
+// fakeSingleIdentityValidator stands for providers whose instances have a single identity, e.g. IAM users
+type fakeSingleIdentityValidator struct{}
+
+func (v *fakeSingleIdentityValidator) ProviderCode() string {
+	return "single-identity-provider-code"
+}
+
+func (v *fakeSingleIdentityValidator) ValidateSourceSelector(ctx app.Context, providerId string, selector plumbing.SourceSelector) error {
+	if !selector.IsEmpty() {
+		return errFakeInvalidSelector
+	}
+
+	return nil
+}
+
 func TestNewInstance(t *testing.T) {
 	db, err := migrations.NewInMemoryMigratedDatabase(t, "aws_credentials_sink_tests")
 	require.NoError(t, err)
@@ -41,12 +76,80 @@ func TestNewInstance(t *testing.T) {
 
 	require.Equal(t, &AwsCredentialsSinkInstance{
 		InstanceId:     instanceId,
+		Version:        1,
 		FilePath:       filePath,
 		AwsProfileName: "test-profile",
 		Label:          "default",
+		ProviderCode:   "some-provider-code",
+		ProviderId:     "some-provider-id",
+		CreatedAt:      1,
+		LastDrainedAt:  nil,
 	}, instance)
 }
 
+func Test_ListInstances(t *testing.T) {
+	db, err := migrations.NewInMemoryMigratedDatabase(t, "aws_credentials_sink_tests")
+	require.NoError(t, err)
+	mockClock := testhelpers.NewMockClock()
+	bus := eventing.NewEventbus(db, mockClock)
+	encryptionService := vault.NewVault(db, bus, mockClock)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	controller := NewAwsCredentialsSinkController(db, bus, encryptionService, mockClock)
+
+	instances, err := controller.ListInstances(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []AwsCredentialsSinkInstance{}, instances)
+
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "credentials")
+
+	firstCall := mockClock.On("NowUnix").Return(1)
+	instanceId, err := controller.NewInstance(ctx, AwsCredentialsSink_NewInstanceCommandInput{
+		FilePath:       filePath,
+		AwsProfileName: "first-profile",
+		Label:          "first",
+		ProviderCode:   "some-provider-code",
+		ProviderId:     "some-provider-id",
+	})
+	require.NoError(t, err)
+	firstCall.Unset()
+
+	mockClock.On("NowUnix").Return(2)
+	instanceId2, err := controller.NewInstance(ctx, AwsCredentialsSink_NewInstanceCommandInput{
+		FilePath:       filePath,
+		AwsProfileName: "second-profile",
+		Label:          "second",
+		ProviderCode:   "some-provider-code",
+		ProviderId:     "some-other-provider-id",
+	})
+	require.NoError(t, err)
+
+	instances, err = controller.ListInstances(ctx)
+	require.NoError(t, err)
+
+	require.Equal(t, []AwsCredentialsSinkInstance{{
+		InstanceId:     instanceId2,
+		Version:        1,
+		FilePath:       filePath,
+		AwsProfileName: "second-profile",
+		Label:          "second",
+		ProviderCode:   "some-provider-code",
+		ProviderId:     "some-other-provider-id",
+		CreatedAt:      2,
+	}, {
+		InstanceId:     instanceId,
+		Version:        1,
+		FilePath:       filePath,
+		AwsProfileName: "first-profile",
+		Label:          "first",
+		ProviderCode:   "some-provider-code",
+		ProviderId:     "some-provider-id",
+		CreatedAt:      1,
+	}}, instances)
+}
+
 func Test_DeleteInstance(t *testing.T) {
 	db, err := migrations.NewInMemoryMigratedDatabase(t, "aws_credentials_sink_tests")
 	require.NoError(t, err)
@@ -114,6 +217,41 @@ func Test_ListConnectedSinks(t *testing.T) {
 	}}, instances)
 }
 
+func Test_ListConnectedSinks_AfterRestart(t *testing.T) {
+	db, err := migrations.NewInMemoryMigratedDatabase(t, "aws_credentials_sink_tests")
+	require.NoError(t, err)
+	mockClock := testhelpers.NewMockClock()
+	bus := eventing.NewEventbus(db, mockClock)
+	encryptionService := vault.NewVault(db, bus, mockClock)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	controller := NewAwsCredentialsSinkController(db, bus, encryptionService, mockClock)
+
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "credentials")
+	mockClock.On("NowUnix").Return(1)
+
+	instanceId, err := controller.NewInstance(ctx, AwsCredentialsSink_NewInstanceCommandInput{
+		FilePath:       filePath,
+		AwsProfileName: "default",
+		Label:          "default",
+		ProviderCode:   "some-provider-code",
+		ProviderId:     "some-provider-id",
+	})
+	require.NoError(t, err)
+
+	restartedController := NewAwsCredentialsSinkController(db, bus, encryptionService, mockClock)
+
+	instances, err := restartedController.ListConnectedSinks(ctx, "some-provider-code", "some-provider-id")
+	require.NoError(t, err)
+
+	require.Equal(t, []plumbing.SinkInstance{{
+		SinkCode: SinkCode,
+		SinkId:   instanceId,
+	}}, instances)
+}
+
 func Test_ListConnectedSinks_WhenNoneExist(t *testing.T) {
 	db, err := migrations.NewInMemoryMigratedDatabase(t, "aws_credentials_sink_tests")
 	require.NoError(t, err)
@@ -130,3 +268,224 @@ func Test_ListConnectedSinks_WhenNoneExist(t *testing.T) {
 
 	require.Equal(t, []plumbing.SinkInstance{}, instances)
 }
+
+func Test_FlowData(t *testing.T) {
+	db, err := migrations.NewInMemoryMigratedDatabase(t, "aws_credentials_sink_tests")
+	require.NoError(t, err)
+	mockClock := testhelpers.NewMockClock()
+	bus := eventing.NewEventbus(db, mockClock)
+	encryptionService := vault.NewVault(db, bus, mockClock)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	controller := NewAwsCredentialsSinkController(db, bus, encryptionService, mockClock)
+
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "credentials")
+	createdAtCall := mockClock.On("NowUnix").Return(1)
+
+	instanceId, err := controller.NewInstance(ctx, AwsCredentialsSink_NewInstanceCommandInput{
+		FilePath:       filePath,
+		AwsProfileName: "test-profile",
+		Label:          "default",
+		ProviderCode:   "some-provider-code",
+		ProviderId:     "some-provider-id",
+	})
+	require.NoError(t, err)
+
+	createdAtCall.Unset()
+	mockClock.On("NowUnix").Return(5)
+
+	ch := bus.Subscribe(AwsCredentialsSinkEventSource)
+
+	err = controller.FlowData(ctx, awsidc.AwsCredentials{
+		AccessKeyID:     "test-access-key-id",
+		SecretAccessKey: "test-secret-access-key",
+		SessionToken:    "test-session-token",
+	}, instanceId)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+
+	require.Equal(t, `[test-profile]
+aws_access_key_id = test-access-key-id
+aws_secret_access_key = test-secret-access-key
+aws_session_token = test-session-token
+`, string(content))
+
+	instance, err := controller.GetInstanceData(ctx, instanceId)
+	require.NoError(t, err)
+	require.Equal(t, 2, instance.Version)
+	require.Equal(t, int64(5), *instance.LastDrainedAt)
+
+	event := <-ch
+
+	require.Equal(t, uint(2), event.EventVersion)
+	require.Equal(t, AwsCredentialsSinkDrainedEvent{
+		InstanceId:   instanceId,
+		ProviderCode: "some-provider-code",
+		ProviderId:   "some-provider-id",
+	}, event.Event)
+}
+
+func Test_FlowData_InstanceDoesNotExist(t *testing.T) {
+	db, err := migrations.NewInMemoryMigratedDatabase(t, "aws_credentials_sink_tests")
+	require.NoError(t, err)
+	mockClock := testhelpers.NewMockClock()
+	bus := eventing.NewEventbus(db, mockClock)
+	encryptionService := vault.NewVault(db, bus, mockClock)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	controller := NewAwsCredentialsSinkController(db, bus, encryptionService, mockClock)
+
+	err = controller.FlowData(ctx, awsidc.AwsCredentials{
+		AccessKeyID:     "test-access-key-id",
+		SecretAccessKey: "test-secret-access-key",
+	}, "well-if-u-can-find-me-it-sucks")
+	require.ErrorIs(t, err, ErrInstanceWasNotFound)
+}
+
+func TestNewInstance_WithSourceSelector(t *testing.T) {
+	db, err := migrations.NewInMemoryMigratedDatabase(t, "aws_credentials_sink_tests")
+	require.NoError(t, err)
+	mockClock := testhelpers.NewMockClock()
+	bus := eventing.NewEventbus(db, mockClock)
+	encryptionService := vault.NewVault(db, bus, mockClock)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	controller := NewAwsCredentialsSinkController(db, bus, encryptionService, mockClock)
+	controller.AddSourceValidators(&fakeSourceValidator{})
+
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "credentials")
+	mockClock.On("NowUnix").Return(1)
+
+	selector := plumbing.SourceSelector{
+		AccountId: "test-account-id",
+		RoleName:  "test-role-name",
+	}
+
+	instanceId, err := controller.NewInstance(ctx, AwsCredentialsSink_NewInstanceCommandInput{
+		FilePath:       filePath,
+		AwsProfileName: "test-profile",
+		Label:          "default",
+		ProviderCode:   "some-provider-code",
+		ProviderId:     "some-provider-id",
+		SourceSelector: selector,
+	})
+	require.NoError(t, err)
+
+	instance, err := controller.GetInstanceData(ctx, instanceId)
+	require.NoError(t, err)
+	require.Equal(t, selector, instance.SourceSelector)
+
+	sinks, err := controller.ListConnectedSinks(ctx, "some-provider-code", "some-provider-id")
+	require.NoError(t, err)
+	require.Equal(t, []plumbing.SinkInstance{{
+		SinkCode:       SinkCode,
+		SinkId:         instanceId,
+		SourceSelector: selector,
+	}}, sinks)
+}
+
+func TestNewInstance_Error_InvalidSourceSelector(t *testing.T) {
+	db, err := migrations.NewInMemoryMigratedDatabase(t, "aws_credentials_sink_tests")
+	require.NoError(t, err)
+	mockClock := testhelpers.NewMockClock()
+	bus := eventing.NewEventbus(db, mockClock)
+	encryptionService := vault.NewVault(db, bus, mockClock)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	controller := NewAwsCredentialsSinkController(db, bus, encryptionService, mockClock)
+	controller.AddSourceValidators(&fakeSourceValidator{}, &fakeSingleIdentityValidator{})
+
+	filePath := filepath.Join(t.TempDir(), "credentials")
+	mockClock.On("NowUnix").Return(1)
+
+	type test struct {
+		name         string
+		providerCode string
+		selector     plumbing.SourceSelector
+		want         error
+	}
+
+	tests := []test{
+		{
+			name:         "Missing selector rejected by the provider",
+			providerCode: "some-provider-code",
+			selector:     plumbing.SourceSelector{},
+			want:         errFakeInvalidSelector,
+		},
+		{
+			name:         "Selector for a provider with a single identity",
+			providerCode: "single-identity-provider-code",
+			selector:     plumbing.SourceSelector{AccountId: "test-account-id", RoleName: "test-role-name"},
+			want:         errFakeInvalidSelector,
+		},
+		{
+			name:         "Selector rejected by the provider",
+			providerCode: "some-provider-code",
+			selector:     plumbing.SourceSelector{AccountId: "unknown-account-id", RoleName: "test-role-name"},
+			want:         errFakeInvalidSelector,
+		},
+		{
+			name:         "Selector for a provider without a validator",
+			providerCode: "some-other-provider-code",
+			selector:     plumbing.SourceSelector{AccountId: "test-account-id", RoleName: "test-role-name"},
+			want:         ErrInvalidSourceSelector,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := controller.NewInstance(ctx, AwsCredentialsSink_NewInstanceCommandInput{
+				FilePath:       filePath,
+				AwsProfileName: "test-profile",
+				Label:          "default",
+				ProviderCode:   tt.providerCode,
+				ProviderId:     "some-provider-id",
+				SourceSelector: tt.selector,
+			})
+
+			require.True(t, errors.Is(err, app.ErrValidation))
+			require.Equal(t, tt.want.Error(), err.Error())
+		})
+	}
+
+	instances, err := controller.ListInstances(ctx)
+	require.NoError(t, err)
+	require.Empty(t, instances)
+}
+
+func TestNewInstance_SingleIdentitySource(t *testing.T) {
+	db, err := migrations.NewInMemoryMigratedDatabase(t, "aws_credentials_sink_tests")
+	require.NoError(t, err)
+	mockClock := testhelpers.NewMockClock()
+	bus := eventing.NewEventbus(db, mockClock)
+	encryptionService := vault.NewVault(db, bus, mockClock)
+
+	ctx := testhelpers.NewMockAppContext()
+
+	controller := NewAwsCredentialsSinkController(db, bus, encryptionService, mockClock)
+	controller.AddSourceValidators(&fakeSingleIdentityValidator{})
+
+	filePath := filepath.Join(t.TempDir(), "credentials")
+	mockClock.On("NowUnix").Return(1)
+
+	instanceId, err := controller.NewInstance(ctx, AwsCredentialsSink_NewInstanceCommandInput{
+		FilePath:       filePath,
+		AwsProfileName: "test-profile",
+		Label:          "default",
+		ProviderCode:   "single-identity-provider-code",
+		ProviderId:     "some-provider-id",
+	})
+	require.NoError(t, err)
+
+	instance, err := controller.GetInstanceData(ctx, instanceId)
+	require.NoError(t, err)
+	require.True(t, instance.SourceSelector.IsEmpty())
+}
@@ -0,0 +1,361 @@
+// Package totp keeps TOTP secrets of MFA devices encrypted in the vault
+// so that providers can answer MFA prompts without the user reaching for their phone.
+package totp
+
+import (
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abjrcode/swervo/internal/app"
+	"github.com/abjrcode/swervo/internal/eventing"
+	"github.com/abjrcode/swervo/internal/security/encryption"
+	"github.com/abjrcode/swervo/internal/utils"
+	"github.com/segmentio/ksuid"
+)
+
+var (
+	ErrInvalidLabel                 = app.NewValidationError("INVALID_LABEL")
+	ErrInvalidMfaSerial             = app.NewValidationError("INVALID_MFA_SERIAL")
+	ErrInvalidTotpSecret            = app.NewValidationError("INVALID_TOTP_SECRET")
+	ErrUnsupportedTotpParameters    = app.NewValidationError("UNSUPPORTED_TOTP_PARAMETERS")
+	ErrInvalidConfirmationCode      = app.NewValidationError("INVALID_CONFIRMATION_CODE")
+	ErrAuthenticatorAlreadyImported = app.NewValidationError("AUTHENTICATOR_ALREADY_IMPORTED")
+	ErrAuthenticatorWasNotFound     = app.NewValidationError("AUTHENTICATOR_WAS_NOT_FOUND")
+	ErrTotpCodesExhausted           = app.NewValidationError("TOTP_CODES_EXHAUSTED")
+)
+
+var TotpEventSource = eventing.EventSource("Totp")
+
+// skewSteps is how many time steps before or after the current one are accepted,
+// AWS tolerates as much drift between its clock and the one of the device.
+const skewSteps = 1
+
+var mfaSerialRegex = regexp.MustCompile(`^[\w+=/:,.@-]{9,256}$`)
+
+// AuthenticatorImportedEvent records that a TOTP secret was imported, never the secret itself.
+type AuthenticatorImportedEvent struct {
+	InstanceId string
+
+	Label     string
+	MfaSerial string
+}
+
+type AuthenticatorRemovedEvent struct {
+	InstanceId string
+}
+
+// Authenticator describes an imported TOTP secret without the secret.
+type Authenticator struct {
+	InstanceId  string    `json:"instanceId"`
+	Label       string    `json:"label"`
+	MfaSerial   string    `json:"mfaSerial"`
+	Issuer      string    `json:"issuer"`
+	AccountName string    `json:"accountName"`
+	Algorithm   Algorithm `json:"algorithm"`
+	Digits      int       `json:"digits"`
+	Period      int64     `json:"period"`
+	CreatedAt   int64     `json:"createdAt"`
+}
+
+type TotpController struct {
+	db                *sql.DB
+	bus               *eventing.Eventbus
+	encryptionService encryption.EncryptionService
+	clock             utils.Clock
+
+	// codesMu makes sure a time step is handed out once even when codes are requested concurrently
+	codesMu sync.Mutex
+}
+
+func NewTotpController(db *sql.DB, bus *eventing.Eventbus, encryptionService encryption.EncryptionService, clock utils.Clock) *TotpController {
+	return &TotpController{
+		db:                db,
+		bus:               bus,
+		encryptionService: encryptionService,
+		clock:             clock,
+	}
+}
+
+type Totp_ImportCommandInput struct {
+	// Label defaults to the issuer and account name of an otpauth:// URI
+	Label string `json:"label"`
+	// MfaSerial is the ARN of the virtual MFA device the secret belongs to
+	MfaSerial string `json:"mfaSerial"`
+	// Secret is an otpauth://totp/ URI or a base32 secret
+	Secret string `json:"secret"`
+	// ConfirmationCode is checked against the secret when given, so that typos are caught before the first MFA prompt
+	ConfirmationCode string `json:"confirmationCode"`
+}
+
+// Import stores a TOTP secret encrypted with the vault and publishes [AuthenticatorImportedEvent].
+// The secret can never be read back, only codes are generated from it.
+func (c *TotpController) Import(ctx app.Context, input Totp_ImportCommandInput) (string, error) {
+	if !mfaSerialRegex.MatchString(input.MfaSerial) {
+		return "", ErrInvalidMfaSerial
+	}
+
+	key, err := ParseKey(input.Secret)
+
+	if err != nil {
+		return "", err
+	}
+
+	label := strings.TrimSpace(input.Label)
+
+	if label == "" {
+		label = strings.Trim(key.Issuer+":"+key.AccountName, ":")
+	}
+
+	if len(label) < 1 || len(label) > 50 {
+		return "", ErrInvalidLabel
+	}
+
+	nowUnix := c.clock.NowUnix()
+
+	if input.ConfirmationCode != "" && !key.Verify(input.ConfirmationCode, nowUnix, skewSteps) {
+		return "", ErrInvalidConfirmationCode
+	}
+
+	secretEnc, keyId, err := c.encryptionService.Encrypt(base32.StdEncoding.EncodeToString(key.Secret))
+
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	uniqueId, err := ksuid.NewRandomWithTime(time.Unix(nowUnix, 0))
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+	instanceId := uniqueId.String()
+	version := 1
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM totp_authenticator WHERE mfa_serial = ?)", input.MfaSerial).Scan(&exists); err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	if exists {
+		return "", ErrAuthenticatorAlreadyImported
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO totp_authenticator
+	(instance_id, version, label, mfa_serial, issuer, account_name, algorithm, digits, period, secret_enc, last_used_step, created_at, enc_key_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		instanceId,
+		version,
+		label,
+		input.MfaSerial,
+		key.Issuer,
+		key.AccountName,
+		key.Algorithm,
+		key.Digits,
+		key.Period,
+		secretEnc,
+		0,
+		nowUnix,
+		keyId)
+
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	publish, err := c.bus.PublishTx(ctx, AuthenticatorImportedEvent{
+		InstanceId: instanceId,
+		Label:      label,
+		MfaSerial:  input.MfaSerial,
+	}, eventing.EventMeta{
+		SourceType:   TotpEventSource,
+		SourceId:     instanceId,
+		EventVersion: uint(version),
+	}, tx)
+
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	publish()
+
+	return instanceId, nil
+}
+
+func (c *TotpController) ListAuthenticators(ctx app.Context) ([]Authenticator, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT instance_id, label, mfa_serial, issuer, account_name, algorithm, digits, period, created_at
+	FROM totp_authenticator ORDER BY instance_id DESC`)
+
+	if err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+	defer rows.Close()
+
+	authenticators := make([]Authenticator, 0)
+
+	for rows.Next() {
+		var authenticator Authenticator
+
+		if err := rows.Scan(&authenticator.InstanceId, &authenticator.Label, &authenticator.MfaSerial, &authenticator.Issuer, &authenticator.AccountName,
+			&authenticator.Algorithm, &authenticator.Digits, &authenticator.Period, &authenticator.CreatedAt); err != nil {
+			return nil, errors.Join(err, app.ErrFatal)
+		}
+
+		authenticators = append(authenticators, authenticator)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Join(err, app.ErrFatal)
+	}
+
+	return authenticators, nil
+}
+
+// ListMfaSerials returns the MFA devices codes can be generated for.
+func (c *TotpController) ListMfaSerials(ctx app.Context) ([]string, error) {
+	authenticators, err := c.ListAuthenticators(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mfaSerials := make([]string, 0, len(authenticators))
+
+	for _, authenticator := range authenticators {
+		mfaSerials = append(mfaSerials, authenticator.MfaSerial)
+	}
+
+	return mfaSerials, nil
+}
+
+// Remove deletes an authenticator and publishes [AuthenticatorRemovedEvent].
+func (c *TotpController) Remove(ctx app.Context, instanceId string) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+	defer tx.Rollback()
+
+	var version int
+
+	if err := tx.QueryRowContext(ctx, "SELECT version FROM totp_authenticator WHERE instance_id = ?", instanceId).Scan(&version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAuthenticatorWasNotFound
+		}
+
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM totp_authenticator WHERE instance_id = ?", instanceId); err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	publish, err := c.bus.PublishTx(ctx, AuthenticatorRemovedEvent{
+		InstanceId: instanceId,
+	}, eventing.EventMeta{
+		SourceType:   TotpEventSource,
+		SourceId:     instanceId,
+		EventVersion: uint(version + 1),
+	}, tx)
+
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Join(err, app.ErrFatal)
+	}
+
+	publish()
+
+	return nil
+}
+
+// MfaCodeSource hands out codes of MFA devices to other providers.
+// It is kept apart from [TotpController] so that codes cannot be pulled through the bindings of the frontend.
+type MfaCodeSource struct {
+	controller *TotpController
+}
+
+func NewMfaCodeSource(controller *TotpController) *MfaCodeSource {
+	return &MfaCodeSource{controller: controller}
+}
+
+// GetMfaCode returns a code of an MFA device that was not handed out before, or an empty code when no secret of the device was imported.
+// AWS rejects codes that were used already, so once the code of the current time step is used the one of the next step is handed out,
+// after that [ErrTotpCodesExhausted] is returned until time moves on.
+func (s *MfaCodeSource) GetMfaCode(ctx app.Context, mfaSerial string) (string, error) {
+	return s.controller.getMfaCode(ctx, mfaSerial)
+}
+
+// ListMfaSerials returns the MFA devices codes can be generated for.
+func (s *MfaCodeSource) ListMfaSerials(ctx app.Context) ([]string, error) {
+	return s.controller.ListMfaSerials(ctx)
+}
+
+func (c *TotpController) getMfaCode(ctx app.Context, mfaSerial string) (string, error) {
+	c.codesMu.Lock()
+	defer c.codesMu.Unlock()
+
+	row := c.db.QueryRowContext(ctx, `SELECT instance_id, algorithm, digits, period, secret_enc, last_used_step, enc_key_id
+	FROM totp_authenticator WHERE mfa_serial = ?`, mfaSerial)
+
+	var instanceId, secretEnc, keyId string
+	var lastUsedStep int64
+
+	key := Key{}
+
+	if err := row.Scan(&instanceId, &key.Algorithm, &key.Digits, &key.Period, &secretEnc, &lastUsedStep, &keyId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	currentStep := key.Step(c.clock.NowUnix())
+	step := currentStep
+
+	if lastUsedStep >= step {
+		step = lastUsedStep + 1
+	}
+
+	if step > currentStep+skewSteps {
+		ctx.Logger().Warn().Msgf("codes of MFA device [%s] are exhausted until the next time step", mfaSerial)
+		return "", ErrTotpCodesExhausted
+	}
+
+	secret, err := c.encryptionService.Decrypt(secretEnc, keyId)
+
+	if err != nil {
+		return "", errors.Join(errors.New("failed to decrypt TOTP secret"), err, app.ErrFatal)
+	}
+
+	key.Secret, err = base32.StdEncoding.DecodeString(secret)
+
+	if err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	if _, err := c.db.ExecContext(ctx, "UPDATE totp_authenticator SET last_used_step = ? WHERE instance_id = ?", step, instanceId); err != nil {
+		return "", errors.Join(err, app.ErrFatal)
+	}
+
+	ctx.Logger().Info().Msgf("generated code of MFA device [%s]", mfaSerial)
+
+	return key.Code(step), nil
+}
@@ -0,0 +1,169 @@
+package totp
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/abjrcode/swervo/internal/eventing"
+	"github.com/abjrcode/swervo/internal/migrations"
+	"github.com/abjrcode/swervo/internal/security/vault"
+	"github.com/abjrcode/swervo/internal/testhelpers"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testMfaSerial = "arn:aws:iam::123456789012:mfa/legacy"
+	testSecret    = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	testUri       = "otpauth://totp/Amazon%20Web%20Services:legacy@123456789012?secret=" + testSecret + "&issuer=Amazon%20Web%20Services"
+)
+
+var testKey = Key{Secret: []byte("12345678901234567890"), Algorithm: AlgorithmSha1, Digits: 6, Period: 30}
+
+func initController(t *testing.T) (*TotpController, *eventing.Eventbus, *sql.DB, *testhelpers.MockClock) {
+	db, err := migrations.NewInMemoryMigratedDatabase(t, "totp-controller-tests.db")
+	require.NoError(t, err)
+
+	mockClock := testhelpers.NewMockClock()
+
+	bus := eventing.NewEventbus(db, mockClock)
+
+	vault := vault.NewVault(db, bus, mockClock)
+	timeSetCall := mockClock.On("NowUnix").Return(1)
+	err = vault.Configure(testhelpers.NewMockAppContext(), "abc")
+	require.NoError(t, err)
+	timeSetCall.Unset()
+
+	return NewTotpController(db, bus, vault, mockClock), bus, db, mockClock
+}
+
+func TestImport(t *testing.T) {
+	controller, bus, db, mockClock := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	events := bus.Subscribe(TotpEventSource)
+
+	mockClock.On("NowUnix").Return(1000)
+
+	instanceId, err := controller.Import(ctx, Totp_ImportCommandInput{
+		MfaSerial:        testMfaSerial,
+		Secret:           testUri,
+		ConfirmationCode: testKey.Code(testKey.Step(1000)),
+	})
+	require.NoError(t, err)
+
+	envelope := <-events
+	require.Equal(t, AuthenticatorImportedEvent{
+		InstanceId: instanceId,
+		Label:      "Amazon Web Services:legacy@123456789012",
+		MfaSerial:  testMfaSerial,
+	}, envelope.Event)
+
+	var secretEnc string
+	err = db.QueryRow("SELECT secret_enc FROM totp_authenticator WHERE instance_id = ?", instanceId).Scan(&secretEnc)
+	require.NoError(t, err)
+	require.NotContains(t, secretEnc, testSecret, "secrets must be stored encrypted")
+
+	authenticators, err := controller.ListAuthenticators(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []Authenticator{{
+		InstanceId:  instanceId,
+		Label:       "Amazon Web Services:legacy@123456789012",
+		MfaSerial:   testMfaSerial,
+		Issuer:      "Amazon Web Services",
+		AccountName: "legacy@123456789012",
+		Algorithm:   AlgorithmSha1,
+		Digits:      6,
+		Period:      30,
+		CreatedAt:   1000,
+	}}, authenticators)
+
+	mfaSerials, err := controller.ListMfaSerials(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{testMfaSerial}, mfaSerials)
+
+	_, err = controller.Import(ctx, Totp_ImportCommandInput{Label: "again", MfaSerial: testMfaSerial, Secret: testSecret})
+	require.Same(t, ErrAuthenticatorAlreadyImported, err)
+}
+
+func TestImport_InvalidInput(t *testing.T) {
+	controller, _, _, mockClock := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	mockClock.On("NowUnix").Return(1000)
+
+	testCases := []struct {
+		name     string
+		input    Totp_ImportCommandInput
+		expected error
+	}{
+		{name: "mfa serial", input: Totp_ImportCommandInput{Label: "legacy", MfaSerial: "short", Secret: testSecret}, expected: ErrInvalidMfaSerial},
+		{name: "secret", input: Totp_ImportCommandInput{Label: "legacy", MfaSerial: testMfaSerial, Secret: "not base32!"}, expected: ErrInvalidTotpSecret},
+		{name: "label", input: Totp_ImportCommandInput{MfaSerial: testMfaSerial, Secret: testSecret}, expected: ErrInvalidLabel},
+		{name: "confirmation code", input: Totp_ImportCommandInput{Label: "legacy", MfaSerial: testMfaSerial, Secret: testSecret, ConfirmationCode: testKey.Code(testKey.Step(1000) + 2)}, expected: ErrInvalidConfirmationCode},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := controller.Import(ctx, testCase.input)
+			require.Same(t, testCase.expected, err)
+		})
+	}
+}
+
+func TestGetMfaCode(t *testing.T) {
+	controller, _, _, mockClock := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	mockClock.On("NowUnix").Return(1000)
+
+	_, err := controller.Import(ctx, Totp_ImportCommandInput{Label: "legacy", MfaSerial: testMfaSerial, Secret: testSecret})
+	require.NoError(t, err)
+
+	codes := NewMfaCodeSource(controller)
+
+	code, err := codes.GetMfaCode(ctx, testMfaSerial)
+	require.NoError(t, err)
+	require.Equal(t, testKey.Code(testKey.Step(1000)), code)
+
+	code, err = codes.GetMfaCode(ctx, testMfaSerial)
+	require.NoError(t, err)
+	require.Equal(t, testKey.Code(testKey.Step(1000)+1), code, "codes are never handed out twice")
+
+	_, err = codes.GetMfaCode(ctx, testMfaSerial)
+	require.Same(t, ErrTotpCodesExhausted, err, "codes beyond the skew window are not accepted by AWS")
+
+	mockClock.ExpectedCalls = nil
+	mockClock.On("NowUnix").Return(1060)
+
+	code, err = codes.GetMfaCode(ctx, testMfaSerial)
+	require.NoError(t, err)
+	require.Equal(t, testKey.Code(testKey.Step(1060)), code)
+
+	code, err = codes.GetMfaCode(ctx, "arn:aws:iam::123456789012:mfa/unknown")
+	require.NoError(t, err)
+	require.Empty(t, code, "devices without an imported secret have no codes")
+}
+
+func TestRemove(t *testing.T) {
+	controller, bus, _, mockClock := initController(t)
+	ctx := testhelpers.NewMockAppContext()
+
+	mockClock.On("NowUnix").Return(1000)
+
+	instanceId, err := controller.Import(ctx, Totp_ImportCommandInput{Label: "legacy", MfaSerial: testMfaSerial, Secret: testSecret})
+	require.NoError(t, err)
+
+	events := bus.Subscribe(TotpEventSource)
+
+	require.NoError(t, controller.Remove(ctx, instanceId))
+
+	envelope := <-events
+	require.Equal(t, AuthenticatorRemovedEvent{InstanceId: instanceId}, envelope.Event)
+
+	code, err := NewMfaCodeSource(controller).GetMfaCode(ctx, testMfaSerial)
+	require.NoError(t, err)
+	require.Empty(t, code)
+
+	err = controller.Remove(ctx, instanceId)
+	require.Same(t, ErrAuthenticatorWasNotFound, err)
+}
@@ -0,0 +1,188 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type Algorithm string
+
+const (
+	AlgorithmSha1   Algorithm = "SHA1"
+	AlgorithmSha256 Algorithm = "SHA256"
+	AlgorithmSha512 Algorithm = "SHA512"
+)
+
+const (
+	defaultDigits = 6
+	defaultPeriod = 30
+
+	minSecretLength = 10
+)
+
+// Key is everything needed to generate the codes of an authenticator, as found in an otpauth:// URI.
+type Key struct {
+	Issuer      string
+	AccountName string
+	Secret      []byte
+	Algorithm   Algorithm
+	Digits      int
+	// Period is how many seconds a code is valid for
+	Period int64
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "=", "").Replace(secret))
+
+	decoded, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+
+	if err != nil || len(decoded) < minSecretLength {
+		return nil, ErrInvalidTotpSecret
+	}
+
+	return decoded, nil
+}
+
+// ParseKey reads a key from an otpauth://totp/ URI or from a bare base32 secret,
+// in which case the defaults of most authenticators apply: SHA1, 6 digits and 30 seconds.
+func ParseKey(input string) (*Key, error) {
+	input = strings.TrimSpace(input)
+
+	if !strings.HasPrefix(strings.ToLower(input), "otpauth:") {
+		secret, err := decodeSecret(input)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &Key{Secret: secret, Algorithm: AlgorithmSha1, Digits: defaultDigits, Period: defaultPeriod}, nil
+	}
+
+	uri, err := url.Parse(input)
+
+	if err != nil || !strings.EqualFold(uri.Scheme, "otpauth") {
+		return nil, ErrInvalidTotpSecret
+	}
+
+	if !strings.EqualFold(uri.Host, "totp") {
+		return nil, ErrUnsupportedTotpParameters
+	}
+
+	query := uri.Query()
+
+	secret, err := decodeSecret(query.Get("secret"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{Secret: secret, Algorithm: AlgorithmSha1, Digits: defaultDigits, Period: defaultPeriod}
+
+	// the label is "issuer:account" where the issuer is optional
+	label := strings.TrimPrefix(uri.Path, "/")
+
+	if issuer, accountName, ok := strings.Cut(label, ":"); ok {
+		key.Issuer = strings.TrimSpace(issuer)
+		key.AccountName = strings.TrimSpace(accountName)
+	} else {
+		key.AccountName = label
+	}
+
+	if issuer := query.Get("issuer"); issuer != "" {
+		key.Issuer = issuer
+	}
+
+	if algorithm := query.Get("algorithm"); algorithm != "" {
+		key.Algorithm = Algorithm(strings.ToUpper(algorithm))
+	}
+
+	if digits := query.Get("digits"); digits != "" {
+		if key.Digits, err = strconv.Atoi(digits); err != nil {
+			return nil, ErrUnsupportedTotpParameters
+		}
+	}
+
+	if period := query.Get("period"); period != "" {
+		if key.Period, err = strconv.ParseInt(period, 10, 64); err != nil {
+			return nil, ErrUnsupportedTotpParameters
+		}
+	}
+
+	if err := key.validate(); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (k *Key) validate() error {
+	switch k.Algorithm {
+	case AlgorithmSha1, AlgorithmSha256, AlgorithmSha512:
+	default:
+		return ErrUnsupportedTotpParameters
+	}
+
+	// AWS only accepts MFA codes of 6 digits
+	if k.Digits != 6 || k.Period < 1 || k.Period > 300 {
+		return ErrUnsupportedTotpParameters
+	}
+
+	return nil
+}
+
+func (k *Key) newHash() func() hash.Hash {
+	switch k.Algorithm {
+	case AlgorithmSha256:
+		return sha256.New
+	case AlgorithmSha512:
+		return sha512.New
+	default:
+		return sha1.New
+	}
+}
+
+// Step is the time step a Unix time in seconds falls in.
+func (k *Key) Step(nowUnix int64) int64 {
+	return nowUnix / k.Period
+}
+
+// Code generates the code of a time step as defined by RFC 6238.
+func (k *Key) Code(step int64) string {
+	mac := hmac.New(k.newHash(), k.Secret)
+	binary.Write(mac, binary.BigEndian, uint64(step))
+	sum := mac.Sum(nil)
+
+	// dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := int64(binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff)
+
+	modulo := int64(1)
+	for i := 0; i < k.Digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", k.Digits, value%modulo)
+}
+
+// Verify reports whether a code is the one of the current time step or of up to skewSteps steps around it,
+// which tolerates clocks that drifted apart.
+func (k *Key) Verify(code string, nowUnix int64, skewSteps int64) bool {
+	step := k.Step(nowUnix)
+
+	for candidate := step - skewSteps; candidate <= step+skewSteps; candidate++ {
+		if hmac.Equal([]byte(k.Code(candidate)), []byte(code)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,95 @@
+package totp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// test vectors of RFC 6238 appendix B
+func TestCode_Rfc6238(t *testing.T) {
+	keys := map[Algorithm][]byte{
+		AlgorithmSha1:   []byte("12345678901234567890"),
+		AlgorithmSha256: []byte("12345678901234567890123456789012"),
+		AlgorithmSha512: []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+
+	testCases := []struct {
+		nowUnix   int64
+		algorithm Algorithm
+		expected  string
+	}{
+		{nowUnix: 59, algorithm: AlgorithmSha1, expected: "94287082"},
+		{nowUnix: 59, algorithm: AlgorithmSha256, expected: "46119246"},
+		{nowUnix: 59, algorithm: AlgorithmSha512, expected: "90693936"},
+		{nowUnix: 1111111109, algorithm: AlgorithmSha1, expected: "07081804"},
+		{nowUnix: 1111111109, algorithm: AlgorithmSha256, expected: "68084774"},
+		{nowUnix: 1111111109, algorithm: AlgorithmSha512, expected: "25091201"},
+		{nowUnix: 20000000000, algorithm: AlgorithmSha1, expected: "65353130"},
+	}
+
+	for _, testCase := range testCases {
+		key := Key{Secret: keys[testCase.algorithm], Algorithm: testCase.algorithm, Digits: 8, Period: 30}
+
+		require.Equal(t, testCase.expected, key.Code(key.Step(testCase.nowUnix)), "%s at %d", testCase.algorithm, testCase.nowUnix)
+	}
+
+	key := Key{Secret: keys[AlgorithmSha1], Algorithm: AlgorithmSha1, Digits: 6, Period: 30}
+	require.Equal(t, "287082", key.Code(key.Step(59)), "shorter codes are truncated from the left")
+}
+
+func TestVerify(t *testing.T) {
+	key := Key{Secret: []byte("12345678901234567890"), Algorithm: AlgorithmSha1, Digits: 6, Period: 30}
+
+	code := key.Code(key.Step(1000))
+
+	require.True(t, key.Verify(code, 1000, 1))
+	require.True(t, key.Verify(code, 1030, 1), "codes of the previous step are accepted within the skew window")
+	require.True(t, key.Verify(code, 970, 1), "codes of the next step are accepted within the skew window")
+	require.False(t, key.Verify(code, 1060, 1))
+	require.False(t, key.Verify(code, 1030, 0))
+}
+
+func TestParseKey(t *testing.T) {
+	key, err := ParseKey("otpauth://totp/Amazon%20Web%20Services:legacy@123456789012?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&issuer=Amazon%20Web%20Services")
+	require.NoError(t, err)
+	require.Equal(t, &Key{
+		Issuer:      "Amazon Web Services",
+		AccountName: "legacy@123456789012",
+		Secret:      []byte("12345678901234567890"),
+		Algorithm:   AlgorithmSha1,
+		Digits:      6,
+		Period:      30,
+	}, key)
+
+	key, err = ParseKey("otpauth://totp/legacy?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&algorithm=sha256&digits=6&period=60")
+	require.NoError(t, err)
+	require.Equal(t, "", key.Issuer)
+	require.Equal(t, "legacy", key.AccountName)
+	require.Equal(t, AlgorithmSha256, key.Algorithm)
+	require.Equal(t, 6, key.Digits)
+	require.Equal(t, int64(60), key.Period)
+
+	key, err = ParseKey(" gezd gnbv gy3t qojq gezd gnbv gy3t qojq ")
+	require.NoError(t, err, "bare secrets are accepted as authenticator apps display them")
+	require.Equal(t, &Key{Secret: []byte("12345678901234567890"), Algorithm: AlgorithmSha1, Digits: 6, Period: 30}, key)
+
+	testCases := []struct {
+		input    string
+		expected error
+	}{
+		{input: "not base32!", expected: ErrInvalidTotpSecret},
+		{input: "GEZDGNBV", expected: ErrInvalidTotpSecret},
+		{input: "otpauth://totp/legacy", expected: ErrInvalidTotpSecret},
+		{input: "otpauth://hotp/legacy?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&counter=1", expected: ErrUnsupportedTotpParameters},
+		{input: "otpauth://totp/legacy?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&algorithm=MD5", expected: ErrUnsupportedTotpParameters},
+		{input: "otpauth://totp/legacy?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=4", expected: ErrUnsupportedTotpParameters},
+		{input: "otpauth://totp/legacy?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=8", expected: ErrUnsupportedTotpParameters},
+		{input: "otpauth://totp/legacy?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&period=0", expected: ErrUnsupportedTotpParameters},
+	}
+
+	for _, testCase := range testCases {
+		_, err := ParseKey(testCase.input)
+		require.Same(t, testCase.expected, err, testCase.input)
+	}
+}